@@ -0,0 +1,95 @@
+package main
+
+/*
+	ACTIVATION RECORDS
+
+	Every call gets a CallFrame of its own holding the values of its
+	parameters and locals, so a recursive procedure no longer shares them
+	between its activations. The frame links to the activation of the
+	procedure it is declared in, which need not be its caller, and a name
+	declared further out is found by following those links one enclosing
+	scope at a time. Globals, those of units included, live in the bottom
+	frame for the whole run.
+*/
+
+/* The activation holding the variables of scope, as seen from frame */
+func (i *Interpreter) activation(frame *CallFrame, scope *ScopedSymbolTable) *CallFrame {
+	scope = owning_scope(scope)
+	for ; frame != nil; frame = frame.link {
+		if frame.scope == scope {
+			return frame
+		}
+	}
+	return i.stack[0]
+}
+
+/* The scope in the chain of scope that declares symbol, nil when none does */
+func declaring_scope(scope *ScopedSymbolTable, symbol Symbol) *ScopedSymbolTable {
+	for ; scope != nil; scope = scope.enclosing_scope {
+		if found, ok := scope.lookup(symbol.getName(), true); ok == true && found == symbol {
+			return scope
+		}
+	}
+	return nil
+}
+
+/* The value of a variable as the code running in frame sees it, a field being that of its Self */
+func (i *Interpreter) value(frame *CallFrame, variable *VarSymbol) float64 {
+	scope := declaring_scope(frame.scope, variable)
+	if scope != nil && scope.class != nil {
+		if object := i.object(i.self(frame)); object != nil {
+			return object.values[variable]
+		}
+		return 0
+	}
+	return i.activation(frame, scope).values[variable]
+}
+
+/* Opens the activation of a call to proc on the heap, link being the live activation of the procedure declaring it */
+func (i *Interpreter) call_frame(proc *ProcedureSymbol, link *CallFrame, token *lexemes) *CallFrame {
+	size := frame_size(proc.scope)
+	i.allocate(size, token)
+	return &CallFrame{proc.name, proc.scope, token, nil, link, make(map[*VarSymbol]float64), size}
+}
+
+/* Runs proc on the values of args in an activation linked to link, self being the instance a method runs on, and returns the value a function leaves in its result */
+func (i *Interpreter) invoke(proc *ProcedureSymbol, link *CallFrame, args []*Node, token *lexemes, self float64) float64 {
+	values := []float64{}
+	for _, arg := range args {
+		values = append(values, i.run(arg))
+	}
+	i.enter(token)
+	frame := i.call_frame(proc, link, token)
+	for index, param := range proc.params {
+		frame.values[param] = values[index]
+		if param.stype.name == "INTEGER_CONST" {
+			frame.values[param] = i.store_integer(token, values[index])
+		}
+		/* a CONST array is the caller's own, any other a copy */
+		if param.stype.array != nil && param.constant == false {
+			frame.values[param] = i.copy_array(values[index], token)
+		}
+	}
+	if member := method_member(proc); member != nil {
+		frame.values[member.self] = self
+	}
+	i.stack = append(i.stack, frame)
+	caller_scope := i.scope
+	i.scope = proc.scope
+	i.run(proc.decl.block)
+	result := 0.0
+	if proc.result != nil {
+		result = frame.values[proc.result]
+	}
+	i.release(frame)
+	i.scope = caller_scope
+	i.stack = i.stack[:len(i.stack)-1]
+	return result
+}
+
+/* Copies the globals back into the symbol table, where the rest of the tools look once the program ends */
+func (i *Interpreter) export(scope *ScopedSymbolTable) {
+	for _, variable := range scope_variables(scope) {
+		variable.value = i.stack[0].values[variable]
+	}
+}
@@ -0,0 +1,27 @@
+PROGRAM Arith;
+VAR
+   a, b, q, r : INTEGER;
+   x, y : REAL;
+
+PROCEDURE Show(n, d : INTEGER);
+VAR
+   quotient : REAL;
+BEGIN
+   quotient := n / d;
+   WRITELN(n, ' DIV ', d, ' = ', n DIV d, ', ', n, ' MOD ', d, ' = ', n MOD d, ', ', n, ' / ', d, ' = ', quotient)
+END;
+
+BEGIN {Arith}
+   a := 17;
+   b := 5;
+   Show(a, b);
+   Show(-a, b);
+   Show(a, -b);
+   Show(-a, -b);
+   q := a DIV b * b + a MOD b;
+   r := a - - b;
+   x := 20 DIV 7 + 3.14;
+   y := +(-x) * 2;
+   WRITELN('q = ', q, ', r = ', r, ', x = ', x, ', y = ', y);
+   WRITELN('it''s 100% done')
+END.  {Arith}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+)
+
+/*
+	ARRAYS
+
+		array_type        : ARRAY (LBRACKET bound DOTDOT bound RBRACKET)? OF type_spec
+		bound             : MINUS? INTEGER_CONST
+		formal_parameters : CONST? ID (COMMA ID)* COLON type_spec
+		index             : ID LBRACKET expr RBRACKET
+		element_statement : index ASSIGN expr
+		builtin_call      : ID LPAREN (expr (COMMA expr)*)? RPAREN
+
+	A variable of ARRAY[1..10] OF T is a static array of that many
+	elements, one of ARRAY OF T a dynamic array, empty until SetLength
+	gives it elements, indexed from 0. A parameter of ARRAY OF T is an open
+	array, taking static and dynamic arrays of T alike, also indexed from
+	0. Length, High and Low tell the bounds of any of them. A variable
+	holds a handle to elements the interpreter keeps apart, and assigning
+	an array, or passing it to a parameter, copies the elements, so no two
+	variables share them; a CONST parameter is the exception, reading the
+	caller's array, which it cannot change. Every index is checked against
+	the bounds, runtime error 201 reporting one outside them. Arrays cannot
+	hold arrays, and only run on the interpreter.
+*/
+
+/* The builtin functions, called in expressions, SetLength being a procedure */
+var array_builtins = map[string]bool{
+	"LENGTH": true,
+	"HIGH":   true,
+	"LOW":    true,
+}
+
+type ArraySpec struct {
+	token   *lexemes
+	element *Spec
+	/* the bounds of a static array */
+	static bool
+	low    int
+	high   int
+}
+
+type ArrayType struct {
+	element *BuiltinSymbol
+	static  bool
+	low     int
+	high    int
+	/* a parameter taking static and dynamic arrays alike */
+	open bool
+}
+
+/* The index of the first element, 0 but for a static array */
+func (a *ArrayType) first() int {
+	if a.static == true {
+		return a.low
+	}
+	return 0
+}
+
+/* name[index], the element of an array variable */
+type Index struct {
+	token *lexemes
+	index *Node
+}
+
+type IndexAssign struct {
+	target *Index
+	token  *lexemes
+	expr   *Node
+}
+
+/* A call in an expression, to a builtin function, Length, High or Low, or to a FUNCTION */
+type BuiltinCall struct {
+	token *lexemes
+	args  []*Node
+	/* the function called, or the procedural variable called through, nil for a builtin */
+	proc_symbol *ProcedureSymbol
+	variable    *VarSymbol
+}
+
+func (r *rules) array_type() *Spec {
+	array := &ArraySpec{r.lexer.Cur(), nil, false, 0, 0}
+	r.digest(ARRAY)
+	name := "ARRAY"
+	if r.lexer.Cur().ttype == LBRACKET {
+		r.digest(LBRACKET)
+		array.static = true
+		array.low = r.bound()
+		r.digest(DOTDOT)
+		array.high = r.bound()
+		r.digest(RBRACKET)
+		name = fmt.Sprintf("ARRAY[%d..%d]", array.low, array.high)
+	}
+	r.digest(OF)
+	array.element = r.type_spec()
+	return &Spec{ARRAY, name + " OF " + array.element.sstring, array}
+}
+
+func (r *rules) bound() int {
+	negative := r.lexer.Cur().ttype == MINUS
+	if negative == true {
+		r.digest(MINUS)
+	}
+	token := r.lexer.Cur()
+	r.digest(INTEGER_CONST)
+	if negative == true {
+		return -int(number_value(token))
+	}
+	return int(number_value(token))
+}
+
+func (r *rules) index() *Index {
+	token := r.lexer.Cur()
+	r.digest(ID)
+	r.digest(LBRACKET)
+	index := r.expr()
+	r.digest(RBRACKET)
+	return &Index{token, index}
+}
+
+func (r *rules) element_statement() interface{} {
+	target := r.index()
+	token := r.lexer.Cur()
+	r.digest(ASSIGN)
+	return &IndexAssign{target, token, r.expr()}
+}
+
+func (r *rules) builtin_call() *BuiltinCall {
+	token := r.lexer.Cur()
+	r.digest(ID)
+	return &BuiltinCall{token, r.arguments(), nil, nil}
+}
+
+/* The type a parameter declares, where ARRAY OF is an open array */
+func (s SemanticsAnalyser) param_type(spec *Spec, token *lexemes) *BuiltinSymbol {
+	if spec.array != nil {
+		return s.array_symbol(spec, token, true)
+	}
+	return s.type_symbol(spec, token)
+}
+
+func (s SemanticsAnalyser) array_symbol(spec *Spec, token *lexemes, param bool) *BuiltinSymbol {
+	array := spec.array
+	if array.element.array != nil {
+		compile_error("Semantic", array.token, "an array cannot hold arrays")
+	}
+	if array.static == true && array.high < array.low {
+		compile_error("Semantic", array.token, "array bounds %d..%d are empty", array.low, array.high)
+	}
+	element := s.type_symbol(array.element, token)
+	return &BuiltinSymbol{spec.sstring, nil, nil, &ArrayType{element, array.static, array.low, array.high, param == true && array.static == false}}
+}
+
+/* The array variable a name refers to */
+func (s SemanticsAnalyser) array_variable(token *lexemes) *VarSymbol {
+	symbol, ok := s.scope.lookup(token.tstring, false)
+	if ok == false {
+		compile_error("Semantic", token, "%s undeclared", token.tstring)
+	}
+	variable, is_var := symbol.(*VarSymbol)
+	if is_var == false || variable.stype.array == nil {
+		compile_error("Semantic", token, "%s is not an array", token.tstring)
+	}
+	s.check_member_name(symbol, token)
+	s.reference(token, symbol)
+	return variable
+}
+
+/* Rejects a change to a CONST parameter */
+func (s SemanticsAnalyser) writable(variable *VarSymbol, token *lexemes) {
+	if variable.constant == true {
+		compile_error("Semantic", token, "%s is a CONST parameter and cannot be changed", variable.name)
+	}
+}
+
+func (s SemanticsAnalyser) check_index(v *Index) {
+	s.array_variable(v.token)
+	s.check(v.index)
+	if s.type_of(v.index).name != "INTEGER_CONST" {
+		compile_error("Semantic", node_token(v.index), "index of %s must be an INTEGER", v.token.tstring)
+	}
+}
+
+func (s SemanticsAnalyser) check_index_assign(v *IndexAssign) {
+	s.check_index(v.target)
+	array := s.array_variable(v.target.token)
+	s.writable(array, v.target.token)
+	s.check(v.expr)
+	s.assignable(array.stype.array.element, v.expr, v.token)
+}
+
+func (s SemanticsAnalyser) check_builtin_call(v *BuiltinCall) {
+	name := v.token.tstring
+	if symbol := s.callable(name); symbol != nil {
+		s.check_function_call(v, symbol)
+		return
+	}
+	if array_builtins[name] == false {
+		compile_error("Semantic", v.token, "%s is not a function", name)
+	}
+	if len(v.args) != 1 {
+		compile_error("Semantic", v.token, "%s expects one argument, got %d", name, len(v.args))
+	}
+	s.check(v.args[0])
+	if s.type_of(v.args[0]).array == nil {
+		compile_error("Semantic", node_token(v.args[0]), "%s wants an array", name)
+	}
+}
+
+/* SetLength(name, length) resizes a dynamic array variable */
+func (s SemanticsAnalyser) check_set_length(v *ProcedureCall) {
+	if len(v.args) != 2 {
+		compile_error("Semantic", v.token, "SETLENGTH expects 2 arguments, got %d", len(v.args))
+	}
+	variable, ok := v.args[0].token.(*Var)
+	if ok == false || v.args[0].left != nil || v.args[0].right != nil {
+		compile_error("Semantic", node_token(v.args[0]), "SETLENGTH wants an array variable")
+	}
+	array := s.array_variable(variable.token)
+	switch {
+	case array.stype.array.static == true:
+		compile_error("Semantic", variable.token, "%s is a static array, whose length cannot change", array.name)
+	case array.stype.array.open == true:
+		compile_error("Semantic", variable.token, "%s is an open array parameter, whose length cannot change", array.name)
+	}
+	s.writable(array, variable.token)
+	s.check(v.args[1])
+	if s.type_of(v.args[1]).name != "INTEGER_CONST" {
+		compile_error("Semantic", node_token(v.args[1]), "the length of %s must be an INTEGER", array.name)
+	}
+}
+
+/* Checks the store of an array, true when it is one: an array of the same type, or any of its element type for an open array */
+func (s SemanticsAnalyser) assignable_array(target *BuiltinSymbol, expr *Node, token *lexemes) bool {
+	if _, is_procedure := s.procedure_value(expr); is_procedure == true {
+		if target.array != nil {
+			compile_error("Semantic", token, "a procedure cannot be used as %s", type_name(target))
+		}
+		return false
+	}
+	stype := s.type_of(expr)
+	if target.array == nil {
+		if stype.array != nil {
+			compile_error("Semantic", token, "an array cannot be used as %s", type_name(target))
+		}
+		return false
+	}
+	if stype.array == nil {
+		compile_error("Semantic", token, "%s cannot be used as %s", type_name(stype), type_name(target))
+	}
+	same := stype.name == target.name
+	if target.array.open == true {
+		same = stype.array.element.name == target.array.element.name
+	}
+	if same == false {
+		compile_error("Semantic", token, "%s cannot be used as %s", type_name(stype), type_name(target))
+	}
+	return true
+}
+
+/* Elements for an array of length, taken from the heap */
+func (i *Interpreter) allocate_elements(length int64, token *lexemes) []float64 {
+	if length > MAX_ALLOCATION/VALUE_SIZE {
+		i.runtime_error(RUNTIME_HEAP_OVERFLOW, token, "%d elements at once", length)
+	}
+	i.allocate(int(length)*VALUE_SIZE, token)
+	return make([]float64, length)
+}
+
+func (i *Interpreter) new_array(elements []float64) float64 {
+	i.arrays = append(i.arrays, elements)
+	return float64(len(i.arrays))
+}
+
+/* The handle of the array a variable holds, a static array getting its elements on first use at token */
+func (i *Interpreter) array_handle(frame *CallFrame, variable *VarSymbol, token *lexemes) float64 {
+	array := variable.stype.array
+	if frame.values[variable] == 0 && array.static == true {
+		frame.values[variable] = i.new_array(i.allocate_elements(int64(array.high)-int64(array.low)+1, token))
+	}
+	return frame.values[variable]
+}
+
+func (i *Interpreter) elements(handle float64) []float64 {
+	if handle == 0 {
+		return nil
+	}
+	return i.arrays[int(handle)-1]
+}
+
+func (i *Interpreter) copy_array(handle float64, token *lexemes) float64 {
+	if handle == 0 {
+		return 0
+	}
+	elements := i.allocate_elements(int64(len(i.elements(handle))), token)
+	copy(elements, i.elements(handle))
+	return i.new_array(elements)
+}
+
+/* Gives the elements of an array back to the heap */
+func (i *Interpreter) free_array(handle float64) {
+	if handle != 0 {
+		i.free(len(i.elements(handle)) * VALUE_SIZE)
+		i.arrays[int(handle)-1] = nil
+	}
+}
+
+/* Gives variable a copy of the array handle holds, dropping the elements it had */
+func (i *Interpreter) assign_array(frame *CallFrame, variable *VarSymbol, handle float64, token *lexemes) {
+	copied := i.copy_array(handle, token)
+	i.free_array(frame.values[variable])
+	frame.values[variable] = copied
+}
+
+/* Gives back the frame of a call that returns and its arrays, but those its CONST parameters share with the caller */
+func (i *Interpreter) release(frame *CallFrame) {
+	for variable, value := range frame.values {
+		if variable.stype.array != nil && variable.constant == false {
+			i.free_array(value)
+		}
+	}
+	i.free(frame.size)
+}
+
+/* The elements of the array v indexes and the position of the element, failing when the index is out of bounds */
+func (i *Interpreter) locate(v *Index, index float64) ([]float64, int) {
+	variable, frame := i.variable(v.token)
+	elements := i.elements(i.array_handle(frame, variable, v.token))
+	first := int64(variable.stype.array.first())
+	last := first + int64(len(elements)) - 1
+	if len(elements) == 0 {
+		i.runtime_error(RUNTIME_RANGE_CHECK, v.token, "index %d of empty array %s", int64(index), v.token.tstring)
+	}
+	if int64(index) < first || int64(index) > last {
+		i.runtime_error(RUNTIME_RANGE_CHECK, v.token, "index %d outside %d..%d of %s", int64(index), first, last, v.token.tstring)
+	}
+	return elements, int(int64(index) - first)
+}
+
+func (i *Interpreter) element(v *Index) float64 {
+	elements, position := i.locate(v, i.run(v.index))
+	return elements[position]
+}
+
+func (i *Interpreter) store_element(v *IndexAssign) {
+	index := i.run(v.target.index)
+	value := i.run(v.expr)
+	elements, position := i.locate(v.target, index)
+	variable, _ := i.variable(v.target.token)
+	if variable.stype.array.element.name == "INTEGER_CONST" {
+		value = i.store_integer(v.token, value)
+	}
+	elements[position] = value
+}
+
+/* Resizes a dynamic array, keeping the elements that still fit, zeroing new ones and giving the old ones back */
+func (i *Interpreter) set_length(v *ProcedureCall) {
+	variable, frame := i.variable(v.args[0].token.(*Var).token)
+	length := int64(i.run(v.args[1]))
+	if length < 0 {
+		i.runtime_error(RUNTIME_RANGE_CHECK, v.token, "negative length %d for %s", length, variable.name)
+	}
+	resized := i.allocate_elements(length, v.token)
+	handle := frame.values[variable]
+	copy(resized, i.elements(handle))
+	if handle == 0 {
+		frame.values[variable] = i.new_array(resized)
+		return
+	}
+	i.free_array(handle)
+	i.arrays[int(handle)-1] = resized
+}
+
+func (i *Interpreter) builtin_call(v *BuiltinCall) float64 {
+	if v.proc_symbol != nil || v.variable != nil {
+		return i.call_function(v)
+	}
+	array := SemanticsAnalyser{i.scope, nil}.type_of(v.args[0]).array
+	length := len(i.elements(i.run(v.args[0])))
+	switch v.token.tstring {
+	case "LOW":
+		return float64(array.first())
+	case "HIGH":
+		return float64(array.first() + length - 1)
+	}
+	return float64(length)
+}
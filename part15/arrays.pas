@@ -0,0 +1,98 @@
+PROGRAM Arrays;
+VAR
+   squares : ARRAY[1..5] OF INTEGER;
+   data, saved : ARRAY OF INTEGER;
+   weights : ARRAY OF REAL;
+   n : INTEGER;
+
+PROCEDURE Sum(CONST xs : ARRAY OF INTEGER);
+VAR
+   i, total : INTEGER;
+BEGIN {Sum}
+   total := 0;
+   i := Low(xs);
+   WHILE i <= High(xs) DO
+   BEGIN
+      total := total + xs[i];
+      i := i + 1
+   END;
+   WRITELN('sum of ', Length(xs), ' elements = ', total)
+END;  {Sum}
+
+PROCEDURE Clear(xs : ARRAY OF INTEGER);
+VAR
+   i : INTEGER;
+BEGIN {Clear}
+   i := 0;
+   WHILE i < Length(xs) DO
+   BEGIN
+      xs[i] := 0;
+      i := i + 1
+   END;
+   Sum(xs)
+END;  {Clear}
+
+PROCEDURE Mean(CONST ws : ARRAY OF REAL);
+VAR
+   i : INTEGER;
+   total : REAL;
+BEGIN {Mean}
+   total := 0.0;
+   i := 0;
+   WHILE i < Length(ws) DO
+   BEGIN
+      total := total + ws[i];
+      i := i + 1
+   END;
+   WRITELN('mean = ', total / Length(ws))
+END;  {Mean}
+
+BEGIN {Arrays}
+   n := Low(squares);
+   WHILE n <= High(squares) DO
+   BEGIN
+      squares[n] := n * n;
+      n := n + 1
+   END;
+   WRITELN('squares ', Low(squares), '..', High(squares));
+   Sum(squares);
+
+   WRITELN('empty data has ', Length(data), ' elements, high ', High(data));
+   SetLength(data, 3);
+   data[0] := 10;
+   data[1] := 20;
+   data[2] := 30;
+   Sum(data);
+
+   { assignment copies, the copy keeps its elements }
+   saved := data;
+   data[0] := 100;
+   WRITELN('data[0] = ', data[0], ', saved[0] = ', saved[0]);
+
+   { a larger array keeps the elements it had }
+   SetLength(data, 5);
+   data[4] := 5;
+   Sum(data);
+
+   { a value parameter clears its own copy }
+   Clear(data);
+   Sum(data);
+
+   SetLength(weights, 2);
+   weights[0] := 1.5;
+   weights[1] := 2.0;
+   Mean(weights);
+
+   TRY
+      data[5] := 1
+   EXCEPT
+      ON E : ERangeError DO WRITELN('caught error ', E)
+   END;
+   TRY
+      WRITELN(squares[0])
+   EXCEPT
+      ON E : ERangeError DO WRITELN('caught error ', E)
+   END;
+   SetLength(data, 0);
+   WRITELN('data has ', Length(data), ' elements')
+END.  {Arrays}
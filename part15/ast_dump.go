@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+	AST DUMP
+*/
+
+type AstNode struct {
+	Kind     string     `json:"kind"`
+	Value    string     `json:"value,omitempty"`
+	Line     int        `json:"line"`
+	Column   int        `json:"column"`
+	Children []*AstNode `json:"children,omitempty"`
+}
+
+func new_ast_node(kind string, value string, token *lexemes) *AstNode {
+	node := &AstNode{Kind: kind, Value: value}
+	if token != nil {
+		node.Line = token.line
+		node.Column = token.column_number()
+	}
+	return node
+}
+
+func (a *AstNode) add(children ...*AstNode) {
+	for _, child := range children {
+		if child != nil {
+			a.Children = append(a.Children, child)
+		}
+	}
+}
+
+func build_ast(node interface{}) *AstNode {
+	switch v := node.(type) {
+	case *Block:
+		ast := &AstNode{Kind: "Block"}
+		for _, decl := range v.declaration_list.elem {
+			ast.add(build_ast(decl))
+		}
+		compound := build_ast(v.compound)
+		ast.add(compound)
+		if len(ast.Children) > 0 {
+			ast.Line = ast.Children[0].Line
+			ast.Column = ast.Children[0].Column
+		}
+		return ast
+	case *ProcedureDecl:
+		ast := new_ast_node("ProcedureDecl", v.proc_name, v.token)
+		for _, param := range v.params {
+			ast.add(new_ast_node("Param", param.var_name.token.tstring+" : "+param.var_type.sstring, param.var_name.token))
+		}
+		if v.result != nil {
+			ast.add(new_ast_node("Result", v.result.sstring, v.token))
+		}
+		if v.block != nil {
+			ast.add(build_ast(v.block))
+		}
+		return ast
+	case *Uses:
+		ast := new_ast_node("Uses", "", v.token)
+		for _, name := range v.names {
+			ast.add(new_ast_node("Unit", name.tstring, name))
+		}
+		return ast
+	case *VarDeclaration:
+		return new_ast_node("VarDecl", v.token.tstring+" : "+v.spec.sstring, v.token)
+	case *TypeDeclaration:
+		kind := " = PROCEDURE"
+		if v.result != nil {
+			kind = " = FUNCTION : " + v.result.sstring
+		}
+		ast := new_ast_node("TypeDecl", v.token.tstring+kind, v.token)
+		for _, param := range v.params {
+			ast.add(new_ast_node("Param", param.var_name.token.tstring+" : "+param.var_type.sstring, param.var_name.token))
+		}
+		return ast
+	case *Compound:
+		ast := new_ast_node("Compound", "", v.token)
+		for _, elem := range v.elem {
+			ast.add(build_ast(elem))
+		}
+		return ast
+	case *Assign:
+		ast := new_ast_node("Assign", v.token.tstring, v.token)
+		ast.add(build_ast(v.variable), build_ast(v.expr))
+		return ast
+	case *ProcedureCall:
+		ast := new_ast_node("ProcedureCall", v.proc_name, v.token)
+		for _, arg := range v.args {
+			ast.add(build_ast(arg))
+		}
+		return ast
+	case *While:
+		ast := new_ast_node("While", "", v.token)
+		ast.add(build_ast(v.condition), build_ast(v.body))
+		return ast
+	case *If:
+		ast := new_ast_node("If", "", v.token)
+		ast.add(build_ast(v.condition), build_ast(v.then_branch))
+		if v.else_branch != nil {
+			ast.add(build_ast(v.else_branch))
+		}
+		return ast
+	case *Try:
+		ast := new_ast_node("Try", "", v.token)
+		ast.add(build_ast(v.body))
+		for _, handler := range v.all_handlers() {
+			text := ""
+			if handler.class != nil {
+				text = handler.class.tstring
+			}
+			if handler.name != nil {
+				text = handler.name.tstring + " : " + text
+			}
+			node := new_ast_node("Handler", text, handler.token)
+			node.add(build_ast(handler.body))
+			ast.add(node)
+		}
+		if v.finally != nil {
+			ast.add(build_ast(v.finally))
+		}
+		return ast
+	case *Raise:
+		text := ""
+		if v.class != nil {
+			text = v.class.tstring
+		}
+		return new_ast_node("Raise", text, v.token)
+	case *ClassDeclaration:
+		text := v.token.tstring + " = CLASS"
+		if v.parent != nil {
+			text += "(" + v.parent.tstring + ")"
+		}
+		ast := new_ast_node("ClassDecl", text, v.token)
+		for _, member := range v.members {
+			ast.add(build_ast(member.decl))
+		}
+		return ast
+	case *Designator:
+		ast := new_ast_node("Designator", v.token.tstring, v.token)
+		ast.add(build_ast(v.object))
+		for _, arg := range v.args {
+			ast.add(build_ast(arg))
+		}
+		return ast
+	case *MemberAssign:
+		ast := new_ast_node("MemberAssign", "", v.token)
+		ast.add(build_ast(v.target), build_ast(v.expr))
+		return ast
+	case *Inherited:
+		text := ""
+		if v.name != nil {
+			text = v.name.tstring
+		}
+		ast := new_ast_node("Inherited", text, v.token)
+		for _, arg := range v.args {
+			ast.add(build_ast(arg))
+		}
+		return ast
+	case *Nil:
+		return new_ast_node("Nil", "", v.token)
+	case *Index:
+		ast := new_ast_node("Index", v.token.tstring, v.token)
+		ast.add(build_ast(v.index))
+		return ast
+	case *IndexAssign:
+		ast := new_ast_node("IndexAssign", "", v.token)
+		ast.add(build_ast(v.target), build_ast(v.expr))
+		return ast
+	case *BuiltinCall:
+		ast := new_ast_node("BuiltinCall", v.token.tstring, v.token)
+		for _, arg := range v.args {
+			ast.add(build_ast(arg))
+		}
+		return ast
+	case *Node:
+		switch token := v.token.(type) {
+		case *Op:
+			kind := "BinOp"
+			if v.left == nil {
+				kind = "UnaryOp"
+			}
+			ast := new_ast_node(kind, token.token.tstring, token.token)
+			if v.left != nil {
+				ast.add(build_ast(v.left))
+			}
+			if v.right != nil {
+				ast.add(build_ast(v.right))
+			}
+			return ast
+		default:
+			return build_ast(token)
+		}
+	case *Var:
+		return new_ast_node("Var", v.token.tstring, v.token)
+	case *Number:
+		return new_ast_node("Number", v.token.tstring, v.token)
+	case *Str:
+		return new_ast_node("String", v.token.tstring, v.token)
+	case nil:
+		return &AstNode{Kind: "NoOp"}
+	default:
+		return &AstNode{Kind: fmt.Sprintf("%T", v)}
+	}
+}
+
+func dump_ast(w io.Writer, tree *Block, format string) error {
+	ast := build_ast(tree)
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(ast)
+	case "dot":
+		fmt.Fprintln(w, "digraph AST {")
+		fmt.Fprintln(w, "	node [shape=box];")
+		count := 0
+		dump_dot(w, ast, &count)
+		fmt.Fprintln(w, "}")
+		return nil
+	case "sexpr":
+		dump_sexpr(w, ast, 0)
+		fmt.Fprintln(w)
+		return nil
+	default:
+		return fmt.Errorf("unknown -dump-ast format '%s', want json, dot or sexpr", format)
+	}
+}
+
+var dot_escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+func dump_dot(w io.Writer, ast *AstNode, count *int) int {
+	id := *count
+	*count++
+	label := ast.Kind
+	if ast.Value != "" {
+		label += "\\n" + dot_escaper.Replace(ast.Value)
+	}
+	fmt.Fprintf(w, "	n%d [label=\"%s\\n%d:%d\"];\n", id, label, ast.Line, ast.Column)
+	for _, child := range ast.Children {
+		child_id := dump_dot(w, child, count)
+		fmt.Fprintf(w, "	n%d -> n%d;\n", id, child_id)
+	}
+	return id
+}
+
+func dump_sexpr(w io.Writer, ast *AstNode, depth int) {
+	fmt.Fprintf(w, "%s(%s", strings.Repeat("  ", depth), ast.Kind)
+	if ast.Value != "" {
+		fmt.Fprintf(w, " %q", ast.Value)
+	}
+	fmt.Fprintf(w, " @%d:%d", ast.Line, ast.Column)
+	for _, child := range ast.Children {
+		fmt.Fprintln(w)
+		dump_sexpr(w, child, depth+1)
+	}
+	fmt.Fprint(w, ")")
+}
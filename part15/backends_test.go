@@ -0,0 +1,494 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* Programs besides the samples, wrapping INTEGER and ending in the runtime errors every backend must raise as the interpreter does */
+var parity_programs = []struct {
+	name   string
+	source string
+}{
+	{"wrap.pas", `PROGRAM Wrap;
+VAR x, y : INTEGER;
+    r : REAL;
+PROCEDURE Show(n : INTEGER);
+BEGIN
+   WRITELN('show ', n)
+END;
+BEGIN
+   x := 2147483647;
+   y := x + 1;
+   WRITELN(y, ' ', x * x, ' ', -y, ' ', x * 2 DIV 2, ' ', y - 1, ' ', +2147483648);
+   r := 3000000000.7;
+   x := r;
+   WRITELN('stored ', x);
+   x := 4294967301;
+   WRITELN('literal ', x);
+   Show(r);
+   Show(2147483648)
+END.
+`},
+	{"short.pas", `{$MODE TP}
+PROGRAM Short;
+VAR x : INTEGER;
+BEGIN
+   x := 32767;
+   x := x + 1;
+   WRITELN(x, ' ', x * 3);
+   x := 40000;
+   WRITELN(x)
+END.
+`},
+	{"overflow.pas", `{$Q+}
+PROGRAM Overflow;
+VAR x : INTEGER;
+BEGIN
+   x := 2147483647;
+   WRITELN('before ', x - 1 + 1);
+   x := x * 2;
+   WRITELN('after ', x)
+END.
+`},
+	{"negate.pas", `{$Q+}
+PROGRAM Negate;
+VAR x : INTEGER;
+BEGIN
+   x := -2147483647 - 1;
+   WRITELN('before ', x);
+   x := -x;
+   WRITELN('after ', x)
+END.
+`},
+	{"sign.pas", `PROGRAM Sign;
+VAR x : INTEGER;
+BEGIN
+   x := -2147483647 - 1;
+   WRITELN(+2147483648, ' ', - -2147483648, ' ', - -x, ' ', 1 - -x, ' ', 1 + -x)
+END.
+`},
+	{"signed.pas", `{$Q+}
+PROGRAM Signed;
+VAR x : INTEGER;
+BEGIN
+   x := -2147483647 - 1;
+   WRITELN('before ', - -2147483647);
+   x := - -x;
+   WRITELN('after ', x)
+END.
+`},
+	{"names.pas", `PROGRAM Names;
+PROCEDURE A;
+   PROCEDURE B;
+   BEGIN
+      WRITELN('nested')
+   END;
+BEGIN
+   B
+END;
+PROCEDURE A_B;
+BEGIN
+   WRITELN('global')
+END;
+PROCEDURE Main;
+BEGIN
+   WRITELN('main')
+END;
+PROCEDURE Writeln_int;
+BEGIN
+   WRITELN('writeln')
+END;
+BEGIN
+   A;
+   A_B;
+   Main;
+   Writeln_int
+END.
+`},
+	{"range.pas", `{$R+}
+PROGRAM Range;
+VAR x : INTEGER;
+    r : REAL;
+PROCEDURE Show(n : INTEGER);
+BEGIN
+   WRITELN('show ', n)
+END;
+BEGIN
+   r := 2147483647.0;
+   x := r;
+   Show(r);
+   r := r + 1.0;
+   Show(r)
+END.
+`},
+	{"divide.pas", `PROGRAM Divide;
+VAR x, y : INTEGER;
+PROCEDURE P(n : INTEGER);
+BEGIN
+   WRITELN('before ', 7 DIV 2);
+   x := 10 DIV n;
+   WRITELN('after ', x)
+END;
+BEGIN
+   y := 0;
+   P(y)
+END.
+`},
+	{"modulo.pas", `PROGRAM Modulo;
+VAR x, y : INTEGER;
+BEGIN
+   y := 3;
+   WRITELN('before ', 7 MOD y);
+   y := y - 3;
+   x := 7 MOD y;
+   WRITELN('after ', x)
+END.
+`},
+	{"quotient.pas", `PROGRAM Quotient;
+VAR r, zero : REAL;
+BEGIN
+   zero := 0.0;
+   WRITELN('before ', 7 / 2);
+   r := 1.5 / zero;
+   WRITELN('after ', r)
+END.
+`},
+}
+
+/* The samples and the parity programs, all written out as files */
+func parity_files(t *testing.T) []string {
+	t.Helper()
+	files := samples(t)
+	for _, program := range parity_programs {
+		files = append(files, write_program(t, program.name, program.source))
+	}
+	return files
+}
+
+/* Runs the program at path through a backend, refused is set for programs it does not take */
+type Backend func(t *testing.T, path string) (run Run, refused bool)
+
+/* Every program must print the same and exit the same through backend as on the interpreter */
+func compare_backend(t *testing.T, backend Backend) {
+	for _, path := range parity_files(t) {
+		path := path
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			want := run_pascal(t, "", path)
+			got, refused := backend(t, path)
+			if refused == true {
+				t.Skip("interpreter only")
+			}
+			if got.stdout != want.stdout || got.code != want.code {
+				t.Errorf("got exit %d with\n%s%s\nwant exit %d with\n%s%s", got.code, got.stdout, got.stderr, want.code, want.stdout, want.stderr)
+			}
+		})
+	}
+}
+
+/* Emits the program at path for language, refused when the program only runs on the interpreter */
+func emitted(t *testing.T, path string, language string) (string, bool) {
+	t.Helper()
+	run := run_pascal(t, "", "-emit", language, path)
+	if run.code != 0 && strings.Contains(run.stderr, "only run on the interpreter") == true {
+		return "", true
+	}
+	if run.code != 0 {
+		t.Fatalf("-emit %s failed: %s", language, run.stderr)
+	}
+	return run.stdout, false
+}
+
+/* Runs a compiled program, the exit code being part of what is compared */
+func run_binary(t *testing.T, name string, args ...string) Run {
+	t.Helper()
+	command := exec.Command(name, args...)
+	var stdout, stderr strings.Builder
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	err := command.Run()
+	code := 0
+	if exit, ok := err.(*exec.ExitError); ok == true {
+		code = exit.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return Run{stdout.String(), stderr.String(), code}
+}
+
+func interpreter_backend(t *testing.T, path string) (Run, bool) {
+	return run_pascal(t, "", path), false
+}
+
+func vm_backend(t *testing.T, path string) (Run, bool) {
+	run := run_pascal(t, "", "-vm", path)
+	return run, run.code != 0 && strings.Contains(run.stderr, "only run on the interpreter") == true
+}
+
+func TestVmMatchesInterpreter(t *testing.T) {
+	compare_backend(t, vm_backend)
+}
+
+/* Looks a tool up on PATH, skipping the test when it is not installed */
+func tool(t *testing.T, name string) string {
+	t.Helper()
+	path, err := exec.LookPath(name)
+	if err != nil {
+		t.Skipf("%s is not installed", name)
+	}
+	return path
+}
+
+/* Builds the emitted Go source with the go command and runs it */
+func go_backend(t *testing.T, path string) (Run, bool) {
+	golang := tool(t, "go")
+	source, refused := emitted(t, path, "go")
+	if refused == true {
+		return Run{}, true
+	}
+	program := write_program(t, "main.go", source)
+	binary := filepath.Join(filepath.Dir(program), "program")
+	if build := run_binary(t, golang, "build", "-o", binary, program); build.code != 0 {
+		t.Fatalf("emitted Go does not build:\n%s\n%s", build.stderr, source)
+	}
+	return run_binary(t, binary), false
+}
+
+func TestGoMatchesInterpreter(t *testing.T) {
+	compare_backend(t, go_backend)
+}
+
+/* Compiles the emitted C with the system compiler and runs it */
+func c_backend(t *testing.T, path string) (Run, bool) {
+	compiler := tool(t, "cc")
+	source, refused := emitted(t, path, "c")
+	if refused == true {
+		return Run{}, true
+	}
+	program := write_program(t, "program.c", source)
+	binary := filepath.Join(filepath.Dir(program), "program")
+	if build := run_binary(t, compiler, "-std=c99", "-Wall", "-Werror", "-o", binary, program); build.code != 0 {
+		t.Fatalf("emitted C does not compile:\n%s\n%s", build.stderr, source)
+	}
+	return run_binary(t, binary), false
+}
+
+func TestCMatchesInterpreter(t *testing.T) {
+	compare_backend(t, c_backend)
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files of the emitters from their current output")
+
+/* Compares what language is emitted for each sample with testdata/language, returning the emitted sources */
+func golden(t *testing.T, language string, extension string) map[string]string {
+	t.Helper()
+	sources := make(map[string]string)
+	for _, sample := range samples(t) {
+		source, refused := emitted(t, sample, language)
+		if refused == true {
+			continue
+		}
+		sources[sample] = source
+		path := filepath.Join("testdata", language, strings.TrimSuffix(sample, ".pas")+extension)
+		if *update == true {
+			os.MkdirAll(filepath.Dir(path), 0755)
+			if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%v, run go test -args -update to write it", err)
+		}
+		if source != string(want) {
+			t.Errorf("%s emits other than %s, run go test -args -update if that is intended:\n%s", sample, path, source)
+		}
+	}
+	if len(sources) == 0 {
+		t.Fatalf("no sample emits %s", language)
+	}
+	return sources
+}
+
+/* The modules must be well formed s-expressions importing the host and exporting main */
+func TestWatGolden(t *testing.T) {
+	for sample, source := range golden(t, "wat", ".wat") {
+		depth := 0
+		quoted := false
+		for index := 0; index < len(source); index++ {
+			switch c := source[index]; {
+			case quoted == true && c == '\\':
+				index++
+			case c == '"':
+				quoted = !quoted
+			case quoted == true:
+			case c == '(':
+				depth++
+			case c == ')':
+				depth--
+				if depth == 0 && strings.TrimSpace(source[index+1:]) != "" {
+					t.Errorf("%s has text after the module", sample)
+				}
+			}
+			if depth < 0 {
+				t.Fatalf("%s closes more than it opens", sample)
+			}
+		}
+		if depth != 0 || quoted == true {
+			t.Errorf("%s leaves %d parentheses open", sample, depth)
+		}
+		for _, want := range []string{"(module\n", `(import "host" "write_int"`, `(import "host" "writeln"`, `(import "host" "readln"`, `(memory (export "memory")`, `(export "main" (func $main))`} {
+			if strings.Contains(source, want) == false {
+				t.Errorf("%s lacks %s", sample, want)
+			}
+		}
+	}
+}
+
+/* WebAssembly cannot raise runtime errors, so checked code is refused rather than left to wrap */
+func TestWatRefusesChecks(t *testing.T) {
+	for _, program := range parity_programs {
+		if strings.Contains(program.source, "{$Q+}") == false && strings.Contains(program.source, "{$R+}") == false {
+			continue
+		}
+		run := run_pascal(t, "", "-emit", "wat", write_program(t, program.name, program.source))
+		if run.code == 0 || strings.Contains(run.stderr, "cannot fail in WebAssembly") == false {
+			t.Errorf("%s emitted with exit %d:\n%s", program.name, run.code, run.stderr)
+		}
+	}
+}
+
+func TestLlvmGolden(t *testing.T) {
+	golden(t, "llvm", ".ll")
+}
+
+/* Runs the emitted IR on the LLVM interpreter */
+func llvm_backend(t *testing.T, path string) (Run, bool) {
+	lli := tool(t, "lli")
+	source, refused := emitted(t, path, "llvm")
+	if refused == true {
+		return Run{}, true
+	}
+	program := write_program(t, "program.ll", source)
+	/* LLVM 14 reads ptr only when asked to, later releases no longer know the option */
+	run := run_binary(t, lli, "-opaque-pointers", program)
+	if strings.Contains(run.stderr, "Unknown command line argument") == true {
+		run = run_binary(t, lli, program)
+	}
+	return run, false
+}
+
+func TestLlvmMatchesInterpreter(t *testing.T) {
+	compare_backend(t, llvm_backend)
+}
+
+/* Nested procedures reach variables through the static link to the live activation of their enclosing procedure */
+var nested_programs = []struct {
+	name   string
+	source string
+	want   string
+}{
+	{"shadow.pas", `PROGRAM Shadow;
+VAR a : INTEGER;
+PROCEDURE P1(a : INTEGER);
+VAR b : INTEGER;
+   PROCEDURE P2(a : INTEGER);
+      PROCEDURE P3;
+      BEGIN
+         WRITELN('p3 a = ', a, ' b = ', b);
+         b := b + a
+      END;
+   BEGIN
+      P3;
+      WRITELN('p2 a = ', a)
+   END;
+BEGIN
+   b := 100;
+   P2(a * 2);
+   WRITELN('p1 a = ', a, ' b = ', b)
+END;
+BEGIN
+   a := 5;
+   P1(a + 1);
+   WRITELN('global a = ', a)
+END.
+`, "p3 a = 12 b = 100\np2 a = 12\np1 a = 6 b = 112\nglobal a = 5\n"},
+	{"recursive.pas", `PROGRAM Recursive;
+VAR total : INTEGER;
+PROCEDURE Count(n : INTEGER);
+VAR depth : INTEGER;
+   PROCEDURE Add;
+   BEGIN
+      total := total + depth
+   END;
+BEGIN
+   depth := n;
+   IF n > 0 THEN
+   BEGIN
+      Count(n - 1);
+      Add;
+      WRITELN('depth ', depth, ' total ', total)
+   END
+END;
+BEGIN
+   total := 0;
+   Count(3)
+END.
+`, "depth 1 total 1\ndepth 2 total 3\ndepth 3 total 6\n"},
+	{"outward.pas", `PROGRAM Outward;
+VAR a : INTEGER;
+PROCEDURE Outer(a : INTEGER);
+   PROCEDURE Show(tag : INTEGER);
+   BEGIN
+      WRITELN('show ', tag, ' a = ', a)
+   END;
+   PROCEDURE Middle(a : INTEGER);
+      PROCEDURE Inner;
+      BEGIN
+         Show(a)
+      END;
+   BEGIN
+      Inner
+   END;
+BEGIN
+   Middle(a + 10)
+END;
+BEGIN
+   a := 1;
+   Outer(2)
+END.
+`, "show 12 a = 2\n"},
+}
+
+func TestNestedScopes(t *testing.T) {
+	backends := []struct {
+		name    string
+		backend Backend
+	}{
+		{"interpreter", interpreter_backend},
+		{"vm", vm_backend},
+		{"go", go_backend},
+		{"c", c_backend},
+		{"llvm", llvm_backend},
+	}
+	for _, program := range nested_programs {
+		path := write_program(t, program.name, program.source)
+		for _, backend := range backends {
+			program, backend := program, backend
+			t.Run(program.name+" "+backend.name, func(t *testing.T) {
+				run, refused := backend.backend(t, path)
+				if refused == true {
+					t.Fatal("nested procedures must run on every backend")
+				}
+				if run.stdout != program.want || run.code != 0 {
+					t.Errorf("got exit %d with\n%s%s\nwant\n%s", run.code, run.stdout, run.stderr, program.want)
+				}
+			})
+		}
+	}
+}
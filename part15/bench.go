@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+	BENCHMARK
+*/
+
+func time_runs(runs int, run func()) time.Duration {
+	start := time.Now()
+	for index := 0; index < runs; index++ {
+		run()
+	}
+	return time.Since(start) / time.Duration(runs)
+}
+
+/* Runs a program on both backends, checks they agree on every global and compares timings */
+func run_bench(path string, args []string, defines []string, out io.Writer) (err error) {
+	defer catch_compile_error(&err)
+	runs := 20
+	if len(args) > 0 {
+		if runs, err = strconv.Atoi(args[0]); err != nil {
+			return err
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	tokens := preprocess(file, path, defines)
+	parser := rules{lexer{0, len(tokens), tokens}}
+	tree := parser.Parse()
+	if program_uses(tree) != nil {
+		return fmt.Errorf("units only run on the interpreter, bench needs the virtual machine too")
+	}
+	if feature := interpreter_only(tree); feature != "" {
+		return fmt.Errorf("%s only run on the interpreter, bench needs the virtual machine too", feature)
+	}
+	symbol_table := new_global_scope()
+	analyser := SemanticsAnalyser{symbol_table, nil}
+	analyser.check(tree)
+	bytecode := compile_program(tree, symbol_table)
+
+	var failure error
+	walker := time_runs(runs, func() {
+		interpreter := new_interpreter(symbol_table)
+		interpreter.out = io.Discard
+		failure = interpreter.execute(context.Background(), tree)
+		interpreter.export(symbol_table)
+	})
+	if failure != nil {
+		return failure
+	}
+	expected := make(map[string]float64)
+	for _, variable := range scope_variables(symbol_table) {
+		expected[variable.name] = variable.value
+	}
+	var vm *VM
+	machine := time_runs(runs, func() {
+		vm = new_vm(bytecode)
+		vm.out = io.Discard
+		failure = vm.execute(context.Background())
+	})
+	if failure != nil {
+		return failure
+	}
+	vm.export(symbol_table)
+	for _, variable := range scope_variables(symbol_table) {
+		if variable.value != expected[variable.name] {
+			return fmt.Errorf("%s: tree walker computed %v, vm computed %v", variable.name, expected[variable.name], variable.value)
+		}
+	}
+	fmt.Fprintf(out, "%-12s %12s/run\n", "tree walker", walker)
+	fmt.Fprintf(out, "%-12s %12s/run\n", "vm", machine)
+	fmt.Fprintf(out, "speedup      %11.2fx\n", float64(walker)/float64(machine))
+	return nil
+}
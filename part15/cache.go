@@ -0,0 +1,593 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+	UNIT CACHE
+
+	An analysed unit is stored as JSON under a hash of its path and defines
+	followed by a hash of the cache version and its source. The entry records
+	the hashes of its includes and the keys of the units it uses, and is only
+	loaded while they all still match, so a change anywhere below a unit
+	analyses it again. A unit's key covers all of that, so the change carries
+	on up to every unit using it. An entry that no longer matches is
+	removed, and storing a unit removes the entries of its older sources and
+	those left by other versions.
+
+	Symbols are numbered scope by scope, in the order the scopes were opened
+	and by name within each, which gives the same numbers whether a unit was
+	analysed or loaded. Calls refer to their procedure by unit and number.
+*/
+
+const UNIT_CACHE_VERSION = 2
+
+type UnitCache struct {
+	dir     string
+	rebuild bool
+	hits    int
+	misses  int
+}
+
+type CachedToken struct {
+	Type   int    `json:"t"`
+	Text   string `json:"s"`
+	Line   int    `json:"l"`
+	Column int    `json:"c"`
+	Checks int    `json:"k,omitempty"`
+	File   string `json:"f,omitempty"`
+}
+
+type CachedRef struct {
+	Unit string `json:"u,omitempty"`
+	Id   int    `json:"i"`
+}
+
+/* Tokens are 1 based indices into CachedUnit.Tokens */
+type CachedNode struct {
+	Kind   string        `json:"k"`
+	Tokens []int         `json:"t,omitempty"`
+	Text   string        `json:"s,omitempty"`
+	Nodes  []*CachedNode `json:"n,omitempty"`
+	Symbol *CachedRef    `json:"y,omitempty"`
+}
+
+type CachedSymbol struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Type   string `json:"type,omitempty"`
+	Token  int    `json:"token"`
+	Params []int  `json:"params,omitempty"`
+	Scope  int    `json:"scope,omitempty"`
+	/* a CONST parameter */
+	Constant bool `json:"constant,omitempty"`
+}
+
+/* Parent is an index into CachedUnit.Scopes, the first scope being the unit's own */
+type CachedScope struct {
+	Name    string         `json:"name"`
+	Level   int            `json:"level"`
+	Parent  int            `json:"parent"`
+	Symbols []CachedSymbol `json:"symbols"`
+}
+
+type CachedUnit struct {
+	Version        int               `json:"version"`
+	Name           string            `json:"name"`
+	Key            string            `json:"key"`
+	Token          int               `json:"token"`
+	Uses           []int             `json:"uses"`
+	UsesToken      int               `json:"uses_token"`
+	Dependencies   []string          `json:"dependencies"`
+	Includes       map[string]string `json:"includes"`
+	Tokens         []CachedToken     `json:"tokens"`
+	Exports        []*CachedNode     `json:"exports"`
+	Implementation []*CachedNode     `json:"implementation"`
+	Initialization *CachedNode       `json:"initialization"`
+	Finalization   *CachedNode       `json:"finalization"`
+	Scopes         []CachedScope     `json:"scopes"`
+}
+
+func default_cache_dir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pascal-units")
+}
+
+func hash_text(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(hash, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+/* Names the cache entries of a unit source, whatever it holds */
+func source_location(path string, defines []string) string {
+	sorted := make([]string, len(defines))
+	for index, define := range defines {
+		sorted[index] = strings.ToUpper(define)
+	}
+	sort.Strings(sorted)
+	return hash_text(path, strings.Join(sorted, ","))
+}
+
+/* Names the cache entry of a unit source */
+func source_key(path string, content []byte, defines []string) string {
+	return source_location(path, defines) + "-" + hash_text(fmt.Sprint(UNIT_CACHE_VERSION), string(content))
+}
+
+/* Hash of each include as it is now, "" for one that cannot be read */
+func include_hashes(included []string) map[string]string {
+	hashes := make(map[string]string)
+	for _, path := range included {
+		content, err := os.ReadFile(path)
+		if err == nil {
+			hashes[path] = hash_text(string(content))
+		} else {
+			hashes[path] = ""
+		}
+	}
+	return hashes
+}
+
+func unit_key(path string, content []byte, defines []string, unit *Unit) string {
+	parts := []string{source_key(path, content, defines)}
+	for _, dependency := range unit.uses.units {
+		parts = append(parts, dependency.key)
+	}
+	hashes := include_hashes(unit.included)
+	for _, include := range unit.included {
+		parts = append(parts, include, hashes[include])
+	}
+	return hash_text(parts...)
+}
+
+/* Scopes in the order they were opened and the symbols declared in them, builtins left out */
+func number_symbols(top *ScopedSymbolTable) ([]*ScopedSymbolTable, []Symbol) {
+	scopes := []*ScopedSymbolTable{top}
+	for index := 0; index < len(scopes); index++ {
+		scopes = append(scopes, scopes[index].inferior_scope...)
+	}
+	symbols := []Symbol{}
+	for _, scope := range scopes {
+		names := []string{}
+		for name, symbol := range scope.symbols {
+			if _, builtin := symbol.(*BuiltinSymbol); builtin == false {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			symbols = append(symbols, scope.symbols[name])
+		}
+	}
+	return scopes, symbols
+}
+
+func (c *UnitCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+/* The unit as cached, nil when there is no entry still matching its dependencies and includes */
+func (c *UnitCache) fetch(l *UnitLoader, name string, path string, content []byte) *Unit {
+	if c == nil {
+		return nil
+	}
+	c.misses++
+	if c.rebuild == true {
+		return nil
+	}
+	entry := c.path(source_key(path, content, l.defines))
+	file, err := os.Open(entry)
+	if err != nil {
+		return nil
+	}
+	cached := &CachedUnit{}
+	err = json.NewDecoder(file).Decode(cached)
+	file.Close()
+	if err != nil || cached.Version != UNIT_CACHE_VERSION || cached.Name != name {
+		os.Remove(entry)
+		return nil
+	}
+	d := &UnitDecoder{cached, make([]lexemes, len(cached.Tokens)), nil, l}
+	for index, token := range cached.Tokens {
+		d.tokens[index] = lexemes{token.Type, token.Text, token.Line, token.Column, token.Checks, token.File}
+	}
+	uses := &Uses{d.token(cached.UsesToken), nil, nil, nil}
+	for _, name := range cached.Uses {
+		uses.names = append(uses.names, d.token(name))
+	}
+	l.resolve(uses, filepath.Dir(path))
+	if len(uses.units) != len(cached.Dependencies) {
+		os.Remove(entry)
+		return nil
+	}
+	for index, dependency := range uses.units {
+		if dependency.key != cached.Dependencies[index] {
+			os.Remove(entry)
+			return nil
+		}
+	}
+	included := []string{}
+	for include := range cached.Includes {
+		included = append(included, include)
+	}
+	for include, hash := range include_hashes(included) {
+		if hash == "" || hash != cached.Includes[include] {
+			os.Remove(entry)
+			return nil
+		}
+	}
+	unit := d.unit(uses)
+	unit.included = included
+	unit.key = cached.Key
+	c.misses--
+	c.hits++
+	return unit
+}
+
+/* Writes the analysed unit to the cache, which is only a speed up and so fails silently */
+func (c *UnitCache) store(unit *Unit, path string, content []byte, defines []string) {
+	/* what only the interpreter runs is not encoded, units using it are analysed every time */
+	if c == nil || interpreter_only(&Block{unit.exports, nil}) != "" || interpreter_only(&Block{unit.implementation, unit.initialization}) != "" || interpreter_only(unit.finalization) != "" {
+		return
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return
+	}
+	file, err := os.CreateTemp(c.dir, "unit-*.tmp")
+	if err != nil {
+		return
+	}
+	err = json.NewEncoder(file).Encode(encode_unit(unit))
+	if close_err := file.Close(); err == nil {
+		err = close_err
+	}
+	key := source_key(path, content, defines)
+	if err != nil || os.Rename(file.Name(), c.path(key)) != nil {
+		os.Remove(file.Name())
+		return
+	}
+	c.prune(source_location(path, defines), key)
+}
+
+/* Removes the entries of the unit at location but the one named key, and those named as no version names them now */
+func (c *UnitCache) prune(location string, key string) {
+	entries, _ := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(filepath.Base(entry), ".json")
+		if name != key && (strings.HasPrefix(name, location+"-") == true || strings.Contains(name, "-") == false) {
+			os.Remove(entry)
+		}
+	}
+}
+
+func (c *UnitCache) report(out io.Writer) {
+	total := c.hits + c.misses
+	if total == 0 {
+		fmt.Fprintln(out, "unit cache: no units")
+		return
+	}
+	fmt.Fprintf(out, "unit cache: %d of %d units loaded from %s (%d%%)\n", c.hits, total, c.dir, 100*c.hits/total)
+}
+
+/*
+	ENCODING
+*/
+
+type UnitEncoder struct {
+	tokens []CachedToken
+	index  map[*lexemes]int
+	refs   map[Symbol]*CachedRef
+}
+
+func encode_unit(unit *Unit) *CachedUnit {
+	e := &UnitEncoder{nil, make(map[*lexemes]int), make(map[Symbol]*CachedRef)}
+	for _, dependency := range unit.uses.units {
+		_, symbols := number_symbols(dependency.scope)
+		for id, symbol := range symbols {
+			e.refs[symbol] = &CachedRef{dependency.name, id}
+		}
+	}
+	scopes, symbols := number_symbols(unit.scope)
+	for id, symbol := range symbols {
+		e.refs[symbol] = &CachedRef{"", id}
+	}
+	cached := &CachedUnit{Version: UNIT_CACHE_VERSION, Name: unit.name, Key: unit.key, Token: e.token(unit.token)}
+	cached.UsesToken = e.token(unit.uses.token)
+	for index, name := range unit.uses.names {
+		cached.Uses = append(cached.Uses, e.token(name))
+		cached.Dependencies = append(cached.Dependencies, unit.uses.units[index].key)
+	}
+	cached.Includes = include_hashes(unit.included)
+	for _, decl := range unit.exports.elem {
+		cached.Exports = append(cached.Exports, e.node(decl))
+	}
+	for _, decl := range unit.implementation.elem {
+		cached.Implementation = append(cached.Implementation, e.node(decl))
+	}
+	cached.Initialization = e.node(unit.initialization)
+	cached.Finalization = e.node(unit.finalization)
+	numbers := make(map[*ScopedSymbolTable]int)
+	for index, scope := range scopes {
+		numbers[scope] = index
+	}
+	for index, scope := range scopes {
+		parent := -1
+		if index > 0 {
+			parent = numbers[scope.enclosing_scope]
+		}
+		cached_scope := CachedScope{scope.scope_name, scope.scope_level, parent, []CachedSymbol{}}
+		_, mine := number_symbols(&ScopedSymbolTable{scope.symbols, "", 0, nil, nil, nil})
+		for _, symbol := range mine {
+			switch v := symbol.(type) {
+			case *VarSymbol:
+				cached_scope.Symbols = append(cached_scope.Symbols, CachedSymbol{"var", v.name, v.stype.name, e.token(v.token), nil, 0, v.constant})
+			case *ProcedureSymbol:
+				params := []int{}
+				for _, param := range v.params {
+					params = append(params, e.refs[param].Id)
+				}
+				cached_scope.Symbols = append(cached_scope.Symbols, CachedSymbol{"proc", v.name, "", e.token(v.token), params, numbers[v.scope], false})
+			}
+		}
+		cached.Scopes = append(cached.Scopes, cached_scope)
+	}
+	cached.Tokens = e.tokens
+	return cached
+}
+
+func (e *UnitEncoder) token(token *lexemes) int {
+	if token == nil {
+		return 0
+	}
+	if index, ok := e.index[token]; ok == true {
+		return index
+	}
+	e.tokens = append(e.tokens, CachedToken{token.ttype, token.tstring, token.line, token.column, token.checks, token.file})
+	e.index[token] = len(e.tokens)
+	return len(e.tokens)
+}
+
+func (e *UnitEncoder) node(node interface{}) *CachedNode {
+	switch v := node.(type) {
+	case *Block:
+		if v == nil {
+			return nil
+		}
+		cached := &CachedNode{"Block", nil, "", []*CachedNode{e.node(v.compound)}, nil}
+		for _, decl := range v.declaration_list.elem {
+			cached.Nodes = append(cached.Nodes, e.node(decl))
+		}
+		return cached
+	case *VarDeclaration:
+		return &CachedNode{"VarDeclaration", []int{e.token(v.token)}, v.spec.sstring, nil, nil}
+	case *ProcedureDecl:
+		cached := &CachedNode{"ProcedureDecl", []int{e.token(v.token)}, v.proc_name, []*CachedNode{e.node(v.block)}, nil}
+		for _, param := range v.params {
+			kind := "Param"
+			if param.constant == true {
+				kind = "ConstParam"
+			}
+			cached.Nodes = append(cached.Nodes, &CachedNode{kind, []int{e.token(param.var_name.token)}, param.var_type.sstring, nil, nil})
+		}
+		return cached
+	case *Compound:
+		cached := &CachedNode{"Compound", []int{e.token(v.token), e.token(v.end_token)}, "", nil, nil}
+		for _, elem := range v.elem {
+			cached.Nodes = append(cached.Nodes, e.node(elem))
+		}
+		return cached
+	case *Assign:
+		return &CachedNode{"Assign", []int{e.token(v.token)}, "", []*CachedNode{e.node(v.variable), e.node(v.expr)}, nil}
+	case *ProcedureCall:
+		cached := &CachedNode{"ProcedureCall", []int{e.token(v.token)}, v.proc_name, nil, nil}
+		for _, arg := range v.args {
+			cached.Nodes = append(cached.Nodes, e.node(arg))
+		}
+		if v.proc_symbol != nil {
+			cached.Symbol = e.refs[v.proc_symbol]
+		}
+		return cached
+	case *While:
+		return &CachedNode{"While", []int{e.token(v.token)}, "", []*CachedNode{e.node(v.condition), e.node(v.body)}, nil}
+	case *If:
+		return &CachedNode{"If", []int{e.token(v.token)}, "", []*CachedNode{e.node(v.condition), e.node(v.then_branch), e.node(v.else_branch)}, nil}
+	case *Node:
+		if v == nil {
+			return nil
+		}
+		return &CachedNode{"Node", nil, "", []*CachedNode{e.node(v.left), e.node(v.token), e.node(v.right)}, nil}
+	case *Var:
+		return &CachedNode{"Var", []int{e.token(v.token)}, "", nil, nil}
+	case *Op:
+		return &CachedNode{"Op", []int{e.token(v.token)}, "", nil, nil}
+	case *Number:
+		return &CachedNode{"Number", []int{e.token(v.token)}, "", nil, nil}
+	case *Str:
+		return &CachedNode{"Str", []int{e.token(v.token)}, "", nil, nil}
+	}
+	return nil
+}
+
+/*
+	DECODING
+*/
+
+type UnitDecoder struct {
+	cached  *CachedUnit
+	tokens  []lexemes
+	symbols []Symbol
+	loader  *UnitLoader
+}
+
+func (d *UnitDecoder) token(index int) *lexemes {
+	if index == 0 {
+		return nil
+	}
+	return &d.tokens[index-1]
+}
+
+/* Rebuilds the scopes, symbols and tree of the cached unit on top of the units it uses */
+func (d *UnitDecoder) unit(uses *Uses) *Unit {
+	cached := d.cached
+	unit := &Unit{d.token(cached.Token), cached.Name, uses, Elem_list{}, Elem_list{}, nil, nil, nil, nil, ""}
+	unit.scope = new_top_scope(unit.name, uses)
+	scopes := []*ScopedSymbolTable{unit.scope}
+	for _, cached_scope := range cached.Scopes[1:] {
+		parent := scopes[cached_scope.Parent]
+		scope := &ScopedSymbolTable{make(map[string]Symbol), cached_scope.Name, cached_scope.Level, parent, nil, nil}
+		parent.inferior_scope = append(parent.inferior_scope, scope)
+		scopes = append(scopes, scope)
+	}
+	for index, cached_scope := range cached.Scopes {
+		for _, cached_symbol := range cached_scope.Symbols {
+			var symbol Symbol
+			if cached_symbol.Kind == "var" {
+				stype, _ := unit.scope.lookup(cached_symbol.Type, false)
+				symbol = &VarSymbol{cached_symbol.Name, stype.(*BuiltinSymbol), 0, d.token(cached_symbol.Token), cached_symbol.Constant}
+			} else {
+				symbol = &ProcedureSymbol{cached_symbol.Name, nil, d.token(cached_symbol.Token), scopes[cached_symbol.Scope], nil, nil}
+			}
+			scopes[index].insert(symbol)
+			d.symbols = append(d.symbols, symbol)
+		}
+	}
+	id := 0
+	for _, cached_scope := range cached.Scopes {
+		for _, cached_symbol := range cached_scope.Symbols {
+			if proc, ok := d.symbols[id].(*ProcedureSymbol); ok == true {
+				proc.params = []*VarSymbol{}
+				for _, param := range cached_symbol.Params {
+					proc.params = append(proc.params, d.symbols[param].(*VarSymbol))
+				}
+			}
+			id++
+		}
+	}
+	for _, decl := range cached.Exports {
+		unit.exports.elem = append(unit.exports.elem, d.node(decl))
+	}
+	for _, decl := range cached.Implementation {
+		unit.implementation.elem = append(unit.implementation.elem, d.node(decl))
+	}
+	unit.initialization = d.node(cached.Initialization)
+	unit.finalization = d.node(cached.Finalization)
+	for _, decl := range append(append([]interface{}{}, unit.exports.elem...), unit.implementation.elem...) {
+		d.link(unit.scope, decl)
+	}
+	return unit
+}
+
+/* Points every procedure symbol at its declaration, the one with a block once it has been seen */
+func (d *UnitDecoder) link(scope *ScopedSymbolTable, node interface{}) {
+	decl, ok := node.(*ProcedureDecl)
+	if ok == false {
+		return
+	}
+	symbol, _ := scope.lookup(decl.proc_name, true)
+	proc := symbol.(*ProcedureSymbol)
+	if proc.decl == nil || decl.block != nil {
+		proc.decl = decl
+	}
+	if decl.block != nil {
+		for _, inner := range decl.block.declaration_list.elem {
+			d.link(proc.scope, inner)
+		}
+	}
+}
+
+func (d *UnitDecoder) symbol(ref *CachedRef) *ProcedureSymbol {
+	if ref == nil {
+		return nil
+	}
+	symbols := d.symbols
+	if ref.Unit != "" {
+		_, symbols = number_symbols(d.loader.units[ref.Unit].scope)
+	}
+	return symbols[ref.Id].(*ProcedureSymbol)
+}
+
+func (d *UnitDecoder) expression(cached *CachedNode) *Node {
+	if node, ok := d.node(cached).(*Node); ok == true {
+		return node
+	}
+	return nil
+}
+
+func (d *UnitDecoder) node(cached *CachedNode) interface{} {
+	if cached == nil {
+		return nil
+	}
+	var token *lexemes
+	if len(cached.Tokens) > 0 {
+		token = d.token(cached.Tokens[0])
+	}
+	switch cached.Kind {
+	case "Block":
+		block := &Block{Elem_list{}, d.node(cached.Nodes[0])}
+		for _, decl := range cached.Nodes[1:] {
+			block.declaration_list.elem = append(block.declaration_list.elem, d.node(decl))
+		}
+		return block
+	case "VarDeclaration":
+		return &VarDeclaration{token, spec_of(cached.Text)}
+	case "ProcedureDecl":
+		decl := &ProcedureDecl{token, cached.Text, nil, nil, nil}
+		if block, ok := d.node(cached.Nodes[0]).(*Block); ok == true {
+			decl.block = block
+		}
+		for _, param := range cached.Nodes[1:] {
+			decl.params = append(decl.params, Param{&Var{d.token(param.Tokens[0]), 0}, spec_of(param.Text), param.Kind == "ConstParam"})
+		}
+		return decl
+	case "Compound":
+		compound := &Compound{token, nil, d.token(cached.Tokens[1])}
+		for _, elem := range cached.Nodes {
+			compound.elem = append(compound.elem, d.node(elem))
+		}
+		return compound
+	case "Assign":
+		return &Assign{d.node(cached.Nodes[0]).(*Var), token, d.expression(cached.Nodes[1])}
+	case "ProcedureCall":
+		call := &ProcedureCall{token, cached.Text, []*Node{}, d.symbol(cached.Symbol), nil}
+		for _, arg := range cached.Nodes {
+			call.args = append(call.args, d.expression(arg))
+		}
+		return call
+	case "While":
+		return &While{token, d.expression(cached.Nodes[0]), d.node(cached.Nodes[1])}
+	case "If":
+		return &If{token, d.expression(cached.Nodes[0]), d.node(cached.Nodes[1]), d.node(cached.Nodes[2])}
+	case "Node":
+		return &Node{d.expression(cached.Nodes[0]), d.node(cached.Nodes[1]), d.expression(cached.Nodes[2])}
+	case "Var":
+		return &Var{token, 0}
+	case "Op":
+		return &Op{token}
+	case "Number":
+		return &Number{token}
+	case "Str":
+		return &Str{token}
+	}
+	return nil
+}
+
+func spec_of(name string) *Spec {
+	if name == "REAL_CONST" {
+		return &Spec{REAL_CONST, name, nil}
+	}
+	return &Spec{INTEGER_CONST, name, nil}
+}
@@ -0,0 +1,25 @@
+PROGRAM Calls;
+VAR
+   a, total : INTEGER;
+
+PROCEDURE Add(x : INTEGER);
+BEGIN
+   total := total + x
+END;
+
+PROCEDURE Twice(y : INTEGER);
+VAR
+   a : INTEGER;
+BEGIN
+   a := y * 2;
+   Add(a);
+   Add(a)
+END;
+
+BEGIN {Calls}
+   a := 5;
+   total := 0;
+   Twice(a);
+   Add(1);
+   WRITELN('total = ', total)
+END.  {Calls}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+/*
+	RUNTIME CHECKS
+
+	INTEGER is 32 bits wide, 16 under {$MODE TP} and {$MODE FPC}. Directives in comments switch checks on and off
+	for the code that follows them, both being off at the start of a file:
+
+		{$Q+}  {$OVERFLOWCHECKS ON}   arithmetic overflow is runtime error 215
+		{$R+}  {$RANGECHECKS ON}      storing a value that does not fit is runtime error 201
+		{$Q-,R-}                      wrap around silently
+
+	The preprocessor stamps every token with the switches in force where it
+	was read. The VM and the emitted programs follow the same rules through
+	integer_result and integer_store, except WebAssembly which only wraps.
+*/
+
+const (
+	CHECK_OVERFLOW = 1 << iota
+	CHECK_RANGE
+	SHORT_INTEGER
+)
+
+var check_switches = map[string]int{
+	"Q":              CHECK_OVERFLOW,
+	"OVERFLOWCHECKS": CHECK_OVERFLOW,
+	"R":              CHECK_RANGE,
+	"RANGECHECKS":    CHECK_RANGE,
+}
+
+/* Returns the checks in force after the directive comment in token, other directives are left alone */
+func apply_directive(token *lexemes, checks int) int {
+	body := strings.TrimSuffix(strings.TrimPrefix(token.tstring, "{$"), "}")
+	for _, item := range strings.Split(body, ",") {
+		item = strings.ToUpper(strings.TrimSpace(item))
+		name, state := item, ""
+		if fields := strings.Fields(item); len(fields) == 2 {
+			name, state = fields[0], fields[1]
+		} else if strings.HasSuffix(item, "+") == true || strings.HasSuffix(item, "-") == true {
+			name, state = item[:len(item)-1], item[len(item)-1:]
+		}
+		check, ok := check_switches[name]
+		if ok == false {
+			continue
+		}
+		switch state {
+		case "+", "ON":
+			checks |= check
+		case "-", "OFF":
+			checks &^= check
+		default:
+			compile_error("Lexer", token, "directive %s wants + or -", name)
+		}
+	}
+	return checks
+}
+
+/* Whether value is an INTEGER in the dialect token was written in */
+func fits_integer(token *lexemes, value float64) bool {
+	if token.checks&SHORT_INTEGER != 0 {
+		return value >= math.MinInt16 && value <= math.MaxInt16
+	}
+	return value >= math.MinInt32 && value <= math.MaxInt32
+}
+
+func wrap_integer(token *lexemes, value int64) float64 {
+	if token.checks&SHORT_INTEGER != 0 {
+		return float64(int16(value))
+	}
+	return float64(int32(value))
+}
+
+/* The width of INTEGER in the dialect token was written in */
+func integer_bits(token *lexemes) int {
+	if token.checks&SHORT_INTEGER != 0 {
+		return 16
+	}
+	return 32
+}
+
+/* Whether node computes an INTEGER with + - * DIV or MOD, a result the backends wrap or check as integer_result does */
+func integer_operation(analyser SemanticsAnalyser, node *Node, op *lexemes) bool {
+	switch op.ttype {
+	case PLUS, MINUS, MUL, INTEGER_DIV, MOD:
+		return analyser.type_of(node).name == "INTEGER_CONST"
+	}
+	return false
+}
+
+/* The INTEGER the exact result of op becomes, false when {$Q+} makes the overflow runtime error 215 */
+func integer_result(op *lexemes, result int64) (float64, bool) {
+	if fits_integer(op, float64(result)) == false && op.checks&CHECK_OVERFLOW != 0 {
+		return 0, false
+	}
+	return wrap_integer(op, result), true
+}
+
+/* The value an INTEGER variable holds after value is stored in it at token, false when {$R+} makes it runtime error 201 */
+func integer_store(token *lexemes, value float64) (float64, bool) {
+	if fits_integer(token, value) == true {
+		return value, true
+	}
+	if token.checks&CHECK_RANGE != 0 {
+		return 0, false
+	}
+	return wrap_integer(token, int64(value)), true
+}
+
+/* Whether storing node in an INTEGER at token can change it, which only REAL values and literals out of range can */
+func may_not_fit(analyser SemanticsAnalyser, node *Node, token *lexemes) bool {
+	if number, ok := node.token.(*Number); ok == true && number.token.ttype == INTEGER_CONST {
+		return fits_integer(token, number_value(number.token)) == false
+	}
+	return analyser.type_of(node).name != "INTEGER_CONST"
+}
+
+/* Whether node computes an INTEGER with + - * DIV or MOD, remembered per node */
+func (i *Interpreter) integer_arithmetic(node *Node, op *lexemes) bool {
+	integer, ok := i.integers[node]
+	if ok == false {
+		integer = integer_operation(SemanticsAnalyser{i.scope, nil}, node, op)
+		i.integers[node] = integer
+	}
+	return integer
+}
+
+/* Exact INTEGER arithmetic, failing under {$Q+} and wrapping otherwise when the result overflows */
+func (i *Interpreter) integer_op(op *lexemes, left float64, right float64) float64 {
+	a, b := int64(left), int64(right)
+	var result int64
+	switch op.ttype {
+	case PLUS:
+		result = a + b
+	case MINUS:
+		result = a - b
+	case MUL:
+		result = a * b
+	case INTEGER_DIV, MOD:
+		if b == 0 {
+			i.runtime_error(RUNTIME_DIVISION_BY_ZERO, op, "")
+		}
+		if op.ttype == INTEGER_DIV {
+			result = a / b
+		} else {
+			result = a % b
+		}
+	}
+	value, ok := integer_result(op, result)
+	if ok == false {
+		i.runtime_error(RUNTIME_OVERFLOW, op, "%d does not fit in INTEGER", result)
+	}
+	return value
+}
+
+/* The value an INTEGER variable holds after value is stored in it at token */
+func (i *Interpreter) store_integer(token *lexemes, value float64) float64 {
+	stored, ok := integer_store(token, value)
+	if ok == false {
+		i.runtime_error(RUNTIME_RANGE_CHECK, token, "%.0f is out of INTEGER range", value)
+	}
+	return stored
+}
@@ -0,0 +1,675 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+	CLASSES
+
+		class_type          : CLASS (LPAREN ID RPAREN)? class_member* END
+		class_member        : PRIVATE | PROTECTED | PUBLIC
+		                    | variable_declaration SEMI
+		                    | method_heading ((VIRTUAL | OVERRIDE) SEMI)?
+		method_heading      : (PROCEDURE | CONSTRUCTOR | DESTRUCTOR) ID (LPAREN formal_parameter_list RPAREN)? SEMI
+		designator          : ID (DOT ID)+ (LPAREN (expr (COMMA expr)*)? RPAREN)?
+		member_statement    : designator (ASSIGN expr)?
+		inherited_statement : INHERITED (ID (LPAREN (expr (COMMA expr)*)? RPAREN)?)?
+
+	A class declares its fields and the headings of its methods, whose
+	blocks follow as PROCEDURE TDog.Speak, and inherits the members of its
+	parent. The members live in a scope of the class, which lookup searches
+	the parent's from in turn, so inside a method its own and inherited
+	fields are plain names between the locals and the globals, with Self
+	the instance the method runs on. A variable of a class holds a
+	reference to an instance, NIL being none, and an instance keeps its
+	fields in a frame of its own. Every virtual method gets a slot in the
+	vtable a class copies from its parent, an override taking over the
+	slot of the method it replaces, and a call goes through the slot in
+	the vtable of the instance's class. As if every class descended from
+	TObject, Create makes a bare instance, Free destroys one through its
+	Destroy, and INHERITED Create or Destroy does nothing, unless the
+	classes declare their own. Classes only run on the interpreter.
+*/
+
+type ClassDeclaration struct {
+	token   *lexemes
+	parent  *lexemes
+	members []*MemberDecl
+}
+
+type MemberDecl struct {
+	/* PRIVATE, PROTECTED or PUBLIC */
+	visibility int
+	/* VAR for a field, PROCEDURE, CONSTRUCTOR or DESTRUCTOR for a method */
+	kind int
+	/* VIRTUAL or OVERRIDE, 0 for a static method or a field */
+	binding int
+	/* a *VarDeclaration, or the *ProcedureDecl heading of a method */
+	decl interface{}
+}
+
+/* object.name(args), or a constructor called on the class itself */
+type Designator struct {
+	token  *lexemes
+	object *Node
+	args   []*Node
+	/* filled in by the analyser: the field or method, nil for Free or a bare Create */
+	symbol Symbol
+	/* the class an instance is created of, when the object names a class */
+	create *ClassSymbol
+}
+
+type MemberAssign struct {
+	target *Designator
+	token  *lexemes
+	expr   *Node
+}
+
+type Inherited struct {
+	token *lexemes
+	/* nil calls the inherited method of the same name with the same arguments */
+	name *lexemes
+	args []*Node
+	/* filled in by the analyser, nil when there is nothing to inherit */
+	proc *ProcedureSymbol
+}
+
+type Nil struct {
+	token *lexemes
+}
+
+type ClassSymbol struct {
+	name   string
+	parent *ClassSymbol
+	scope  *ScopedSymbolTable
+	token  *lexemes
+	/* the type of its variables, pointing back here */
+	stype   *BuiltinSymbol
+	members map[Symbol]*Member
+	vtable  []*ProcedureSymbol
+}
+
+func (c *ClassSymbol) getName() string {
+	return c.name
+}
+
+func (c *ClassSymbol) String() string {
+	if c.parent == nil {
+		return fmt.Sprintf("%s: <CLASS>", c.name)
+	}
+	return fmt.Sprintf("%s: <CLASS(%s)>", c.name, c.parent.name)
+}
+
+/* What a class says about a field or method it declares */
+type Member struct {
+	class      *ClassSymbol
+	visibility int
+	kind       int
+	/* the vtable slot of a virtual method, -1 otherwise */
+	slot int
+	/* the Self of a method */
+	self *VarSymbol
+}
+
+/* The type of NIL, which any object or procedural variable may hold */
+var nil_type = &BuiltinSymbol{"NIL", nil, nil, nil}
+
+func (c *ClassSymbol) descends(ancestor *ClassSymbol) bool {
+	for ; c != nil; c = c.parent {
+		if c == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+/* The record of symbol in the class or the classes it inherits from, nil when it is no member */
+func (c *ClassSymbol) member(symbol Symbol) *Member {
+	for ; c != nil; c = c.parent {
+		if member, ok := c.members[symbol]; ok == true {
+			return member
+		}
+	}
+	return nil
+}
+
+/* The member record of a method, nil for a plain procedure */
+func method_member(proc *ProcedureSymbol) *Member {
+	if proc.scope == nil || proc.scope.enclosing_scope == nil || proc.scope.enclosing_scope.class == nil {
+		return nil
+	}
+	return proc.scope.enclosing_scope.class.members[proc]
+}
+
+func is_reference(stype *BuiltinSymbol) bool {
+	return stype != nil && (stype.class != nil || stype == nil_type)
+}
+
+func (r *rules) class_type(token *lexemes) *ClassDeclaration {
+	r.digest(CLASS)
+	class := &ClassDeclaration{token, nil, nil}
+	if r.lexer.Cur().ttype == LPAR {
+		r.digest(LPAR)
+		class.parent = r.lexer.Cur()
+		r.digest(ID)
+		r.digest(RPAR)
+	}
+	visibility := PUBLIC
+	for {
+		switch kind := r.lexer.Cur().ttype; kind {
+		case PRIVATE, PROTECTED, PUBLIC:
+			visibility = kind
+			r.digest(kind)
+		case ID:
+			list := r.variable_declaration()
+			spec := list.elem[len(list.elem)-1].(*Spec)
+			for _, elem := range list.elem[:len(list.elem)-1] {
+				field := elem.(*VarDeclaration)
+				field.spec = spec
+				class.members = append(class.members, &MemberDecl{visibility, VAR, 0, field})
+			}
+			r.digest(SEMI)
+		case PROCEDURE, CONSTRUCTOR, DESTRUCTOR:
+			heading := r.procedure_heading()
+			binding := r.lexer.Cur().ttype
+			if binding == VIRTUAL || binding == OVERRIDE {
+				r.digest(binding)
+				r.digest(SEMI)
+			} else {
+				binding = 0
+			}
+			class.members = append(class.members, &MemberDecl{visibility, kind, binding, heading})
+		default:
+			r.digest(END)
+			return class
+		}
+	}
+}
+
+func (r *rules) arguments() []*Node {
+	args := []*Node{}
+	if r.lexer.Cur().ttype == LPAR {
+		r.digest(LPAR)
+		if r.lexer.Cur().ttype != RPAR {
+			args = append(args, r.expr())
+			for r.lexer.Cur().ttype == COMMA {
+				r.digest(COMMA)
+				args = append(args, r.expr())
+			}
+		}
+		r.digest(RPAR)
+	}
+	return args
+}
+
+func (r *rules) designator() *Designator {
+	token := r.lexer.Cur()
+	r.digest(ID)
+	object := &Node{nil, &Var{token, 0}, nil}
+	var node *Designator
+	for r.lexer.Cur().ttype == DOT {
+		r.digest(DOT)
+		if node != nil {
+			object = &Node{nil, node, nil}
+		}
+		node = &Designator{r.lexer.Cur(), object, nil, nil, nil}
+		r.digest(ID)
+	}
+	node.args = r.arguments()
+	return node
+}
+
+func (r *rules) member_statement() interface{} {
+	target := r.designator()
+	if r.lexer.Cur().ttype != ASSIGN {
+		return target
+	}
+	token := r.lexer.Cur()
+	r.digest(ASSIGN)
+	return &MemberAssign{target, token, r.expr()}
+}
+
+func (r *rules) inherited_statement() interface{} {
+	node := &Inherited{r.lexer.Cur(), nil, nil, nil}
+	r.digest(INHERITED)
+	if r.lexer.Cur().ttype == ID {
+		node.name = r.lexer.Cur()
+		r.digest(ID)
+		node.args = r.arguments()
+	}
+	return node
+}
+
+func (s SemanticsAnalyser) declare_class(v *ClassDeclaration) {
+	if _, found := s.scope.lookup(v.token.tstring, true); found == true {
+		compile_error("Semantic", v.token, "%s already declared", v.token.tstring)
+	}
+	class := &ClassSymbol{v.token.tstring, nil, nil, v.token, nil, make(map[Symbol]*Member), nil}
+	class.stype = &BuiltinSymbol{class.name, nil, class, nil}
+	class.scope = &ScopedSymbolTable{make(map[string]Symbol), class.name, s.scope.scope_level, s.scope, nil, class}
+	if v.parent != nil {
+		symbol, _ := s.scope.lookup(v.parent.tstring, false)
+		parent, ok := symbol.(*ClassSymbol)
+		if ok == false {
+			compile_error("Semantic", v.parent, "%s is not a class", v.parent.tstring)
+		}
+		class.parent = parent
+		class.vtable = append([]*ProcedureSymbol{}, parent.vtable...)
+		s.reference(v.parent, parent)
+	}
+	/* inserted first, so fields and parameters may refer to the class */
+	s.scope.insert(class)
+	s.reference(v.token, class)
+	for _, member := range v.members {
+		switch decl := member.decl.(type) {
+		case *VarDeclaration:
+			if _, found := class.scope.lookup(decl.token.tstring, true); found == true {
+				compile_error("Semantic", decl.token, "%s already declared", decl.token.tstring)
+			}
+			field := &VarSymbol{decl.token.tstring, s.type_symbol(decl.spec, decl.token), 0, decl.token, false}
+			class.scope.insert(field)
+			class.members[field] = &Member{class, member.visibility, VAR, -1, nil}
+			s.reference(decl.token, field)
+		case *ProcedureDecl:
+			s.declare_method(class, member, decl)
+		}
+	}
+}
+
+func (s SemanticsAnalyser) declare_method(class *ClassSymbol, member *MemberDecl, decl *ProcedureDecl) {
+	if strings.Contains(decl.proc_name, ".") == true {
+		compile_error("Semantic", decl.token, "method %s cannot be qualified inside its class", decl.proc_name)
+	}
+	if _, own := class.scope.symbols[decl.proc_name]; own == true {
+		compile_error("Semantic", decl.token, "%s already declared", decl.proc_name)
+	}
+	scope := &ScopedSymbolTable{make(map[string]Symbol), decl.proc_name, class.scope.scope_level + 1, class.scope, nil, nil}
+	proc := &ProcedureSymbol{decl.proc_name, []*VarSymbol{}, decl.token, scope, decl, nil}
+	info := &Member{class, member.visibility, member.kind, -1, &VarSymbol{"SELF", class.stype, 0, decl.token, false}}
+	scope.insert(info.self)
+	for _, param := range decl.params {
+		variable := &VarSymbol{param.var_name.token.tstring, s.param_type(param.var_type, param.var_name.token), 0, param.var_name.token, param.constant}
+		scope.insert(variable)
+		s.reference(param.var_name.token, variable)
+		proc.params = append(proc.params, variable)
+	}
+	symbol, inherits := class.scope.lookup(decl.proc_name, true)
+	original, is_method := symbol.(*ProcedureSymbol)
+	var overridden *Member
+	if is_method == true {
+		overridden = class.member(original)
+	}
+	switch {
+	case member.binding == OVERRIDE:
+		if overridden == nil || overridden.slot < 0 {
+			compile_error("Semantic", decl.token, "%s has no virtual method %s to override", class.name, decl.proc_name)
+		}
+		if overridden.kind != member.kind || same_signature(proc_signature(original), proc_signature(proc)) == false {
+			compile_error("Semantic", decl.token, "%s differs from the method it overrides at line %d", decl.proc_name, original.token.line)
+		}
+		info.slot = overridden.slot
+		class.vtable[info.slot] = proc
+	case overridden != nil && overridden.slot >= 0:
+		compile_error("Semantic", decl.token, "%s hides the virtual method of %s, declare it OVERRIDE", decl.proc_name, overridden.class.name)
+	case inherits == true && is_method == false:
+		compile_error("Semantic", decl.token, "%s already declared", decl.proc_name)
+	case member.binding == VIRTUAL:
+		info.slot = len(class.vtable)
+		class.vtable = append(class.vtable, proc)
+	}
+	class.scope.insert(proc)
+	class.members[proc] = info
+	s.reference(decl.token, proc)
+}
+
+/* Gives a method declared in its class the block of PROCEDURE Class.Method */
+func (s SemanticsAnalyser) implement_method(v *ProcedureDecl) {
+	names := strings.SplitN(v.proc_name, ".", 2)
+	symbol, _ := s.scope.lookup(names[0], false)
+	class, ok := symbol.(*ClassSymbol)
+	if ok == false {
+		compile_error("Semantic", v.token, "%s is not a class", names[0])
+	}
+	s.reference(v.token, class)
+	if v.block == nil {
+		compile_error("Semantic", v.token, "method %s is declared in its class, not FORWARD", v.proc_name)
+	}
+	proc, ok := class.scope.symbols[names[1]].(*ProcedureSymbol)
+	if ok == false {
+		compile_error("Semantic", v.token, "%s declares no method %s", class.name, names[1])
+	}
+	if proc.decl.block != nil {
+		compile_error("Semantic", v.token, "method %s already defined at line %d", v.proc_name, proc.decl.token.line)
+	}
+	/* found under its full name by whatever walks the declarations */
+	s.scope.symbols[v.proc_name] = proc
+	s.implement(proc, v)
+}
+
+/* Reports the methods of a class left without a block */
+func (s SemanticsAnalyser) unimplemented(v *ClassDeclaration) {
+	symbol, _ := s.scope.lookup(v.token.tstring, true)
+	class := symbol.(*ClassSymbol)
+	for _, member := range v.members {
+		if heading, ok := member.decl.(*ProcedureDecl); ok == true {
+			if class.scope.symbols[heading.proc_name].(*ProcedureSymbol).decl.block == nil {
+				compile_error("Semantic", heading.token, "method %s.%s is declared but never defined", class.name, heading.proc_name)
+			}
+		}
+	}
+}
+
+/* The class whose method is being analysed, nil outside methods */
+func (s SemanticsAnalyser) current_class() *ClassSymbol {
+	for scope := s.scope; scope != nil; scope = scope.enclosing_scope {
+		if scope.class != nil {
+			return scope.class
+		}
+	}
+	return nil
+}
+
+/* The method being analysed and its class */
+func (s SemanticsAnalyser) current_method() (*ProcedureSymbol, *ClassSymbol) {
+	for scope := s.scope; scope.enclosing_scope != nil; scope = scope.enclosing_scope {
+		if class := scope.enclosing_scope.class; class != nil {
+			return class.scope.symbols[scope.scope_name].(*ProcedureSymbol), class
+		}
+	}
+	return nil, nil
+}
+
+/* PRIVATE members are seen by the methods of their class only, PROTECTED ones by those of descendants too */
+func (s SemanticsAnalyser) visible(member *Member, token *lexemes) {
+	current := s.current_class()
+	switch {
+	case member.visibility == PRIVATE && current != member.class:
+		compile_error("Semantic", token, "%s is private to %s", token.tstring, member.class.name)
+	case member.visibility == PROTECTED && current.descends(member.class) == false:
+		compile_error("Semantic", token, "%s is protected in %s", token.tstring, member.class.name)
+	}
+}
+
+/* Checks a plain name used inside a method, which may be a member the method cannot see */
+func (s SemanticsAnalyser) check_member_name(symbol Symbol, token *lexemes) {
+	if class := s.current_class(); class != nil {
+		if member := class.member(symbol); member != nil {
+			s.visible(member, token)
+		}
+	}
+}
+
+func (s SemanticsAnalyser) check_arguments(name string, token *lexemes, params []*BuiltinSymbol, args []*Node) {
+	if len(args) != len(params) {
+		compile_error("Semantic", token, "%s expects %d arguments, got %d", name, len(params), len(args))
+	}
+	for index, arg := range args {
+		s.check(arg)
+		s.assignable(params[index], arg, node_token(arg))
+	}
+}
+
+/* The class a designator selects from: the object's, or the class it names for a constructor */
+func (s SemanticsAnalyser) object_class(v *Designator) *ClassSymbol {
+	if variable, ok := v.object.token.(*Var); ok == true {
+		symbol, _ := s.scope.lookup(variable.token.tstring, false)
+		if class, is_class := symbol.(*ClassSymbol); is_class == true {
+			s.reference(variable.token, class)
+			v.create = class
+			return class
+		}
+	}
+	s.check(v.object)
+	class := s.type_of(v.object).class
+	if class == nil {
+		compile_error("Semantic", v.token, "%s is selected from something that is not an object", v.token.tstring)
+	}
+	return class
+}
+
+/* A designator is a call when it is a statement, and a field or constructor call in an expression */
+func (s SemanticsAnalyser) check_designator(v *Designator, statement bool) {
+	class := s.object_class(v)
+	symbol, found := class.scope.lookup(v.token.tstring, true)
+	if found == false {
+		if v.token.tstring == "FREE" && v.create == nil && statement == true && len(v.args) == 0 {
+			return
+		}
+		if v.token.tstring == "CREATE" && v.create != nil && len(v.args) == 0 {
+			return
+		}
+		compile_error("Semantic", v.token, "%s has no member %s", class.name, v.token.tstring)
+	}
+	member := class.member(symbol)
+	s.visible(member, v.token)
+	s.reference(v.token, symbol)
+	v.symbol = symbol
+	switch target := symbol.(type) {
+	case *VarSymbol:
+		if v.create != nil || statement == true || len(v.args) > 0 {
+			compile_error("Semantic", v.token, "%s is a field of %s, not a method", v.token.tstring, class.name)
+		}
+	case *ProcedureSymbol:
+		if v.create != nil && member.kind != CONSTRUCTOR {
+			compile_error("Semantic", v.token, "%s is not a constructor of %s", v.token.tstring, class.name)
+		}
+		if v.create == nil && statement == false {
+			compile_error("Semantic", v.token, "method %s has no value", v.token.tstring)
+		}
+		s.check_arguments(v.token.tstring, v.token, proc_signature(target), v.args)
+	}
+}
+
+func (s SemanticsAnalyser) designator_type(v *Designator) *BuiltinSymbol {
+	if v.create != nil {
+		return v.create.stype
+	}
+	field, ok := v.symbol.(*VarSymbol)
+	if ok == false {
+		compile_error("Semantic", v.token, "%s has no type", v.token.tstring)
+	}
+	return field.stype
+}
+
+func (s SemanticsAnalyser) check_member_assign(v *MemberAssign) {
+	s.check_designator(v.target, false)
+	field, ok := v.target.symbol.(*VarSymbol)
+	if ok == false || v.target.create != nil {
+		compile_error("Semantic", v.token, "only a field can be assigned to")
+	}
+	s.check(v.expr)
+	s.assignable(field.stype, v.expr, v.token)
+}
+
+func (s SemanticsAnalyser) check_inherited(v *Inherited) {
+	method, class := s.current_method()
+	if method == nil {
+		compile_error("Semantic", v.token, "INHERITED is only allowed in a method")
+	}
+	name := method.name
+	if v.name != nil {
+		name = v.name.tstring
+	}
+	if class.parent != nil {
+		symbol, _ := class.parent.scope.lookup(name, true)
+		v.proc, _ = symbol.(*ProcedureSymbol)
+	}
+	if v.proc == nil {
+		/* a bare INHERITED with nothing to inherit does nothing, nor does TObject's Create or Destroy */
+		if v.name == nil || ((name == "CREATE" || name == "DESTROY") && len(v.args) == 0) {
+			return
+		}
+		compile_error("Semantic", v.name, "%s inherits no method %s", class.name, name)
+	}
+	if v.name == nil {
+		for _, param := range method.params {
+			v.args = append(v.args, &Node{nil, &Var{param.token, 0}, nil})
+		}
+	} else {
+		s.reference(v.name, v.proc)
+	}
+	s.visible(class.member(v.proc), v.token)
+	s.check_arguments(name, v.token, proc_signature(v.proc), v.args)
+}
+
+/* Objects and NIL can only be compared with each other, for identity */
+func reference_operands(op *Op, left *BuiltinSymbol, right *BuiltinSymbol) bool {
+	if is_reference(left) == false && is_reference(right) == false {
+		return false
+	}
+	if (op.token.ttype == EQUAL || op.token.ttype == NOT_EQUAL) && is_reference(left) == true && is_reference(right) == true {
+		return true
+	}
+	compile_error("Semantic", op.token, "an object cannot be an operand of '%s'", op.token.tstring)
+	return false
+}
+
+/* Checks the store of an object or NIL, true when it is one */
+func (s SemanticsAnalyser) assignable_reference(target *BuiltinSymbol, expr *Node, token *lexemes) bool {
+	if _, is_nil := expr.token.(*Nil); is_nil == true {
+		if target.class == nil && target.procedure == nil {
+			compile_error("Semantic", token, "NIL cannot be used as %s", type_name(target))
+		}
+		return true
+	}
+	_, is_procedure := s.procedure_value(expr)
+	if target.class == nil {
+		if is_procedure == false && is_reference(s.type_of(expr)) == true {
+			compile_error("Semantic", token, "an object cannot be used as %s", type_name(target))
+		}
+		return false
+	}
+	if is_procedure == true {
+		compile_error("Semantic", token, "a procedure cannot be used as %s", type_name(target))
+	}
+	stype := s.type_of(expr)
+	if stype.class.descends(target.class) == false {
+		compile_error("Semantic", token, "%s is not a %s", type_name(stype), type_name(target))
+	}
+	return true
+}
+
+/* The instance a reference stands for, nil when it is NIL or destroyed */
+func (i *Interpreter) object(reference float64) *CallFrame {
+	index := int(reference)
+	if index < 1 || index > len(i.objects) {
+		return nil
+	}
+	return i.objects[index-1]
+}
+
+/* The instance a reference stands for, failing like a bad pointer when there is none */
+func (i *Interpreter) instance(reference float64, token *lexemes) *CallFrame {
+	object := i.object(reference)
+	if object == nil && reference == 0 {
+		i.runtime_error(RUNTIME_NIL_POINTER, token, "%s used on NIL", token.tstring)
+	}
+	if object == nil {
+		i.runtime_error(RUNTIME_NIL_POINTER, token, "%s used on a destroyed object", token.tstring)
+	}
+	return object
+}
+
+/* The instance the method running in frame was called on */
+func (i *Interpreter) self(frame *CallFrame) float64 {
+	symbol, _ := frame.scope.lookup("SELF", false)
+	return i.value(frame, symbol.(*VarSymbol))
+}
+
+/* Calls a method on an instance, a virtual one through the vtable of the instance's class */
+func (i *Interpreter) invoke_method(proc *ProcedureSymbol, self float64, args []*Node, token *lexemes) {
+	if member := method_member(proc); member.slot >= 0 {
+		proc = i.instance(self, token).scope.class.vtable[member.slot]
+	}
+	i.invoke(proc, i.activation(i.stack[len(i.stack)-1], proc.scope.enclosing_scope), args, token, self)
+}
+
+/* Bytes an instance of class takes: one value per field, its own and inherited */
+func object_size(class *ClassSymbol) int {
+	size := 0
+	for ; class != nil; class = class.parent {
+		size += frame_size(class.scope)
+	}
+	return size
+}
+
+/* Allocates an instance on the heap and runs its constructor */
+func (i *Interpreter) construct(v *Designator) float64 {
+	size := object_size(v.create)
+	i.allocate(size, v.token)
+	i.objects = append(i.objects, &CallFrame{v.create.name, v.create.scope, v.token, nil, nil, make(map[*VarSymbol]float64), size})
+	self := float64(len(i.objects))
+	if v.symbol != nil {
+		i.invoke_method(v.symbol.(*ProcedureSymbol), self, v.args, v.token)
+	}
+	return self
+}
+
+/* The value of a designator in an expression, a field or a new instance */
+func (i *Interpreter) select_member(v *Designator) float64 {
+	if v.create != nil {
+		return i.construct(v)
+	}
+	field := v.symbol.(*VarSymbol)
+	object := i.instance(i.run(v.object), v.token)
+	if field.stype.array != nil {
+		return i.array_handle(object, field, v.token)
+	}
+	return object.values[field]
+}
+
+/* Runs a designator as a statement, a destructor or FREE destroying the instance afterwards */
+func (i *Interpreter) call_member(v *Designator) {
+	if v.create != nil {
+		i.construct(v)
+		return
+	}
+	self := i.run(v.object)
+	if self == 0 && v.symbol == nil {
+		return
+	}
+	object := i.instance(self, v.token)
+	proc, _ := v.symbol.(*ProcedureSymbol)
+	if proc == nil {
+		symbol, _ := object.scope.lookup("DESTROY", true)
+		if proc, _ = symbol.(*ProcedureSymbol); proc == nil || method_member(proc).kind != DESTRUCTOR {
+			i.destroy(self)
+			return
+		}
+	}
+	i.invoke_method(proc, self, v.args, v.token)
+	if method_member(proc).kind == DESTRUCTOR {
+		i.destroy(self)
+	}
+}
+
+/* Gives back the fields of an instance, and its arrays, to the heap */
+func (i *Interpreter) destroy(self float64) {
+	i.release(i.objects[int(self)-1])
+	i.objects[int(self)-1] = nil
+}
+
+func (i *Interpreter) assign_member(v *MemberAssign) {
+	object := i.instance(i.run(v.target.object), v.target.token)
+	field := v.target.symbol.(*VarSymbol)
+	value := i.run(v.expr)
+	if field.stype.array != nil {
+		i.assign_array(object, field, value, v.token)
+		return
+	}
+	if field.stype.name == "INTEGER_CONST" {
+		value = i.store_integer(v.token, value)
+	}
+	object.values[field] = value
+}
+
+/* Calls the method the parent class has, whatever the instance overrides it with */
+func (i *Interpreter) inherited(v *Inherited) {
+	if v.proc == nil {
+		return
+	}
+	frame := i.stack[len(i.stack)-1]
+	i.invoke(v.proc, i.activation(frame, v.proc.scope.enclosing_scope), v.args, v.token, i.self(frame))
+}
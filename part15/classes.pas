@@ -0,0 +1,101 @@
+PROGRAM Classes;
+TYPE
+   TShape = CLASS
+   PRIVATE
+      id : INTEGER;
+   PROTECTED
+      sides : INTEGER;
+   PUBLIC
+      CONSTRUCTOR Create(n : INTEGER);
+      DESTRUCTOR Destroy; VIRTUAL;
+      PROCEDURE Describe; VIRTUAL;
+      PROCEDURE Area(scale : REAL); VIRTUAL;
+   END;
+
+   TSquare = CLASS(TShape)
+      side : REAL;
+      CONSTRUCTOR Make(n : INTEGER; s : REAL);
+      PROCEDURE Area(scale : REAL); OVERRIDE;
+   END;
+
+   TTriangle = CLASS(TSquare)
+      PROCEDURE Describe; OVERRIDE;
+      PROCEDURE Area(scale : REAL); OVERRIDE;
+      DESTRUCTOR Destroy; OVERRIDE;
+   END;
+
+VAR
+   created : INTEGER;
+   shape : TShape;
+   square : TSquare;
+
+CONSTRUCTOR TShape.Create(n : INTEGER);
+BEGIN
+   created := created + 1;
+   id := created;
+   sides := n
+END;
+
+DESTRUCTOR TShape.Destroy;
+BEGIN
+   WRITELN('shape ', id, ' destroyed')
+END;
+
+PROCEDURE TShape.Describe;
+BEGIN
+   WRITELN('shape ', id, ' has ', sides, ' sides');
+   Area(1.0)
+END;
+
+PROCEDURE TShape.Area(scale : REAL);
+BEGIN
+   WRITELN('  area unknown')
+END;
+
+CONSTRUCTOR TSquare.Make(n : INTEGER; s : REAL);
+BEGIN
+   INHERITED Create(n);
+   side := s
+END;
+
+PROCEDURE TSquare.Area(scale : REAL);
+BEGIN
+   WRITELN('  area ', side * side * scale)
+END;
+
+PROCEDURE TTriangle.Describe;
+BEGIN
+   WRITELN('a triangle, whose parent says');
+   INHERITED
+END;
+
+PROCEDURE TTriangle.Area(scale : REAL);
+BEGIN
+   WRITELN('  area ', Self.side * Self.side * scale / 2)
+END;
+
+DESTRUCTOR TTriangle.Destroy;
+BEGIN
+   WRITELN('triangle going');
+   INHERITED
+END;
+
+BEGIN {Classes}
+   created := 0;
+   shape := TShape.Create(0);
+   shape.Describe;
+   shape.Free;
+   square := TSquare.Make(4, 2.0);
+   shape := square;
+   shape.Describe;
+   square.side := 3.0;
+   shape.Area(2.0);
+   shape := TTriangle.Make(3, 4.0);
+   shape.Describe;
+   shape.Destroy;
+   IF shape <> NIL THEN WRITELN('references outlive their objects');
+   shape := NIL;
+   shape.Free;
+   square.Free;
+   WRITELN(created, ' shapes created')
+END.  {Classes}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/*
+	DEBUG ADAPTER PROTOCOL FRONTEND
+
+	The program runs on a goroutine of its own while requests are read.
+	Its call stack and variables are only read while it is paused, waiting
+	in stopped for the request that resumes it, and what it writes goes
+	to the client as output events rather than into the protocol stream.
+*/
+
+type DapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type DapArguments struct {
+	Program     string `json:"program"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+	Source      struct {
+		Path string `json:"path"`
+	} `json:"source"`
+	Breakpoints []struct {
+		Line int `json:"line"`
+	} `json:"breakpoints"`
+	FrameId            int    `json:"frameId"`
+	VariablesReference int    `json:"variablesReference"`
+	Expression         string `json:"expression"`
+
+	/* added to those of the command line, as -D and -unit-path */
+	Defines  []string `json:"defines"`
+	UnitPath []string `json:"unitPath"`
+}
+
+type DapServer struct {
+	in          *bufio.Reader
+	out         io.Writer
+	defines     []string
+	search      []string
+	lock        sync.Mutex
+	seq         int
+	program     string
+	tree        *Block
+	interpreter *Interpreter
+	debugger    *Debugger
+	resume      chan int
+	paused      bool
+	references  []DapScope
+}
+
+/* Sends what the program writes as output events, a line at a time */
+type DapOutput struct {
+	server *DapServer
+	line   []byte
+}
+
+func (o *DapOutput) Write(data []byte) (int, error) {
+	o.line = append(o.line, data...)
+	if end := bytes.LastIndexByte(o.line, '\n'); end >= 0 {
+		o.server.event("output", map[string]interface{}{"category": "stdout", "output": string(o.line[:end+1])})
+		o.line = append([]byte{}, o.line[end+1:]...)
+	}
+	return len(data), nil
+}
+
+/* Sends a last line the program did not end */
+func (o *DapOutput) flush() {
+	if len(o.line) > 0 {
+		o.server.event("output", map[string]interface{}{"category": "stdout", "output": string(o.line)})
+		o.line = nil
+	}
+}
+
+/* A scope of the variables view and the frame its values are read from */
+type DapScope struct {
+	frame *CallFrame
+	scope *ScopedSymbolTable
+}
+
+func (s *DapServer) send(message map[string]interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.seq++
+	message["seq"] = s.seq
+	write_framed(s.out, message)
+}
+
+func (s *DapServer) event(name string, body interface{}) {
+	s.send(map[string]interface{}{"type": "event", "event": name, "body": body})
+}
+
+func (s *DapServer) respond(request *DapRequest, body interface{}, err error) {
+	response := map[string]interface{}{"type": "response", "request_seq": request.Seq, "command": request.Command, "success": err == nil, "body": body}
+	if err != nil {
+		response["message"] = err.Error()
+	}
+	s.send(response)
+}
+
+/* Runs on the interpreter goroutine, blocking it until the client resumes */
+func (s *DapServer) stopped(d *Debugger, i *Interpreter, reason string) int {
+	s.lock.Lock()
+	s.paused = true
+	s.references = nil
+	s.lock.Unlock()
+	s.event("stopped", map[string]interface{}{"reason": reason, "threadId": 1, "allThreadsStopped": true})
+	return <-s.resume
+}
+
+func (s *DapServer) launch(args *DapArguments) (err error) {
+	defer catch_compile_error(&err)
+	file, err := os.Open(args.Program)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	defines := append(append([]string{}, s.defines...), args.Defines...)
+	tokens := preprocess(file, args.Program, defines)
+	parser := rules{lexer{0, len(tokens), tokens}}
+	s.tree = parser.Parse()
+	load_units(s.tree, args.Program, append(append([]string{}, s.search...), args.UnitPath...), defines, nil)
+	symbol_table := new_top_scope("Global", program_uses(s.tree))
+	analyser := SemanticsAnalyser{symbol_table, nil}
+	analyser.check(s.tree)
+	s.program = args.Program
+	s.interpreter = new_interpreter(symbol_table)
+	s.interpreter.out = &DapOutput{s, nil}
+	s.debugger = new_debugger(s, args.StopOnEntry)
+	s.interpreter.debugger = s.debugger
+	return nil
+}
+
+/* The interpreter when it waits in stopped, whose state may be read until it is resumed */
+func (s *DapServer) paused_interpreter() (*Interpreter, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.paused == false {
+		return nil, fmt.Errorf("program is not paused")
+	}
+	return s.interpreter, nil
+}
+
+func (s *DapServer) reference(frame *CallFrame, scope *ScopedSymbolTable) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.references = append(s.references, DapScope{frame, scope})
+	return len(s.references)
+}
+
+/* The scope a variables reference names, looked up under the lock stopped clears the references with */
+func (s *DapServer) referenced(id int) (DapScope, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if id < 1 || id > len(s.references) {
+		return DapScope{}, false
+	}
+	return s.references[id-1], true
+}
+
+/* Whether a client's source path is the launched program, the only file breakpoints stop in */
+func (s *DapServer) is_program(path string) bool {
+	program, err := filepath.Abs(s.program)
+	if err != nil {
+		return false
+	}
+	source, err := filepath.Abs(path)
+	return err == nil && source == program
+}
+
+func (s *DapServer) frame(id int) (*Interpreter, *CallFrame, error) {
+	interpreter, err := s.paused_interpreter()
+	if err != nil {
+		return nil, nil, err
+	}
+	if id < 0 || id >= len(interpreter.stack) {
+		return nil, nil, fmt.Errorf("unknown frame %d", id)
+	}
+	return interpreter, interpreter.stack[id], nil
+}
+
+func (s *DapServer) handle(request *DapRequest) bool {
+	args := &DapArguments{}
+	if len(request.Arguments) > 0 {
+		json.Unmarshal(request.Arguments, args)
+	}
+	switch request.Command {
+	case "initialize":
+		s.respond(request, map[string]interface{}{"supportsConfigurationDoneRequest": true, "supportsEvaluateForHovers": true}, nil)
+		s.event("initialized", nil)
+	case "launch":
+		s.respond(request, nil, s.launch(args))
+	case "setBreakpoints":
+		breakpoints := []map[string]interface{}{}
+		switch {
+		case s.debugger == nil:
+		case s.is_program(args.Source.Path) == true:
+			lines := []int{}
+			for _, breakpoint := range args.Breakpoints {
+				lines = append(lines, breakpoint.Line)
+				breakpoints = append(breakpoints, map[string]interface{}{"verified": true, "line": breakpoint.Line})
+			}
+			s.debugger.replace_breakpoints(lines)
+		default:
+			for _, breakpoint := range args.Breakpoints {
+				breakpoints = append(breakpoints, map[string]interface{}{"verified": false, "line": breakpoint.Line, "message": "breakpoints only stop in the program, not in units or include files"})
+			}
+		}
+		s.respond(request, map[string]interface{}{"breakpoints": breakpoints}, nil)
+	case "configurationDone":
+		s.respond(request, nil, nil)
+		if s.interpreter != nil {
+			go func() {
+				exit_code := 0
+				err := s.interpreter.execute(context.Background(), s.tree)
+				s.interpreter.out.(*DapOutput).flush()
+				if err != nil {
+					s.event("output", map[string]interface{}{"category": "stderr", "output": err.Error() + "\n"})
+					exit_code = 1
+				}
+				s.event("exited", map[string]interface{}{"exitCode": exit_code})
+				s.event("terminated", nil)
+			}()
+		}
+	case "threads":
+		s.respond(request, map[string]interface{}{"threads": []map[string]interface{}{{"id": 1, "name": "main"}}}, nil)
+	case "stackTrace":
+		interpreter, err := s.paused_interpreter()
+		frames := []map[string]interface{}{}
+		if err == nil {
+			for index := len(interpreter.stack) - 1; index >= 0; index-- {
+				frame := interpreter.stack[index]
+				line, column, path := 0, 0, s.program
+				if frame.token != nil {
+					line, column = frame.token.line, frame.token.column_number()
+				}
+				if frame.token != nil && frame.token.file != "" {
+					path = frame.token.file
+				}
+				frames = append(frames, map[string]interface{}{"id": index, "name": frame.name, "line": line, "column": column, "source": map[string]string{"path": path}})
+			}
+		}
+		s.respond(request, map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, err)
+	case "scopes":
+		_, frame, err := s.frame(args.FrameId)
+		scopes := []map[string]interface{}{}
+		if err == nil {
+			for scope := frame.scope; scope != nil; scope = scope.enclosing_scope {
+				scopes = append(scopes, map[string]interface{}{"name": scope.scope_name, "variablesReference": s.reference(frame, scope), "expensive": false})
+			}
+		}
+		s.respond(request, map[string]interface{}{"scopes": scopes}, err)
+	case "variables":
+		interpreter, err := s.paused_interpreter()
+		variables := []map[string]interface{}{}
+		reference, ok := s.referenced(args.VariablesReference)
+		if err == nil && ok == true {
+			for _, variable := range scope_variables(reference.scope) {
+				variables = append(variables, map[string]interface{}{"name": variable.name, "value": format_value(interpreter.value(reference.frame, variable), variable.stype), "type": type_name(variable.stype), "variablesReference": 0})
+			}
+		}
+		s.respond(request, map[string]interface{}{"variables": variables}, err)
+	case "evaluate":
+		interpreter, frame, err := s.frame(args.FrameId)
+		if err != nil {
+			s.respond(request, nil, err)
+			break
+		}
+		result, err := s.debugger.evaluate(interpreter, frame, args.Expression)
+		s.respond(request, map[string]interface{}{"result": result, "variablesReference": 0}, err)
+	case "continue", "next", "stepIn", "stepOut":
+		modes := map[string]int{"continue": DEBUG_CONTINUE, "next": DEBUG_STEP_OVER, "stepIn": DEBUG_STEP_IN, "stepOut": DEBUG_STEP_OUT}
+		s.lock.Lock()
+		paused := s.paused
+		s.paused = false
+		s.lock.Unlock()
+		if paused == false {
+			s.respond(request, nil, fmt.Errorf("program is not paused"))
+			break
+		}
+		s.respond(request, map[string]interface{}{"allThreadsContinued": true}, nil)
+		s.resume <- modes[request.Command]
+	case "disconnect", "terminate":
+		s.respond(request, nil, nil)
+		return false
+	default:
+		s.respond(request, nil, fmt.Errorf("unsupported request %s", strings.TrimSpace(request.Command)))
+	}
+	return true
+}
+
+func run_dap(defines []string, search []string, in io.Reader, out io.Writer) error {
+	server := &DapServer{in: bufio.NewReader(in), out: out, defines: defines, search: search, resume: make(chan int)}
+	for {
+		body, err := read_framed(server.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		request := &DapRequest{}
+		if err := json.Unmarshal(body, request); err != nil {
+			return err
+		}
+		if server.handle(request) == false {
+			return nil
+		}
+	}
+}
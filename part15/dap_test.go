@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type DapClient struct {
+	t        *testing.T
+	in       *io.PipeWriter
+	messages chan map[string]interface{}
+	seq      int
+	output   strings.Builder
+}
+
+/* Reads the messages as they come, the adapter blocking on a write nobody reads otherwise */
+func (c *DapClient) read(out *bufio.Reader) {
+	defer close(c.messages)
+	for {
+		body, err := read_framed(out)
+		if err != nil {
+			return
+		}
+		message := map[string]interface{}{}
+		if err := json.Unmarshal(body, &message); err != nil {
+			message = map[string]interface{}{"broken": string(body)}
+		}
+		c.messages <- message
+	}
+}
+
+func (c *DapClient) request(command string, arguments interface{}) {
+	c.seq++
+	write_framed(c.in, map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": arguments})
+}
+
+/* Reads messages until one passes want, keeping the program output met on the way */
+func (c *DapClient) until(want func(message map[string]interface{}) bool) map[string]interface{} {
+	c.t.Helper()
+	for {
+		message, ok := <-c.messages
+		if ok == false {
+			c.t.Fatal("protocol stream ended")
+		}
+		if broken, ok := message["broken"]; ok == true {
+			c.t.Fatalf("message is not json: %s", broken)
+		}
+		if message["event"] == "output" {
+			c.output.WriteString(message["body"].(map[string]interface{})["output"].(string))
+		}
+		if want(message) == true {
+			return message
+		}
+	}
+}
+
+func (c *DapClient) response(command string) map[string]interface{} {
+	c.t.Helper()
+	return c.until(func(message map[string]interface{}) bool {
+		return message["type"] == "response" && message["command"] == command
+	})
+}
+
+func (c *DapClient) event(name string) map[string]interface{} {
+	c.t.Helper()
+	return c.until(func(message map[string]interface{}) bool {
+		return message["type"] == "event" && message["event"] == name
+	})
+}
+
+func body(message map[string]interface{}) map[string]interface{} {
+	return message["body"].(map[string]interface{})
+}
+
+/* A whole session: the program's output arrives as events and its state is read while it is paused, run it with -race */
+func TestDapSession(t *testing.T) {
+	in_reader, in_writer := io.Pipe()
+	out_reader, out_writer := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- run_dap(nil, nil, in_reader, out_writer)
+		out_writer.Close()
+	}()
+	client := &DapClient{t, in_writer, make(chan map[string]interface{}, 64), 0, strings.Builder{}}
+	go client.read(bufio.NewReader(out_reader))
+
+	client.request("initialize", map[string]interface{}{})
+	client.response("initialize")
+	client.request("launch", map[string]interface{}{"program": "calls.pas"})
+	if launched := client.response("launch"); launched["success"] != true {
+		t.Fatalf("launch failed: %v", launched["message"])
+	}
+	client.request("setBreakpoints", map[string]interface{}{"source": map[string]string{"path": "calls.pas"}, "breakpoints": []map[string]int{{"line": 7}}})
+	client.response("setBreakpoints")
+	client.request("configurationDone", map[string]interface{}{})
+	client.event("stopped")
+
+	client.request("stackTrace", map[string]interface{}{"threadId": 1})
+	frames := body(client.response("stackTrace"))["stackFrames"].([]interface{})
+	if len(frames) != 3 {
+		t.Fatalf("got %d frames, want ADD, TWICE and the program", len(frames))
+	}
+	top := frames[0].(map[string]interface{})
+	if top["name"] != "ADD" || top["line"] != float64(7) {
+		t.Errorf("top frame is %v at line %v, want ADD at line 7", top["name"], top["line"])
+	}
+	client.request("scopes", map[string]interface{}{"frameId": top["id"]})
+	scopes := body(client.response("scopes"))["scopes"].([]interface{})
+	reference := scopes[0].(map[string]interface{})["variablesReference"]
+	client.request("variables", map[string]interface{}{"variablesReference": reference})
+	variables := body(client.response("variables"))["variables"].([]interface{})
+	if len(variables) != 1 || variables[0].(map[string]interface{})["value"] != "10" {
+		t.Errorf("ADD sees %v, want X = 10", variables)
+	}
+	client.request("evaluate", map[string]interface{}{"frameId": top["id"], "expression": "total + x"})
+	if result := body(client.response("evaluate"))["result"]; result != "10" {
+		t.Errorf("total + x is %v, want 10", result)
+	}
+
+	client.request("setBreakpoints", map[string]interface{}{"source": map[string]string{"path": "calls.pas"}, "breakpoints": []map[string]int{}})
+	client.response("setBreakpoints")
+	client.request("continue", map[string]interface{}{"threadId": 1})
+	client.response("continue")
+	/* requests made while the program runs, which must not race it */
+	client.request("setBreakpoints", map[string]interface{}{"source": map[string]string{"path": "calls.pas"}, "breakpoints": []map[string]int{{"line": 1}}})
+	client.request("stackTrace", map[string]interface{}{"threadId": 1})
+	client.event("terminated")
+	if client.output.String() != "total = 21\n" {
+		t.Errorf("program wrote %q, want the line of calls.pas", client.output.String())
+	}
+	client.request("disconnect", map[string]interface{}{})
+	client.response("disconnect")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+/* The launch arguments add -D symbols and unit directories to those of the command line */
+func TestDapLaunchDefines(t *testing.T) {
+	path, lib := defined_program(t)
+	in_reader, in_writer := io.Pipe()
+	out_reader, out_writer := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- run_dap([]string{"FAST"}, nil, in_reader, out_writer)
+		out_writer.Close()
+	}()
+	client := &DapClient{t, in_writer, make(chan map[string]interface{}, 64), 0, strings.Builder{}}
+	go client.read(bufio.NewReader(out_reader))
+
+	client.request("initialize", map[string]interface{}{})
+	client.response("initialize")
+	client.request("launch", map[string]interface{}{"program": path, "unitPath": []string{lib}})
+	if launched := client.response("launch"); launched["success"] != true {
+		t.Fatalf("launch failed: %v", launched["message"])
+	}
+	client.request("configurationDone", map[string]interface{}{})
+	client.event("terminated")
+	if client.output.String() != "shown 7\n" {
+		t.Errorf("program wrote %q", client.output.String())
+	}
+	client.request("disconnect", map[string]interface{}{})
+	client.response("disconnect")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+/* Breakpoints in an include file are reported unverified, leaving those of the program in place */
+func TestDapIncludeBreakpoints(t *testing.T) {
+	path := write_program(t, "main.pas", "PROGRAM Main;\n{$I body.inc}\nBEGIN\n   Show;\n   WRITELN('done')\nEND.\n")
+	include := filepath.Join(filepath.Dir(path), "body.inc")
+	if err := os.WriteFile(include, []byte("PROCEDURE Show;\nBEGIN\n   WRITELN('from include')\nEND;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	in_reader, in_writer := io.Pipe()
+	out_reader, out_writer := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- run_dap(nil, nil, in_reader, out_writer)
+		out_writer.Close()
+	}()
+	client := &DapClient{t, in_writer, make(chan map[string]interface{}, 64), 0, strings.Builder{}}
+	go client.read(bufio.NewReader(out_reader))
+
+	client.request("initialize", map[string]interface{}{})
+	client.response("initialize")
+	client.request("launch", map[string]interface{}{"program": path})
+	client.response("launch")
+	client.request("setBreakpoints", map[string]interface{}{"source": map[string]string{"path": path}, "breakpoints": []map[string]int{{"line": 5}}})
+	if verified := body(client.response("setBreakpoints"))["breakpoints"].([]interface{})[0].(map[string]interface{})["verified"]; verified != true {
+		t.Errorf("program breakpoint verified is %v", verified)
+	}
+	client.request("setBreakpoints", map[string]interface{}{"source": map[string]string{"path": include}, "breakpoints": []map[string]int{{"line": 3}}})
+	if verified := body(client.response("setBreakpoints"))["breakpoints"].([]interface{})[0].(map[string]interface{})["verified"]; verified != false {
+		t.Errorf("include breakpoint verified is %v", verified)
+	}
+	client.request("configurationDone", map[string]interface{}{})
+	client.event("stopped")
+	client.request("stackTrace", map[string]interface{}{"threadId": 1})
+	top := body(client.response("stackTrace"))["stackFrames"].([]interface{})[0].(map[string]interface{})
+	if top["line"] != float64(5) || top["source"].(map[string]interface{})["path"] != path {
+		t.Errorf("stopped at %v, want line 5 of the program", top)
+	}
+	client.request("continue", map[string]interface{}{"threadId": 1})
+	client.event("terminated")
+	client.request("disconnect", map[string]interface{}{})
+	client.response("disconnect")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+	CONTROL FLOW GRAPH
+*/
+
+/* Straight line statements, the condition of a WHILE or IF ends the block it is in */
+type BasicBlock struct {
+	id    int
+	nodes []interface{}
+	succs []*BasicBlock
+	preds []*BasicBlock
+}
+
+/* Flow graph of one procedure body, or of the main program */
+type Cfg struct {
+	name   string
+	scope  *ScopedSymbolTable
+	locals map[*VarSymbol]bool
+	params []*VarSymbol
+	entry  *BasicBlock
+	exit   *BasicBlock
+	blocks []*BasicBlock
+}
+
+type CfgBuilder struct {
+	cfg     *Cfg
+	current *BasicBlock
+}
+
+func (c *Cfg) new_block() *BasicBlock {
+	block := &BasicBlock{len(c.blocks), nil, nil, nil}
+	c.blocks = append(c.blocks, block)
+	return block
+}
+
+func link_blocks(from *BasicBlock, to *BasicBlock) {
+	from.succs = append(from.succs, to)
+	to.preds = append(to.preds, from)
+}
+
+func build_cfg(name string, scope *ScopedSymbolTable, params []*VarSymbol, block *Block) *Cfg {
+	cfg := &Cfg{name, scope, make(map[*VarSymbol]bool), params, nil, nil, nil}
+	/* arrays are followed as a whole by no analysis, only their indices are */
+	for _, param := range params {
+		if param.stype.array == nil {
+			cfg.locals[param] = true
+		}
+	}
+	for _, decl := range block.declaration_list.elem {
+		if v, ok := decl.(*VarDeclaration); ok == true && v.spec.array == nil {
+			symbol, _ := scope.lookup(v.token.tstring, true)
+			cfg.locals[symbol.(*VarSymbol)] = true
+		}
+	}
+	cfg.entry = cfg.new_block()
+	builder := &CfgBuilder{cfg, cfg.entry}
+	builder.statement(block.compound)
+	cfg.exit = cfg.new_block()
+	link_blocks(builder.current, cfg.exit)
+	return cfg
+}
+
+/* Flow graphs of the main program and of every procedure, nested ones included */
+func build_cfgs(tree *Block, scope *ScopedSymbolTable) []*Cfg {
+	cfgs := []*Cfg{build_cfg(scope.scope_name, scope, nil, tree)}
+	for _, decl := range tree.declaration_list.elem {
+		if v, ok := decl.(*ProcedureDecl); ok == true && v.block != nil {
+			symbol, _ := scope.lookup(v.proc_name, true)
+			proc := symbol.(*ProcedureSymbol)
+			nested := build_cfgs(v.block, proc.scope)
+			nested[0] = build_cfg(proc.name, proc.scope, proc.params, v.block)
+			cfgs = append(cfgs, nested...)
+		}
+	}
+	return cfgs
+}
+
+func (b *CfgBuilder) statement(node interface{}) {
+	switch v := node.(type) {
+	case *Compound:
+		for _, elem := range v.elem {
+			b.statement(elem)
+		}
+	case *Assign, *ProcedureCall, *MemberAssign, *Designator, *Inherited, *IndexAssign:
+		b.current.nodes = append(b.current.nodes, v)
+	case *While:
+		condition := b.cfg.new_block()
+		condition.nodes = append(condition.nodes, v.condition)
+		link_blocks(b.current, condition)
+		b.current = b.cfg.new_block()
+		link_blocks(condition, b.current)
+		b.statement(v.body)
+		link_blocks(b.current, condition)
+		b.current = b.cfg.new_block()
+		link_blocks(condition, b.current)
+	case *If:
+		condition := b.current
+		condition.nodes = append(condition.nodes, v.condition)
+		b.current = b.cfg.new_block()
+		link_blocks(condition, b.current)
+		b.statement(v.then_branch)
+		then_end := b.current
+		join := b.cfg.new_block()
+		if v.else_branch != nil {
+			b.current = b.cfg.new_block()
+			link_blocks(condition, b.current)
+			b.statement(v.else_branch)
+			link_blocks(b.current, join)
+		} else {
+			link_blocks(condition, join)
+		}
+		link_blocks(then_end, join)
+		b.current = join
+	case *Try:
+		b.try_statement(v)
+	}
+}
+
+/* Reads of the graph's own variables by a statement or condition */
+func (c *Cfg) reads(node interface{}) []*Var {
+	reads := []*Var{}
+	var walk func(node *Node)
+	walk = func(node *Node) {
+		if node == nil {
+			return
+		}
+		if variable, ok := node.token.(*Var); ok == true && c.local(variable) != nil {
+			reads = append(reads, variable)
+		}
+		switch token := node.token.(type) {
+		case *Designator, *Index, *BuiltinCall:
+			reads = append(reads, c.reads(token)...)
+		}
+		walk(node.left)
+		walk(node.right)
+	}
+	switch v := node.(type) {
+	case *Assign:
+		walk(v.expr)
+	case *ProcedureCall:
+		for _, arg := range v.args {
+			walk(arg)
+		}
+	case *Designator:
+		walk(v.object)
+		for _, arg := range v.args {
+			walk(arg)
+		}
+	case *MemberAssign:
+		walk(v.target.object)
+		walk(v.expr)
+	case *Inherited:
+		for _, arg := range v.args {
+			walk(arg)
+		}
+	case *Index:
+		walk(v.index)
+	case *IndexAssign:
+		walk(v.target.index)
+		walk(v.expr)
+	case *BuiltinCall:
+		for _, arg := range v.args {
+			walk(arg)
+		}
+	case *Node:
+		walk(v)
+	}
+	return reads
+}
+
+func (c *Cfg) local(variable *Var) *VarSymbol {
+	symbol, _ := c.scope.lookup(variable.token.tstring, false)
+	if var_symbol, ok := symbol.(*VarSymbol); ok == true && c.locals[var_symbol] == true {
+		return var_symbol
+	}
+	return nil
+}
+
+/* The procedures a statement or condition calls, methods, constructors and procedures it hands on as values included */
+func called_procedures(node interface{}, scope *ScopedSymbolTable) []*ProcedureSymbol {
+	procs := []*ProcedureSymbol{}
+	var walk func(node *Node)
+	walk = func(node *Node) {
+		if node == nil {
+			return
+		}
+		switch token := node.token.(type) {
+		case *Designator, *Index, *BuiltinCall:
+			procs = append(procs, called_procedures(token, scope)...)
+		case *Var:
+			symbol, _ := scope.lookup(token.token.tstring, false)
+			if proc, ok := symbol.(*ProcedureSymbol); ok == true {
+				procs = append(procs, proc)
+			}
+		}
+		walk(node.left)
+		walk(node.right)
+	}
+	switch v := node.(type) {
+	case *Assign:
+		walk(v.expr)
+	case *ProcedureCall:
+		if v.proc_symbol != nil {
+			procs = append(procs, v.proc_symbol)
+		}
+		for _, arg := range v.args {
+			walk(arg)
+		}
+	case *Designator:
+		if proc, ok := v.symbol.(*ProcedureSymbol); ok == true {
+			procs = append(procs, proc)
+		}
+		walk(v.object)
+		for _, arg := range v.args {
+			walk(arg)
+		}
+	case *MemberAssign:
+		walk(v.target.object)
+		walk(v.expr)
+	case *Inherited:
+		if v.proc != nil {
+			procs = append(procs, v.proc)
+		}
+		for _, arg := range v.args {
+			walk(arg)
+		}
+	case *Index:
+		walk(v.index)
+	case *IndexAssign:
+		walk(v.target.index)
+		walk(v.expr)
+	case *BuiltinCall:
+		if v.proc_symbol != nil {
+			procs = append(procs, v.proc_symbol)
+		}
+		for _, arg := range v.args {
+			walk(arg)
+		}
+	case *Node:
+		walk(v)
+	}
+	return procs
+}
+
+/* Variables a call may read or write: all of ours when the callee is nested in us */
+func (c *Cfg) call_effects(node interface{}) []*VarSymbol {
+	for _, proc := range called_procedures(node, c.scope) {
+		for scope := proc.scope.enclosing_scope; scope != nil; scope = scope.enclosing_scope {
+			if scope == c.scope {
+				effects := []*VarSymbol{}
+				for variable := range c.locals {
+					effects = append(effects, variable)
+				}
+				return effects
+			}
+		}
+	}
+	return nil
+}
+
+/*
+	DATA FLOW
+*/
+
+type FlowSet map[interface{}]bool
+
+func (s FlowSet) copy() FlowSet {
+	result := make(FlowSet)
+	for key := range s {
+		result[key] = true
+	}
+	return result
+}
+
+func (s FlowSet) equal(other FlowSet) bool {
+	if len(s) != len(other) {
+		return false
+	}
+	for key := range s {
+		if other[key] == false {
+			return false
+		}
+	}
+	return true
+}
+
+func union(a FlowSet, b FlowSet) FlowSet {
+	result := a.copy()
+	for key := range b {
+		result[key] = true
+	}
+	return result
+}
+
+func intersection(a FlowSet, b FlowSet) FlowSet {
+	result := make(FlowSet)
+	for key := range a {
+		if b[key] == true {
+			result[key] = true
+		}
+	}
+	return result
+}
+
+/* A data flow problem, solved by iterating step over the graph until nothing changes */
+type FlowAnalysis interface {
+	forward() bool
+	boundary(cfg *Cfg) FlowSet
+	top(cfg *Cfg) FlowSet
+	meet(a FlowSet, b FlowSet) FlowSet
+	step(cfg *Cfg, node interface{}, set FlowSet) FlowSet
+}
+
+/* Sets holding before and after each block, in execution order whatever the direction */
+func solve(cfg *Cfg, analysis FlowAnalysis) (map[*BasicBlock]FlowSet, map[*BasicBlock]FlowSet) {
+	before := make(map[*BasicBlock]FlowSet)
+	after := make(map[*BasicBlock]FlowSet)
+	for _, block := range cfg.blocks {
+		before[block] = analysis.top(cfg)
+		after[block] = analysis.top(cfg)
+	}
+	start, into, out_of := cfg.entry, before, after
+	if analysis.forward() == false {
+		start, into, out_of = cfg.exit, after, before
+	}
+	into[start] = analysis.boundary(cfg)
+	for changed := true; changed == true; {
+		changed = false
+		for _, block := range cfg.blocks {
+			inputs := block.preds
+			if analysis.forward() == false {
+				inputs = block.succs
+			}
+			if block != start && len(inputs) > 0 {
+				set := out_of[inputs[0]]
+				for _, input := range inputs[1:] {
+					set = analysis.meet(set, out_of[input])
+				}
+				into[block] = set
+			}
+			set := into[block].copy()
+			nodes := block.nodes
+			for index := range nodes {
+				if analysis.forward() == false {
+					index = len(nodes) - 1 - index
+				}
+				set = analysis.step(cfg, nodes[index], set)
+			}
+			if set.equal(out_of[block]) == false {
+				out_of[block] = set
+				changed = true
+			}
+		}
+	}
+	return before, after
+}
+
+/* A place a variable gets its value: an assignment, a call, or entry for parameters */
+type Definition struct {
+	variable *VarSymbol
+	node     interface{}
+}
+
+type ReachingDefinitions struct {
+	definitions map[interface{}][]*Definition
+}
+
+func (r *ReachingDefinitions) defined_by(cfg *Cfg, node interface{}) []*Definition {
+	if definitions, ok := r.definitions[node]; ok == true {
+		return definitions
+	}
+	definitions := []*Definition{}
+	if assign, ok := node.(*Assign); ok == true {
+		if variable := cfg.local(assign.variable); variable != nil {
+			definitions = append(definitions, &Definition{variable, node})
+		}
+	}
+	for _, variable := range cfg.call_effects(node) {
+		definitions = append(definitions, &Definition{variable, node})
+	}
+	r.definitions[node] = definitions
+	return definitions
+}
+
+func (r *ReachingDefinitions) forward() bool {
+	return true
+}
+
+func (r *ReachingDefinitions) boundary(cfg *Cfg) FlowSet {
+	set := make(FlowSet)
+	for _, param := range cfg.params {
+		if _, ok := r.definitions[param]; ok == false {
+			r.definitions[param] = []*Definition{{param, nil}}
+		}
+		set[r.definitions[param][0]] = true
+	}
+	return set
+}
+
+func (r *ReachingDefinitions) top(cfg *Cfg) FlowSet {
+	return make(FlowSet)
+}
+
+func (r *ReachingDefinitions) meet(a FlowSet, b FlowSet) FlowSet {
+	return union(a, b)
+}
+
+func (r *ReachingDefinitions) step(cfg *Cfg, node interface{}, set FlowSet) FlowSet {
+	definitions := r.defined_by(cfg, node)
+	/* an assignment replaces every earlier value, a call only may */
+	if _, ok := node.(*Assign); ok == true {
+		for _, definition := range definitions {
+			for key := range set {
+				if key.(*Definition).variable == definition.variable {
+					delete(set, key)
+				}
+			}
+		}
+	}
+	for _, definition := range definitions {
+		set[definition] = true
+	}
+	return set
+}
+
+type DefiniteAssignment struct{}
+
+func (d *DefiniteAssignment) forward() bool {
+	return true
+}
+
+func (d *DefiniteAssignment) boundary(cfg *Cfg) FlowSet {
+	set := make(FlowSet)
+	for _, param := range cfg.params {
+		set[param] = true
+	}
+	return set
+}
+
+func (d *DefiniteAssignment) top(cfg *Cfg) FlowSet {
+	set := make(FlowSet)
+	for variable := range cfg.locals {
+		set[variable] = true
+	}
+	return set
+}
+
+func (d *DefiniteAssignment) meet(a FlowSet, b FlowSet) FlowSet {
+	return intersection(a, b)
+}
+
+func (d *DefiniteAssignment) step(cfg *Cfg, node interface{}, set FlowSet) FlowSet {
+	if assign, ok := node.(*Assign); ok == true {
+		if variable := cfg.local(assign.variable); variable != nil {
+			set[variable] = true
+		}
+	}
+	/* assume a nested procedure assigns what it can, rather than warn wrongly */
+	for _, variable := range cfg.call_effects(node) {
+		set[variable] = true
+	}
+	return set
+}
+
+type Liveness struct{}
+
+func (l *Liveness) forward() bool {
+	return false
+}
+
+func (l *Liveness) boundary(cfg *Cfg) FlowSet {
+	return make(FlowSet)
+}
+
+func (l *Liveness) top(cfg *Cfg) FlowSet {
+	return make(FlowSet)
+}
+
+func (l *Liveness) meet(a FlowSet, b FlowSet) FlowSet {
+	return union(a, b)
+}
+
+func (l *Liveness) step(cfg *Cfg, node interface{}, set FlowSet) FlowSet {
+	if assign, ok := node.(*Assign); ok == true {
+		if variable := cfg.local(assign.variable); variable != nil {
+			delete(set, variable)
+		}
+	}
+	for _, read := range cfg.reads(node) {
+		set[cfg.local(read)] = true
+	}
+	for _, variable := range cfg.call_effects(node) {
+		set[variable] = true
+	}
+	return set
+}
+
+/*
+	DIAGNOSTICS
+*/
+
+type Diagnostic struct {
+	rule    string
+	token   *lexemes
+	message string
+}
+
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("Warning: %s %s (%s)", d.message, d.token.position(), d.rule)
+}
+
+/* Warnings about reads before assignment and values never used, in source order; globals start at zero, so reading one early is no mistake */
+func (s SemanticsAnalyser) flow(tree *Block) []*Diagnostic {
+	diagnostics := []*Diagnostic{}
+	for _, cfg := range build_cfgs(tree, s.scope) {
+		reaching := &ReachingDefinitions{make(map[interface{}][]*Definition)}
+		reaching_before, _ := solve(cfg, reaching)
+		assigned_before, _ := solve(cfg, &DefiniteAssignment{})
+		_, live_after := solve(cfg, &Liveness{})
+		globals := cfg.scope == s.scope
+		for _, block := range cfg.blocks {
+			reaching_set := reaching_before[block].copy()
+			assigned_set := assigned_before[block].copy()
+			for _, node := range block.nodes {
+				for _, read := range cfg.reads(node) {
+					variable := cfg.local(read)
+					reached := false
+					for key := range reaching_set {
+						if key.(*Definition).variable == variable {
+							reached = true
+						}
+					}
+					if globals == true {
+						continue
+					}
+					if reached == false {
+						diagnostics = append(diagnostics, &Diagnostic{"read-before-assignment", read.token, fmt.Sprintf("%s is read before being assigned", variable.name)})
+					} else if assigned_set[variable] == false {
+						diagnostics = append(diagnostics, &Diagnostic{"maybe-read-before-assignment", read.token, fmt.Sprintf("%s may be read before being assigned", variable.name)})
+					}
+				}
+				reaching_set = reaching.step(cfg, node, reaching_set)
+				assigned_set = (&DefiniteAssignment{}).step(cfg, node, assigned_set)
+			}
+			live_set := live_after[block].copy()
+			for index := len(block.nodes) - 1; index >= 0; index-- {
+				node := block.nodes[index]
+				if assign, ok := node.(*Assign); ok == true {
+					if variable := cfg.local(assign.variable); variable != nil && live_set[variable] == false {
+						diagnostics = append(diagnostics, &Diagnostic{"dead-store", assign.variable.token, fmt.Sprintf("value assigned to %s is never used", variable.name)})
+					}
+				}
+				live_set = (&Liveness{}).step(cfg, node, live_set)
+			}
+		}
+	}
+	sort.SliceStable(diagnostics, func(a, b int) bool {
+		if diagnostics[a].token.line != diagnostics[b].token.line {
+			return diagnostics[a].token.line < diagnostics[b].token.line
+		}
+		return diagnostics[a].token.column < diagnostics[b].token.column
+	})
+	return diagnostics
+}
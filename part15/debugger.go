@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+	DEBUGGER
+*/
+
+const (
+	DEBUG_CONTINUE = iota
+	DEBUG_STEP_IN
+	DEBUG_STEP_OVER
+	DEBUG_STEP_OUT
+)
+
+/* Something able to show a paused program and tell how to resume it */
+type DebugFrontend interface {
+	stopped(d *Debugger, i *Interpreter, reason string) int
+}
+
+type Debugger struct {
+	breakpoints map[int]bool
+	mode        int
+	depth       int
+	line        int
+	watches     []string
+	frontend    DebugFrontend
+	/* guards breakpoints, which a frontend may set while the program runs */
+	lock sync.Mutex
+}
+
+func new_debugger(frontend DebugFrontend, stop_on_entry bool) *Debugger {
+	mode := DEBUG_CONTINUE
+	if stop_on_entry == true {
+		mode = DEBUG_STEP_IN
+	}
+	return &Debugger{make(map[int]bool), mode, 0, 0, []string{}, frontend, sync.Mutex{}}
+}
+
+func (d *Debugger) breakpoint(line int) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.breakpoints[line]
+}
+
+func (d *Debugger) set_breakpoint(line int, set bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if set == true {
+		d.breakpoints[line] = true
+	} else {
+		delete(d.breakpoints, line)
+	}
+}
+
+func (d *Debugger) replace_breakpoints(lines []int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.breakpoints = make(map[int]bool)
+	for _, line := range lines {
+		d.breakpoints[line] = true
+	}
+}
+
+func (d *Debugger) before(i *Interpreter, token *lexemes) {
+	depth := len(i.stack)
+	previous_line := d.line
+	d.line = token.line
+	reason := ""
+	switch {
+	case d.mode == DEBUG_STEP_IN:
+		reason = "step"
+	case d.mode == DEBUG_STEP_OVER && depth <= d.depth:
+		reason = "step"
+	case d.mode == DEBUG_STEP_OUT && depth < d.depth:
+		reason = "step"
+	case token.file == "" && d.breakpoint(token.line) == true && (token.line != previous_line || depth != d.depth):
+		reason = "breakpoint"
+	}
+	if reason == "" {
+		return
+	}
+	d.depth = depth
+	d.mode = d.frontend.stopped(d, i, reason)
+}
+
+/* Evaluates an expression with the analyser and interpreter, as seen from frame of the paused interpreter */
+func (d *Debugger) evaluate(paused *Interpreter, frame *CallFrame, text string) (result string, err error) {
+	defer catch_compile_error(&err)
+	tokens := tokenize(strings.NewReader(text))
+	parser := rules{lexer{0, len(tokens), tokens}}
+	node := parser.expr()
+	parser.digest(EOF)
+	analyser := SemanticsAnalyser{frame.scope, nil}
+	analyser.check(node)
+	interpreter := new_interpreter(frame.scope)
+	interpreter.out = paused.out
+	interpreter.stack = []*CallFrame{paused.stack[0], frame}
+	defer interpreter.catch(&err)
+	return format_value(interpreter.run(node), analyser.type_of(node)), nil
+}
+
+func scope_variables(scope *ScopedSymbolTable) []*VarSymbol {
+	variables := []*VarSymbol{}
+	for _, symbol := range scope.symbols {
+		if variable, ok := symbol.(*VarSymbol); ok == true {
+			variables = append(variables, variable)
+		}
+	}
+	sort.Slice(variables, func(a, b int) bool { return variables[a].name < variables[b].name })
+	return variables
+}
+
+/*
+	COMMAND LINE FRONTEND
+*/
+
+type DebugCli struct {
+	in  *bufio.Scanner
+	out io.Writer
+	/* lines of the program under "" and of each include file read so far, under its path */
+	sources map[string][]string
+}
+
+/* Shows a line of the file a token came from, which is the program's own when file is empty */
+func (c *DebugCli) show_line(file string, line int) {
+	source, ok := c.sources[file]
+	if ok == false {
+		content, _ := os.ReadFile(file)
+		source = strings.Split(string(content), "\n")
+		c.sources[file] = source
+	}
+	if line >= 1 && line <= len(source) {
+		fmt.Fprintf(c.out, "%4d	%s\n", line, source[line-1])
+	}
+}
+
+func (c *DebugCli) show_stack(i *Interpreter) {
+	for index := len(i.stack) - 1; index >= 0; index-- {
+		frame := i.stack[index]
+		line := 0
+		if frame.token != nil {
+			line = frame.token.line
+		}
+		fmt.Fprintf(c.out, "#%d %s at line %d", len(i.stack)-1-index, frame.name, line)
+		if frame.call_token != nil {
+			fmt.Fprintf(c.out, ", called from line %d", frame.call_token.line)
+		}
+		fmt.Fprintln(c.out)
+	}
+}
+
+func (c *DebugCli) show_scopes(i *Interpreter, frame *CallFrame) {
+	for scope := frame.scope; scope != nil; scope = scope.enclosing_scope {
+		fmt.Fprintf(c.out, "%s (level %d)\n", scope.scope_name, scope.scope_level)
+		for _, variable := range scope_variables(scope) {
+			fmt.Fprintf(c.out, "	%s : %s = %s\n", variable.name, type_name(variable.stype), format_value(i.value(frame, variable), variable.stype))
+		}
+	}
+}
+
+func (c *DebugCli) show_watches(d *Debugger, i *Interpreter, frame *CallFrame) {
+	for index, watch := range d.watches {
+		result, err := d.evaluate(i, frame, watch)
+		if err != nil {
+			result = err.Error()
+		}
+		fmt.Fprintf(c.out, "watch %d: %s = %s\n", index+1, watch, result)
+	}
+}
+
+func (c *DebugCli) help() {
+	fmt.Fprintln(c.out, "c, continue      run until the next breakpoint")
+	fmt.Fprintln(c.out, "s, step          step into procedure calls")
+	fmt.Fprintln(c.out, "n, next          step over procedure calls")
+	fmt.Fprintln(c.out, "o, out           run until the current procedure returns")
+	fmt.Fprintln(c.out, "b, break LINE    set a breakpoint")
+	fmt.Fprintln(c.out, "d, delete LINE   remove a breakpoint")
+	fmt.Fprintln(c.out, "bt, stack        show the call stack")
+	fmt.Fprintln(c.out, "p, print EXPR    evaluate an expression")
+	fmt.Fprintln(c.out, "watch EXPR       evaluate an expression at every stop")
+	fmt.Fprintln(c.out, "unwatch N        remove a watch expression")
+	fmt.Fprintln(c.out, "vars             show the variables of every visible scope")
+	fmt.Fprintln(c.out, "l, list          show the source around the current line")
+	fmt.Fprintln(c.out, "q, quit          abort the program")
+}
+
+func (c *DebugCli) stopped(d *Debugger, i *Interpreter, reason string) int {
+	frame := i.stack[len(i.stack)-1]
+	where := fmt.Sprintf("line %d", frame.token.line)
+	if frame.token.file != "" {
+		where += " of " + frame.token.file
+	}
+	fmt.Fprintf(c.out, "Stopped (%s) at %s in %s\n", reason, where, frame.name)
+	c.show_line(frame.token.file, frame.token.line)
+	c.show_watches(d, i, frame)
+	for {
+		fmt.Fprint(c.out, "(pdb) ")
+		if c.in.Scan() == false {
+			d.replace_breakpoints(nil)
+			return DEBUG_CONTINUE
+		}
+		fields := strings.Fields(c.in.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		arg := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(c.in.Text()), fields[0]))
+		switch fields[0] {
+		case "c", "continue":
+			return DEBUG_CONTINUE
+		case "s", "step":
+			return DEBUG_STEP_IN
+		case "n", "next":
+			return DEBUG_STEP_OVER
+		case "o", "out", "finish":
+			return DEBUG_STEP_OUT
+		case "b", "break", "d", "delete":
+			line, err := strconv.Atoi(arg)
+			if err != nil {
+				fmt.Fprintf(c.out, "invalid line '%s'\n", arg)
+				continue
+			}
+			d.set_breakpoint(line, fields[0] == "b" || fields[0] == "break")
+		case "bt", "stack":
+			c.show_stack(i)
+		case "p", "print":
+			result, err := d.evaluate(i, frame, arg)
+			if err != nil {
+				result = err.Error()
+			}
+			fmt.Fprintln(c.out, result)
+		case "watch":
+			d.watches = append(d.watches, arg)
+		case "unwatch":
+			index, err := strconv.Atoi(arg)
+			if err != nil || index < 1 || index > len(d.watches) {
+				fmt.Fprintf(c.out, "no watch '%s'\n", arg)
+				continue
+			}
+			d.watches = append(d.watches[:index-1], d.watches[index:]...)
+		case "vars":
+			c.show_scopes(i, frame)
+		case "l", "list":
+			for line := frame.token.line - 3; line <= frame.token.line+3; line++ {
+				c.show_line(frame.token.file, line)
+			}
+		case "q", "quit":
+			os.Exit(0)
+		case "h", "help":
+			c.help()
+		default:
+			fmt.Fprintf(c.out, "unknown command '%s', try help\n", fields[0])
+		}
+	}
+}
+
+func run_debugger(path string, defines []string, search []string, in io.Reader, out io.Writer) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	tokens := preprocess(strings.NewReader(string(content)), path, defines)
+	parser := rules{lexer{0, len(tokens), tokens}}
+	tree := parser.Parse()
+	load_units(tree, path, search, defines, nil)
+	symbol_table := new_top_scope("Global", program_uses(tree))
+	analyser := SemanticsAnalyser{symbol_table, nil}
+	analyser.check(tree)
+	cli := &DebugCli{bufio.NewScanner(in), out, map[string][]string{"": strings.Split(string(content), "\n")}}
+	interpreter := new_interpreter(symbol_table)
+	interpreter.debugger = new_debugger(cli, true)
+	if err := interpreter.execute(context.Background(), tree); err != nil {
+		fmt.Fprintln(out, err)
+	}
+	fmt.Fprintln(out, "Program finished")
+	cli.show_scopes(interpreter, interpreter.stack[0])
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* Stepping into a procedure from an include file shows that file's line, not the program's line of the same number */
+func TestDebuggerShowsIncludedLine(t *testing.T) {
+	path := write_program(t, "main.pas", `PROGRAM Main;
+{$I body.inc}
+BEGIN
+   Show
+END.
+`)
+	include := filepath.Join(filepath.Dir(path), "body.inc")
+	if err := os.WriteFile(include, []byte("PROCEDURE Show;\nBEGIN\n   WRITELN('from include')\nEND;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := &strings.Builder{}
+	if err := run_debugger(path, nil, nil, strings.NewReader("s\nl\nc\n"), out); err != nil {
+		t.Fatal(err)
+	}
+	want := "Stopped (step) at line 3 of " + include + " in SHOW\n   3	   WRITELN('from include')\n"
+	if strings.Contains(out.String(), want) == false || strings.Contains(out.String(), "   3	BEGIN\n") == true {
+		t.Errorf("debugger output\n%s\nwant\n%s", out, want)
+	}
+}
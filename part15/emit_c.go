@@ -0,0 +1,454 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+	C BACKEND
+*/
+
+var c_reserved = map[string]bool{
+	"auto": true, "break": true, "case": true, "char": true, "const": true, "continue": true,
+	"default": true, "do": true, "double": true, "else": true, "enum": true, "extern": true,
+	"float": true, "for": true, "goto": true, "if": true, "inline": true, "int": true,
+	"long": true, "register": true, "restrict": true, "return": true, "short": true,
+	"signed": true, "sizeof": true, "static": true, "struct": true, "switch": true,
+	"typedef": true, "union": true, "unsigned": true, "void": true, "volatile": true,
+	"while": true, "main": true, "printf": true, "frame": true, "link": true,
+	"exit": true, "fflush": true, "fprintf": true, "stdout": true, "stderr": true,
+	"runtime_error": true, "int_div": true, "int_mod": true, "real_div": true,
+	"int_wrap": true, "int_overflow": true, "int_range": true,
+}
+
+/* Operators that fail on a zero divisor, computed by a helper checking it */
+var c_checked_operators = map[int]string{
+	INTEGER_DIV: "int_div",
+	MOD:         "int_mod",
+	FLOAT_DIV:   "real_div",
+}
+
+/* The runtime the generated code calls, each part emitted when it is used */
+var c_runtime = []struct {
+	name   string
+	source string
+}{
+	{"runtime_error", `static void runtime_error(int code, int line, const char *message) {
+	fflush(stdout);
+	fprintf(stderr, "Runtime error %d at line %d: %s\n", code, line, message);
+	exit(255);
+}
+`},
+	{"int_wrap", `static long long int_wrap(long long value, int bits) {
+	unsigned long long half = 1ULL << (bits - 1);
+	return (long long)(((unsigned long long)value + half) & (2 * half - 1)) - (long long)half;
+}
+`},
+	{"int_overflow", `static long long int_overflow(long long value, int bits, int line) {
+	if (int_wrap(value, bits) != value) {
+		runtime_error(215, line, "arithmetic overflow");
+	}
+	return value;
+}
+`},
+	{"int_range", `static long long int_range(double value, int bits, int line) {
+	double half = (double)(1LL << (bits - 1));
+	if (value < -half || value > half - 1) {
+		runtime_error(201, line, "range check error");
+	}
+	return (long long)value;
+}
+`},
+	{"int_div", `static long long int_div(long long a, long long b, int line) {
+	if (b == 0) {
+		runtime_error(200, line, "division by zero");
+	}
+	return a / b;
+}
+`},
+	{"int_mod", `static long long int_mod(long long a, long long b, int line) {
+	if (b == 0) {
+		runtime_error(200, line, "division by zero");
+	}
+	return a % b;
+}
+`},
+	{"real_div", `static double real_div(double a, double b, int line) {
+	if (b == 0) {
+		runtime_error(200, line, "division by zero");
+	}
+	return a / b;
+}
+`},
+}
+
+var c_operators = map[int]string{
+	PLUS:          "+",
+	MINUS:         "-",
+	MUL:           "*",
+	INTEGER_DIV:   "/",
+	MOD:           "%",
+	FLOAT_DIV:     "/",
+	EQUAL:         "==",
+	NOT_EQUAL:     "!=",
+	LESS:          "<",
+	LESS_EQUAL:    "<=",
+	GREATER:       ">",
+	GREATER_EQUAL: ">=",
+}
+
+/*
+Every procedure gets a frame struct holding its parameters and locals,
+with a link to the frame of the procedure it is nested in
+*/
+type CEmitter struct {
+	types      *strings.Builder
+	prototypes *strings.Builder
+	functions  *strings.Builder
+	out        *strings.Builder
+	scope      *ScopedSymbolTable
+	names      map[*ScopedSymbolTable]string
+	runtime    map[string]bool
+}
+
+func c_name(name string) string {
+	name = strings.ToLower(name)
+	if c_reserved[name] == true {
+		return name + "_"
+	}
+	return name
+}
+
+func c_type(stype *BuiltinSymbol) string {
+	if stype.name == "INTEGER_CONST" {
+		return "long long"
+	}
+	return "double"
+}
+
+func emit_c(w io.Writer, tree *Block, scope *ScopedSymbolTable) error {
+	e := &CEmitter{&strings.Builder{}, &strings.Builder{}, &strings.Builder{}, &strings.Builder{}, scope, make(map[*ScopedSymbolTable]string), make(map[string]bool)}
+	globals := &strings.Builder{}
+	for _, decl := range tree.declaration_list.elem {
+		if v, ok := decl.(*VarDeclaration); ok == true {
+			symbol, _ := scope.lookup(v.token.tstring, true)
+			variable := symbol.(*VarSymbol)
+			fmt.Fprintf(globals, "static %s %s;\n", c_type(variable.stype), c_name(variable.name))
+		}
+	}
+	e.procedures(tree, "")
+	main := &strings.Builder{}
+	e.out = main
+	e.statement(tree.compound, 1)
+	fmt.Fprintln(w, "#include <stdio.h>")
+	if e.runtime["runtime_error"] == true {
+		fmt.Fprintln(w, "#include <stdlib.h>")
+	}
+	fmt.Fprintln(w)
+	for _, part := range c_runtime {
+		if e.runtime[part.name] == true {
+			fmt.Fprintln(w, part.source)
+		}
+	}
+	io.WriteString(w, e.types.String())
+	io.WriteString(w, e.prototypes.String())
+	io.WriteString(w, globals.String())
+	io.WriteString(w, e.functions.String())
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "int main(void) {")
+	io.WriteString(w, main.String())
+	fmt.Fprintln(w, "\treturn 0;")
+	fmt.Fprintln(w, "}")
+	return nil
+}
+
+/* Emits the frame struct and function of every procedure declared in block, innermost first */
+func (e *CEmitter) procedures(block *Block, prefix string) {
+	for _, decl := range block.declaration_list.elem {
+		v, ok := decl.(*ProcedureDecl)
+		if ok == false {
+			continue
+		}
+		symbol, _ := e.scope.lookup(v.proc_name, true)
+		proc := symbol.(*ProcedureSymbol)
+		/* the number ending the name keeps nested A.B apart from a global A_B */
+		name, ok := e.names[proc.scope]
+		if ok == false {
+			name = fmt.Sprintf("%s%s_%d", prefix, strings.ToLower(proc.name), len(e.names))
+			e.names[proc.scope] = name
+		}
+		/* a FORWARD heading lets calls precede the function */
+		if v.block == nil {
+			fmt.Fprintf(e.prototypes, "%s;\n", e.signature(proc))
+			continue
+		}
+		enclosing := e.scope
+		e.scope = proc.scope
+		e.procedures(v.block, name+"_")
+		e.frame(proc, v.block)
+		e.function(proc, v.block)
+		e.scope = enclosing
+	}
+}
+
+func (e *CEmitter) frame(proc *ProcedureSymbol, block *Block) {
+	name := e.names[proc.scope]
+	fmt.Fprintf(e.types, "struct frame_%s {\n", name)
+	if parent, ok := e.names[proc.scope.enclosing_scope]; ok == true {
+		fmt.Fprintf(e.types, "\tstruct frame_%s *link;\n", parent)
+	}
+	for _, param := range proc.params {
+		fmt.Fprintf(e.types, "\t%s %s;\n", c_type(param.stype), c_name(param.name))
+	}
+	for _, decl := range block.declaration_list.elem {
+		if v, ok := decl.(*VarDeclaration); ok == true {
+			symbol, _ := proc.scope.lookup(v.token.tstring, true)
+			variable := symbol.(*VarSymbol)
+			fmt.Fprintf(e.types, "\t%s %s;\n", c_type(variable.stype), c_name(variable.name))
+		}
+	}
+	/* C99 forbids empty structs */
+	fmt.Fprintln(e.types, "\tchar unused;")
+	fmt.Fprintln(e.types, "};")
+	fmt.Fprintln(e.types)
+}
+
+func (e *CEmitter) signature(proc *ProcedureSymbol) string {
+	params := []string{}
+	if parent, ok := e.names[proc.scope.enclosing_scope]; ok == true {
+		params = append(params, fmt.Sprintf("struct frame_%s *link", parent))
+	}
+	for _, param := range proc.params {
+		params = append(params, c_type(param.stype)+" "+c_name(param.name))
+	}
+	if len(params) == 0 {
+		params = append(params, "void")
+	}
+	return fmt.Sprintf("void proc_%s(%s)", e.names[proc.scope], strings.Join(params, ", "))
+}
+
+func (e *CEmitter) function(proc *ProcedureSymbol, block *Block) {
+	name := e.names[proc.scope]
+	body := &strings.Builder{}
+	e.out = body
+	fmt.Fprintln(body)
+	fmt.Fprintf(body, "%s {\n", e.signature(proc))
+	fmt.Fprintf(body, "\tstruct frame_%s frame = {0};\n", name)
+	fmt.Fprintln(body, "\t(void)frame;")
+	if _, ok := e.names[proc.scope.enclosing_scope]; ok == true {
+		fmt.Fprintln(body, "\tframe.link = link;")
+	}
+	for _, param := range proc.params {
+		fmt.Fprintf(body, "\tframe.%s = %s;\n", c_name(param.name), c_name(param.name))
+	}
+	e.statement(block.compound, 1)
+	fmt.Fprintln(body, "}")
+	e.functions.WriteString(body.String())
+}
+
+/* Walks the static links up to the frame of scope, globals live outside any frame */
+func (e *CEmitter) frame_of(scope *ScopedSymbolTable) string {
+	if scope.enclosing_scope == nil {
+		return ""
+	}
+	if scope == e.scope {
+		return "frame."
+	}
+	access := "frame.link->"
+	for level := e.scope.scope_level - 1; level > scope.scope_level; level-- {
+		access += "link->"
+	}
+	return access
+}
+
+func (e *CEmitter) variable(name string) string {
+	for scope := e.scope; scope != nil; scope = scope.enclosing_scope {
+		if _, ok := scope.lookup(name, true); ok == true {
+			return e.frame_of(scope) + c_name(name)
+		}
+	}
+	compile_error("C backend", nil, "%s has no storage", name)
+	return ""
+}
+
+func (e *CEmitter) statement(node interface{}, depth int) {
+	indent := strings.Repeat("\t", depth)
+	switch v := node.(type) {
+	case *Compound:
+		for _, elem := range v.elem {
+			e.statement(elem, depth)
+		}
+	case *Assign:
+		symbol, _ := e.scope.lookup(v.variable.token.tstring, false)
+		variable := symbol.(*VarSymbol)
+		fmt.Fprintf(e.out, "%s%s = %s;\n", indent, e.variable(variable.name), e.stored(v.expr, variable.stype, v.token))
+	case *ProcedureCall:
+		if v.proc_symbol == nil {
+			e.writeln(v.args, indent)
+			return
+		}
+		args := []string{}
+		enclosing := v.proc_symbol.scope.enclosing_scope
+		if enclosing.enclosing_scope != nil {
+			link := e.frame_of(enclosing)
+			if link == "frame." {
+				args = append(args, "&frame")
+			} else {
+				args = append(args, strings.TrimSuffix(link, "->"))
+			}
+		}
+		for index, arg := range v.args {
+			args = append(args, e.stored(arg, v.proc_symbol.params[index].stype, v.token))
+		}
+		fmt.Fprintf(e.out, "%sproc_%s(%s);\n", indent, e.names[v.proc_symbol.scope], strings.Join(args, ", "))
+	case *While:
+		fmt.Fprintf(e.out, "%swhile (%s) {\n", indent, e.expression(v.condition, 0))
+		e.statement(v.body, depth+1)
+		fmt.Fprintf(e.out, "%s}\n", indent)
+	case *If:
+		fmt.Fprintf(e.out, "%sif (%s) {\n", indent, e.expression(v.condition, 0))
+		e.statement(v.then_branch, depth+1)
+		if v.else_branch != nil {
+			fmt.Fprintf(e.out, "%s} else {\n", indent)
+			e.statement(v.else_branch, depth+1)
+		}
+		fmt.Fprintf(e.out, "%s}\n", indent)
+	case nil:
+	default:
+		compile_error("C backend", node_token(v), "cannot emit %s", node_kind(v))
+	}
+}
+
+func (e *CEmitter) writeln(args []*Node, indent string) {
+	layout := ""
+	values := []string{}
+	analyser := SemanticsAnalyser{e.scope, nil}
+	for _, arg := range args {
+		if str, ok := arg.token.(*Str); ok == true {
+			layout += strings.Replace(str.token.tstring, "%", "%%", -1)
+			continue
+		}
+		if analyser.type_of(arg).name == "INTEGER_CONST" {
+			layout += "%lld"
+			values = append(values, "(long long)("+e.expression(arg, 0)+")")
+		} else {
+			layout += "%f"
+			values = append(values, e.expression(arg, 0))
+		}
+	}
+	fmt.Fprintf(e.out, "%sprintf(%s", indent, strconv.Quote(layout+"\n"))
+	for _, value := range values {
+		fmt.Fprintf(e.out, ", %s", value)
+	}
+	fmt.Fprintln(e.out, ");")
+}
+
+/* Expression converted to the wanted C type, the cast truncates REAL like the interpreter */
+func (e *CEmitter) typed(node *Node, stype *BuiltinSymbol) string {
+	analyser := SemanticsAnalyser{e.scope, nil}
+	source := e.expression(node, 0)
+	if analyser.type_of(node).name == stype.name {
+		return source
+	}
+	return fmt.Sprintf("(%s)(%s)", c_type(stype), source)
+}
+
+/* Expression converted for storing in a variable of type stype at token, out of range INTEGER values wrapping or failing under {$R+} */
+func (e *CEmitter) stored(node *Node, stype *BuiltinSymbol, token *lexemes) string {
+	analyser := SemanticsAnalyser{e.scope, nil}
+	if stype.name != "INTEGER_CONST" || may_not_fit(analyser, node, token) == false {
+		return e.typed(node, stype)
+	}
+	if token.checks&CHECK_RANGE != 0 {
+		e.use("int_range")
+		return fmt.Sprintf("int_range(%s, %d, %d)", e.expression(node, 0), integer_bits(token), token.line)
+	}
+	e.use("int_wrap")
+	return fmt.Sprintf("int_wrap(%s, %d)", e.typed(node, stype), integer_bits(token))
+}
+
+/* An exact INTEGER result wrapped to the width of INTEGER, or failing on overflow under {$Q+} */
+func (e *CEmitter) integer(op *lexemes, source string) string {
+	if op.checks&CHECK_OVERFLOW != 0 {
+		e.use("int_overflow")
+		return fmt.Sprintf("int_overflow(%s, %d, %d)", source, integer_bits(op), op.line)
+	}
+	e.use("int_wrap")
+	return fmt.Sprintf("int_wrap(%s, %d)", source, integer_bits(op))
+}
+
+/* Marks a runtime part as used, along with the parts it calls */
+func (e *CEmitter) use(part string) {
+	e.runtime[part] = true
+	switch part {
+	case "int_overflow":
+		e.runtime["int_wrap"] = true
+		e.runtime["runtime_error"] = true
+	case "int_range", "int_div", "int_mod", "real_div":
+		e.runtime["runtime_error"] = true
+	}
+}
+
+func (e *CEmitter) expression(node *Node, parent int) string {
+	analyser := SemanticsAnalyser{e.scope, nil}
+	switch v := node.token.(type) {
+	case *Number:
+		return v.token.tstring
+	case *Var:
+		return e.variable(v.token.tstring)
+	case *Op:
+		if node.left == nil {
+			operand := e.expression(node.right, 4)
+			integer := integer_operation(analyser, node, v.token)
+			if v.token.ttype == PLUS {
+				if integer == true {
+					return e.integer(v.token, operand)
+				}
+				return operand
+			}
+			/* keep "- -x" from turning into the decrement operator */
+			if strings.HasPrefix(operand, "-") == true {
+				operand = "(" + operand + ")"
+			}
+			if integer == true {
+				return e.integer(v.token, "-"+operand)
+			}
+			return "-" + operand
+		}
+		precedence := op_precedence(v.token.ttype)
+		if _, checked := c_checked_operators[v.token.ttype]; checked == true {
+			precedence = 0
+		}
+		left := e.expression(node.left, precedence)
+		right := e.expression(node.right, precedence+1)
+		if v.token.ttype == FLOAT_DIV {
+			if analyser.type_of(node.left).name == "INTEGER_CONST" {
+				left = "(double)(" + left + ")"
+			}
+			if analyser.type_of(node.right).name == "INTEGER_CONST" {
+				right = "(double)(" + right + ")"
+			}
+		}
+		if helper, checked := c_checked_operators[v.token.ttype]; checked == true {
+			e.use(helper)
+			source := fmt.Sprintf("%s(%s, %s, %d)", helper, left, right, v.token.line)
+			if integer_operation(analyser, node, v.token) == true {
+				return e.integer(v.token, source)
+			}
+			return source
+		}
+		source := fmt.Sprintf("%s %s %s", left, c_operators[v.token.ttype], right)
+		if integer_operation(analyser, node, v.token) == true {
+			return e.integer(v.token, source)
+		}
+		if precedence < parent {
+			return "(" + source + ")"
+		}
+		return source
+	}
+	compile_error("C backend", node_token(node), "cannot emit %s", node_kind(node.token))
+	return ""
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+	GO BACKEND
+*/
+
+var go_reserved = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true, "default": true,
+	"defer": true, "else": true, "fallthrough": true, "for": true, "func": true, "go": true,
+	"goto": true, "if": true, "import": true, "interface": true, "map": true, "package": true,
+	"range": true, "return": true, "select": true, "struct": true, "switch": true, "type": true,
+	"var": true, "fmt": true, "main": true, "int64": true, "float64": true, "bool": true,
+	"string": true, "len": true, "print": true, "println": true, "true": true, "false": true,
+	"nil": true, "int": true, "os": true,
+	"runtime_error": true, "int_div": true, "int_mod": true, "real_div": true,
+	"int_wrap": true, "int_overflow": true, "int_range": true,
+}
+
+/* Operators that fail on a zero divisor, computed by a helper checking it */
+var go_checked_operators = map[int]string{
+	INTEGER_DIV: "int_div",
+	MOD:         "int_mod",
+	FLOAT_DIV:   "real_div",
+}
+
+/* The runtime the generated code calls, each part emitted when it is used */
+var go_runtime = []struct {
+	name   string
+	source string
+}{
+	{"runtime_error", `func runtime_error(code int, line int, message string) {
+	fmt.Fprintf(os.Stderr, "Runtime error %d at line %d: %s\n", code, line, message)
+	os.Exit(255)
+}
+`},
+	{"int_wrap", `func int_wrap(value int64, bits int) int64 {
+	shift := 64 - bits
+	return value << shift >> shift
+}
+`},
+	{"int_overflow", `func int_overflow(value int64, bits int, line int) int64 {
+	if int_wrap(value, bits) != value {
+		runtime_error(215, line, "arithmetic overflow")
+	}
+	return value
+}
+`},
+	{"int_range", `func int_range(value float64, bits int, line int) int64 {
+	half := float64(int64(1) << (bits - 1))
+	if value < -half || value > half-1 {
+		runtime_error(201, line, "range check error")
+	}
+	return int64(value)
+}
+`},
+	{"int_div", `func int_div(a int64, b int64, line int) int64 {
+	if b == 0 {
+		runtime_error(200, line, "division by zero")
+	}
+	return a / b
+}
+`},
+	{"int_mod", `func int_mod(a int64, b int64, line int) int64 {
+	if b == 0 {
+		runtime_error(200, line, "division by zero")
+	}
+	return a % b
+}
+`},
+	{"real_div", `func real_div(a float64, b float64, line int) float64 {
+	if b == 0 {
+		runtime_error(200, line, "division by zero")
+	}
+	return a / b
+}
+`},
+}
+
+var go_operators = map[int]string{
+	PLUS:          "+",
+	MINUS:         "-",
+	MUL:           "*",
+	INTEGER_DIV:   "/",
+	MOD:           "%",
+	FLOAT_DIV:     "/",
+	EQUAL:         "==",
+	NOT_EQUAL:     "!=",
+	LESS:          "<",
+	LESS_EQUAL:    "<=",
+	GREATER:       ">",
+	GREATER_EQUAL: ">=",
+}
+
+type GoEmitter struct {
+	out       *strings.Builder
+	scope     *ScopedSymbolTable
+	used      map[Symbol]bool
+	uses_io   bool
+	uses_math bool
+	runtime   map[string]bool
+}
+
+func go_name(name string) string {
+	name = strings.ToLower(name)
+	if go_reserved[name] == true {
+		return name + "_"
+	}
+	return name
+}
+
+func go_type(stype *BuiltinSymbol) string {
+	if stype.name == "INTEGER_CONST" {
+		return "int64"
+	}
+	return "float64"
+}
+
+func op_precedence(op int) int {
+	switch {
+	case relational(op):
+		return 1
+	case prior2(op):
+		return 2
+	}
+	return 3
+}
+
+func emit_go(w io.Writer, tree *Block, scope *ScopedSymbolTable) error {
+	e := &GoEmitter{&strings.Builder{}, scope, make(map[Symbol]bool), false, false, make(map[string]bool)}
+	e.mark_used(tree)
+	body := &strings.Builder{}
+	e.out = body
+	e.block(tree, nil)
+	source := &strings.Builder{}
+	fmt.Fprintln(source, "package main")
+	fmt.Fprintln(source)
+	if e.uses_io == true {
+		fmt.Fprintln(source, "import \"fmt\"")
+	}
+	if e.uses_math == true {
+		fmt.Fprintln(source, "import \"math\"")
+	}
+	if e.runtime["runtime_error"] == true {
+		fmt.Fprintln(source, "import \"os\"")
+	}
+	fmt.Fprintln(source)
+	fmt.Fprintln(source, "func main() {")
+	source.WriteString(body.String())
+	fmt.Fprintln(source, "}")
+	for _, part := range go_runtime {
+		if e.runtime[part.name] == true {
+			fmt.Fprintln(source)
+			source.WriteString(part.source)
+		}
+	}
+	formatted, err := format.Source([]byte(source.String()))
+	if err != nil {
+		return fmt.Errorf("go backend produced invalid source: %v", err)
+	}
+	_, err = w.Write(formatted)
+	return err
+}
+
+/* Go refuses locals that are never read, so remember which ones are */
+func (e *GoEmitter) mark_used(node interface{}) {
+	switch v := node.(type) {
+	case *Block:
+		for _, decl := range v.declaration_list.elem {
+			e.mark_used(decl)
+		}
+		e.mark_used(v.compound)
+	case *ProcedureDecl:
+		if v.block == nil {
+			break
+		}
+		symbol, _ := e.scope.lookup(v.proc_name, true)
+		enclosing := e.scope
+		e.scope = symbol.(*ProcedureSymbol).scope
+		e.mark_used(v.block)
+		e.scope = enclosing
+	case *Compound:
+		for _, elem := range v.elem {
+			e.mark_used(elem)
+		}
+	case *Assign:
+		e.mark_used(v.expr)
+	case *ProcedureCall:
+		if v.proc_symbol != nil {
+			e.used[v.proc_symbol] = true
+		}
+		for _, arg := range v.args {
+			e.mark_used(arg)
+		}
+	case *While:
+		e.mark_used(v.condition)
+		e.mark_used(v.body)
+	case *If:
+		e.mark_used(v.condition)
+		e.mark_used(v.then_branch)
+		e.mark_used(v.else_branch)
+	case *Node:
+		if variable, ok := v.token.(*Var); ok == true {
+			symbol, _ := e.scope.lookup(variable.token.tstring, false)
+			e.used[symbol] = true
+		}
+		if v.left != nil {
+			e.mark_used(v.left)
+		}
+		if v.right != nil {
+			e.mark_used(v.right)
+		}
+	}
+}
+
+func (e *GoEmitter) block(block *Block, params []*VarSymbol) {
+	unused := []string{}
+	/* procedures already declared by their FORWARD heading */
+	declared := make(map[*ProcedureSymbol]bool)
+	for _, decl := range block.declaration_list.elem {
+		switch v := decl.(type) {
+		case *VarDeclaration:
+			symbol, _ := e.scope.lookup(v.token.tstring, true)
+			variable := symbol.(*VarSymbol)
+			fmt.Fprintf(e.out, "var %s %s\n", go_name(variable.name), go_type(variable.stype))
+			if e.used[variable] == false {
+				unused = append(unused, go_name(variable.name))
+			}
+		case *ProcedureDecl:
+			symbol, _ := e.scope.lookup(v.proc_name, true)
+			proc := symbol.(*ProcedureSymbol)
+			signature := e.signature(proc)
+			if declared[proc] == false {
+				fmt.Fprintf(e.out, "var %s func(%s)\n", go_name(proc.name), signature)
+				declared[proc] = true
+			}
+			if v.block == nil {
+				continue
+			}
+			fmt.Fprintf(e.out, "%s = func(%s) {\n", go_name(proc.name), signature)
+			enclosing := e.scope
+			e.scope = proc.scope
+			e.block(v.block, proc.params)
+			e.scope = enclosing
+			fmt.Fprintln(e.out, "}")
+			if e.used[proc] == false {
+				unused = append(unused, go_name(proc.name))
+			}
+		}
+	}
+	for _, name := range unused {
+		fmt.Fprintf(e.out, "_ = %s\n", name)
+	}
+	e.statement(block.compound)
+}
+
+func (e *GoEmitter) signature(proc *ProcedureSymbol) string {
+	params := []string{}
+	for _, param := range proc.params {
+		params = append(params, go_name(param.name)+" "+go_type(param.stype))
+	}
+	return strings.Join(params, ", ")
+}
+
+func (e *GoEmitter) statement(node interface{}) {
+	switch v := node.(type) {
+	case *Compound:
+		for _, elem := range v.elem {
+			e.statement(elem)
+		}
+	case *Assign:
+		symbol, _ := e.scope.lookup(v.variable.token.tstring, false)
+		variable := symbol.(*VarSymbol)
+		fmt.Fprintf(e.out, "%s = %s\n", go_name(variable.name), e.stored(v.expr, variable.stype, v.token))
+	case *ProcedureCall:
+		if v.proc_symbol == nil {
+			e.writeln(v.args)
+			return
+		}
+		args := []string{}
+		for index, arg := range v.args {
+			args = append(args, e.stored(arg, v.proc_symbol.params[index].stype, v.token))
+		}
+		fmt.Fprintf(e.out, "%s(%s)\n", go_name(v.proc_name), strings.Join(args, ", "))
+	case *While:
+		fmt.Fprintf(e.out, "for %s {\n", e.condition(v.condition))
+		e.statement(v.body)
+		fmt.Fprintln(e.out, "}")
+	case *If:
+		fmt.Fprintf(e.out, "if %s {\n", e.condition(v.condition))
+		e.statement(v.then_branch)
+		if v.else_branch != nil {
+			fmt.Fprintln(e.out, "} else {")
+			e.statement(v.else_branch)
+		}
+		fmt.Fprintln(e.out, "}")
+	case nil:
+	default:
+		compile_error("Go backend", node_token(v), "cannot emit %s", node_kind(v))
+	}
+}
+
+func (e *GoEmitter) writeln(args []*Node) {
+	e.uses_io = true
+	layout := ""
+	values := []string{}
+	analyser := SemanticsAnalyser{e.scope, nil}
+	for _, arg := range args {
+		if str, ok := arg.token.(*Str); ok == true {
+			layout += strings.Replace(str.token.tstring, "%", "%%", -1)
+			continue
+		}
+		if analyser.type_of(arg).name == "INTEGER_CONST" {
+			layout += "%d"
+		} else {
+			layout += "%f"
+		}
+		values = append(values, e.expression(arg, 0))
+	}
+	fmt.Fprintf(e.out, "fmt.Printf(%s", strconv.Quote(layout+"\n"))
+	for _, value := range values {
+		fmt.Fprintf(e.out, ", %s", value)
+	}
+	fmt.Fprintln(e.out, ")")
+}
+
+/* Pascal conditions are relational expressions, anything else is true when non zero */
+func (e *GoEmitter) condition(node *Node) string {
+	if op, ok := node.token.(*Op); ok == true && node.left != nil && relational(op.token.ttype) == true {
+		return e.expression(node, 0)
+	}
+	return e.expression(node, 0) + " != 0"
+}
+
+/* Expression converted to the wanted Go type, REAL to INTEGER truncating like the interpreter */
+func (e *GoEmitter) typed(node *Node, stype *BuiltinSymbol) string {
+	analyser := SemanticsAnalyser{e.scope, nil}
+	source := e.expression(node, 0)
+	if analyser.type_of(node).name == stype.name {
+		return source
+	}
+	if stype.name == "INTEGER_CONST" {
+		e.uses_math = true
+		return fmt.Sprintf("int64(math.Trunc(%s))", source)
+	}
+	return fmt.Sprintf("float64(%s)", source)
+}
+
+/* Expression converted for storing in a variable of type stype at token, out of range INTEGER values wrapping or failing under {$R+} */
+func (e *GoEmitter) stored(node *Node, stype *BuiltinSymbol, token *lexemes) string {
+	analyser := SemanticsAnalyser{e.scope, nil}
+	if stype.name != "INTEGER_CONST" || may_not_fit(analyser, node, token) == false {
+		return e.typed(node, stype)
+	}
+	if token.checks&CHECK_RANGE != 0 {
+		e.use("int_range")
+		return fmt.Sprintf("int_range(%s, %d, %d)", e.expression(node, 0), integer_bits(token), token.line)
+	}
+	e.use("int_wrap")
+	return fmt.Sprintf("int_wrap(%s, %d)", e.typed(node, stype), integer_bits(token))
+}
+
+/* An exact INTEGER result wrapped to the width of INTEGER, or failing on overflow under {$Q+} */
+func (e *GoEmitter) integer(op *lexemes, source string) string {
+	if op.checks&CHECK_OVERFLOW != 0 {
+		e.use("int_overflow")
+		return fmt.Sprintf("int_overflow(%s, %d, %d)", source, integer_bits(op), op.line)
+	}
+	e.use("int_wrap")
+	return fmt.Sprintf("int_wrap(%s, %d)", source, integer_bits(op))
+}
+
+/* Marks a runtime part as used, along with the parts it calls */
+func (e *GoEmitter) use(part string) {
+	e.runtime[part] = true
+	switch part {
+	case "int_overflow":
+		e.runtime["int_wrap"] = true
+		e.runtime["runtime_error"] = true
+	case "int_range", "int_div", "int_mod", "real_div":
+		e.runtime["runtime_error"] = true
+	}
+	if e.runtime["runtime_error"] == true {
+		e.uses_io = true
+	}
+}
+
+func (e *GoEmitter) expression(node *Node, parent int) string {
+	analyser := SemanticsAnalyser{e.scope, nil}
+	switch v := node.token.(type) {
+	case *Number:
+		return v.token.tstring
+	case *Var:
+		return go_name(v.token.tstring)
+	case *Op:
+		if node.left == nil {
+			operand := e.expression(node.right, 4)
+			integer := integer_operation(analyser, node, v.token)
+			if v.token.ttype == PLUS {
+				if integer == true {
+					return e.integer(v.token, operand)
+				}
+				return operand
+			}
+			/* Go has no "--" prefix operator but would lex one */
+			if strings.HasPrefix(operand, "-") == true {
+				operand = "(" + operand + ")"
+			}
+			if integer == true {
+				return e.integer(v.token, "-"+operand)
+			}
+			return "-" + operand
+		}
+		precedence := op_precedence(v.token.ttype)
+		if _, checked := go_checked_operators[v.token.ttype]; checked == true {
+			precedence = 0
+		}
+		left_type := analyser.type_of(node.left)
+		right_type := analyser.type_of(node.right)
+		left := e.expression(node.left, precedence)
+		right := e.expression(node.right, precedence+1)
+		switch {
+		case v.token.ttype == FLOAT_DIV:
+			if left_type.name == "INTEGER_CONST" {
+				left = "float64(" + left + ")"
+			}
+			if right_type.name == "INTEGER_CONST" {
+				right = "float64(" + right + ")"
+			}
+		case left_type.name != right_type.name:
+			if left_type.name == "INTEGER_CONST" {
+				left = "float64(" + left + ")"
+			} else {
+				right = "float64(" + right + ")"
+			}
+		}
+		if helper, checked := go_checked_operators[v.token.ttype]; checked == true {
+			e.use(helper)
+			source := fmt.Sprintf("%s(%s, %s, %d)", helper, left, right, v.token.line)
+			if integer_operation(analyser, node, v.token) == true {
+				return e.integer(v.token, source)
+			}
+			return source
+		}
+		source := fmt.Sprintf("%s %s %s", left, go_operators[v.token.ttype], right)
+		if integer_operation(analyser, node, v.token) == true {
+			return e.integer(v.token, source)
+		}
+		if precedence < parent {
+			return "(" + source + ")"
+		}
+		return source
+	}
+	compile_error("Go backend", node_token(node), "cannot emit %s", node_kind(node.token))
+	return ""
+}
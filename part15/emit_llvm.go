@@ -0,0 +1,571 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+	LLVM IR BACKEND
+
+	Globals become LLVM globals and every parameter and local gets its own
+	alloca. A procedure with nested procedures also builds an environment
+	holding a pointer to its enclosing environment (the static link) and a
+	pointer to each of its variables, which nested procedures receive as
+	their first argument. The output uses opaque pointers, so LLVM 14 needs
+	-opaque-pointers:
+
+		pascal -emit=llvm prog.pas > prog.ll && lli -opaque-pointers prog.ll
+*/
+
+var llvm_integer_operators = map[int]string{
+	PLUS:          "add",
+	MINUS:         "sub",
+	MUL:           "mul",
+	INTEGER_DIV:   "sdiv",
+	MOD:           "srem",
+	EQUAL:         "icmp eq",
+	NOT_EQUAL:     "icmp ne",
+	LESS:          "icmp slt",
+	LESS_EQUAL:    "icmp sle",
+	GREATER:       "icmp sgt",
+	GREATER_EQUAL: "icmp sge",
+}
+
+var llvm_real_operators = map[int]string{
+	PLUS:          "fadd",
+	MINUS:         "fsub",
+	MUL:           "fmul",
+	FLOAT_DIV:     "fdiv",
+	EQUAL:         "fcmp oeq",
+	NOT_EQUAL:     "fcmp une",
+	LESS:          "fcmp olt",
+	LESS_EQUAL:    "fcmp ole",
+	GREATER:       "fcmp ogt",
+	GREATER_EQUAL: "fcmp oge",
+}
+
+type LlvmEmitter struct {
+	out       *strings.Builder
+	scope     *ScopedSymbolTable
+	names     map[*ScopedSymbolTable]string
+	slots     map[*ScopedSymbolTable][]string
+	nested    map[*ScopedSymbolTable]bool
+	constants *strings.Builder
+	formats   int
+	temps     int
+	labels    int
+	/* the runtime errors the program may raise, each with a message constant */
+	errors map[int]bool
+}
+
+/* Reports a runtime error on stderr after what the program printed, and exits as the interpreter does */
+const llvm_runtime = `
+declare i32 @fflush(ptr)
+declare i32 @dprintf(i32, ptr, ...)
+declare void @exit(i32)
+
+@runtime.format = private unnamed_addr constant [33 x i8] c"Runtime error %d at line %d: %s\0A\00"
+
+define internal void @runtime_error(i32 %code, i32 %line, ptr %message) {
+entry:
+  %t1 = call i32 @fflush(ptr null)
+  %t2 = call i32 (i32, ptr, ...) @dprintf(i32 2, ptr @runtime.format, i32 %code, i32 %line, ptr %message)
+  call void @exit(i32 255)
+  unreachable
+}
+`
+
+func llvm_type(stype *BuiltinSymbol) string {
+	if stype.name == "INTEGER_CONST" {
+		return "i64"
+	}
+	return "double"
+}
+
+/* Decimal REAL literals are rarely exact doubles, which LLVM refuses, so use the hex form */
+func llvm_real(value float64) string {
+	return fmt.Sprintf("0x%016X", math.Float64bits(value))
+}
+
+func llvm_string(data string) string {
+	quoted := &strings.Builder{}
+	for _, b := range []byte(data) {
+		if b < 0x20 || b >= 0x7f || b == '"' || b == '\\' {
+			fmt.Fprintf(quoted, "\\%02X", b)
+		} else {
+			quoted.WriteByte(b)
+		}
+	}
+	return "c\"" + quoted.String() + "\""
+}
+
+func emit_llvm(w io.Writer, tree *Block, scope *ScopedSymbolTable) error {
+	e := &LlvmEmitter{nil, scope, make(map[*ScopedSymbolTable]string), make(map[*ScopedSymbolTable][]string), make(map[*ScopedSymbolTable]bool), &strings.Builder{}, 0, 0, 0, make(map[int]bool)}
+	types := &strings.Builder{}
+	functions := &strings.Builder{}
+	e.procedures(tree, "", types, functions)
+	e.function(scope, nil, tree, functions)
+	fmt.Fprintln(w, "; generated from Pascal source")
+	fmt.Fprintln(w)
+	io.WriteString(w, types.String())
+	for _, decl := range tree.declaration_list.elem {
+		if v, ok := decl.(*VarDeclaration); ok == true {
+			symbol, _ := scope.lookup(v.token.tstring, true)
+			variable := symbol.(*VarSymbol)
+			zero := "0"
+			if llvm_type(variable.stype) == "double" {
+				zero = "0.0"
+			}
+			fmt.Fprintf(w, "@var.%s = internal global %s %s\n", strings.ToLower(variable.name), llvm_type(variable.stype), zero)
+		}
+	}
+	io.WriteString(w, e.constants.String())
+	codes := []int{}
+	for code := range e.errors {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		message := runtime_messages[code] + "\x00"
+		fmt.Fprintf(w, "@runtime.%d = private unnamed_addr constant [%d x i8] %s\n", code, len(message), llvm_string(message))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "declare i32 @printf(ptr, ...)")
+	if len(e.errors) > 0 {
+		io.WriteString(w, llvm_runtime)
+	}
+	io.WriteString(w, functions.String())
+	return nil
+}
+
+func (e *LlvmEmitter) procedures(block *Block, prefix string, types *strings.Builder, functions *strings.Builder) {
+	for _, decl := range block.declaration_list.elem {
+		v, ok := decl.(*ProcedureDecl)
+		if ok == false {
+			continue
+		}
+		e.nested[e.scope] = true
+		symbol, _ := e.scope.lookup(v.proc_name, true)
+		proc := symbol.(*ProcedureSymbol)
+		name := prefix + strings.ToLower(proc.name)
+		e.names[proc.scope] = name
+		if v.block == nil {
+			continue
+		}
+		slots := []string{}
+		for _, param := range proc.params {
+			slots = append(slots, param.name)
+		}
+		for _, decl := range v.block.declaration_list.elem {
+			if variable, ok := decl.(*VarDeclaration); ok == true {
+				slots = append(slots, variable.token.tstring)
+			}
+		}
+		e.slots[proc.scope] = slots
+		enclosing := e.scope
+		e.scope = proc.scope
+		e.procedures(v.block, name+".", types, functions)
+		if e.nested[proc.scope] == true {
+			fields := strings.TrimSuffix(strings.Repeat("ptr, ", len(slots)+1), ", ")
+			fmt.Fprintf(types, "%%env.%s = type { %s }\n", name, fields)
+		}
+		e.function(proc.scope, proc.params, v.block, functions)
+		e.scope = enclosing
+	}
+}
+
+/* Emits the function of a procedure, or main when params is nil */
+func (e *LlvmEmitter) function(scope *ScopedSymbolTable, params []*VarSymbol, block *Block, functions *strings.Builder) {
+	e.scope = scope
+	e.out = &strings.Builder{}
+	e.temps = 0
+	fmt.Fprintln(e.out)
+	if params == nil {
+		fmt.Fprintln(e.out, "define i32 @main() {")
+	} else {
+		args := []string{}
+		if scope.enclosing_scope.enclosing_scope != nil {
+			args = append(args, "ptr %link")
+		}
+		for _, param := range params {
+			args = append(args, fmt.Sprintf("%s %%arg.%s", llvm_type(param.stype), strings.ToLower(param.name)))
+		}
+		fmt.Fprintf(e.out, "define internal void @proc.%s(%s) {\n", e.names[scope], strings.Join(args, ", "))
+	}
+	fmt.Fprintln(e.out, "entry:")
+	if params != nil {
+		for _, name := range e.slots[scope] {
+			fmt.Fprintf(e.out, "  %%var.%s = alloca %s\n", strings.ToLower(name), llvm_type(symbol_of(scope, name).stype))
+		}
+		for _, name := range e.slots[scope] {
+			variable := symbol_of(scope, name)
+			value := "0"
+			if llvm_type(variable.stype) == "double" {
+				value = "0.0"
+			}
+			if param_index(params, name) >= 0 {
+				value = "%arg." + strings.ToLower(name)
+			}
+			fmt.Fprintf(e.out, "  store %s %s, ptr %%var.%s\n", llvm_type(variable.stype), value, strings.ToLower(name))
+		}
+		if e.nested[scope] == true {
+			e.environment(scope)
+		}
+	}
+	e.statement(block.compound)
+	if params == nil {
+		fmt.Fprintln(e.out, "  ret i32 0")
+	} else {
+		fmt.Fprintln(e.out, "  ret void")
+	}
+	fmt.Fprintln(e.out, "}")
+	functions.WriteString(e.out.String())
+}
+
+func symbol_of(scope *ScopedSymbolTable, name string) *VarSymbol {
+	symbol, _ := scope.lookup(name, true)
+	return symbol.(*VarSymbol)
+}
+
+func param_index(params []*VarSymbol, name string) int {
+	for index, param := range params {
+		if param.name == name {
+			return index
+		}
+	}
+	return -1
+}
+
+/* Fills the environment nested procedures use to reach this procedure's variables */
+func (e *LlvmEmitter) environment(scope *ScopedSymbolTable) {
+	name := e.names[scope]
+	fmt.Fprintf(e.out, "  %%env = alloca %%env.%s\n", name)
+	link := "null"
+	if scope.enclosing_scope.enclosing_scope != nil {
+		link = "%link"
+	}
+	fmt.Fprintf(e.out, "  %%env.link = getelementptr %%env.%s, ptr %%env, i32 0, i32 0\n", name)
+	fmt.Fprintf(e.out, "  store ptr %s, ptr %%env.link\n", link)
+	for index, variable := range e.slots[scope] {
+		field := e.temp()
+		fmt.Fprintf(e.out, "  %s = getelementptr %%env.%s, ptr %%env, i32 0, i32 %d\n", field, name, index+1)
+		fmt.Fprintf(e.out, "  store ptr %%var.%s, ptr %s\n", strings.ToLower(variable), field)
+	}
+}
+
+func (e *LlvmEmitter) temp() string {
+	e.temps++
+	return fmt.Sprintf("%%t%d", e.temps)
+}
+
+/* Returns a pointer to the storage of name, loading it through static links if needed */
+func (e *LlvmEmitter) address(name string) string {
+	scope := e.scope
+	for ; scope != nil; scope = scope.enclosing_scope {
+		if _, ok := scope.lookup(name, true); ok == true {
+			break
+		}
+	}
+	switch {
+	case scope == nil:
+		compile_error("LLVM backend", nil, "%s has no storage", name)
+	case scope.enclosing_scope == nil:
+		return "@var." + strings.ToLower(name)
+	case scope == e.scope:
+		return "%var." + strings.ToLower(name)
+	}
+	env := "%link"
+	for level := e.scope.scope_level - 1; level > scope.scope_level; level-- {
+		next := e.temp()
+		fmt.Fprintf(e.out, "  %s = load ptr, ptr %s\n", next, env)
+		env = next
+	}
+	index := 0
+	for slot, variable := range e.slots[scope] {
+		if variable == name {
+			index = slot + 1
+		}
+	}
+	field := e.temp()
+	fmt.Fprintf(e.out, "  %s = getelementptr %%env.%s, ptr %s, i32 0, i32 %d\n", field, e.names[scope], env, index)
+	pointer := e.temp()
+	fmt.Fprintf(e.out, "  %s = load ptr, ptr %s\n", pointer, field)
+	return pointer
+}
+
+func (e *LlvmEmitter) statement(node interface{}) {
+	switch v := node.(type) {
+	case *Compound:
+		for _, elem := range v.elem {
+			e.statement(elem)
+		}
+	case *Assign:
+		symbol, _ := e.scope.lookup(v.variable.token.tstring, false)
+		variable := symbol.(*VarSymbol)
+		value := e.stored(v.expr, llvm_type(variable.stype), v.token)
+		pointer := e.address(variable.name)
+		fmt.Fprintf(e.out, "  store %s %s, ptr %s\n", llvm_type(variable.stype), value, pointer)
+	case *ProcedureCall:
+		if v.proc_symbol == nil {
+			e.writeln(v.args)
+			return
+		}
+		args := []string{}
+		enclosing := v.proc_symbol.scope.enclosing_scope
+		if enclosing.enclosing_scope != nil {
+			env := "%env"
+			if enclosing != e.scope {
+				env = "%link"
+				for level := e.scope.scope_level - 1; level > enclosing.scope_level; level-- {
+					next := e.temp()
+					fmt.Fprintf(e.out, "  %s = load ptr, ptr %s\n", next, env)
+					env = next
+				}
+			}
+			args = append(args, "ptr "+env)
+		}
+		for index, arg := range v.args {
+			stype := llvm_type(v.proc_symbol.params[index].stype)
+			args = append(args, stype+" "+e.stored(arg, stype, v.token))
+		}
+		fmt.Fprintf(e.out, "  call void @proc.%s(%s)\n", e.names[v.proc_symbol.scope], strings.Join(args, ", "))
+	case *While:
+		e.labels++
+		label := e.labels
+		fmt.Fprintf(e.out, "  br label %%while%d.cond\n", label)
+		fmt.Fprintf(e.out, "while%d.cond:\n", label)
+		condition := e.condition(v.condition)
+		fmt.Fprintf(e.out, "  br i1 %s, label %%while%d.body, label %%while%d.end\n", condition, label, label)
+		fmt.Fprintf(e.out, "while%d.body:\n", label)
+		e.statement(v.body)
+		fmt.Fprintf(e.out, "  br label %%while%d.cond\n", label)
+		fmt.Fprintf(e.out, "while%d.end:\n", label)
+	case *If:
+		e.labels++
+		label := e.labels
+		condition := e.condition(v.condition)
+		fmt.Fprintf(e.out, "  br i1 %s, label %%if%d.then, label %%if%d.else\n", condition, label, label)
+		fmt.Fprintf(e.out, "if%d.then:\n", label)
+		e.statement(v.then_branch)
+		fmt.Fprintf(e.out, "  br label %%if%d.end\n", label)
+		fmt.Fprintf(e.out, "if%d.else:\n", label)
+		e.statement(v.else_branch)
+		fmt.Fprintf(e.out, "  br label %%if%d.end\n", label)
+		fmt.Fprintf(e.out, "if%d.end:\n", label)
+	case nil:
+	default:
+		compile_error("LLVM backend", node_token(v), "cannot emit %s", node_kind(v))
+	}
+}
+
+func (e *LlvmEmitter) writeln(args []*Node) {
+	analyser := SemanticsAnalyser{e.scope, nil}
+	layout := ""
+	values := []string{}
+	for _, arg := range args {
+		if str, ok := arg.token.(*Str); ok == true {
+			layout += strings.Replace(str.token.tstring, "%", "%%", -1)
+			continue
+		}
+		if analyser.type_of(arg).name == "INTEGER_CONST" {
+			layout += "%lld"
+			values = append(values, "i64 "+e.typed(arg, "i64"))
+		} else {
+			layout += "%f"
+			values = append(values, "double "+e.typed(arg, "double"))
+		}
+	}
+	layout += "\n\x00"
+	e.formats++
+	fmt.Fprintf(e.constants, "@fmt.%d = private unnamed_addr constant [%d x i8] %s\n", e.formats, len(layout), llvm_string(layout))
+	values = append([]string{fmt.Sprintf("ptr @fmt.%d", e.formats)}, values...)
+	fmt.Fprintf(e.out, "  %s = call i32 (ptr, ...) @printf(%s)\n", e.temp(), strings.Join(values, ", "))
+}
+
+/* Returns an i1, anything but a comparison is true when non zero */
+func (e *LlvmEmitter) condition(node *Node) string {
+	if op, ok := node.token.(*Op); ok == true && node.left != nil && relational(op.token.ttype) == true {
+		return e.compare(node, op)
+	}
+	analyser := SemanticsAnalyser{e.scope, nil}
+	value := e.expression(node)
+	result := e.temp()
+	if llvm_type(analyser.type_of(node)) == "i64" {
+		fmt.Fprintf(e.out, "  %s = icmp ne i64 %s, 0\n", result, value)
+	} else {
+		fmt.Fprintf(e.out, "  %s = fcmp une double %s, 0.0\n", result, value)
+	}
+	return result
+}
+
+/* Returns the expression converted to want, REAL to INTEGER truncating like the interpreter */
+func (e *LlvmEmitter) typed(node *Node, want string) string {
+	analyser := SemanticsAnalyser{e.scope, nil}
+	value := e.expression(node)
+	have := llvm_type(analyser.type_of(node))
+	if have == want {
+		return value
+	}
+	result := e.temp()
+	if want == "i64" {
+		fmt.Fprintf(e.out, "  %s = fptosi double %s to i64\n", result, value)
+	} else {
+		fmt.Fprintf(e.out, "  %s = sitofp i64 %s to double\n", result, value)
+	}
+	return result
+}
+
+/* Returns node converted for storing in a variable of type want at token, out of range INTEGER values wrapping or failing under {$R+} */
+func (e *LlvmEmitter) stored(node *Node, want string, token *lexemes) string {
+	analyser := SemanticsAnalyser{e.scope, nil}
+	if want != "i64" || may_not_fit(analyser, node, token) == false {
+		return e.typed(node, want)
+	}
+	bits := integer_bits(token)
+	if llvm_type(analyser.type_of(node)) == "i64" {
+		value := e.expression(node)
+		result := e.wrap(value, bits)
+		if token.checks&CHECK_RANGE != 0 {
+			changed := e.temp()
+			fmt.Fprintf(e.out, "  %s = icmp ne i64 %s, %s\n", changed, result, value)
+			e.raise(RUNTIME_RANGE_CHECK, token, changed)
+		}
+		return result
+	}
+	value := e.expression(node)
+	if token.checks&CHECK_RANGE != 0 {
+		half := math.Ldexp(1, bits-1)
+		low, high, outside := e.temp(), e.temp(), e.temp()
+		fmt.Fprintf(e.out, "  %s = fcmp olt double %s, %s\n", low, value, llvm_real(-half))
+		fmt.Fprintf(e.out, "  %s = fcmp ogt double %s, %s\n", high, value, llvm_real(half-1))
+		fmt.Fprintf(e.out, "  %s = or i1 %s, %s\n", outside, low, high)
+		e.raise(RUNTIME_RANGE_CHECK, token, outside)
+	}
+	truncated := e.temp()
+	fmt.Fprintf(e.out, "  %s = fptosi double %s to i64\n", truncated, value)
+	return e.wrap(truncated, bits)
+}
+
+/* Returns value wrapped to a signed integer of bits */
+func (e *LlvmEmitter) wrap(value string, bits int) string {
+	narrow := e.temp()
+	fmt.Fprintf(e.out, "  %s = trunc i64 %s to i%d\n", narrow, value, bits)
+	result := e.temp()
+	fmt.Fprintf(e.out, "  %s = sext i%d %s to i64\n", result, bits, narrow)
+	return result
+}
+
+/* Returns an exact INTEGER result wrapped to the width of INTEGER, raising runtime error 215 on overflow under {$Q+} */
+func (e *LlvmEmitter) integer(op *lexemes, value string) string {
+	result := e.wrap(value, integer_bits(op))
+	if op.checks&CHECK_OVERFLOW != 0 {
+		changed := e.temp()
+		fmt.Fprintf(e.out, "  %s = icmp ne i64 %s, %s\n", changed, result, value)
+		e.raise(RUNTIME_OVERFLOW, op, changed)
+	}
+	return result
+}
+
+func (e *LlvmEmitter) compare(node *Node, op *Op) string {
+	analyser := SemanticsAnalyser{e.scope, nil}
+	stype := "i64"
+	operator := llvm_integer_operators[op.token.ttype]
+	if analyser.type_of(node.left).name == "REAL_CONST" || analyser.type_of(node.right).name == "REAL_CONST" {
+		stype = "double"
+		operator = llvm_real_operators[op.token.ttype]
+	}
+	left := e.typed(node.left, stype)
+	right := e.typed(node.right, stype)
+	result := e.temp()
+	fmt.Fprintf(e.out, "  %s = %s %s %s, %s\n", result, operator, stype, left, right)
+	return result
+}
+
+/* Branches to runtime error 200 when divisor is zero, sdiv and srem by zero being undefined */
+func (e *LlvmEmitter) check_divisor(token *lexemes, stype string, divisor string) {
+	zero := e.temp()
+	if stype == "i64" {
+		fmt.Fprintf(e.out, "  %s = icmp eq i64 %s, 0\n", zero, divisor)
+	} else {
+		fmt.Fprintf(e.out, "  %s = fcmp oeq double %s, 0.0\n", zero, divisor)
+	}
+	e.raise(RUNTIME_DIVISION_BY_ZERO, token, zero)
+}
+
+/* Raises runtime error code at token when failed is true, going on otherwise */
+func (e *LlvmEmitter) raise(code int, token *lexemes, failed string) {
+	e.errors[code] = true
+	e.labels++
+	label := e.labels
+	fmt.Fprintf(e.out, "  br i1 %s, label %%error%d, label %%error%d.ok\n", failed, label, label)
+	fmt.Fprintf(e.out, "error%d:\n", label)
+	fmt.Fprintf(e.out, "  call void @runtime_error(i32 %d, i32 %d, ptr @runtime.%d)\n", code, token.line, code)
+	fmt.Fprintln(e.out, "  unreachable")
+	fmt.Fprintf(e.out, "error%d.ok:\n", label)
+}
+
+/* Emits the instructions computing node and returns the SSA value or constant holding it */
+func (e *LlvmEmitter) expression(node *Node) string {
+	analyser := SemanticsAnalyser{e.scope, nil}
+	switch v := node.token.(type) {
+	case *Number:
+		if v.token.ttype == REAL_CONST {
+			value, _ := strconv.ParseFloat(v.token.tstring, 64)
+			return llvm_real(value)
+		}
+		return v.token.tstring
+	case *Var:
+		symbol, _ := e.scope.lookup(v.token.tstring, false)
+		variable := symbol.(*VarSymbol)
+		pointer := e.address(variable.name)
+		result := e.temp()
+		fmt.Fprintf(e.out, "  %s = load %s, ptr %s\n", result, llvm_type(variable.stype), pointer)
+		return result
+	case *Op:
+		stype := llvm_type(analyser.type_of(node))
+		if node.left == nil {
+			operand := e.expression(node.right)
+			if v.token.ttype == PLUS {
+				if stype == "i64" {
+					return e.integer(v.token, operand)
+				}
+				return operand
+			}
+			result := e.temp()
+			if stype == "i64" {
+				fmt.Fprintf(e.out, "  %s = sub i64 0, %s\n", result, operand)
+				return e.integer(v.token, result)
+			}
+			fmt.Fprintf(e.out, "  %s = fneg double %s\n", result, operand)
+			return result
+		}
+		if relational(v.token.ttype) == true {
+			truth := e.compare(node, v)
+			result := e.temp()
+			fmt.Fprintf(e.out, "  %s = zext i1 %s to i64\n", result, truth)
+			return result
+		}
+		left := e.typed(node.left, stype)
+		right := e.typed(node.right, stype)
+		if v.token.ttype == INTEGER_DIV || v.token.ttype == MOD || v.token.ttype == FLOAT_DIV {
+			e.check_divisor(v.token, stype, right)
+		}
+		operator := llvm_integer_operators[v.token.ttype]
+		if stype == "double" {
+			operator = llvm_real_operators[v.token.ttype]
+		}
+		result := e.temp()
+		fmt.Fprintf(e.out, "  %s = %s %s %s, %s\n", result, operator, stype, left, right)
+		if integer_operation(analyser, node, v.token) == true {
+			return e.integer(v.token, result)
+		}
+		return result
+	}
+	compile_error("LLVM backend", node_token(node), "cannot emit %s", node_kind(node.token))
+	return ""
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+	WEBASSEMBLY TEXT BACKEND
+
+	Frames live on a stack in linear memory that grows from the end of the
+	string data. A frame is the address of the enclosing frame (the static
+	link) followed by one 8 byte slot per parameter and local:
+
+		fp + 0       static link
+		fp + 8 + 8n  slot n, i64 for INTEGER and f64 for REAL
+
+	The host provides the output primitives WRITELN is made of:
+
+		host.write_str(offset, length i32)   bytes of a string in memory
+		host.write_int(value i64)            decimal integer
+		host.write_real(value f64)           fixed notation, six decimals
+		host.writeln()                       end of line
+		host.readln() i64                    next input line read as an integer
+
+	Nothing calls readln until the dialect can read input, but hosts provide
+	it from the start. The module exports its memory and a "main" function
+	running the program, each procedure being $proc. followed by its dotted
+	path, so none clashes with main or the imports.
+	INTEGER results wrap with the sign extension instructions of WebAssembly
+	2.0. The module has no way to raise a runtime error, so code compiled
+	with {$Q+} or {$R+} is refused.
+*/
+
+var wat_integer_operators = map[int]string{
+	PLUS:          "i64.add",
+	MINUS:         "i64.sub",
+	MUL:           "i64.mul",
+	INTEGER_DIV:   "i64.div_s",
+	MOD:           "i64.rem_s",
+	EQUAL:         "i64.eq",
+	NOT_EQUAL:     "i64.ne",
+	LESS:          "i64.lt_s",
+	LESS_EQUAL:    "i64.le_s",
+	GREATER:       "i64.gt_s",
+	GREATER_EQUAL: "i64.ge_s",
+}
+
+var wat_real_operators = map[int]string{
+	PLUS:          "f64.add",
+	MINUS:         "f64.sub",
+	MUL:           "f64.mul",
+	FLOAT_DIV:     "f64.div",
+	EQUAL:         "f64.eq",
+	NOT_EQUAL:     "f64.ne",
+	LESS:          "f64.lt",
+	LESS_EQUAL:    "f64.le",
+	GREATER:       "f64.gt",
+	GREATER_EQUAL: "f64.ge",
+}
+
+type WatEmitter struct {
+	out     *strings.Builder
+	scope   *ScopedSymbolTable
+	names   map[*ScopedSymbolTable]string
+	slots   map[*ScopedSymbolTable]map[string]int
+	strings map[string]int
+	data    []byte
+	labels  int
+	depth   int
+}
+
+func wat_type(stype *BuiltinSymbol) string {
+	if stype.name == "INTEGER_CONST" {
+		return "i64"
+	}
+	return "f64"
+}
+
+/* Bytes outside printable ASCII, quotes and backslashes are written as \hh escapes */
+func wat_string(data []byte) string {
+	quoted := &strings.Builder{}
+	for _, b := range data {
+		if b < 0x20 || b >= 0x7f || b == '"' || b == '\\' {
+			fmt.Fprintf(quoted, "\\%02x", b)
+		} else {
+			quoted.WriteByte(b)
+		}
+	}
+	return "\"" + quoted.String() + "\""
+}
+
+func emit_wat(w io.Writer, tree *Block, scope *ScopedSymbolTable) error {
+	e := &WatEmitter{nil, scope, make(map[*ScopedSymbolTable]string), make(map[*ScopedSymbolTable]map[string]int), make(map[string]int), []byte{}, 0, 0}
+	functions := &strings.Builder{}
+	e.names[scope] = "main"
+	e.allocate(scope, nil, tree)
+	e.procedures(tree, "proc.", functions)
+	e.function(scope, nil, tree, functions)
+	stack := (len(e.data) + 7) &^ 7
+	fmt.Fprintln(w, "(module")
+	fmt.Fprintln(w, "  (import \"host\" \"write_str\" (func $write_str (param i32 i32)))")
+	fmt.Fprintln(w, "  (import \"host\" \"write_int\" (func $write_int (param i64)))")
+	fmt.Fprintln(w, "  (import \"host\" \"write_real\" (func $write_real (param f64)))")
+	fmt.Fprintln(w, "  (import \"host\" \"writeln\" (func $writeln))")
+	fmt.Fprintln(w, "  (import \"host\" \"readln\" (func $readln (result i64)))")
+	fmt.Fprintln(w, "  (memory (export \"memory\") 16)")
+	fmt.Fprintf(w, "  (global $sp (mut i32) (i32.const %d))\n", stack)
+	if len(e.data) > 0 {
+		fmt.Fprintf(w, "  (data (i32.const 0) %s)\n", wat_string(e.data))
+	}
+	io.WriteString(w, functions.String())
+	fmt.Fprintln(w, "  (export \"main\" (func $main))")
+	fmt.Fprintln(w, ")")
+	return nil
+}
+
+func (e *WatEmitter) procedures(block *Block, prefix string, functions *strings.Builder) {
+	for _, decl := range block.declaration_list.elem {
+		v, ok := decl.(*ProcedureDecl)
+		if ok == false {
+			continue
+		}
+		symbol, _ := e.scope.lookup(v.proc_name, true)
+		proc := symbol.(*ProcedureSymbol)
+		e.names[proc.scope] = prefix + strings.ToLower(proc.name)
+		if v.block == nil {
+			continue
+		}
+		e.allocate(proc.scope, proc.params, v.block)
+		enclosing := e.scope
+		e.scope = proc.scope
+		e.procedures(v.block, e.names[proc.scope]+".", functions)
+		e.function(proc.scope, proc.params, v.block, functions)
+		e.scope = enclosing
+	}
+}
+
+/* Gives every parameter and local of a scope its frame slot */
+func (e *WatEmitter) allocate(scope *ScopedSymbolTable, params []*VarSymbol, block *Block) {
+	slots := make(map[string]int)
+	e.slots[scope] = slots
+	for _, param := range params {
+		slots[param.name] = len(slots)
+	}
+	for _, decl := range block.declaration_list.elem {
+		if v, ok := decl.(*VarDeclaration); ok == true {
+			slots[v.token.tstring] = len(slots)
+		}
+	}
+}
+
+/* Emits the function of a procedure, or of the main program when params is nil */
+func (e *WatEmitter) function(scope *ScopedSymbolTable, params []*VarSymbol, block *Block, functions *strings.Builder) {
+	e.scope = scope
+	slots := e.slots[scope]
+	e.out = &strings.Builder{}
+	e.depth = 2
+	fmt.Fprintf(e.out, "  (func $%s", e.names[scope])
+	if params != nil {
+		fmt.Fprint(e.out, " (param $link i32)")
+		for _, param := range params {
+			fmt.Fprintf(e.out, " (param $arg.%s %s)", strings.ToLower(param.name), wat_type(param.stype))
+		}
+	}
+	fmt.Fprintln(e.out)
+	e.line("(local $fp i32)")
+	e.line("global.get $sp")
+	e.line("local.set $fp")
+	e.line("global.get $sp")
+	e.line("i32.const %d", 8+8*len(slots))
+	e.line("i32.add")
+	e.line("global.set $sp")
+	e.line("local.get $fp")
+	if params != nil {
+		e.line("local.get $link")
+	} else {
+		e.line("i32.const 0")
+	}
+	e.line("i32.store")
+	/* the stack is reused, so locals must be cleared */
+	for slot := 0; slot < len(slots); slot++ {
+		e.line("local.get $fp")
+		e.line("i64.const 0")
+		e.line("i64.store offset=%d", 8+8*slot)
+	}
+	for _, param := range params {
+		e.line("local.get $fp")
+		e.line("local.get $arg.%s", strings.ToLower(param.name))
+		e.line("%s.store offset=%d", wat_type(param.stype), 8+8*slots[param.name])
+	}
+	e.statement(block.compound)
+	e.line("local.get $fp")
+	e.line("global.set $sp")
+	fmt.Fprintln(e.out, "  )")
+	functions.WriteString(e.out.String())
+}
+
+func (e *WatEmitter) line(format string, args ...interface{}) {
+	fmt.Fprintf(e.out, "%s%s\n", strings.Repeat("  ", e.depth), fmt.Sprintf(format, args...))
+}
+
+/* Pushes the address of the frame of scope by following static links */
+func (e *WatEmitter) frame(scope *ScopedSymbolTable) {
+	e.line("local.get $fp")
+	for level := e.scope.scope_level; level > scope.scope_level; level-- {
+		e.line("i32.load")
+	}
+}
+
+/* Pushes the frame holding name and returns the offset of its slot */
+func (e *WatEmitter) address(name string) int {
+	for scope := e.scope; scope != nil; scope = scope.enclosing_scope {
+		if slot, ok := e.slots[scope][name]; ok == true {
+			e.frame(scope)
+			return 8 + 8*slot
+		}
+	}
+	compile_error("WebAssembly backend", nil, "%s has no storage", name)
+	return 0
+}
+
+func (e *WatEmitter) statement(node interface{}) {
+	switch v := node.(type) {
+	case *Compound:
+		for _, elem := range v.elem {
+			e.statement(elem)
+		}
+	case *Assign:
+		symbol, _ := e.scope.lookup(v.variable.token.tstring, false)
+		variable := symbol.(*VarSymbol)
+		offset := e.address(variable.name)
+		e.stored(v.expr, wat_type(variable.stype), v.token)
+		e.line("%s.store offset=%d", wat_type(variable.stype), offset)
+	case *ProcedureCall:
+		if v.proc_symbol == nil {
+			e.writeln(v.args)
+			return
+		}
+		e.frame(v.proc_symbol.scope.enclosing_scope)
+		for index, arg := range v.args {
+			e.stored(arg, wat_type(v.proc_symbol.params[index].stype), v.token)
+		}
+		e.line("call $%s", e.names[v.proc_symbol.scope])
+	case *While:
+		e.labels++
+		label := e.labels
+		e.line("block $exit%d", label)
+		e.depth++
+		e.line("loop $loop%d", label)
+		e.depth++
+		e.condition(v.condition)
+		e.line("i32.eqz")
+		e.line("br_if $exit%d", label)
+		e.statement(v.body)
+		e.line("br $loop%d", label)
+		e.depth--
+		e.line("end")
+		e.depth--
+		e.line("end")
+	case *If:
+		e.condition(v.condition)
+		e.line("if")
+		e.depth++
+		e.statement(v.then_branch)
+		if v.else_branch != nil {
+			e.depth--
+			e.line("else")
+			e.depth++
+			e.statement(v.else_branch)
+		}
+		e.depth--
+		e.line("end")
+	case nil:
+	default:
+		compile_error("WebAssembly backend", node_token(v), "cannot emit %s", node_kind(v))
+	}
+}
+
+func (e *WatEmitter) writeln(args []*Node) {
+	analyser := SemanticsAnalyser{e.scope, nil}
+	for _, arg := range args {
+		if str, ok := arg.token.(*Str); ok == true {
+			text := str.token.tstring
+			offset, ok := e.strings[text]
+			if ok == false {
+				offset = len(e.data)
+				e.strings[text] = offset
+				e.data = append(e.data, text...)
+			}
+			e.line("i32.const %d", offset)
+			e.line("i32.const %d", len(text))
+			e.line("call $write_str")
+			continue
+		}
+		e.expression(arg)
+		if analyser.type_of(arg).name == "INTEGER_CONST" {
+			e.line("call $write_int")
+		} else {
+			e.line("call $write_real")
+		}
+	}
+	e.line("call $writeln")
+}
+
+/* Pushes an i32 truth value, anything but a comparison is true when non zero */
+func (e *WatEmitter) condition(node *Node) {
+	if op, ok := node.token.(*Op); ok == true && node.left != nil && relational(op.token.ttype) == true {
+		e.compare(node, op)
+		return
+	}
+	analyser := SemanticsAnalyser{e.scope, nil}
+	e.expression(node)
+	e.line("%s.const 0", wat_type(analyser.type_of(node)))
+	e.line("%s.ne", wat_type(analyser.type_of(node)))
+}
+
+/* Pushes an expression converted to want, REAL to INTEGER truncating like the interpreter */
+func (e *WatEmitter) typed(node *Node, want string) {
+	analyser := SemanticsAnalyser{e.scope, nil}
+	e.expression(node)
+	have := wat_type(analyser.type_of(node))
+	switch {
+	case have == want:
+	case want == "i64":
+		e.line("i64.trunc_f64_s")
+	default:
+		e.line("f64.convert_i64_s")
+	}
+}
+
+/* Pushes node converted for storing in a variable of type want at token, out of range INTEGER values wrapping */
+func (e *WatEmitter) stored(node *Node, want string, token *lexemes) {
+	e.typed(node, want)
+	if want == "i64" && may_not_fit(SemanticsAnalyser{e.scope, nil}, node, token) == true {
+		if token.checks&CHECK_RANGE != 0 {
+			compile_error("WebAssembly backend", token, "range checks cannot fail in WebAssembly, turn them off with {$R-}")
+		}
+		e.line("i64.extend%d_s", integer_bits(token))
+	}
+}
+
+/* Wraps the exact INTEGER result of op on the stack to the width of INTEGER */
+func (e *WatEmitter) integer(op *lexemes) {
+	if op.checks&CHECK_OVERFLOW != 0 {
+		compile_error("WebAssembly backend", op, "overflow checks cannot fail in WebAssembly, turn them off with {$Q-}")
+	}
+	e.line("i64.extend%d_s", integer_bits(op))
+}
+
+func (e *WatEmitter) compare(node *Node, op *Op) {
+	analyser := SemanticsAnalyser{e.scope, nil}
+	stype := "i64"
+	if analyser.type_of(node.left).name == "REAL_CONST" || analyser.type_of(node.right).name == "REAL_CONST" {
+		stype = "f64"
+	}
+	e.typed(node.left, stype)
+	e.typed(node.right, stype)
+	if stype == "i64" {
+		e.line("%s", wat_integer_operators[op.token.ttype])
+	} else {
+		e.line("%s", wat_real_operators[op.token.ttype])
+	}
+}
+
+func (e *WatEmitter) expression(node *Node) {
+	analyser := SemanticsAnalyser{e.scope, nil}
+	switch v := node.token.(type) {
+	case *Number:
+		e.line("%s.const %s", wat_type(analyser.type_of(v)), v.token.tstring)
+		return
+	case *Var:
+		symbol, _ := e.scope.lookup(v.token.tstring, false)
+		variable := symbol.(*VarSymbol)
+		offset := e.address(variable.name)
+		e.line("%s.load offset=%d", wat_type(variable.stype), offset)
+		return
+	case *Op:
+		stype := wat_type(analyser.type_of(node))
+		if node.left == nil {
+			if v.token.ttype == PLUS {
+				e.expression(node.right)
+				if stype == "i64" {
+					e.integer(v.token)
+				}
+			} else if stype == "i64" {
+				e.line("i64.const 0")
+				e.expression(node.right)
+				e.line("i64.sub")
+				e.integer(v.token)
+			} else {
+				e.expression(node.right)
+				e.line("f64.neg")
+			}
+			return
+		}
+		if relational(v.token.ttype) == true {
+			e.compare(node, v)
+			e.line("i64.extend_i32_u")
+			return
+		}
+		e.typed(node.left, stype)
+		e.typed(node.right, stype)
+		if stype == "i64" {
+			e.line("%s", wat_integer_operators[v.token.ttype])
+			e.integer(v.token)
+		} else {
+			e.line("%s", wat_real_operators[v.token.ttype])
+		}
+		return
+	}
+	compile_error("WebAssembly backend", node_token(node), "cannot emit %s", node_kind(node.token))
+}
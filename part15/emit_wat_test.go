@@ -0,0 +1,479 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*
+	A WebAssembly text validator for the modules the backend emits: it parses
+	the s-expressions, resolves every identifier and type checks each function
+	body on an operand stack, as an engine would before running it.
+*/
+
+type WatSexp struct {
+	atom string
+	list []*WatSexp
+}
+
+func (s *WatSexp) head() string {
+	if len(s.list) == 0 || s.list[0].list != nil {
+		return ""
+	}
+	return s.list[0].atom
+}
+
+/* Splits source into parentheses, strings and atoms */
+func wat_tokens(source string) ([]string, error) {
+	tokens := []string{}
+	for index := 0; index < len(source); {
+		switch c := source[index]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			index++
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			index++
+		case c == '"':
+			end := index + 1
+			for ; end < len(source) && source[end] != '"'; end++ {
+				if source[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, source[index:end+1])
+			index = end + 1
+		default:
+			end := index
+			for end < len(source) && strings.IndexByte(" \t\n\r()\"", source[end]) < 0 {
+				end++
+			}
+			tokens = append(tokens, source[index:end])
+			index = end
+		}
+	}
+	return tokens, nil
+}
+
+func parse_wat(source string) (*WatSexp, error) {
+	tokens, err := wat_tokens(source)
+	if err != nil {
+		return nil, err
+	}
+	stack := []*WatSexp{{list: []*WatSexp{}}}
+	for _, token := range tokens {
+		top := stack[len(stack)-1]
+		switch token {
+		case "(":
+			list := &WatSexp{list: []*WatSexp{}}
+			top.list = append(top.list, list)
+			stack = append(stack, list)
+		case ")":
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("unbalanced )")
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			top.list = append(top.list, &WatSexp{atom: token})
+		}
+	}
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("%d parentheses left open", len(stack)-1)
+	}
+	if len(stack[0].list) != 1 || stack[0].list[0].head() != "module" {
+		return nil, fmt.Errorf("want exactly one module")
+	}
+	return stack[0].list[0], nil
+}
+
+type WatSignature struct {
+	params  []string
+	results []string
+}
+
+/* Types of the operands each plain instruction pops and of the values it pushes */
+var wat_instructions = map[string]WatSignature{}
+
+func init() {
+	binary := func(t string, result string, names ...string) {
+		for _, name := range names {
+			wat_instructions[t+"."+name] = WatSignature{[]string{t, t}, []string{result}}
+		}
+	}
+	binary("i32", "i32", "add", "sub", "mul")
+	binary("i64", "i64", "add", "sub", "mul", "div_s", "rem_s")
+	binary("i64", "i32", "eq", "ne", "lt_s", "le_s", "gt_s", "ge_s")
+	binary("f64", "f64", "add", "sub", "mul", "div")
+	binary("f64", "i32", "eq", "ne", "lt", "le", "gt", "ge")
+	for _, unary := range []struct{ name, from, to string }{
+		{"i32.eqz", "i32", "i32"},
+		{"i64.eqz", "i64", "i32"},
+		{"f64.neg", "f64", "f64"},
+		{"i64.extend8_s", "i64", "i64"},
+		{"i64.extend16_s", "i64", "i64"},
+		{"i64.extend32_s", "i64", "i64"},
+		{"i64.extend_i32_u", "i32", "i64"},
+		{"i64.extend_i32_s", "i32", "i64"},
+		{"i64.trunc_f64_s", "f64", "i64"},
+		{"f64.convert_i64_s", "i64", "f64"},
+		{"i32.load", "i32", "i32"},
+		{"i64.load", "i32", "i64"},
+		{"f64.load", "i32", "f64"},
+	} {
+		wat_instructions[unary.name] = WatSignature{[]string{unary.from}, []string{unary.to}}
+	}
+	for _, t := range []string{"i32", "i64", "f64"} {
+		wat_instructions[t+".store"] = WatSignature{[]string{"i32", t}, nil}
+	}
+	wat_instructions["drop"] = WatSignature{[]string{"any"}, nil}
+	wat_instructions["nop"] = WatSignature{}
+}
+
+type WatModule struct {
+	functions map[string]WatSignature
+	globals   map[string]string
+	mutable   map[string]bool
+}
+
+/* Checks every field of module and returns the first problem found */
+func validate_wat(source string) error {
+	module, err := parse_wat(source)
+	if err != nil {
+		return err
+	}
+	m := &WatModule{make(map[string]WatSignature), make(map[string]string), make(map[string]bool)}
+	exports := make(map[string]bool)
+	bodies := []*WatSexp{}
+	for _, field := range module.list[1:] {
+		switch field.head() {
+		case "import":
+			if len(field.list) != 4 || field.list[3].head() != "func" {
+				return fmt.Errorf("import %v is not a function import", field.list)
+			}
+			id, signature, _, err := wat_function_type(field.list[3])
+			if err != nil {
+				return err
+			}
+			if err := m.declare(id, signature); err != nil {
+				return err
+			}
+		case "func":
+			id, signature, _, err := wat_function_type(field)
+			if err != nil {
+				return err
+			}
+			if err := m.declare(id, signature); err != nil {
+				return err
+			}
+			bodies = append(bodies, field)
+		case "global":
+			if len(field.list) != 4 || strings.HasPrefix(field.list[1].atom, "$") == false {
+				return fmt.Errorf("malformed global")
+			}
+			t := field.list[2]
+			if t.head() == "mut" {
+				m.mutable[field.list[1].atom] = true
+				t = t.list[1]
+			}
+			m.globals[field.list[1].atom] = t.atom
+		case "memory", "data":
+		case "export":
+			if len(field.list) != 3 || field.list[2].head() != "func" || len(field.list[2].list) != 2 {
+				return fmt.Errorf("malformed export")
+			}
+			if exports[field.list[1].atom] == true {
+				return fmt.Errorf("export %s twice", field.list[1].atom)
+			}
+			exports[field.list[1].atom] = true
+			if _, ok := m.functions[field.list[2].list[1].atom]; ok == false {
+				return fmt.Errorf("export of unknown function %s", field.list[2].list[1].atom)
+			}
+		default:
+			return fmt.Errorf("unknown module field %s", field.head())
+		}
+	}
+	for _, body := range bodies {
+		if err := m.check(body); err != nil {
+			return fmt.Errorf("func %s: %v", body.list[1].atom, err)
+		}
+	}
+	return nil
+}
+
+func (m *WatModule) declare(id string, signature WatSignature) error {
+	if _, ok := m.functions[id]; ok == true {
+		return fmt.Errorf("function %s declared twice", id)
+	}
+	m.functions[id] = signature
+	return nil
+}
+
+/* The id, signature and named params and locals of a func field, and where its instructions start */
+func wat_function_type(field *WatSexp) (string, WatSignature, int, error) {
+	signature := WatSignature{}
+	if len(field.list) < 2 || strings.HasPrefix(field.list[1].atom, "$") == false {
+		return "", signature, 0, fmt.Errorf("function without an id")
+	}
+	index := 2
+	for ; index < len(field.list) && field.list[index].list != nil; index++ {
+		item := field.list[index]
+		switch item.head() {
+		case "param":
+			for _, t := range item.list[1:] {
+				if strings.HasPrefix(t.atom, "$") == false {
+					signature.params = append(signature.params, t.atom)
+				}
+			}
+		case "result":
+			signature.results = append(signature.results, item.list[1].atom)
+		case "local":
+		default:
+			return "", signature, 0, fmt.Errorf("unexpected (%s) in %s", item.head(), field.list[1].atom)
+		}
+	}
+	return field.list[1].atom, signature, index, nil
+}
+
+type WatControl struct {
+	label       string
+	height      int
+	unreachable bool
+}
+
+/* Type checks the flat instructions of a function body */
+func (m *WatModule) check(field *WatSexp) error {
+	_, signature, start, _ := wat_function_type(field)
+	locals := make(map[string]string)
+	for _, item := range field.list[2:start] {
+		if item.head() == "param" || item.head() == "local" {
+			if len(item.list) != 3 {
+				return fmt.Errorf("unnamed %s", item.head())
+			}
+			if _, ok := locals[item.list[1].atom]; ok == true {
+				return fmt.Errorf("%s declared twice", item.list[1].atom)
+			}
+			locals[item.list[1].atom] = item.list[2].atom
+		}
+	}
+	stack := []string{}
+	controls := []*WatControl{{"", 0, false}}
+	pop := func(want string) error {
+		top := controls[len(controls)-1]
+		if len(stack) == top.height {
+			if top.unreachable == true {
+				return nil
+			}
+			return fmt.Errorf("pops %s from an empty stack", want)
+		}
+		have := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if want != "any" && have != want {
+			return fmt.Errorf("pops %s where %s is", want, have)
+		}
+		return nil
+	}
+	apply := func(signature WatSignature) error {
+		for index := len(signature.params) - 1; index >= 0; index-- {
+			if err := pop(signature.params[index]); err != nil {
+				return err
+			}
+		}
+		stack = append(stack, signature.results...)
+		return nil
+	}
+	target := func(label string) error {
+		for _, control := range controls[1:] {
+			if control.label == label {
+				return nil
+			}
+		}
+		return fmt.Errorf("branch to unknown label %s", label)
+	}
+	body := field.list[start:]
+	immediate := func(index *int) (string, error) {
+		*index++
+		if *index >= len(body) || body[*index].list != nil {
+			return "", fmt.Errorf("%s wants an immediate", body[*index-1].atom)
+		}
+		return body[*index].atom, nil
+	}
+	for index := 0; index < len(body); index++ {
+		if body[index].list != nil {
+			return fmt.Errorf("folded instruction (%s)", body[index].head())
+		}
+		name := body[index].atom
+		var err error
+		switch {
+		case strings.HasSuffix(name, ".const"):
+			var value string
+			if value, err = immediate(&index); err != nil {
+				return err
+			}
+			if name == "f64.const" {
+				_, err = strconv.ParseFloat(value, 64)
+			} else {
+				_, err = strconv.ParseInt(value, 10, 64)
+			}
+			if err != nil {
+				return fmt.Errorf("%s %s: %v", name, value, err)
+			}
+			stack = append(stack, strings.TrimSuffix(name, ".const"))
+		case name == "local.get" || name == "local.set" || name == "local.tee":
+			var id string
+			if id, err = immediate(&index); err != nil {
+				return err
+			}
+			t, ok := locals[id]
+			if ok == false {
+				return fmt.Errorf("%s of unknown local %s", name, id)
+			}
+			switch name {
+			case "local.get":
+				stack = append(stack, t)
+			case "local.set":
+				err = pop(t)
+			default:
+				err = apply(WatSignature{[]string{t}, []string{t}})
+			}
+		case name == "global.get" || name == "global.set":
+			var id string
+			if id, err = immediate(&index); err != nil {
+				return err
+			}
+			t, ok := m.globals[id]
+			if ok == false {
+				return fmt.Errorf("%s of unknown global %s", name, id)
+			}
+			if name == "global.get" {
+				stack = append(stack, t)
+			} else if m.mutable[id] == false {
+				return fmt.Errorf("global.set of immutable %s", id)
+			} else {
+				err = pop(t)
+			}
+		case name == "call":
+			var id string
+			if id, err = immediate(&index); err != nil {
+				return err
+			}
+			callee, ok := m.functions[id]
+			if ok == false {
+				return fmt.Errorf("call of unknown function %s", id)
+			}
+			err = apply(callee)
+		case name == "block" || name == "loop" || name == "if":
+			if name == "if" {
+				if err = pop("i32"); err != nil {
+					return err
+				}
+			}
+			label := ""
+			if index+1 < len(body) && strings.HasPrefix(body[index+1].atom, "$") == true {
+				index++
+				label = body[index].atom
+			}
+			controls = append(controls, &WatControl{label, len(stack), false})
+		case name == "else" || name == "end":
+			if len(controls) == 1 {
+				return fmt.Errorf("%s outside a block", name)
+			}
+			top := controls[len(controls)-1]
+			if len(stack) != top.height {
+				return fmt.Errorf("%s leaves %d values on the stack", name, len(stack)-top.height)
+			}
+			if name == "end" {
+				controls = controls[:len(controls)-1]
+			} else {
+				top.unreachable = false
+			}
+		case name == "br" || name == "br_if":
+			var label string
+			if label, err = immediate(&index); err != nil {
+				return err
+			}
+			if err = target(label); err != nil {
+				return err
+			}
+			if name == "br_if" {
+				err = pop("i32")
+			} else {
+				top := controls[len(controls)-1]
+				stack = stack[:top.height]
+				top.unreachable = true
+			}
+		default:
+			instruction, ok := wat_instructions[name]
+			if ok == false {
+				return fmt.Errorf("unknown instruction %s", name)
+			}
+			if strings.Contains(name, ".load") || strings.Contains(name, ".store") {
+				for index+1 < len(body) && (strings.HasPrefix(body[index+1].atom, "offset=") || strings.HasPrefix(body[index+1].atom, "align=")) {
+					index++
+				}
+			}
+			err = apply(instruction)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	if len(controls) != 1 {
+		return fmt.Errorf("%d blocks left open", len(controls)-1)
+	}
+	if strings.Join(stack, " ") != strings.Join(signature.results, " ") {
+		return fmt.Errorf("ends with [%s] on the stack, want [%s]", strings.Join(stack, " "), strings.Join(signature.results, " "))
+	}
+	return nil
+}
+
+/* Every module the backend emits, for the samples and the parity programs, must validate */
+func TestWatValidates(t *testing.T) {
+	for _, path := range parity_files(t) {
+		run := run_pascal(t, "", "-emit", "wat", path)
+		/* checked code and what only the interpreter runs are refused, as TestWatRefusesChecks wants */
+		if run.code != 0 && (strings.Contains(run.stderr, "cannot fail in WebAssembly") == true || strings.Contains(run.stderr, "only run on the interpreter") == true) {
+			continue
+		}
+		if run.code != 0 {
+			t.Fatalf("-emit wat failed: %s", run.stderr)
+		}
+		if err := validate_wat(run.stdout); err != nil {
+			t.Errorf("%s: %v\n%s", filepath.Base(path), err, run.stdout)
+		}
+	}
+}
+
+/* The validator itself must catch what an engine would reject */
+func TestWatValidatorRejects(t *testing.T) {
+	module := `(module
+  (import "host" "writeln" (func $writeln))
+  (global $sp (mut i32) (i32.const 0))
+  (func $main
+    %s
+  )
+  (export "main" (func $main))
+)`
+	for _, body := range []string{
+		"i64.const 1",
+		"i32.const 1\n i64.const 2\n i64.add\n drop",
+		"call $missing",
+		"local.get $fp",
+		"block $a\n br $b\n end",
+		"(func $main)",
+	} {
+		if err := validate_wat(fmt.Sprintf(module, body)); err == nil {
+			t.Errorf("accepts %q", body)
+		}
+	}
+	if err := validate_wat(fmt.Sprintf(module, "i64.const 1\n i64.const 2\n i64.add\n drop\n call $writeln")); err != nil {
+		t.Errorf("rejects a valid body: %v", err)
+	}
+	if err := validate_wat(strings.Replace(fmt.Sprintf(module, "nop"), "$writeln", "$main", 2)); err == nil {
+		t.Errorf("accepts two functions named $main")
+	}
+}
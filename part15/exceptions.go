@@ -0,0 +1,388 @@
+package main
+
+import (
+	"strings"
+)
+
+/*
+	EXCEPTIONS
+
+		try_statement   : TRY statement_list (EXCEPT exception_block | FINALLY statement_list) END
+		exception_block : handler (SEMI handler)* SEMI? (ELSE statement_list)? | statement_list
+		handler         : ON (ID COLON)? ID DO statement
+		raise_statement : RAISE ID?
+
+	Runtime errors are the exceptions: a handler names the class of the
+	errors it takes, the variable it may declare holds the runtime error
+	number, and Exception takes them all. Whatever the body of a TRY left
+	on the call stack is unwound before a handler or the FINALLY part runs.
+	An exception nobody handles ends the program with the usual runtime
+	error report, raised from where it was first raised. Exceptions only
+	run on the interpreter.
+*/
+
+const RUNTIME_EXCEPTION = 217
+
+func init() {
+	runtime_messages[RUNTIME_EXCEPTION] = "unhandled exception"
+}
+
+/* Exception classes by name, each taking the runtime error of that number */
+var exception_classes = map[string]int{
+	"EXCEPTION":        RUNTIME_EXCEPTION,
+	"EDIVBYZERO":       RUNTIME_DIVISION_BY_ZERO,
+	"ERANGEERROR":      RUNTIME_RANGE_CHECK,
+	"EOUTOFMEMORY":     RUNTIME_HEAP_OVERFLOW,
+	"EINTOVERFLOW":     RUNTIME_OVERFLOW,
+	"EACCESSVIOLATION": RUNTIME_NIL_POINTER,
+	"EINVALIDCAST":     RUNTIME_INVALID_CAST,
+}
+
+type Try struct {
+	token    *lexemes
+	body     *Compound
+	handlers []*Handler
+	/* the ELSE part, or the whole EXCEPT part when it has no ON */
+	otherwise *Handler
+	finally   *Compound
+}
+
+type Handler struct {
+	token *lexemes
+	/* the variable and the class, both nil for an ELSE part */
+	name  *lexemes
+	class *lexemes
+	body  interface{}
+	/* filled in by the analyser */
+	scope    *ScopedSymbolTable
+	variable *VarSymbol
+}
+
+type Raise struct {
+	token *lexemes
+	/* nil raises the exception being handled again */
+	class *lexemes
+}
+
+/* The handlers of a TRY, its ELSE part last */
+func (t *Try) all_handlers() []*Handler {
+	if t.otherwise == nil {
+		return t.handlers
+	}
+	return append(append([]*Handler{}, t.handlers...), t.otherwise)
+}
+
+func (r *rules) try_statement() interface{} {
+	token := r.lexer.Cur()
+	r.digest(TRY)
+	body := r.statement_list()
+	node := &Try{token, &Compound{token, body.elem, r.lexer.Cur()}, nil, nil, nil}
+	if r.lexer.Cur().ttype == FINALLY {
+		finally := r.lexer.Cur()
+		r.digest(FINALLY)
+		statements := r.statement_list()
+		node.finally = &Compound{finally, statements.elem, r.lexer.Cur()}
+	} else {
+		except := r.lexer.Cur()
+		r.digest(EXCEPT)
+		for r.lexer.Cur().ttype == ON {
+			node.handlers = append(node.handlers, r.exception_handler())
+			if r.lexer.Cur().ttype == SEMI {
+				r.digest(SEMI)
+			}
+		}
+		if len(node.handlers) > 0 && r.lexer.Cur().ttype == ELSE {
+			except = r.lexer.Cur()
+			r.digest(ELSE)
+		}
+		if len(node.handlers) == 0 || except.ttype == ELSE {
+			statements := r.statement_list()
+			node.otherwise = &Handler{except, nil, nil, &Compound{except, statements.elem, r.lexer.Cur()}, nil, nil}
+		}
+	}
+	r.digest(END)
+	return node
+}
+
+func (r *rules) exception_handler() *Handler {
+	token := r.lexer.Cur()
+	r.digest(ON)
+	var name *lexemes
+	class := r.lexer.Cur()
+	r.digest(ID)
+	if r.lexer.Cur().ttype == COLON {
+		r.digest(COLON)
+		name = class
+		class = r.lexer.Cur()
+		r.digest(ID)
+	}
+	r.digest(DO)
+	return &Handler{token, name, class, r.statement(), nil, nil}
+}
+
+func (r *rules) raise_statement() interface{} {
+	token := r.lexer.Cur()
+	r.digest(RAISE)
+	node := &Raise{token, nil}
+	if r.lexer.Cur().ttype == ID {
+		node.class = r.lexer.Cur()
+		r.digest(ID)
+	}
+	return node
+}
+
+/* The runtime error number a class name stands for */
+func exception_code(class *lexemes) int {
+	code, ok := exception_classes[strings.ToUpper(class.tstring)]
+	if ok == false {
+		compile_error("Semantic", class, "%s is not an exception class", class.tstring)
+	}
+	return code
+}
+
+/* True when a handler for class takes the runtime error code */
+func handles(class *lexemes, code int) bool {
+	if class == nil {
+		return true
+	}
+	handled := exception_code(class)
+	return handled == RUNTIME_EXCEPTION || handled == code
+}
+
+func (s SemanticsAnalyser) check_try(v *Try) {
+	s.check(v.body)
+	for _, handler := range v.all_handlers() {
+		s.check_handler(handler)
+	}
+	if v.finally != nil {
+		s.check(v.finally)
+	}
+}
+
+/* A handler gets a scope for its variable, at the level of the code around it whose activation holds it */
+func (s SemanticsAnalyser) check_handler(h *Handler) {
+	h.scope = &ScopedSymbolTable{make(map[string]Symbol), "EXCEPT", s.scope.scope_level, s.scope, nil, nil}
+	if h.class != nil {
+		exception_code(h.class)
+	}
+	if h.name != nil {
+		integer, _ := s.scope.lookup("INTEGER_CONST", false)
+		h.variable = &VarSymbol{h.name.tstring, integer.(*BuiltinSymbol), 0, h.name, false}
+		h.scope.insert(h.variable)
+		s.reference(h.name, h.variable)
+	}
+	s.scope = h.scope
+	s.check(h.body)
+}
+
+func (s SemanticsAnalyser) check_raise(v *Raise) {
+	if v.class != nil {
+		exception_code(v.class)
+		return
+	}
+	for scope := s.scope; scope != nil && scope.scope_level == s.scope.scope_level; scope = scope.enclosing_scope {
+		if scope.scope_name == "EXCEPT" {
+			return
+		}
+	}
+	compile_error("Semantic", v.token, "RAISE without a class is only allowed in an exception handler")
+}
+
+/* The scope whose activation holds the variables of scope, handlers keeping theirs in the code around them */
+func owning_scope(scope *ScopedSymbolTable) *ScopedSymbolTable {
+	for scope != nil && (scope.scope_name == "EXCEPT" || scope.class != nil) {
+		scope = scope.enclosing_scope
+	}
+	return scope
+}
+
+/* Runs body, returning the exception it raised with the interpreter unwound back to here */
+func (i *Interpreter) protect(body interface{}) (raised *RuntimeError) {
+	depth, scope, handling := len(i.stack), i.scope, len(i.handling)
+	defer func() {
+		if r := recover(); r != nil {
+			exception, ok := r.(*RuntimeError)
+			if ok == false {
+				panic(r)
+			}
+			i.unwind(depth)
+			i.scope = scope
+			i.handling = i.handling[:handling]
+			raised = exception
+		}
+	}()
+	i.run(body)
+	return nil
+}
+
+func (i *Interpreter) attempt(v *Try) {
+	raised := i.protect(v.body)
+	if v.finally != nil {
+		i.run(v.finally)
+		if raised != nil {
+			panic(raised)
+		}
+		return
+	}
+	if raised == nil {
+		return
+	}
+	for _, handler := range v.all_handlers() {
+		if handles(handler.class, raised.code) == true {
+			i.handle(handler, raised)
+			return
+		}
+	}
+	panic(raised)
+}
+
+/* Runs a handler, a RAISE in it or in anything it calls can raise the exception again */
+func (i *Interpreter) handle(h *Handler, raised *RuntimeError) {
+	if h.variable != nil {
+		i.activation(i.stack[len(i.stack)-1], h.scope).values[h.variable] = float64(raised.code)
+	}
+	scope := i.scope
+	i.handling = append(i.handling, raised)
+	i.scope = h.scope
+	i.run(h.body)
+	i.scope = scope
+	i.handling = i.handling[:len(i.handling)-1]
+}
+
+func (i *Interpreter) raise(v *Raise) {
+	if v.class == nil {
+		panic(i.handling[len(i.handling)-1])
+	}
+	i.runtime_error(exception_code(v.class), v.token, "")
+}
+
+/* Any statement of the body may raise, so the handlers are entered from before and after each */
+func (b *CfgBuilder) try_statement(v *Try) {
+	bodies := []interface{}{}
+	for _, handler := range v.all_handlers() {
+		bodies = append(bodies, handler.body)
+	}
+	if v.finally != nil {
+		bodies = append(bodies, v.finally)
+	}
+	entries := []*BasicBlock{}
+	for range bodies {
+		entries = append(entries, b.cfg.new_block())
+	}
+	raise := func() {
+		for _, entry := range entries {
+			link_blocks(b.current, entry)
+		}
+	}
+	raise()
+	for _, elem := range v.body.elem {
+		b.statement(elem)
+		next := b.cfg.new_block()
+		link_blocks(b.current, next)
+		b.current = next
+		raise()
+	}
+	join := b.cfg.new_block()
+	/* without an exception the body goes on to FINALLY, which raise linked already */
+	if v.finally == nil {
+		link_blocks(b.current, join)
+	}
+	for index, body := range bodies {
+		b.current = entries[index]
+		b.statement(body)
+		link_blocks(b.current, join)
+	}
+	b.current = join
+}
+
+/* The first feature in node only the interpreter runs, "" when every backend can */
+func interpreter_only(node interface{}) string {
+	switch v := node.(type) {
+	case *Block:
+		for _, decl := range v.declaration_list.elem {
+			if feature := interpreter_only(decl); feature != "" {
+				return feature
+			}
+		}
+		return interpreter_only(v.compound)
+	case *TypeDeclaration:
+		return "procedural types"
+	case *VarDeclaration:
+		if v.spec.array != nil {
+			return "arrays"
+		}
+		if v.spec.sstring == "BOOLEAN_CONST" {
+			return "BOOLEAN"
+		}
+	case *ProcedureDecl:
+		if v.result != nil {
+			return "functions"
+		}
+		for _, param := range v.params {
+			if param.var_type.array != nil {
+				return "arrays"
+			}
+			if param.var_type.sstring == "BOOLEAN_CONST" {
+				return "BOOLEAN"
+			}
+		}
+		if v.block != nil {
+			return interpreter_only(v.block)
+		}
+	case *Compound:
+		for _, elem := range v.elem {
+			if feature := interpreter_only(elem); feature != "" {
+				return feature
+			}
+		}
+	case *While:
+		if feature := interpreter_only(v.condition); feature != "" {
+			return feature
+		}
+		return interpreter_only(v.body)
+	case *If:
+		if feature := interpreter_only(v.condition); feature != "" {
+			return feature
+		}
+		if feature := interpreter_only(v.then_branch); feature != "" {
+			return feature
+		}
+		return interpreter_only(v.else_branch)
+	case *Try, *Raise:
+		return "exceptions"
+	case *Assign:
+		return interpreter_only(v.expr)
+	case *ProcedureCall:
+		if v.proc_name == "SETLENGTH" && v.proc_symbol == nil && v.variable == nil {
+			return "arrays"
+		}
+		for _, arg := range v.args {
+			if feature := interpreter_only(arg); feature != "" {
+				return feature
+			}
+		}
+	case *Node:
+		if v == nil {
+			return ""
+		}
+		if feature := interpreter_only(v.token); feature != "" {
+			return feature
+		}
+		if feature := interpreter_only(v.left); feature != "" {
+			return feature
+		}
+		return interpreter_only(v.right)
+	case *BuiltinCall:
+		if v.proc_symbol != nil || v.variable != nil {
+			return "functions"
+		}
+		return "arrays"
+	case *Index, *IndexAssign:
+		return "arrays"
+	case *Nil:
+		return "classes"
+	case *ClassDeclaration, *Designator, *MemberAssign, *Inherited:
+		return "classes"
+	}
+	return ""
+}
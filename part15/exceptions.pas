@@ -0,0 +1,53 @@
+PROGRAM Exceptions;
+VAR
+   attempts, cleanups : INTEGER;
+
+PROCEDURE Divide(a, b : INTEGER);
+VAR
+   q : INTEGER;
+BEGIN {Divide}
+   attempts := attempts + 1;
+   TRY
+      q := a DIV b;
+      WRITELN(a, ' div ', b, ' = ', q)
+   FINALLY
+      cleanups := cleanups + 1
+   END
+END;  {Divide}
+
+PROCEDURE Deep(n : INTEGER);
+BEGIN {Deep}
+   IF n = 0 THEN RAISE ERangeError;
+   Deep(n - 1)
+END;  {Deep}
+
+BEGIN {Exceptions}
+   attempts := 0;
+   cleanups := 0;
+   TRY
+      Divide(10, 2);
+      Divide(1, 0);
+      WRITELN('not reached')
+   EXCEPT
+      ON E : EDivByZero DO WRITELN('caught error ', E, ' after ', attempts, ' attempts');
+      ON ERangeError DO WRITELN('range')
+   END;
+   TRY
+      Deep(5)
+   EXCEPT
+      ON E : EDivByZero DO WRITELN('wrong handler');
+      ON E : Exception DO WRITELN('Deep raised ', E)
+   END;
+   TRY
+      TRY
+         RAISE Exception
+      EXCEPT
+         WRITELN('handled, raising again');
+         RAISE
+      END
+   EXCEPT
+      ON E : Exception DO WRITELN('outer handler got ', E)
+   END;
+   WRITELN('cleanups = ', cleanups);
+   Divide(3, 0)
+END.  {Exceptions}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+)
+
+/* Exceptions raised under nested calls: what runs on the way out and where the program goes on */
+var unwinding_programs = []struct {
+	name   string
+	source string
+	args   []string
+	stdout string
+	stderr string
+}{
+	{"finally.pas", `PROGRAM Unwind;
+PROCEDURE Level(n : INTEGER);
+VAR k : INTEGER;
+BEGIN
+   k := n;
+   TRY
+      IF n = 0 THEN WRITELN(1 DIV n) ELSE Level(n - 1)
+   FINALLY
+      WRITELN('finally ', k)
+   END
+END;
+BEGIN
+   TRY
+      Level(2)
+   EXCEPT
+      ON E : EDivByZero DO WRITELN('caught ', E)
+   END;
+   WRITELN('after')
+END.
+`, nil, "finally 0\nfinally 1\nfinally 2\ncaught 200\nafter\n", ""},
+	{"unhandled.pas", `PROGRAM Unhandled;
+PROCEDURE Level(n : INTEGER);
+BEGIN
+   TRY
+      IF n = 0 THEN WRITELN(1 DIV n) ELSE Level(n - 1)
+   FINALLY
+      WRITELN('finally ', n)
+   END
+END;
+BEGIN
+   Level(1);
+   WRITELN('not reached')
+END.
+`, nil, "finally 0\nfinally 1\n", "Runtime error 200 at line 5 in LEVEL called from LEVEL called from Global: division by zero\n"},
+	{"locals.pas", `PROGRAM Locals;
+PROCEDURE Fail(n : INTEGER);
+VAR k : INTEGER;
+BEGIN
+   k := n;
+   IF k = 0 THEN RAISE ERangeError;
+   Fail(k - 1)
+END;
+PROCEDURE Safe(n : INTEGER);
+VAR k : INTEGER;
+BEGIN
+   k := n * 10;
+   TRY
+      Fail(n)
+   EXCEPT
+      WRITELN('safe ', k)
+   END;
+   WRITELN('k is still ', k)
+END;
+BEGIN
+   Safe(3)
+END.
+`, nil, "safe 30\nk is still 30\n", ""},
+	{"replaced.pas", `PROGRAM Replaced;
+PROCEDURE Inner;
+BEGIN
+   TRY
+      RAISE ERangeError
+   FINALLY
+      WRITELN('inner finally');
+      RAISE EIntOverflow
+   END
+END;
+PROCEDURE Outer;
+BEGIN
+   TRY
+      Inner
+   FINALLY
+      WRITELN('outer finally')
+   END
+END;
+BEGIN
+   TRY
+      Outer
+   EXCEPT
+      ON E : ERangeError DO WRITELN('range ', E);
+      ON E : EIntOverflow DO WRITELN('overflow ', E)
+   END
+END.
+`, nil, "inner finally\nouter finally\noverflow 215\n", ""},
+	{"reraised.pas", `PROGRAM Reraised;
+PROCEDURE Inner;
+BEGIN
+   TRY
+      RAISE EAccessViolation
+   EXCEPT
+      WRITELN('inner handler');
+      RAISE
+   END
+END;
+PROCEDURE Middle;
+BEGIN
+   TRY
+      Inner
+   FINALLY
+      WRITELN('middle finally')
+   END
+END;
+BEGIN
+   TRY
+      Middle
+   EXCEPT
+      ON E : EAccessViolation DO WRITELN('outer handler ', E)
+   END
+END.
+`, nil, "inner handler\nmiddle finally\nouter handler 216\n", ""},
+	/* frames the unwinding skips give back their depth and memory, or the loop would run out of both */
+	{"released.pas", `PROGRAM Released;
+VAR i, caught : INTEGER;
+PROCEDURE Down(n : INTEGER);
+VAR a, b, c : INTEGER;
+BEGIN
+   IF n = 0 THEN RAISE Exception;
+   Down(n - 1)
+END;
+BEGIN
+   WHILE i < 200 DO
+   BEGIN
+      TRY
+         Down(8)
+      EXCEPT
+         caught := caught + 1
+      END;
+      i := i + 1
+   END;
+   WRITELN(caught)
+END.
+`, []string{"-max-depth", "12", "-max-memory", "2000"}, "200\n", ""},
+}
+
+func TestUnwindingThroughCalls(t *testing.T) {
+	for _, program := range unwinding_programs {
+		path := write_program(t, program.name, program.source)
+		t.Run(program.name, func(t *testing.T) {
+			run := run_pascal(t, "", append(program.args, path)...)
+			code := 0
+			if program.stderr != "" {
+				code = 255
+			}
+			if run.stdout != program.stdout || run.stderr != program.stderr || run.code != code {
+				t.Errorf("exit %d with\n%s%s\nwant exit %d with\n%s%s", run.code, run.stdout, run.stderr, code, program.stdout, program.stderr)
+			}
+		})
+	}
+}
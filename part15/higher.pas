@@ -0,0 +1,77 @@
+PROGRAM Higher;
+TYPE
+   TAction = PROCEDURE(n : INTEGER);
+   TBinary = PROCEDURE(a, b : INTEGER);
+   TCompare = FUNCTION(a, b : INTEGER) : BOOLEAN;
+VAR
+   total, best : INTEGER;
+   act : TAction;
+
+PROCEDURE Add(n : INTEGER);
+BEGIN
+   total := total + n
+END;
+
+PROCEDURE Twice(n : INTEGER);
+BEGIN
+   total := total + 2 * n
+END;
+
+PROCEDURE Larger(a, b : INTEGER);
+BEGIN
+   IF a > b THEN best := a ELSE best := b
+END;
+
+FUNCTION Less(a, b : INTEGER) : BOOLEAN;
+BEGIN
+   Less := a < b
+END;
+
+FUNCTION Pick(better : TCompare; a, b : INTEGER) : INTEGER;
+BEGIN
+   IF better(a, b) THEN Pick := a ELSE Pick := b
+END;
+
+PROCEDURE Each(count : INTEGER; f : TAction);
+VAR k : INTEGER;
+BEGIN
+   k := 1;
+   WHILE k <= count DO
+   BEGIN
+      f(k);
+      k := k + 1
+   END
+END;
+
+PROCEDURE Combine(f : TBinary; x, y : INTEGER);
+BEGIN
+   f(x, y)
+END;
+
+PROCEDURE Counter(start : INTEGER);
+VAR seen : INTEGER;
+   PROCEDURE Note(n : INTEGER);
+   BEGIN
+      seen := seen + n
+   END;
+BEGIN
+   seen := start;
+   Each(4, Note);
+   IF start > 0 THEN Counter(start - 100);
+   WRITELN('counter ', start, ' saw ', seen)
+END;
+
+BEGIN
+   total := 0;
+   Each(3, Add);
+   WRITELN('add ', total);
+   act := Twice;
+   Each(3, act);
+   WRITELN('twice ', total);
+   act(100);
+   WRITELN('direct ', total);
+   Combine(Larger, 3, 9);
+   WRITELN('best ', best);
+   WRITELN('pick ', Pick(Less, 3, 9), ' ', Less(9, 3));
+   Counter(100)
+END.
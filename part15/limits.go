@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+	RESOURCE LIMITS
+*/
+
+/* Budgets for one execution, zero meaning unlimited */
+type Limits struct {
+	steps   int
+	depth   int
+	memory  int
+	timeout time.Duration
+}
+
+/* Frames shown at each end of a long call stack */
+const STACK_SHOWN = 8
+
+type LimitError struct {
+	limit   string
+	message string
+	token   *lexemes
+	stack   []StackEntry
+}
+
+func (e *LimitError) Error() string {
+	text := fmt.Sprintf("Limit Error: %s", e.message)
+	if e.token != nil {
+		text += " " + e.token.position()
+	}
+	return text + format_stack(e.stack)
+}
+
+/* One line per frame, innermost first, eliding the middle of deep recursions */
+func format_stack(stack []StackEntry) string {
+	lines := &strings.Builder{}
+	for index, frame := range stack {
+		if len(stack) > 2*STACK_SHOWN && index == STACK_SHOWN {
+			fmt.Fprintf(lines, "\n\t... %d more frames", len(stack)-2*STACK_SHOWN)
+		}
+		if len(stack) > 2*STACK_SHOWN && index >= STACK_SHOWN && index < len(stack)-STACK_SHOWN {
+			continue
+		}
+		if index == 0 {
+			fmt.Fprintf(lines, "\n\tin %s", frame)
+		} else {
+			fmt.Fprintf(lines, "\n\tcalled from %s", frame)
+		}
+	}
+	return lines.String()
+}
+
+type StackEntry struct {
+	name string
+	line int
+}
+
+func (s StackEntry) String() string {
+	return fmt.Sprintf("%s at line %d", s.name, s.line)
+}
+
+/* The call stack innermost first */
+func (i *Interpreter) backtrace() []StackEntry {
+	stack := []StackEntry{}
+	for index := len(i.stack) - 1; index >= 0; index-- {
+		frame := i.stack[index]
+		line := 0
+		if frame.token != nil {
+			line = frame.token.line
+		}
+		stack = append(stack, StackEntry{frame.name, line})
+	}
+	return stack
+}
+
+func (i *Interpreter) abort(limit string, token *lexemes, format string, args ...interface{}) {
+	panic(&LimitError{limit, fmt.Sprintf(format, args...), token, i.backtrace()})
+}
+
+/* Counts a statement against the step budget, and looks at the context now and then */
+func (i *Interpreter) step(token *lexemes) {
+	i.steps++
+	if i.limits.steps > 0 && i.steps > i.limits.steps {
+		i.abort("steps", token, "step limit of %d exceeded", i.limits.steps)
+	}
+	if i.steps%1024 != 0 {
+		return
+	}
+	select {
+	case <-i.ctx.Done():
+		if i.ctx.Err() == context.DeadlineExceeded {
+			i.abort("timeout", token, "time limit of %v exceeded", i.limits.timeout)
+		}
+		i.abort("cancelled", token, "execution cancelled")
+	default:
+	}
+}
+
+/* Bytes a value takes, be it a variable or anything allocated for one */
+const VALUE_SIZE = 8
+
+/* The most one allocation may take, more being runtime error 203 whatever the memory limit */
+const MAX_ALLOCATION = 1 << 30
+
+/* Bytes a frame for scope takes: one value per variable it declares */
+func frame_size(scope *ScopedSymbolTable) int {
+	size := 0
+	for _, symbol := range scope.symbols {
+		if _, ok := symbol.(*VarSymbol); ok == true {
+			size += VALUE_SIZE
+		}
+	}
+	return size
+}
+
+/* Takes bytes from the one heap everything the program allocates comes from, failing past the memory limit */
+func (i *Interpreter) allocate(bytes int, token *lexemes) {
+	if bytes > MAX_ALLOCATION {
+		i.runtime_error(RUNTIME_HEAP_OVERFLOW, token, "%d bytes at once", bytes)
+	}
+	if i.limits.memory > 0 && i.memory+bytes > i.limits.memory {
+		i.abort("memory", token, "memory limit of %d bytes exceeded", i.limits.memory)
+	}
+	i.memory += bytes
+}
+
+func (i *Interpreter) free(bytes int) {
+	i.memory -= bytes
+}
+
+func (i *Interpreter) enter(token *lexemes) {
+	if i.limits.depth > 0 && len(i.stack) > i.limits.depth {
+		i.abort("depth", token, "call depth limit of %d exceeded", i.limits.depth)
+	}
+}
+
+/* Pops the activations above depth that an error skipped, giving back what they took */
+func (i *Interpreter) unwind(depth int) {
+	for len(i.stack) > depth {
+		frame := i.stack[len(i.stack)-1]
+		i.stack = i.stack[:len(i.stack)-1]
+		/* the section of a unit runs on the values of the program frame, which it does not own */
+		if frame.size > 0 {
+			i.release(frame)
+		}
+	}
+}
+
+/* Runs node under the interpreter's limits, returning a *LimitError or *RuntimeError when it aborts */
+func (i *Interpreter) execute(ctx context.Context, node interface{}) (err error) {
+	_, err = i.evaluate(ctx, node)
+	return err
+}
+
+/* Like execute, also returning the value of node; every call has the whole step budget */
+func (i *Interpreter) evaluate(ctx context.Context, node interface{}) (value float64, err error) {
+	if i.limits.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.limits.timeout)
+		defer cancel()
+	}
+	i.ctx = ctx
+	i.steps = 0
+	defer i.catch(&err)
+	return i.run(node), nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+/* Programs run under -max-memory, each allocating through the one heap the limit watches */
+var memory_programs = []struct {
+	name   string
+	source string
+	limit  string
+	/* the end of stdout, and what stderr must hold, empty when the program finishes */
+	stdout string
+	stderr string
+}{
+	{"recursion.pas", `PROGRAM Recursion;
+PROCEDURE Down(k : INTEGER);
+VAR a, b : INTEGER;
+BEGIN
+   IF k > 0 THEN Down(k - 1)
+END;
+BEGIN
+   Down(10);
+   WRITELN('shallow');
+   Down(1000)
+END.
+`, "2000", "shallow\n", "memory limit of 2000 bytes exceeded"},
+	{"unwound.pas", `PROGRAM Unwound;
+VAR n : INTEGER;
+PROCEDURE Down(k : INTEGER);
+VAR a, b : INTEGER;
+BEGIN
+   IF k > 0 THEN Down(k - 1)
+   ELSE n := n DIV k
+END;
+BEGIN
+   n := 0;
+   WHILE n < 1000 DO
+   BEGIN
+      TRY
+         Down(20)
+      EXCEPT
+         ON E : EDivByZero DO n := n + 1
+      END
+   END;
+   WRITELN('unwound ', n)
+END.
+`, "2000", "unwound 1000\n", ""},
+	{"objects.pas", `PROGRAM Objects;
+TYPE
+   TPoint = CLASS
+      x, y : INTEGER;
+   END;
+   TPixel = CLASS(TPoint)
+      colour : INTEGER;
+   END;
+VAR
+   n : INTEGER;
+   p : TPixel;
+BEGIN
+   n := 0;
+   WHILE n < 1000 DO
+   BEGIN
+      p := TPixel.Create;
+      p.Free;
+      n := n + 1
+   END;
+   WRITELN('freed ', n);
+   WHILE n > 0 DO
+   BEGIN
+      p := TPixel.Create;
+      n := n - 1
+   END;
+   WRITELN('kept')
+END.
+`, "2000", "freed 1000\n", "memory limit of 2000 bytes exceeded"},
+	{"arrays.pas", `PROGRAM Arrays;
+VAR a, b, c : ARRAY OF INTEGER;
+BEGIN
+   SetLength(a, 100);
+   SetLength(b, 100);
+   WRITELN('two');
+   SetLength(c, 100)
+END.
+`, "2000", "two\n", "memory limit of 2000 bytes exceeded"},
+	{"resized.pas", `PROGRAM Resized;
+VAR
+   n : INTEGER;
+   a, b : ARRAY OF INTEGER;
+PROCEDURE Fill;
+VAR local : ARRAY OF INTEGER;
+BEGIN
+   SetLength(local, 150)
+END;
+BEGIN
+   n := 0;
+   WHILE n < 1000 DO
+   BEGIN
+      SetLength(a, 100 + n MOD 50);
+      Fill;
+      b := a;
+      n := n + 1
+   END;
+   WRITELN('resized ', n)
+END.
+`, "4000", "resized 1000\n", ""},
+	{"huge.pas", `PROGRAM Huge;
+VAR a : ARRAY OF INTEGER;
+BEGIN
+   TRY
+      SetLength(a, 2000000000)
+   EXCEPT
+      ON E : EOutOfMemory DO WRITELN('caught')
+   END;
+   SetLength(a, 2000000000)
+END.
+`, "0", "caught\n", "Runtime error 203"},
+}
+
+func TestMemoryLimit(t *testing.T) {
+	for _, program := range memory_programs {
+		program := program
+		t.Run(program.name, func(t *testing.T) {
+			path := write_program(t, program.name, program.source)
+			run := run_pascal(t, "", "-max-memory", program.limit, "-max-depth", "0", path)
+			if strings.HasSuffix(run.stdout, program.stdout) == false {
+				t.Errorf("stdout ends\n%s\nwant\n%s", run.stdout, program.stdout)
+			}
+			if program.stderr == "" && run.code != 0 {
+				t.Errorf("exit %d: %s", run.code, run.stderr)
+			}
+			if program.stderr != "" && (run.code == 0 || strings.Contains(run.stderr, program.stderr) == false) {
+				t.Errorf("exit %d with %q, want %q", run.code, run.stderr, program.stderr)
+			}
+		})
+	}
+}
+
+/* Programs the limits stop, which the virtual machine must stop where the interpreter does */
+var limit_programs = []struct {
+	name   string
+	source string
+	args   []string
+	stderr string
+}{
+	{"loop.pas", `PROGRAM Loop;
+VAR n : INTEGER;
+BEGIN
+   n := 0;
+   WHILE n >= 0 DO n := n + 1 - 1
+END.
+`, []string{"-max-steps", "1000"}, "step limit of 1000 exceeded line [5:22]"},
+	{"forever.pas", `PROGRAM Forever;
+VAR n : INTEGER;
+BEGIN
+   n := 0;
+   WHILE n >= 0 DO n := n + 1 - 1
+END.
+`, []string{"-timeout", "100ms"}, "time limit of 100ms exceeded"},
+	{"deep.pas", `PROGRAM Deep;
+PROCEDURE Down(k : INTEGER);
+BEGIN
+   IF k > 0 THEN Down(k - 1)
+END;
+BEGIN
+   Down(10);
+   WRITELN('shallow');
+   Down(1000000)
+END.
+`, []string{"-max-depth", "50"}, "call depth limit of 50 exceeded line [4:18]"},
+	{"frames.pas", `PROGRAM Frames;
+PROCEDURE Down(k : INTEGER);
+VAR a, b : INTEGER;
+BEGIN
+   IF k > 0 THEN Down(k - 1)
+END;
+BEGIN
+   Down(10);
+   WRITELN('shallow');
+   Down(1000)
+END.
+`, []string{"-max-memory", "2000", "-max-depth", "0"}, "memory limit of 2000 bytes exceeded line [5:18]"},
+}
+
+func TestVmLimits(t *testing.T) {
+	for _, program := range limit_programs {
+		program := program
+		t.Run(program.name, func(t *testing.T) {
+			path := write_program(t, program.name, program.source)
+			want := run_pascal(t, "", append(program.args, path)...)
+			if want.code == 0 || strings.Contains(want.stderr, program.stderr) == false {
+				t.Fatalf("interpreter: exit %d with %q, want %q", want.code, want.stderr, program.stderr)
+			}
+			got := run_pascal(t, "", append(append([]string{"-vm"}, program.args...), path)...)
+			if got.code != want.code || got.stdout != want.stdout || strings.Contains(got.stderr, program.stderr) == false {
+				t.Errorf("-vm: exit %d with %q and %q, want exit %d with %q and %q", got.code, got.stdout, got.stderr, want.code, want.stdout, want.stderr)
+			}
+		})
+	}
+}
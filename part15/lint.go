@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+	LINTER
+
+	A config file sets the severity of rules, one per line, # starts a comment:
+
+		unused-parameter = off
+		integer-to-real  = error
+
+	A comment in the source silences rules on its own line and the next one:
+
+		{lint:ignore shadowed-name}
+		{lint:ignore}                   every rule
+*/
+
+const (
+	LINT_OFF = iota
+	LINT_INFO
+	LINT_WARNING
+	LINT_ERROR
+)
+
+var lint_severities = map[string]int{
+	"off":     LINT_OFF,
+	"info":    LINT_INFO,
+	"warning": LINT_WARNING,
+	"error":   LINT_ERROR,
+}
+
+var reverse_lint_severities = map[int]string{
+	LINT_OFF:     "off",
+	LINT_INFO:    "info",
+	LINT_WARNING: "warning",
+	LINT_ERROR:   "error",
+}
+
+var lint_rules = map[string]int{
+	"unused-variable":              LINT_WARNING,
+	"unused-parameter":             LINT_INFO,
+	"unused-procedure":             LINT_WARNING,
+	"shadowed-name":                LINT_WARNING,
+	"empty-compound":               LINT_INFO,
+	"integer-to-real":              LINT_INFO,
+	"read-before-assignment":       LINT_ERROR,
+	"maybe-read-before-assignment": LINT_WARNING,
+	"dead-store":                   LINT_WARNING,
+}
+
+var lint_ignore = regexp.MustCompile(`\{\s*lint:ignore\b([^}]*)\}`)
+
+type Linter struct {
+	scope       *ScopedSymbolTable
+	reads       map[Symbol]bool
+	calls       map[Symbol]bool
+	unread      map[*lexemes]bool
+	diagnostics []*Diagnostic
+}
+
+func read_lint_config(path string) (map[string]int, error) {
+	severities := make(map[string]int)
+	for rule, severity := range lint_rules {
+		severities[rule] = severity
+	}
+	if path == "" {
+		return severities, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan() == true; line++ {
+		text := strings.TrimSpace(strings.SplitN(scanner.Text(), "#", 2)[0])
+		if text == "" {
+			continue
+		}
+		fields := strings.SplitN(text, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want 'rule = severity'", path, line)
+		}
+		rule, name := strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1])
+		if _, ok := lint_rules[rule]; ok == false {
+			return nil, fmt.Errorf("%s:%d: unknown rule '%s'", path, line, rule)
+		}
+		severity, ok := lint_severities[name]
+		if ok == false {
+			return nil, fmt.Errorf("%s:%d: unknown severity '%s', want off, info, warning or error", path, line, name)
+		}
+		severities[rule] = severity
+	}
+	return severities, scanner.Err()
+}
+
+/* Rules silenced on each line, an empty list silencing them all */
+func lint_suppressions(text string) map[int][]string {
+	suppressed := make(map[int][]string)
+	for index, line := range strings.Split(text, "\n") {
+		for _, match := range lint_ignore.FindAllStringSubmatch(line, -1) {
+			rules := strings.FieldsFunc(match[1], func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+			if len(rules) == 0 {
+				rules = []string{"*"}
+			}
+			suppressed[index+1] = append(suppressed[index+1], rules...)
+			suppressed[index+2] = append(suppressed[index+2], rules...)
+		}
+	}
+	return suppressed
+}
+
+func is_suppressed(suppressed map[int][]string, diagnostic *Diagnostic) bool {
+	/* the comments only cover the file they are in */
+	if diagnostic.token.file != "" {
+		return false
+	}
+	for _, rule := range suppressed[diagnostic.token.line] {
+		if rule == "*" || rule == diagnostic.rule {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Linter) report(rule string, token *lexemes, format string, args ...interface{}) {
+	l.diagnostics = append(l.diagnostics, &Diagnostic{rule, token, fmt.Sprintf(format, args...)})
+}
+
+/* True when proc is called, a virtual method also by a call of the method it overrides */
+func (l *Linter) called(proc *ProcedureSymbol) bool {
+	if l.calls[proc] == true {
+		return true
+	}
+	member := method_member(proc)
+	if member == nil || member.slot < 0 {
+		return false
+	}
+	for class := member.class.parent; class != nil && member.slot < len(class.vtable); class = class.parent {
+		if l.calls[class.vtable[member.slot]] == true {
+			return true
+		}
+	}
+	return false
+}
+
+/* Records which variables are read and which procedures are called */
+func (l *Linter) mark(node interface{}) {
+	switch v := node.(type) {
+	case *Block:
+		for _, decl := range v.declaration_list.elem {
+			l.mark(decl)
+		}
+		l.mark(v.compound)
+	case *ProcedureDecl:
+		if v.block == nil {
+			break
+		}
+		symbol, _ := l.scope.lookup(v.proc_name, true)
+		enclosing := l.scope
+		l.scope = symbol.(*ProcedureSymbol).scope
+		l.mark(v.block)
+		l.scope = enclosing
+	case *Compound:
+		for _, elem := range v.elem {
+			l.mark(elem)
+		}
+	case *Assign:
+		l.mark(v.expr)
+	case *ProcedureCall:
+		if v.proc_symbol != nil {
+			l.calls[v.proc_symbol] = true
+		}
+		if v.variable != nil {
+			l.reads[v.variable] = true
+		}
+		for _, arg := range v.args {
+			l.mark(arg)
+		}
+	case *While:
+		l.mark(v.condition)
+		l.mark(v.body)
+	case *If:
+		l.mark(v.condition)
+		l.mark(v.then_branch)
+		l.mark(v.else_branch)
+	case *Try:
+		l.mark(v.body)
+		enclosing := l.scope
+		for _, handler := range v.all_handlers() {
+			l.scope = handler.scope
+			l.mark(handler.body)
+		}
+		l.scope = enclosing
+		if v.finally != nil {
+			l.mark(v.finally)
+		}
+	case *Designator:
+		l.mark(v.object)
+		switch symbol := v.symbol.(type) {
+		case *VarSymbol:
+			l.reads[symbol] = true
+		case *ProcedureSymbol:
+			l.calls[symbol] = true
+		case nil:
+			/* Free calls the destructor Destroy */
+			if v.create == nil {
+				class := SemanticsAnalyser{l.scope, nil}.type_of(v.object).class
+				if destroy, ok := class.scope.lookup("DESTROY", true); ok == true {
+					l.calls[destroy] = true
+				}
+			}
+		}
+		for _, arg := range v.args {
+			l.mark(arg)
+		}
+	case *MemberAssign:
+		l.mark(v.target.object)
+		l.mark(v.expr)
+	case *Inherited:
+		if v.proc != nil {
+			l.calls[v.proc] = true
+		}
+		for _, arg := range v.args {
+			l.mark(arg)
+		}
+	case *Index:
+		symbol, _ := l.scope.lookup(v.token.tstring, false)
+		l.reads[symbol] = true
+		l.mark(v.index)
+	case *IndexAssign:
+		l.mark(v.target.index)
+		l.mark(v.expr)
+	case *BuiltinCall:
+		for _, arg := range v.args {
+			l.mark(arg)
+		}
+	case *Node:
+		switch v.token.(type) {
+		case *Designator, *Index, *BuiltinCall:
+			l.mark(v.token)
+		}
+		if variable, ok := v.token.(*Var); ok == true {
+			symbol, _ := l.scope.lookup(variable.token.tstring, false)
+			l.reads[symbol] = true
+			/* a procedure taken as a value may be called through it */
+			if proc, is_proc := symbol.(*ProcedureSymbol); is_proc == true {
+				l.calls[proc] = true
+			}
+		}
+		if v.left != nil {
+			l.mark(v.left)
+		}
+		if v.right != nil {
+			l.mark(v.right)
+		}
+	}
+}
+
+func (l *Linter) shadows(name string, token *lexemes) {
+	if l.scope.enclosing_scope == nil {
+		return
+	}
+	if outer, ok := l.scope.enclosing_scope.lookup(name, false); ok == true {
+		if _, builtin := outer.(*BuiltinSymbol); builtin == false {
+			l.report("shadowed-name", token, "%s shadows a declaration of an enclosing scope", name)
+		}
+	}
+}
+
+func (l *Linter) check(node interface{}) {
+	switch v := node.(type) {
+	case *Block:
+		for _, decl := range v.declaration_list.elem {
+			l.check(decl)
+		}
+		l.check(v.compound)
+	case *VarDeclaration:
+		symbol, _ := l.scope.lookup(v.token.tstring, true)
+		l.shadows(v.token.tstring, v.token)
+		if l.reads[symbol] == false {
+			l.report("unused-variable", v.token, "%s is never read", v.token.tstring)
+		}
+	case *ClassDeclaration:
+		symbol, _ := l.scope.lookup(v.token.tstring, true)
+		enclosing := l.scope
+		l.scope = symbol.(*ClassSymbol).scope
+		for _, member := range v.members {
+			if field, ok := member.decl.(*VarDeclaration); ok == true {
+				l.check(field)
+			}
+		}
+		l.scope = enclosing
+	case *ProcedureDecl:
+		/* the FORWARD heading is checked with the declaration completing it */
+		if v.block == nil {
+			break
+		}
+		symbol, _ := l.scope.lookup(v.proc_name, true)
+		proc := symbol.(*ProcedureSymbol)
+		l.shadows(v.proc_name, v.token)
+		if l.called(proc) == false {
+			l.report("unused-procedure", v.token, "procedure %s is never called", v.proc_name)
+		}
+		enclosing := l.scope
+		l.scope = proc.scope
+		/* the parameters of a virtual method are those of every override, each may read different ones */
+		virtual := method_member(proc) != nil && method_member(proc).slot >= 0
+		for _, param := range proc.params {
+			l.shadows(param.name, param.token)
+			if l.reads[param] == false && virtual == false {
+				l.report("unused-parameter", param.token, "parameter %s is never read", param.name)
+			}
+		}
+		l.check(v.block)
+		l.scope = enclosing
+	case *Compound:
+		empty := true
+		for _, elem := range v.elem {
+			if elem != nil {
+				empty = false
+			}
+			l.check(elem)
+		}
+		if empty == true {
+			l.report("empty-compound", v.token, "empty BEGIN END block")
+		}
+	case *Assign:
+		symbol, _ := l.scope.lookup(v.variable.token.tstring, false)
+		variable := symbol.(*VarSymbol)
+		if l.reads[variable] == false {
+			l.unread[v.variable.token] = true
+		}
+		analyser := SemanticsAnalyser{l.scope, nil}
+		if variable.stype.name == "REAL_CONST" && analyser.type_of(v.expr).name == "INTEGER_CONST" {
+			l.report("integer-to-real", v.token, "INTEGER value assigned to REAL variable %s", variable.name)
+		}
+	case *IndexAssign:
+		analyser := SemanticsAnalyser{l.scope, nil}
+		array := analyser.array_variable(v.target.token).stype.array
+		if array.element.name == "REAL_CONST" && analyser.type_of(v.expr).name == "INTEGER_CONST" {
+			l.report("integer-to-real", v.token, "INTEGER value assigned to an element of REAL array %s", v.target.token.tstring)
+		}
+	case *While:
+		l.check(v.body)
+	case *If:
+		l.check(v.then_branch)
+		l.check(v.else_branch)
+	case *Try:
+		/* an empty part of a TRY is no BEGIN END block, only its statements are checked */
+		for _, elem := range v.body.elem {
+			l.check(elem)
+		}
+		enclosing := l.scope
+		for _, handler := range v.all_handlers() {
+			l.scope = handler.scope
+			if body, ok := handler.body.(*Compound); ok == true && handler.class == nil {
+				for _, elem := range body.elem {
+					l.check(elem)
+				}
+			} else {
+				l.check(handler.body)
+			}
+		}
+		l.scope = enclosing
+		if v.finally != nil {
+			for _, elem := range v.finally.elem {
+				l.check(elem)
+			}
+		}
+	}
+}
+
+/* Analyses source and returns its diagnostics, unsuppressed and in source order */
+func lint_source(text string, path string, defines []string, search []string) (diagnostics []*Diagnostic, err error) {
+	defer catch_compile_error(&err)
+	tokens := preprocess(strings.NewReader(text), path, defines)
+	parser := rules{lexer{0, len(tokens), tokens}}
+	tree := parser.Parse()
+	load_units(tree, path, search, defines, nil)
+	scope := new_top_scope("Global", program_uses(tree))
+	analyser := SemanticsAnalyser{scope, nil}
+	analyser.check(tree)
+	linter := &Linter{scope, make(map[Symbol]bool), make(map[Symbol]bool), make(map[*lexemes]bool), nil}
+	linter.mark(tree)
+	linter.check(tree)
+	suppressed := lint_suppressions(text)
+	for _, diagnostic := range append(linter.diagnostics, analyser.flow(tree)...) {
+		/* every store to a variable never read is dead, unused-variable already says so */
+		if diagnostic.rule == "dead-store" && linter.unread[diagnostic.token] == true {
+			continue
+		}
+		if is_suppressed(suppressed, diagnostic) == false {
+			diagnostics = append(diagnostics, diagnostic)
+		}
+	}
+	sort.SliceStable(diagnostics, func(a, b int) bool {
+		if diagnostics[a].token.line != diagnostics[b].token.line {
+			return diagnostics[a].token.line < diagnostics[b].token.line
+		}
+		return diagnostics[a].token.column < diagnostics[b].token.column
+	})
+	return diagnostics, nil
+}
+
+/* Prints the diagnostics of a file, read with the -D symbols and -unit-path directories, and returns how many have error severity */
+func run_lint(path string, config string, defines []string, search []string, out io.Writer) (int, error) {
+	severities, err := read_lint_config(config)
+	if err != nil {
+		return 0, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	diagnostics, err := lint_source(string(content), path, defines, search)
+	if err != nil {
+		return 0, err
+	}
+	errors := 0
+	for _, diagnostic := range diagnostics {
+		severity := severities[diagnostic.rule]
+		if severity == LINT_OFF {
+			continue
+		}
+		if severity == LINT_ERROR {
+			errors++
+		}
+		file := path
+		if diagnostic.token.file != "" {
+			file = diagnostic.token.file
+		}
+		fmt.Fprintf(out, "%s:%d:%d: %s: %s [%s]\n", file, diagnostic.token.line, diagnostic.token.column_number(), reverse_lint_severities[severity], diagnostic.message, diagnostic.rule)
+	}
+	return errors, nil
+}
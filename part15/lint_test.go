@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const linted_program = `PROGRAM Linted;
+VAR x : INTEGER;
+    r : REAL;
+PROCEDURE P(a : INTEGER);
+VAR u, n : INTEGER;
+BEGIN
+   n := 1;
+   WRITELN(n)
+END;
+BEGIN
+   x := 1;
+   r := x;
+   BEGIN END;
+   P(2)
+END.
+`
+
+/* What lint prints for a program under a config, the file name shortened to the program's */
+var lint_tests = []struct {
+	name   string
+	source string
+	config string
+	stdout string
+	stderr string
+	code   int
+}{
+	{"defaults", linted_program, "", `linted.pas:3:5: warning: R is never read [unused-variable]
+linted.pas:4:13: info: parameter A is never read [unused-parameter]
+linted.pas:5:5: warning: U is never read [unused-variable]
+linted.pas:12:6: info: INTEGER value assigned to REAL variable R [integer-to-real]
+linted.pas:13:4: info: empty BEGIN END block [empty-compound]
+`, "", 0},
+	{"config", linted_program, `# stricter than the defaults
+unused-parameter = off
+integer-to-real  = error   # no silent conversions
+  empty-compound=warning
+`, `linted.pas:3:5: warning: R is never read [unused-variable]
+linted.pas:5:5: warning: U is never read [unused-variable]
+linted.pas:12:6: error: INTEGER value assigned to REAL variable R [integer-to-real]
+linted.pas:13:4: warning: empty BEGIN END block [empty-compound]
+`, "", 1},
+	{"no equals", linted_program, "unused-parameter off\n", "", "lint.conf:1: want 'rule = severity'", 255},
+	{"unknown rule", linted_program, "\nunused-thing = off\n", "", "lint.conf:2: unknown rule 'unused-thing'", 255},
+	{"unknown severity", linted_program, "unused-parameter = fatal\n", "", "lint.conf:1: unknown severity 'fatal', want off, info, warning or error", 255},
+	{"suppressed", `PROGRAM Linted;
+VAR x : INTEGER;
+{lint:ignore unused-variable}
+    r : REAL;
+PROCEDURE P(a : INTEGER); {lint:ignore unused-parameter}
+VAR u, n : INTEGER; { lint:ignore shadowed-name, dead-store }
+BEGIN
+   n := 1;
+   WRITELN(n)
+END;
+BEGIN
+   x := 1;
+   r := x; {lint:ignore integer-to-real}
+   BEGIN END; {lint:ignore}
+   P(2)
+END.
+`, "", `linted.pas:6:5: warning: U is never read [unused-variable]
+`, "", 0},
+	{"errors", `PROGRAM Linted;
+PROCEDURE P;
+VAR n, m : INTEGER;
+BEGIN
+   m := n;
+   WRITELN(m)
+END;
+BEGIN
+   P
+END.
+`, "", `linted.pas:5:9: error: N is read before being assigned [read-before-assignment]
+`, "", 1},
+}
+
+func TestLint(t *testing.T) {
+	for _, test := range lint_tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := write_program(t, "linted.pas", test.source)
+			args := []string{"lint", path}
+			if test.config != "" {
+				config := filepath.Join(filepath.Dir(path), "lint.conf")
+				if err := os.WriteFile(config, []byte(test.config), 0644); err != nil {
+					t.Fatal(err)
+				}
+				args = append([]string{"-lint-config", config}, args...)
+			}
+			run := run_pascal(t, "", args...)
+			stdout := strings.ReplaceAll(run.stdout, path, "linted.pas")
+			stderr := strings.ReplaceAll(run.stderr, filepath.Join(filepath.Dir(path), "lint.conf"), "lint.conf")
+			if stdout != test.stdout || strings.Contains(stderr, test.stderr) == false || run.code != test.code {
+				t.Errorf("exit %d with\n%s%s\nwant exit %d with\n%s%s", run.code, stdout, stderr, test.code, test.stdout, test.stderr)
+			}
+		})
+	}
+}
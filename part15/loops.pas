@@ -0,0 +1,33 @@
+PROGRAM Loops;
+VAR
+   i, j, sum, count : INTEGER;
+   mean : REAL;
+
+PROCEDURE Accumulate(n : INTEGER);
+VAR
+   k : INTEGER;
+BEGIN
+   k := 0;
+   WHILE k < n DO
+   BEGIN
+      sum := sum + k * k - k DIV 3;
+      k := k + 1
+   END;
+   count := count + n
+END;
+
+BEGIN {Loops}
+   sum := 0;
+   count := 0;
+   i := 0;
+   WHILE i < 200 DO
+   BEGIN
+      j := 0;
+      WHILE j <= i DO
+         j := j + 1;
+      Accumulate(j);
+      i := i + 1
+   END;
+   mean := sum / count;
+   WRITELN('sum = ', sum, ' count = ', count, ' mean = ', mean)
+END.  {Loops}
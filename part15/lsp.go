@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+/*
+	LANGUAGE SERVER
+*/
+
+type LspMessage struct {
+	Jsonrpc string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type LspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type LspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type LspRange struct {
+	Start LspPosition `json:"start"`
+	End   LspPosition `json:"end"`
+}
+
+type LspLocation struct {
+	Uri   string   `json:"uri"`
+	Range LspRange `json:"range"`
+}
+
+type LspDiagnostic struct {
+	Range    LspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type LspDocumentSymbol struct {
+	Name           string               `json:"name"`
+	Detail         string               `json:"detail,omitempty"`
+	Kind           int                  `json:"kind"`
+	Range          LspRange             `json:"range"`
+	SelectionRange LspRange             `json:"selectionRange"`
+	Children       []*LspDocumentSymbol `json:"children,omitempty"`
+}
+
+type LspCompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+/* Set by the client in initialize, as -D and -unit-path are on the command line */
+type LspInitializationOptions struct {
+	Defines  []string `json:"defines"`
+	UnitPath []string `json:"unitPath"`
+}
+
+type LspTextDocumentParams struct {
+	TextDocument struct {
+		Uri  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+	Position LspPosition `json:"position"`
+	Context  struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+
+	InitializationOptions LspInitializationOptions `json:"initializationOptions"`
+}
+
+const (
+	LSP_SEVERITY_ERROR   = 1
+	LSP_SEVERITY_WARNING = 2
+	LSP_KIND_CLASS       = 5
+	LSP_KIND_INTERFACE   = 11
+	LSP_KIND_FUNCTION    = 12
+	LSP_KIND_VARIABLE    = 13
+	LSP_ITEM_FUNCTION    = 3
+	LSP_ITEM_VARIABLE    = 6
+	LSP_ITEM_CLASS       = 7
+	LSP_ITEM_INTERFACE   = 8
+	LSP_ITEM_KEYWORD     = 14
+)
+
+/* Last successful analysis of an open file, kept while the user types errors; files holds the lines of its include files and units */
+type LspDocument struct {
+	text       string
+	lines      []string
+	files      map[string][]string
+	tokens     []lexemes
+	tree       *Block
+	scope      *ScopedSymbolTable
+	references map[*lexemes]Symbol
+}
+
+type LspServer struct {
+	in        *bufio.Reader
+	out       io.Writer
+	documents map[string]*LspDocument
+	defines   []string
+	search    []string
+}
+
+/* Path of a file: URI, so that units and include files are found next to the document */
+func uri_path(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(parsed.Path)
+}
+
+func analyse_source(text string, path string, defines []string, search []string) (document *LspDocument, err error) {
+	defer catch_compile_error(&err)
+	document = &LspDocument{text: text, lines: strings.Split(text, "\n"), files: make(map[string][]string), references: make(map[*lexemes]Symbol)}
+	document.tokens = preprocess(strings.NewReader(text), path, defines)
+	parser := rules{lexer{0, len(document.tokens), document.tokens}}
+	document.tree = parser.Parse()
+	load_units(document.tree, path, search, defines, nil)
+	document.scope = new_top_scope("Global", program_uses(document.tree))
+	analyser := SemanticsAnalyser{document.scope, document.references}
+	analyser.check(document.tree)
+	return document, nil
+}
+
+/* Counts the UTF-16 code units LSP characters are measured in up to a byte offset into the line */
+func utf16_character(line string, offset int) int {
+	if offset > len(line) {
+		return utf16_character(line, len(line)) + offset - len(line)
+	}
+	return len(utf16.Encode([]rune(line[:offset])))
+}
+
+/* Byte offset just past a token as written in its line: a string literal ends at its closing quote, and a name at its last letter however the scanner rewrote it */
+func token_end(line string, token *lexemes) int {
+	end := token.column
+	switch {
+	case token.ttype == EOF:
+	case token.ttype == STRING_CONST:
+		for end++; end < len(line); end++ {
+			if line[end] == '\'' && end+1 < len(line) && line[end+1] == '\'' {
+				end++
+			} else if line[end] == '\'' {
+				return end + 1
+			}
+		}
+	case end+len(token.tstring) <= len(line) && strings.EqualFold(line[end:end+len(token.tstring)], token.tstring) == true:
+		return end + len(token.tstring)
+	default:
+		for end < len(line) && (line[end] == '_' || line[end] == '.' || unicode.IsLetter(rune(line[end])) == true || unicode.IsDigit(rune(line[end])) == true) {
+			end++
+		}
+		if end == token.column {
+			return token.column + len(token.tstring)
+		}
+	}
+	return end
+}
+
+/* Where a token sits in the lines of its file, its byte columns converted to UTF-16 */
+func token_range(lines []string, token *lexemes) LspRange {
+	line := ""
+	if token.line >= 1 && token.line <= len(lines) {
+		line = lines[token.line-1]
+	}
+	start := LspPosition{token.line - 1, utf16_character(line, token.column)}
+	return LspRange{start, LspPosition{token.line - 1, utf16_character(line, token_end(line, token))}}
+}
+
+/* The lines of the file a token was read from: the document's, or those of an include file or unit on disk */
+func (d *LspDocument) file_lines(token *lexemes) []string {
+	if token.file == "" {
+		return d.lines
+	}
+	lines, ok := d.files[token.file]
+	if ok == false {
+		content, _ := os.ReadFile(token.file)
+		lines = strings.Split(string(content), "\n")
+		d.files[token.file] = lines
+	}
+	return lines
+}
+
+/* Where a token is, in the document at uri or in the file it was included or used from */
+func (d *LspDocument) location(uri string, token *lexemes) LspLocation {
+	if token.file != "" {
+		path, _ := filepath.Abs(token.file)
+		uri = (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+	}
+	return LspLocation{uri, token_range(d.file_lines(token), token)}
+}
+
+/* The token naming a declaration */
+func declaration_token(decl interface{}) *lexemes {
+	switch v := decl.(type) {
+	case *VarDeclaration:
+		return v.token
+	case *TypeDeclaration:
+		return v.token
+	case *ClassDeclaration:
+		return v.token
+	case *ProcedureDecl:
+		return v.token
+	}
+	return nil
+}
+
+func span_range(lines []string, start *lexemes, end *lexemes) LspRange {
+	return LspRange{token_range(lines, start).Start, token_range(lines, end).End}
+}
+
+func before(a LspPosition, b LspPosition) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Character < b.Character
+}
+
+func (r LspRange) contains(position LspPosition) bool {
+	return before(position, r.Start) == false && before(r.End, position) == false
+}
+
+func symbol_signature(symbol Symbol) string {
+	switch v := symbol.(type) {
+	case *VarSymbol:
+		return fmt.Sprintf("%s : %s", v.name, type_name(v.stype))
+	case *ProcedureSymbol:
+		params := []string{}
+		for _, param := range v.params {
+			params = append(params, fmt.Sprintf("%s : %s", param.name, type_name(param.stype)))
+		}
+		heading := fmt.Sprintf("PROCEDURE %s", v.name)
+		if v.result != nil {
+			heading = fmt.Sprintf("FUNCTION %s", v.name)
+		}
+		if len(params) > 0 {
+			heading += fmt.Sprintf("(%s)", strings.Join(params, "; "))
+		}
+		if v.result != nil {
+			heading += fmt.Sprintf(" : %s", type_name(v.result.stype))
+		}
+		return heading
+	case *ProcTypeSymbol:
+		return fmt.Sprintf("TYPE %s = %s", v.name, procedural_text(v))
+	case *ClassSymbol:
+		if v.parent == nil {
+			return fmt.Sprintf("TYPE %s = CLASS", v.name)
+		}
+		return fmt.Sprintf("TYPE %s = CLASS(%s)", v.name, v.parent.name)
+	}
+	return symbol.getName()
+}
+
+func symbol_token(symbol Symbol) *lexemes {
+	switch v := symbol.(type) {
+	case *VarSymbol:
+		return v.token
+	case *ProcedureSymbol:
+		return v.token
+	case *ProcTypeSymbol:
+		return v.token
+	case *ClassSymbol:
+		return v.token
+	}
+	return nil
+}
+
+func (d *LspDocument) symbol_at(position LspPosition) (*lexemes, Symbol) {
+	for token, symbol := range d.references {
+		if token.file == "" && token_range(d.lines, token).contains(position) == true {
+			return token, symbol
+		}
+	}
+	return nil, nil
+}
+
+/* Innermost procedure scope whose declaration spans the position */
+func (d *LspDocument) scope_at(position LspPosition) *ScopedSymbolTable {
+	scope := d.scope
+	block := d.tree
+	for found := true; found == true; {
+		found = false
+		for _, decl := range block.declaration_list.elem {
+			proc, ok := decl.(*ProcedureDecl)
+			if ok == false || proc.block == nil || proc.token.file != "" {
+				continue
+			}
+			compound, _ := proc.block.compound.(*Compound)
+			if span_range(d.lines, proc.token, compound.end_token).contains(position) == false {
+				continue
+			}
+			symbol, _ := scope.lookup(proc.proc_name, true)
+			scope = symbol.(*ProcedureSymbol).scope
+			block = proc.block
+			found = true
+			break
+		}
+	}
+	return scope
+}
+
+func (d *LspDocument) document_symbols(block *Block, params []Param) []*LspDocumentSymbol {
+	symbols := []*LspDocumentSymbol{}
+	for _, param := range params {
+		token := param.var_name.token
+		symbols = append(symbols, &LspDocumentSymbol{token.tstring, type_name(&BuiltinSymbol{param.var_type.sstring, nil, nil, nil}), LSP_KIND_VARIABLE, token_range(d.lines, token), token_range(d.lines, token), nil})
+	}
+	for _, decl := range block.declaration_list.elem {
+		/* the outline shows the document only, declarations of include files having ranges in another file */
+		if token := declaration_token(decl); token != nil && token.file != "" {
+			continue
+		}
+		switch v := decl.(type) {
+		case *VarDeclaration:
+			symbols = append(symbols, &LspDocumentSymbol{v.token.tstring, type_name(&BuiltinSymbol{v.spec.sstring, nil, nil, nil}), LSP_KIND_VARIABLE, token_range(d.lines, v.token), token_range(d.lines, v.token), nil})
+		case *TypeDeclaration:
+			kind := "PROCEDURE"
+			if v.result != nil {
+				kind = "FUNCTION"
+			}
+			symbols = append(symbols, &LspDocumentSymbol{v.token.tstring, kind, LSP_KIND_INTERFACE, token_range(d.lines, v.token), token_range(d.lines, v.token), nil})
+		case *ClassDeclaration:
+			symbol := &LspDocumentSymbol{v.token.tstring, "CLASS", LSP_KIND_CLASS, token_range(d.lines, v.token), token_range(d.lines, v.token), nil}
+			for _, member := range v.members {
+				switch decl := member.decl.(type) {
+				case *VarDeclaration:
+					symbol.Children = append(symbol.Children, &LspDocumentSymbol{decl.token.tstring, type_name(&BuiltinSymbol{decl.spec.sstring, nil, nil, nil}), LSP_KIND_VARIABLE, token_range(d.lines, decl.token), token_range(d.lines, decl.token), nil})
+				case *ProcedureDecl:
+					symbol.Children = append(symbol.Children, &LspDocumentSymbol{decl.proc_name, reverse_lex[member.kind], LSP_KIND_FUNCTION, token_range(d.lines, decl.token), token_range(d.lines, decl.token), nil})
+				}
+			}
+			symbols = append(symbols, symbol)
+		case *ProcedureDecl:
+			if v.block == nil {
+				break
+			}
+			compound, _ := v.block.compound.(*Compound)
+			kind := "PROCEDURE"
+			if v.result != nil {
+				kind = "FUNCTION"
+			}
+			symbol := &LspDocumentSymbol{v.proc_name, kind, LSP_KIND_FUNCTION, span_range(d.lines, v.token, compound.end_token), token_range(d.lines, v.token), nil}
+			symbol.Children = d.document_symbols(v.block, v.params)
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols
+}
+
+func (d *LspDocument) completions(position LspPosition) []LspCompletionItem {
+	items := []LspCompletionItem{}
+	seen := map[string]bool{}
+	scopes := []*ScopedSymbolTable{}
+	for scope := d.scope_at(position); scope != nil; scope = scope.enclosing_scope {
+		scopes = append(scopes, scope)
+		/* a method sees what its class inherits too */
+		if scope.class != nil {
+			for parent := scope.class.parent; parent != nil; parent = parent.parent {
+				scopes = append(scopes, parent.scope)
+			}
+		}
+	}
+	for _, scope := range scopes {
+		for name, symbol := range scope.symbols {
+			/* the blocks of methods are reached through their class */
+			if seen[name] == true || strings.Contains(name, ".") == true {
+				continue
+			}
+			seen[name] = true
+			switch symbol.(type) {
+			case *VarSymbol:
+				items = append(items, LspCompletionItem{name, LSP_ITEM_VARIABLE, symbol_signature(symbol)})
+			case *ProcedureSymbol:
+				items = append(items, LspCompletionItem{name, LSP_ITEM_FUNCTION, symbol_signature(symbol)})
+			case *ProcTypeSymbol:
+				items = append(items, LspCompletionItem{name, LSP_ITEM_INTERFACE, symbol_signature(symbol)})
+			case *ClassSymbol:
+				items = append(items, LspCompletionItem{name, LSP_ITEM_CLASS, symbol_signature(symbol)})
+			}
+		}
+	}
+	for name := range keyword {
+		items = append(items, LspCompletionItem{name, LSP_ITEM_KEYWORD, ""})
+	}
+	sort.Slice(items, func(a, b int) bool { return items[a].Label < items[b].Label })
+	return items
+}
+
+/* Reads one Content-Length framed body, the transport shared by LSP and DAP */
+func read_framed(in *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "Content-Length:") {
+			length, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(in, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func write_framed(out io.Writer, message interface{}) {
+	body, _ := json.Marshal(message)
+	fmt.Fprintf(out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (l *LspServer) read_message() (*LspMessage, error) {
+	body, err := read_framed(l.in)
+	if err != nil {
+		return nil, err
+	}
+	message := &LspMessage{}
+	if err := json.Unmarshal(body, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func (l *LspServer) write_message(message map[string]interface{}) {
+	message["jsonrpc"] = "2.0"
+	write_framed(l.out, message)
+}
+
+func (l *LspServer) notify(method string, params interface{}) {
+	l.write_message(map[string]interface{}{"method": method, "params": params})
+}
+
+func (l *LspServer) respond(id *json.RawMessage, result interface{}, lsp_err *LspError) {
+	if lsp_err != nil {
+		l.write_message(map[string]interface{}{"id": id, "error": lsp_err})
+		return
+	}
+	l.write_message(map[string]interface{}{"id": id, "result": result})
+}
+
+func (l *LspServer) update(uri string, text string) {
+	document, err := analyse_source(text, uri_path(uri), l.defines, l.search)
+	diagnostics := []LspDiagnostic{}
+	if err != nil {
+		compile_err := err.(*CompileError)
+		rng := LspRange{}
+		if compile_err.token != nil {
+			rng = token_range(strings.Split(text, "\n"), compile_err.token)
+		}
+		diagnostics = append(diagnostics, LspDiagnostic{rng, LSP_SEVERITY_ERROR, "pascal", fmt.Sprintf("%s Error: %s", compile_err.phase, compile_err.message)})
+	} else {
+		l.documents[uri] = document
+		analyser := SemanticsAnalyser{document.scope, nil}
+		for _, warning := range analyser.flow(document.tree) {
+			diagnostics = append(diagnostics, LspDiagnostic{token_range(document.file_lines(warning.token), warning.token), LSP_SEVERITY_WARNING, "pascal", warning.message})
+		}
+	}
+	l.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": diagnostics})
+}
+
+func (l *LspServer) handle(message *LspMessage) (interface{}, *LspError) {
+	params := LspTextDocumentParams{}
+	if len(message.Params) > 0 {
+		json.Unmarshal(message.Params, &params)
+	}
+	uri := params.TextDocument.Uri
+	document := l.documents[uri]
+	switch message.Method {
+	case "initialize":
+		l.defines = append(l.defines, params.InitializationOptions.Defines...)
+		l.search = append(l.search, params.InitializationOptions.UnitPath...)
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1,
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"hoverProvider":          true,
+				"documentSymbolProvider": true,
+				"completionProvider":     map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{"name": "pascal-lsp"},
+		}, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		l.update(uri, params.TextDocument.Text)
+	case "textDocument/didChange":
+		if len(params.ContentChanges) > 0 {
+			l.update(uri, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+	case "textDocument/didClose":
+		delete(l.documents, uri)
+	case "textDocument/definition":
+		if document == nil {
+			return nil, nil
+		}
+		_, symbol := document.symbol_at(params.Position)
+		if symbol == nil || symbol_token(symbol) == nil {
+			return nil, nil
+		}
+		return document.location(uri, symbol_token(symbol)), nil
+	case "textDocument/references":
+		locations := []LspLocation{}
+		if document == nil {
+			return locations, nil
+		}
+		_, symbol := document.symbol_at(params.Position)
+		if symbol == nil {
+			return locations, nil
+		}
+		for token, target := range document.references {
+			if target != symbol || token == symbol_token(symbol) && params.Context.IncludeDeclaration == false {
+				continue
+			}
+			locations = append(locations, document.location(uri, token))
+		}
+		sort.Slice(locations, func(a, b int) bool {
+			return before(locations[a].Range.Start, locations[b].Range.Start)
+		})
+		return locations, nil
+	case "textDocument/hover":
+		if document == nil {
+			return nil, nil
+		}
+		token, symbol := document.symbol_at(params.Position)
+		if symbol == nil {
+			return nil, nil
+		}
+		contents := map[string]string{"kind": "markdown", "value": "```pascal\n" + symbol_signature(symbol) + "\n```"}
+		return map[string]interface{}{"contents": contents, "range": token_range(document.lines, token)}, nil
+	case "textDocument/documentSymbol":
+		if document == nil {
+			return []*LspDocumentSymbol{}, nil
+		}
+		return document.document_symbols(document.tree, nil), nil
+	case "textDocument/completion":
+		if document == nil {
+			return []LspCompletionItem{}, nil
+		}
+		return document.completions(params.Position), nil
+	default:
+		if message.Id != nil {
+			return nil, &LspError{-32601, "method not found: " + message.Method}
+		}
+	}
+	return nil, nil
+}
+
+func run_lsp(defines []string, search []string, in io.Reader, out io.Writer) error {
+	server := &LspServer{bufio.NewReader(in), out, make(map[string]*LspDocument), defines, search}
+	for {
+		message, err := server.read_message()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if message.Method == "exit" {
+			return nil
+		}
+		result, lsp_err := server.handle(message)
+		if message.Id != nil {
+			server.respond(message.Id, result, lsp_err)
+		}
+	}
+}
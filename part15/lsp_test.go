@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* LSP characters count UTF-16 code units, so a character outside the BMP before a token counts twice */
+func TestLspRangesInUtf16(t *testing.T) {
+	uri := "file:///wide.pas"
+	text := "PROGRAM Wide;\nPROCEDURE P;\nVAR n : INTEGER;\nBEGIN\n   WRITELN('\U0001F600', n)\nEND;\nBEGIN\n   P\nEND.\n"
+	in := &bytes.Buffer{}
+	write_framed(in, map[string]interface{}{"jsonrpc": "2.0", "method": "textDocument/didOpen", "params": map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri, "text": text}}})
+	write_framed(in, map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "textDocument/hover", "params": map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri}, "position": LspPosition{4, 17}}})
+	write_framed(in, map[string]interface{}{"jsonrpc": "2.0", "method": "exit"})
+	out := &bytes.Buffer{}
+	if err := run_lsp(nil, nil, in, out); err != nil {
+		t.Fatal(err)
+	}
+	want := LspRange{LspPosition{4, 17}, LspPosition{4, 18}}
+	reader := bufio.NewReader(out)
+	for _, field := range []string{"params", "result"} {
+		body, err := read_framed(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		message := map[string]json.RawMessage{}
+		json.Unmarshal(body, &message)
+		ranged := struct {
+			Range       LspRange
+			Diagnostics []LspDiagnostic
+		}{}
+		json.Unmarshal(message[field], &ranged)
+		if field == "params" && (len(ranged.Diagnostics) != 1 || ranged.Diagnostics[0].Range != want) {
+			t.Errorf("diagnostics %s", body)
+		}
+		if field == "result" && ranged.Range != want {
+			t.Errorf("hover %s", body)
+		}
+	}
+}
+
+/* The initialization options carry the -D symbols and unit directories a document is analysed with */
+func TestLspInitializationOptions(t *testing.T) {
+	path, lib := defined_program(t)
+	text, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &bytes.Buffer{}
+	write_framed(in, map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": map[string]interface{}{"initializationOptions": map[string]interface{}{"defines": []string{"FAST"}, "unitPath": []string{lib}}}})
+	write_framed(in, map[string]interface{}{"jsonrpc": "2.0", "method": "textDocument/didOpen", "params": map[string]interface{}{"textDocument": map[string]interface{}{"uri": "file://" + filepath.ToSlash(path), "text": string(text)}}})
+	write_framed(in, map[string]interface{}{"jsonrpc": "2.0", "method": "exit"})
+	out := &bytes.Buffer{}
+	if err := run_lsp(nil, nil, in, out); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(out)
+	read_framed(reader)
+	body, err := read_framed(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body), `"diagnostics":[]`) == false {
+		t.Errorf("diagnostics %s", body)
+	}
+}
+
+/* A range ends where the token ends in the source, past the quotes of a string and however a name was written */
+func TestLspTokenRanges(t *testing.T) {
+	for _, test := range []struct {
+		line  string
+		token lexemes
+		end   int
+	}{
+		{"   WRITELN('it''s', n)", lexemes{STRING_CONST, "it's", 1, 11, 0, ""}, 18},
+		{"   WRITELN('', n)", lexemes{STRING_CONST, "", 1, 11, 0, ""}, 13},
+		{"   total := 1", lexemes{ID, "TOTAL", 1, 3, 0, ""}, 8},
+		{"   x := 1.50", lexemes{REAL_CONST, "1.50", 1, 8, 0, ""}, 12},
+		{"END.", lexemes{EOF, "EOF", 1, 0, 0, ""}, 0},
+	} {
+		got := token_range([]string{test.line}, &test.token)
+		if got != (LspRange{LspPosition{0, test.token.column}, LspPosition{0, test.end}}) {
+			t.Errorf("%q in %q: got %v, want it to end at %d", test.token.tstring, test.line, got, test.end)
+		}
+	}
+}
+
+/* The definition of a procedure from an include file is in that file */
+func TestLspIncludedDefinition(t *testing.T) {
+	text := "PROGRAM Main;\n{$I body.inc}\nBEGIN\n   Show\nEND.\n"
+	path := write_program(t, "main.pas", text)
+	include := filepath.Join(filepath.Dir(path), "body.inc")
+	if err := os.WriteFile(include, []byte("PROCEDURE Show;\nBEGIN\n   WRITELN('from include')\nEND;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	uri := "file://" + filepath.ToSlash(path)
+	in := &bytes.Buffer{}
+	write_framed(in, map[string]interface{}{"jsonrpc": "2.0", "method": "textDocument/didOpen", "params": map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri, "text": text}}})
+	write_framed(in, map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "textDocument/definition", "params": map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri}, "position": LspPosition{3, 4}}})
+	write_framed(in, map[string]interface{}{"jsonrpc": "2.0", "method": "exit"})
+	out := &bytes.Buffer{}
+	if err := run_lsp(nil, nil, in, out); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(out)
+	read_framed(reader)
+	body, err := read_framed(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := struct{ Result LspLocation }{}
+	json.Unmarshal(body, &message)
+	want := LspLocation{"file://" + filepath.ToSlash(include), LspRange{LspPosition{0, 10}, LspPosition{0, 14}}}
+	if message.Result != want {
+		t.Errorf("definition %s, want %v", body, want)
+	}
+}
@@ -9,6 +9,10 @@ import (
 	"unicode"
 	"bufio"
 	"log"
+	"io"
+	"context"
+	"os/signal"
+	"path/filepath"
 )
 
 const (
@@ -37,6 +41,49 @@ const (
 	CCOMMENT = 24
 	FLOAT_DIV = 25
 	PROCEDURE = 26
+	EQUAL = 27
+	NOT_EQUAL = 28
+	LESS = 29
+	LESS_EQUAL = 30
+	GREATER = 31
+	GREATER_EQUAL = 32
+	WHILE = 33
+	DO = 34
+	STRING_CONST = 35
+	IF = 36
+	THEN = 37
+	ELSE = 38
+	DIRECTIVE = 39
+	UNIT = 40
+	INTERFACE = 41
+	IMPLEMENTATION = 42
+	USES = 43
+	INITIALIZATION = 44
+	FINALIZATION = 45
+	FORWARD = 46
+	TYPE = 47
+	TRY = 48
+	EXCEPT = 49
+	FINALLY = 50
+	RAISE = 51
+	ON = 52
+	CLASS = 53
+	CONSTRUCTOR = 54
+	DESTRUCTOR = 55
+	VIRTUAL = 56
+	OVERRIDE = 57
+	INHERITED = 58
+	PRIVATE = 59
+	PROTECTED = 60
+	PUBLIC = 61
+	NIL = 62
+	ARRAY = 63
+	OF = 64
+	CONST = 65
+	LBRACKET = 66
+	RBRACKET = 67
+	DOTDOT = 68
+	FUNCTION = 69
 )
 
 /* STATIC VALUE */
@@ -65,6 +112,49 @@ var reverse_lex = map[int]string {
 		COMMA : "COMMA",
 		PROGRAM : "PROGRAM",
 		PROCEDURE : "PROCEDURE",
+		EQUAL : "EQUAL",
+		NOT_EQUAL : "NOT_EQUAL",
+		LESS : "LESS",
+		LESS_EQUAL : "LESS_EQUAL",
+		GREATER : "GREATER",
+		GREATER_EQUAL : "GREATER_EQUAL",
+		WHILE : "WHILE",
+		DO : "DO",
+		STRING_CONST : "STRING_CONST",
+		IF : "IF",
+		THEN : "THEN",
+		ELSE : "ELSE",
+		DIRECTIVE : "DIRECTIVE",
+		UNIT : "UNIT",
+		INTERFACE : "INTERFACE",
+		IMPLEMENTATION : "IMPLEMENTATION",
+		USES : "USES",
+		INITIALIZATION : "INITIALIZATION",
+		FINALIZATION : "FINALIZATION",
+		FORWARD : "FORWARD",
+		TYPE : "TYPE",
+		TRY : "TRY",
+		EXCEPT : "EXCEPT",
+		FINALLY : "FINALLY",
+		RAISE : "RAISE",
+		ON : "ON",
+		CLASS : "CLASS",
+		CONSTRUCTOR : "CONSTRUCTOR",
+		DESTRUCTOR : "DESTRUCTOR",
+		VIRTUAL : "VIRTUAL",
+		OVERRIDE : "OVERRIDE",
+		INHERITED : "INHERITED",
+		PRIVATE : "PRIVATE",
+		PROTECTED : "PROTECTED",
+		PUBLIC : "PUBLIC",
+		NIL : "NIL",
+		ARRAY : "ARRAY",
+		OF : "OF",
+		CONST : "CONST",
+		LBRACKET : "LBRACKET",
+		RBRACKET : "RBRACKET",
+		DOTDOT : "DOTDOT",
+		FUNCTION : "FUNCTION",
 }
 
 var lex = map[string]int {
@@ -82,6 +172,15 @@ var lex = map[string]int {
 		"\n" : EOF,
 		"{" : OCOMMENT,
 		"}" : CCOMMENT,
+		"=" : EQUAL,
+		"<" : LESS,
+		">" : GREATER,
+		"<=" : LESS_EQUAL,
+		">=" : GREATER_EQUAL,
+		"<>" : NOT_EQUAL,
+		"[" : LBRACKET,
+		"]" : RBRACKET,
+		".." : DOTDOT,
 }
 
 var keyword = map[string]int {
@@ -93,6 +192,39 @@ var keyword = map[string]int {
 		"INTEGER" : INTEGER_CONST,
 		"PROGRAM" : PROGRAM,
 		"PROCEDURE" : PROCEDURE,
+		"WHILE" : WHILE,
+		"DO" : DO,
+		"MOD" : MOD,
+		"IF" : IF,
+		"THEN" : THEN,
+		"ELSE" : ELSE,
+		"UNIT" : UNIT,
+		"INTERFACE" : INTERFACE,
+		"IMPLEMENTATION" : IMPLEMENTATION,
+		"USES" : USES,
+		"INITIALIZATION" : INITIALIZATION,
+		"FINALIZATION" : FINALIZATION,
+		"FORWARD" : FORWARD,
+		"TYPE" : TYPE,
+		"TRY" : TRY,
+		"EXCEPT" : EXCEPT,
+		"FINALLY" : FINALLY,
+		"RAISE" : RAISE,
+		"ON" : ON,
+		"CLASS" : CLASS,
+		"CONSTRUCTOR" : CONSTRUCTOR,
+		"DESTRUCTOR" : DESTRUCTOR,
+		"VIRTUAL" : VIRTUAL,
+		"OVERRIDE" : OVERRIDE,
+		"INHERITED" : INHERITED,
+		"PRIVATE" : PRIVATE,
+		"PROTECTED" : PROTECTED,
+		"PUBLIC" : PUBLIC,
+		"NIL" : NIL,
+		"ARRAY" : ARRAY,
+		"OF" : OF,
+		"CONST" : CONST,
+		"FUNCTION" : FUNCTION,
 }
 
 /* STRUCT */
@@ -103,6 +235,12 @@ type Symbol interface {
 
 type BuiltinSymbol struct {
 	name string
+	/* the procedural type this is the type of, nil for INTEGER and REAL */
+	procedure *ProcTypeSymbol
+	/* the class this is the type of, likewise */
+	class *ClassSymbol
+	/* the array type this is the type of, likewise */
+	array *ArrayType
 }
 func (b *BuiltinSymbol) getName() string {
 	return b.name
@@ -114,6 +252,11 @@ func (b *BuiltinSymbol) String() string {
 type ProcedureSymbol struct {
 	name string
 	params []*VarSymbol
+	token *lexemes
+	scope *ScopedSymbolTable
+	decl *ProcedureDecl
+	/* where a function keeps the value it returns, nil for a procedure */
+	result *VarSymbol
 }
 
 func (p *ProcedureSymbol) getName() string {
@@ -133,6 +276,9 @@ type VarSymbol struct {
 	name string
 	stype *BuiltinSymbol
 	value float64
+	token *lexemes
+	/* a CONST parameter, which cannot be assigned to */
+	constant bool
 }
 
 func (v *VarSymbol) getName() string {
@@ -149,10 +295,45 @@ func (v *VarSymbol) String() string {
 
 type SemanticsAnalyser struct {
 	scope *ScopedSymbolTable
+	references map[*lexemes]Symbol
 }
 
 type Interpreter struct {
 	scope *ScopedSymbolTable
+	stack []*CallFrame
+	debugger *Debugger
+	out io.Writer
+	ctx context.Context
+	limits Limits
+	steps int
+	memory int
+	integers map[*Node]bool
+	/* procedure values handed out, a value being its index plus one */
+	closures []Closure
+	handles map[Closure]int
+	/* the exceptions being handled, innermost last, for RAISE to raise again */
+	handling []*RuntimeError
+	/* instances, a reference being the index plus one, each keeping its fields in a frame of its class */
+	objects []*CallFrame
+	/* the elements of arrays, a handle being the index plus one */
+	arrays [][]float64
+}
+
+/* One activation on the interpreter call stack, the program itself at the bottom */
+type CallFrame struct {
+	name string
+	scope *ScopedSymbolTable
+	call_token *lexemes
+	token *lexemes
+	/* the activation of the enclosing procedure, and the values of this one */
+	link *CallFrame
+	values map[*VarSymbol]float64
+	/* bytes taken from the heap for it, 0 for frames living as long as the program */
+	size int
+}
+
+func new_interpreter(scope *ScopedSymbolTable) *Interpreter {
+	return &Interpreter{scope, []*CallFrame{&CallFrame{scope.scope_name, scope, nil, nil, nil, make(map[*VarSymbol]float64), 0}}, nil, os.Stdout, context.Background(), Limits{}, 0, 0, make(map[*Node]bool), nil, make(map[Closure]int), nil, nil, nil}
 }
 
 type ScopedSymbolTable struct {
@@ -161,6 +342,8 @@ type ScopedSymbolTable struct {
 	scope_level int
 	enclosing_scope *ScopedSymbolTable
 	inferior_scope []*ScopedSymbolTable
+	/* the class whose members these are, nil for any other scope */
+	class *ClassSymbol
 }
 
 func (s ScopedSymbolTable) String() string {
@@ -183,17 +366,53 @@ func (s ScopedSymbolTable) lookup(name string, current_scope_only bool) (Symbol,
 	if ok == true {
 		return symbol, ok
 	}
+	/* the members of a class include those it inherits */
+	if s.class != nil && s.class.parent != nil {
+		if symbol, ok = s.class.parent.scope.lookup(name, true); ok == true {
+			return symbol, ok
+		}
+	}
 	if current_scope_only == false && s.enclosing_scope != nil {
 		symbol, ok = s.enclosing_scope.lookup(name, false)
 	}
 	return symbol, ok
 }
 
+type CompileError struct {
+	phase string
+	token *lexemes
+	message string
+}
+
+func (e *CompileError) Error() string {
+	if e.token == nil {
+		return fmt.Sprintf("%s Error: %s", e.phase, e.message)
+	}
+	return fmt.Sprintf("%s Error: %s %s", e.phase, e.message, e.token.position())
+}
+
+/* Aborts the current phase, recovered by catch_compile_error */
+func compile_error(phase string, token *lexemes, format string, args ...interface{}) {
+	panic(&CompileError{phase, token, fmt.Sprintf(format, args...)})
+}
+
+func catch_compile_error(err *error) {
+	if r := recover(); r != nil {
+		compile_err, ok := r.(*CompileError)
+		if ok == false {
+			panic(r)
+		}
+		*err = compile_err
+	}
+}
+
 type lexemes struct {
 	ttype int
 	tstring string
 	line int
 	column int
+	checks int
+	file string
 }
 
 type lexer struct {
@@ -207,7 +426,9 @@ type rules struct {
 }
 
 type Compound struct {
+	token *lexemes
 	elem []interface{}
+	end_token *lexemes
 }
 
 type Elem_list struct {
@@ -217,6 +438,8 @@ type Elem_list struct {
 type Spec struct {
 	val int
 	sstring string
+	/* the bounds and element type of an ARRAY, nil for any other type */
+	array *ArraySpec
 }
 
 type Number struct {
@@ -227,6 +450,10 @@ type Op struct {
 	token *lexemes
 }
 
+type Str struct {
+	token *lexemes
+}
+
 var global_scope *VarInit = nil
 type VarInit map[string]*Var
 
@@ -238,12 +465,16 @@ type VarDeclaration struct {
 type Param struct {
 	var_name *Var
 	var_type *Spec
+	constant bool
 }
 
 type ProcedureDecl struct {
+	token *lexemes
 	proc_name string
 	params []Param
 	block *Block
+	/* the type a FUNCTION returns, unnamed when left out of the block of a heading, nil for a procedure */
+	result *Spec
 }
 
 type Var struct {
@@ -251,6 +482,28 @@ type Var struct {
 	value float64
 }
 
+type ProcedureCall struct {
+	token *lexemes
+	proc_name string
+	args []*Node
+	proc_symbol *ProcedureSymbol
+	/* set instead when calling through a procedural variable */
+	variable *VarSymbol
+}
+
+type While struct {
+	token *lexemes
+	condition *Node
+	body interface{}
+}
+
+type If struct {
+	token *lexemes
+	condition *Node
+	then_branch interface{}
+	else_branch interface{}
+}
+
 type Block struct {
 	declaration_list Elem_list
 	compound interface{}
@@ -276,22 +529,42 @@ func (n *lexemes) String() string {
 	return fmt.Sprintf("type [%d] '%s'", n.ttype, n.tstring)
 }
 
-func store_new_token(tokens *[]lexemes, new_token **lexemes) {
+/* The 1-based column every message reports; column itself is the 0-based byte offset into the line */
+func (n *lexemes) column_number() int {
+	return n.column + 1
+}
+
+/* Where the token was read, naming the file when it came from an include */
+func (n *lexemes) position() string {
+	if n.file == "" {
+		return fmt.Sprintf("line [%d:%d]", n.line, n.column_number())
+	}
+	return fmt.Sprintf("line [%d:%d] of %s", n.line, n.column_number(), n.file)
+}
+
+/* Where scan hands what it reads: the tokens, and each directive, which tells whether the text after it is kept */
+type TokenSink interface {
+	keep(token lexemes)
+	directive(token *lexemes) bool
+}
+
+func store_new_token(tokens TokenSink, new_token **lexemes) {
 	if *new_token != nil {
 		(*new_token).tstring = strings.ToUpper((*new_token).tstring)
 		if kword := keyword[(*new_token).tstring]; kword != 0 {
 			(*new_token).ttype = kword
 		}
-		*tokens = append(*tokens, **new_token)
+		tokens.keep(**new_token)
 		*new_token = nil
 	}
 }
 
-func tokenize(file *os.File) []lexemes {
+/* Splits a source file into the tokens it hands to tokens, returning its EOF; text a directive leaves out is only searched for the next directive, never tokenised */
+func scan(file io.Reader, name string, tokens TokenSink) lexemes {
 	scanner := bufio.NewScanner(file)
-	var tokens []lexemes
 	var new_token *lexemes
 	line := 1
+	kept := true
 	for scanner.Scan() {
 		expr := scanner.Text()
 		length := len(expr)
@@ -300,48 +573,75 @@ func tokenize(file *os.File) []lexemes {
 			switch {
 			case expr[index] == '}':
 				comment_mode = false
+			case expr[index] == '{' && comment_mode == false && index < length - 1 && expr[index + 1] == '$':
+				store_new_token(tokens, &new_token)
+				end := strings.IndexByte(expr[index:], '}')
+				if end < 0 {
+					compile_error("Lexer", &lexemes{DIRECTIVE, expr[index:], line, index, 0, name}, "unterminated directive")
+				}
+				kept = tokens.directive(&lexemes{DIRECTIVE, expr[index:index + end + 1], line, index, 0, name})
+				index += end
 			case expr[index] == '{' || comment_mode == true:
-				store_new_token(&tokens, &new_token)
+				store_new_token(tokens, &new_token)
 				comment_mode = true
 				continue
+			case kept == false:
+				continue
 			case unicode.IsSpace(rune(expr[index])):
-				store_new_token(&tokens, &new_token)
+				store_new_token(tokens, &new_token)
 				continue
 			case expr[index] >= '0' && expr[index] <= '9':
 				if new_token == nil {
-					new_token = &lexemes{INTEGER_CONST, "", line, index}
+					new_token = &lexemes{INTEGER_CONST, "", line, index, 0, name}
 				}
 				new_token.tstring += string(expr[index])
 			case expr[index] >= 65 && expr[index] <= 90 || expr[index] >= 97 && expr[index] <= 122 || expr[index] == '_':
 				if new_token != nil && new_token.ttype != ID {
-					store_new_token(&tokens, &new_token)
+					store_new_token(tokens, &new_token)
 				}
 				if new_token == nil {
-					new_token = &lexemes{ID, "", line, index}
+					new_token = &lexemes{ID, "", line, index, 0, name}
 				}
 				new_token.tstring += string(expr[index])
 			case expr[index] == ':' && index < length - 1 && expr[index + 1] == '=':
-				store_new_token(&tokens, &new_token)
-				tokens = append(tokens, lexemes{ASSIGN, ":=", line, index})
+				store_new_token(tokens, &new_token)
+				tokens.keep(lexemes{ASSIGN, ":=", line, index, 0, name})
+				index++
+			case expr[index] == '\'':
+				store_new_token(tokens, &new_token)
+				start := index
+				value := ""
+				for index++; index < length && (expr[index] != '\'' || index + 1 < length && expr[index + 1] == '\''); index++ {
+					if expr[index] == '\'' {
+						index++
+					}
+					value += string(expr[index])
+				}
+				if index >= length {
+					compile_error("Lexer", &lexemes{STRING_CONST, value, line, start, 0, name}, "unterminated string")
+				}
+				tokens.keep(lexemes{STRING_CONST, value, line, start, 0, name})
+			case index < length - 1 && lex[expr[index:index + 2]] != 0:
+				store_new_token(tokens, &new_token)
+				tokens.keep(lexemes{lex[expr[index:index + 2]], expr[index:index + 2], line, index, 0, name})
 				index++
-			case expr[index] == '.' && new_token.ttype == INTEGER_CONST:
+			case expr[index] == '.' && new_token != nil && new_token.ttype == INTEGER_CONST:
 				new_token.tstring += string(expr[index])
 				new_token.ttype = REAL_CONST
 			default:
-				store_new_token(&tokens, &new_token)
+				store_new_token(tokens, &new_token)
 				new_val := lex[string(expr[index])]
-				tokens = append(tokens, lexemes{new_val, string(expr[index]), line, index})
 				if new_val == 0 {
-					fmt.Fprintf(os.Stderr, "Lexer Error: unexpected character '%c' line:[%d:%d]\n", expr[index], line, index)
-					os.Exit(-1)
+					compile_error("Lexer", &lexemes{new_val, string(expr[index]), line, index, 0, name}, "unexpected character '%c'", expr[index])
 				}
+				tokens.keep(lexemes{new_val, string(expr[index]), line, index, 0, name})
 			}
 		}
+		store_new_token(tokens, &new_token)
 		line++
 	}
-	store_new_token(&tokens, &new_token)
-	tokens = append(tokens, lexemes{EOF, "EOF", line, 0})
-	return tokens
+	store_new_token(tokens, &new_token)
+	return lexemes{EOF, "EOF", line, 0, 0, name}
 }
 
 /*
@@ -358,6 +658,14 @@ func prior1(current_token int) bool {
 	return false
 }
 
+func relational(current_token int) bool {
+	switch current_token {
+	case EQUAL, NOT_EQUAL, LESS, LESS_EQUAL, GREATER, GREATER_EQUAL:
+		return true
+	}
+	return false
+}
+
 func prior2(current_token int) bool {
 	if current_token == PLUS || current_token == MINUS {
 		return true
@@ -380,6 +688,13 @@ func (l *lexer) Cur() *lexemes {
 	}
 }
 
+func (l *lexer) Peek() *lexemes {
+	if l.index + 1 >= l.length {
+		return &l.tokens[l.length - 1]
+	}
+	return &l.tokens[l.index + 1]
+}
+
 func (l *lexer) Next() *lexemes {
 	l.index++
 	if l.index == l.length {
@@ -391,12 +706,11 @@ func (l *lexer) Next() *lexemes {
 
 func (r *rules) digest(needed int) {
 	if needed == r.lexer.Cur().ttype {
-		fmt.Printf("Digest := [%d] '%s'\n", r.lexer.Cur().ttype, r.lexer.Cur().tstring)
+		tracer.emit(TRACE_PARSE, "Digest", r.lexer.Cur(), nil, "[%s] '%s'", reverse_lex[needed], r.lexer.Cur().tstring)
 		r.lexer.Next()
 	} else {
 		token := r.lexer.Cur()
-		fmt.Fprintf(os.Stderr, "Unexpected token %s '%s' line [%d:%d] wait for '%s'\n", reverse_lex[token.ttype], token.tstring, token.line, token.column, reverse_lex[needed])
-		os.Exit(-1)
+		compile_error("Syntax", token, "unexpected token %s '%s' wait for '%s'", reverse_lex[token.ttype], token.tstring, reverse_lex[needed])
 	}
 }
 
@@ -411,8 +725,26 @@ func (r *rules) factor() *Node {
 		r.digest(REAL_CONST)
 		node = &Node{nil, &Number{token}, nil}
 	case ID:
+		if r.lexer.Peek().ttype == DOT {
+			node = &Node{nil, r.designator(), nil}
+			break
+		}
+		if r.lexer.Peek().ttype == LBRACKET {
+			node = &Node{nil, r.index(), nil}
+			break
+		}
+		if r.lexer.Peek().ttype == LPAR {
+			node = &Node{nil, r.builtin_call(), nil}
+			break
+		}
 		r.digest(ID)
 		node = &Node{nil, &Var{token, 0}, nil}
+	case NIL:
+		r.digest(NIL)
+		node = &Node{nil, &Nil{token}, nil}
+	case STRING_CONST:
+		r.digest(STRING_CONST)
+		node = &Node{nil, &Str{token}, nil}
 	case LPAR:
 		r.digest(LPAR)
 		node = r.expr()
@@ -424,8 +756,7 @@ func (r *rules) factor() *Node {
 		r.digest(MINUS)
 		node = &Node{nil, &Op{token}, r.factor()}
 	default:
-		fmt.Fprintf(os.Stderr, "Syntax Error\n")
-		os.Exit(-1)
+		compile_error("Syntax", token, "unexpected token %s '%s' in expression", reverse_lex[token.ttype], token.tstring)
 	}
 	return node
 }
@@ -441,8 +772,8 @@ func (r *rules) term() *Node {
 			r.digest(INTEGER_DIV)
 		case FLOAT_DIV:
 			r.digest(FLOAT_DIV)
-//		case MOD:
-//			r.digest(MOD)
+		case MOD:
+			r.digest(MOD)
 		}
 		node = &Node{node, &Op{token},  r.factor()}
 	}
@@ -464,6 +795,16 @@ func (r *rules) expr() *Node {
 	return node
 }
 
+func (r *rules) condition() *Node {
+	node := r.expr()
+	if relational(r.lexer.Cur().ttype) == true {
+		token := r.lexer.Cur()
+		r.digest(token.ttype)
+		node = &Node{node, &Op{token}, r.expr()}
+	}
+	return node
+}
+
 func (r *rules) variable() *Var {
 	token := r.lexer.Cur()
 	r.digest(ID)
@@ -480,7 +821,35 @@ func (r *rules) assignment_statement() interface{} {
 	variable := r.variable()
 	token := r.lexer.Cur()
 	r.digest(ASSIGN)
-	return &Assign{variable, token, r.expr()}
+	return &Assign{variable, token, r.condition()}
+}
+
+func (r *rules) proccall_statement() interface{} {
+	token := r.lexer.Cur()
+	r.digest(ID)
+	return &ProcedureCall{token, token.tstring, r.arguments(), nil, nil}
+}
+
+func (r *rules) while_statement() interface{} {
+	token := r.lexer.Cur()
+	r.digest(WHILE)
+	condition := r.condition()
+	r.digest(DO)
+	return &While{token, condition, r.statement()}
+}
+
+func (r *rules) if_statement() interface{} {
+	token := r.lexer.Cur()
+	r.digest(IF)
+	condition := r.condition()
+	r.digest(THEN)
+	then_branch := r.statement()
+	var else_branch interface{}
+	if r.lexer.Cur().ttype == ELSE {
+		r.digest(ELSE)
+		else_branch = r.statement()
+	}
+	return &If{token, condition, then_branch, else_branch}
 }
 
 func (r *rules) statement() interface{} {
@@ -488,8 +857,24 @@ func (r *rules) statement() interface{} {
 	var node interface{}
 	if ttype == BEGIN {
 		node = r.compound_statement()
-	} else if ttype == ID {
+	} else if ttype == WHILE {
+		node = r.while_statement()
+	} else if ttype == IF {
+		node = r.if_statement()
+	} else if ttype == TRY {
+		node = r.try_statement()
+	} else if ttype == RAISE {
+		node = r.raise_statement()
+	} else if ttype == INHERITED {
+		node = r.inherited_statement()
+	} else if ttype == ID && r.lexer.Peek().ttype == DOT {
+		node = r.member_statement()
+	} else if ttype == ID && r.lexer.Peek().ttype == LBRACKET {
+		node = r.element_statement()
+	} else if ttype == ID && r.lexer.Peek().ttype == ASSIGN {
 		node = r.assignment_statement()
+	} else if ttype == ID {
+		node = r.proccall_statement()
 	} else {
 		return nil
 	}
@@ -508,10 +893,12 @@ func (r *rules) statement_list() Elem_list {
 }
 
 func (r *rules) compound_statement() interface{} {
+	token := r.lexer.Cur()
 	r.digest(BEGIN)
 	node := r.statement_list()
+	end_token := r.lexer.Cur()
 	r.digest(END)
-	root := Compound{node.elem}
+	root := Compound{token, node.elem, end_token}
 	return &root
 }
 
@@ -520,13 +907,20 @@ func (r *rules) type_spec() *Spec {
 	switch token.ttype {
 	case INTEGER_CONST:
 		r.digest(INTEGER_CONST)
-		return &Spec{INTEGER_CONST, "INTEGER_CONST"}
+		return &Spec{INTEGER_CONST, "INTEGER_CONST", nil}
 	case REAL_CONST:
 		r.digest(REAL_CONST)
-		return &Spec{REAL_CONST, "REAL_CONST"}
+		return &Spec{REAL_CONST, "REAL_CONST", nil}
+	case ID:
+		r.digest(ID)
+		if token.tstring == "BOOLEAN" {
+			return &Spec{ID, "BOOLEAN_CONST", nil}
+		}
+		return &Spec{ID, token.tstring, nil}
+	case ARRAY:
+		return r.array_type()
 	default:
-		fmt.Fprintf(os.Stderr, "Semantic Error: %s unknown type\n", token.tstring)
-		os.Exit(-1)
+		compile_error("Semantic", token, "%s unknown type", token.tstring)
 		return nil
 	}
 }
@@ -547,6 +941,10 @@ func (r *rules) variable_declaration() Elem_list {
 }
 
 func (r *rules) formal_parameters() []Param {
+	constant := r.lexer.Cur().ttype == CONST
+	if constant == true {
+		r.digest(CONST)
+	}
 	token := r.lexer.Cur()
 	r.digest(ID)
 	new_var := Var{token, 0}
@@ -563,28 +961,39 @@ func (r *rules) formal_parameters() []Param {
 	type_spec := r.type_spec()
 	param_list := []Param{}
 	for _, val := range list {
-		param_list = append(param_list, Param{&val, type_spec})
+		param_list = append(param_list, Param{&val, type_spec, constant})
 	}
 	return param_list
 }
 
 func (r *rules) formal_parameters_list() []Param {
-	if r.lexer.Cur().ttype != ID {
+	if r.lexer.Cur().ttype != ID && r.lexer.Cur().ttype != CONST {
 		return []Param{}
 	}
 	param_list := r.formal_parameters()
 	for token := r.lexer.Cur(); token.ttype == SEMI; token = r.lexer.Cur() {
 		r.digest(SEMI)
 		param_list = append(param_list, r.formal_parameters()...)
-		fmt.Println(param_list)
 	}
 	return param_list
 }
 
-func (r *rules) procedure_declaration() *ProcedureDecl {
-	r.digest(PROCEDURE)
-	proc_name := r.lexer.Cur().tstring
+/* A procedure without its block, as declared FORWARD or in a unit INTERFACE */
+func (r *rules) procedure_heading() *ProcedureDecl {
+	keyword := r.lexer.Cur()
+	if kind := keyword.ttype; kind == CONSTRUCTOR || kind == DESTRUCTOR || kind == FUNCTION {
+		r.digest(kind)
+	} else {
+		r.digest(PROCEDURE)
+	}
+	name_token := r.lexer.Cur()
+	proc_name := name_token.tstring
 	r.digest(ID)
+	if r.lexer.Cur().ttype == DOT {
+		r.digest(DOT)
+		proc_name += "." + r.lexer.Cur().tstring
+		r.digest(ID)
+	}
 	token := r.lexer.Cur()
 	var params []Param
 	if token.ttype == LPAR {
@@ -592,13 +1001,54 @@ func (r *rules) procedure_declaration() *ProcedureDecl {
 		params = r.formal_parameters_list()
 		r.digest(RPAR)
 	}
+	var result *Spec
+	if keyword.ttype == FUNCTION && r.lexer.Cur().ttype == COLON {
+		r.digest(COLON)
+		result = r.type_spec()
+	} else if keyword.ttype == FUNCTION {
+		result = &Spec{0, "", nil}
+	}
 	r.digest(SEMI)
-	block := r.block()
-	procedure := &ProcedureDecl{proc_name, params, block}
+	return &ProcedureDecl{name_token, proc_name, params, nil, result}
+}
+
+func (r *rules) procedure_declaration() *ProcedureDecl {
+	keyword := r.lexer.Cur()
+	procedure := r.procedure_heading()
+	if keyword.ttype == FUNCTION && strings.Contains(procedure.proc_name, ".") == true {
+		compile_error("Syntax", keyword, "FUNCTION %s cannot implement a method, methods are procedures", procedure.proc_name)
+	}
+	if keyword.ttype != PROCEDURE && keyword.ttype != FUNCTION && strings.Contains(procedure.proc_name, ".") == false {
+		compile_error("Syntax", keyword, "%s %s must implement a method of a class", keyword.tstring, procedure.proc_name)
+	}
+	if r.lexer.Cur().ttype == FORWARD {
+		r.digest(FORWARD)
+		r.digest(SEMI)
+		return procedure
+	}
+	procedure.block = r.block()
 	r.digest(SEMI)
 	return procedure
 }
 
+func (r *rules) variable_section() []interface{} {
+	declare_list := []interface{}{}
+	r.digest(VAR)
+	for ; r.lexer.Cur().ttype == ID; {
+		list := r.variable_declaration()
+		length := len(list.elem)
+		index := 0
+		type_spec, _ := list.elem[length - 1].(*Spec)
+		for ; index < length - 1; index++ {
+			variable, _ := list.elem[index].(*VarDeclaration)
+			variable.spec = type_spec
+			declare_list = append(declare_list, variable)
+		}
+		r.digest(SEMI)
+	}
+	return declare_list
+}
+
 func (r *rules) declaration() Elem_list {
 	token := r.lexer.Cur()
 	declare_list := Elem_list{}
@@ -606,20 +1056,10 @@ func (r *rules) declaration() Elem_list {
 	for {
 		token = r.lexer.Cur()
 		if token.ttype == VAR {
-			r.digest(VAR)
-			for ; r.lexer.Cur().ttype == ID; {
-				list := r.variable_declaration()
-				length := len(list.elem)
-				index := 0
-				type_spec, _ := list.elem[length - 1].(*Spec)
-				for ; index < length - 1; index++ {
-					variable, _ := list.elem[index].(*VarDeclaration)
-					variable.spec = type_spec
-					declare_list.elem = append(declare_list.elem, variable)
-				}
-				r.digest(SEMI)
-			}
-		} else if token.ttype == PROCEDURE {
+			declare_list.elem = append(declare_list.elem, r.variable_section()...)
+		} else if token.ttype == TYPE {
+			declare_list.elem = append(declare_list.elem, r.type_section()...)
+		} else if token.ttype == PROCEDURE || token.ttype == FUNCTION || token.ttype == CONSTRUCTOR || token.ttype == DESTRUCTOR {
 			declare_list.elem = append(declare_list.elem, r.procedure_declaration())
 		} else {
 			break
@@ -637,8 +1077,15 @@ func (r *rules) program() *Block {
 	r.digest(PROGRAM)
 	r.variable()
 	r.digest(SEMI)
+	var uses *Uses
+	if r.lexer.Cur().ttype == USES {
+		uses = r.uses_clause()
+	}
 	declarations := r.block()
 	r.digest(DOT)
+	if uses != nil {
+		declarations.declaration_list.elem = append([]interface{}{uses}, declarations.declaration_list.elem...)
+	}
 	return declarations
 }
 
@@ -646,46 +1093,151 @@ func (r *rules) program() *Block {
 	Interpreter
 */
 
-func (i *Interpreter) run(node interface{}) float64 {
+func number_value(token *lexemes) float64 {
 	var result float64
+	switch token.ttype {
+	case INTEGER_CONST:
+		tmp, _ := strconv.ParseInt(token.tstring, 10, 64)
+		result = float64(tmp)
+	case REAL_CONST:
+		result, _ = strconv.ParseFloat(token.tstring, 64)
+	}
+	return result
+}
+
+/* Relational operators yield 1 for true and 0 for false */
+func compare(op int, left float64, right float64) float64 {
+	var result bool
+	switch op {
+	case EQUAL:
+		result = left == right
+	case NOT_EQUAL:
+		result = left != right
+	case LESS:
+		result = left < right
+	case LESS_EQUAL:
+		result = left <= right
+	case GREATER:
+		result = left > right
+	case GREATER_EQUAL:
+		result = left >= right
+	}
+	if result == true {
+		return 1
+	}
+	return 0
+}
+
+/* WRITELN prints its arguments side by side, numbers formatted by their static type */
+func (i *Interpreter) writeln(args []*Node) {
+	analyser := SemanticsAnalyser{i.scope, nil}
+	for _, arg := range args {
+		if str, ok := arg.token.(*Str); ok == true {
+			fmt.Fprint(i.out, str.token.tstring)
+			continue
+		}
+		fmt.Fprint(i.out, format_value(i.run(arg), analyser.type_of(arg)))
+	}
+	fmt.Fprintln(i.out)
+}
+
+/* Called before every simple statement, where the debugger may pause */
+func (i *Interpreter) statement(token *lexemes) {
+	i.stack[len(i.stack) - 1].token = token
+	i.step(token)
+	if i.debugger != nil {
+		i.debugger.before(i, token)
+	}
+}
+
+func (i *Interpreter) run(node interface{}) float64 {
+	if tracer.enabled(TRACE_EXEC) == true {
+		tracer.emit(TRACE_EXEC, node_kind(node), node_token(node), i.scope, "")
+	}
 	switch v := node.(type) {
 	case *ProcedureDecl:
-//		fmt.Println("Type ProcedurDecl")
-		fmt.Println(v.proc_name)
-		i.run(v.block)
+	case *ProcedureCall:
+		i.statement(v.token)
+		proc := v.proc_symbol
+		var link *CallFrame
+		if v.variable != nil {
+			proc, link = i.callee(v.token)
+		} else if proc == nil && v.proc_name == "SETLENGTH" {
+			i.set_length(v)
+			break
+		} else if proc == nil {
+			i.writeln(v.args)
+			break
+		} else if method_member(proc) != nil {
+			i.invoke_method(proc, i.self(i.stack[len(i.stack) - 1]), v.args, v.token)
+			break
+		} else {
+			link = i.activation(i.stack[len(i.stack) - 1], proc.scope.enclosing_scope)
+		}
+		i.invoke(proc, link, v.args, v.token, 0)
 	case *Block:
-//		fmt.Println("Type Block")
 		list := v.declaration_list.elem
 		for _, variable := range list {
 			i.run(variable)
 		}
 		i.run(v.compound)
+		if len(list) > 0 {
+			if uses, ok := list[0].(*Uses); ok == true {
+				i.finalize(uses)
+			}
+		}
+	case *Uses:
+		i.initialize(v)
 	case *Compound:
-//		fmt.Println("Type Compound")
 		for _, elem := range v.elem {
 			i.run(elem)
 		}
-	case *VarDeclaration:
-//		fmt.Println("Type VarDeclaration")
-	case *Var:
-		var_symbol, _ := i.scope.lookup(v.token.tstring, false)
-		result, ok := var_symbol.(*VarSymbol)
-		if ok == false {
-			return 0
+	case *While:
+		for i.statement(v.token); i.run(v.condition) != 0; i.statement(v.token) {
+			i.run(v.body)
+		}
+	case *ClassDeclaration:
+	case *Designator:
+		i.statement(v.token)
+		i.call_member(v)
+	case *MemberAssign:
+		i.statement(v.token)
+		i.assign_member(v)
+	case *IndexAssign:
+		i.statement(v.token)
+		i.store_element(v)
+	case *Inherited:
+		i.statement(v.token)
+		i.inherited(v)
+	case *Try:
+		i.attempt(v)
+	case *Raise:
+		i.statement(v.token)
+		i.raise(v)
+	case *If:
+		i.statement(v.token)
+		if i.run(v.condition) != 0 {
+			i.run(v.then_branch)
 		} else {
-			return result.value
+			i.run(v.else_branch)
 		}
+	case *VarDeclaration:
+	case *TypeDeclaration:
+	case *Var:
+		return i.load(v.token)
 	case *Assign:
-//		fmt.Println("Type Assign")
-		var_name := v.variable.token.tstring
-		var_symbol, _ := i.scope.lookup(var_name, false)
-		result, ok := var_symbol.(*VarSymbol)
-		if ok == false {
-			return 0
+		i.statement(v.token)
+		result, frame := i.variable(v.variable.token)
+		value := i.run(v.expr)
+		if result.stype.array != nil {
+			i.assign_array(frame, result, value, v.token)
+			break
 		}
-		result.value = i.run(v.expr)
+		if result.stype.name == "INTEGER_CONST" {
+			value = i.store_integer(v.token, value)
+		}
+		frame.values[result] = value
 	case *Node:
-//		fmt.Println("Type Node")
 		var result, left, right float64
 		var test *Node
 		test, ok := node.(*Node)
@@ -701,111 +1253,214 @@ func (i *Interpreter) run(node interface{}) float64 {
 		}
 		switch cur := test.token.(type) {
 		case *Op:
+			if i.integer_arithmetic(test, cur.token) == true {
+				result = i.integer_op(cur.token, left, right)
+				break
+			}
 			switch cur.token.ttype {
 			case MINUS:
 				result = left - right
 			case PLUS:
 				result = left + right
-			case INTEGER_DIV:
-				result = left / right
-			case FLOAT_DIV:
-				result = left / right
+			case INTEGER_DIV, MOD, FLOAT_DIV:
+				if right == 0 || (cur.token.ttype != FLOAT_DIV && int64(right) == 0) {
+					i.runtime_error(RUNTIME_DIVISION_BY_ZERO, cur.token, "")
+				}
+				switch cur.token.ttype {
+				case INTEGER_DIV:
+					result = float64(int64(left) / int64(right))
+				case MOD:
+					result = float64(int64(left) % int64(right))
+				default:
+					result = left / right
+				}
 			case MUL:
 				result = left * right
+			case EQUAL, NOT_EQUAL, LESS, LESS_EQUAL, GREATER, GREATER_EQUAL:
+				result = compare(cur.token.ttype, left, right)
 			case ID:
-				var_name := cur.token.tstring
-				var_symbol, _ := i.scope.lookup(var_name, false)
-				symbol := var_symbol.(*VarSymbol)
-				result = symbol.value
-				fmt.Println("============== := ",result)
+				result = i.load(cur.token)
 			}
+		case *Designator:
+			result = i.select_member(cur)
+		case *Index:
+			result = i.element(cur)
+		case *BuiltinCall:
+			result = i.builtin_call(cur)
 		default:
 			result = i.run(cur)
 		}
 		return result
 	case *Op:
-//		fmt.Println("Type Op")
+	case *Nil:
 	case *Number:
-//		fmt.Println("Type Number")
-		switch v.token.ttype {
-		case INTEGER_CONST:
-			tmp, _ := strconv.ParseInt(v.token.tstring, 10, 64)
-			result = float64(tmp)
-		case REAL_CONST:
-			result, _ = strconv.ParseFloat(v.token.tstring, 64)
-		}
-		return result
+		return number_value(v.token)
+	case nil:
 	default:
-		fmt.Printf("Type unknown %T\n", v)
-		val, ok := node.(*lexemes)
-		fmt.Println(val, ok)
+		fmt.Fprintf(os.Stderr, "Interpreter Error: unknown node %T\n", v)
+		os.Exit(-1)
 	}
 	return 0
 }
 
 func (s SemanticsAnalyser) check(i interface{}) {
+	if tracer.enabled(TRACE_SEMA) == true {
+		tracer.emit(TRACE_SEMA, node_kind(i), node_token(i), s.scope, "")
+	}
 	switch v := i.(type) {
 	case *ProcedureDecl:
-		fmt.Println("Type ProcedurDecl")
-		fmt.Printf("ENTER scope: %s\n", v.proc_name)
-		_, ok := s.scope.lookup(v.proc_name, true)
+		tracer.emit(TRACE_SEMA, "EnterScope", v.token, s.scope, "%s", v.proc_name)
+		if strings.Contains(v.proc_name, ".") == true {
+			s.implement_method(v)
+			tracer.emit(TRACE_SEMA, "LeaveScope", v.token, s.scope, "%s", v.proc_name)
+			break
+		}
+		symbol, ok := s.scope.lookup(v.proc_name, true)
+		if heading, is_proc := symbol.(*ProcedureSymbol); is_proc == true && heading.decl.block == nil && v.block != nil {
+			s.implement(heading, v)
+			tracer.emit(TRACE_SEMA, "LeaveScope", v.token, s.scope, "%s", v.proc_name)
+			break
+		}
 		if ok == true {
-			fmt.Fprintf(os.Stderr, "Semantic Error: procedure %s already declared \n", v.proc_name)
+			compile_error("Semantic", v.token, "%s already declared", v.proc_name)
 		}
-		proc_symbol := ProcedureSymbol{v.proc_name, []*VarSymbol{}}
+		new_scope := ScopedSymbolTable{make(map[string]Symbol), v.proc_name, s.scope.scope_level + 1, s.scope, nil, nil}
+		proc_symbol := ProcedureSymbol{v.proc_name, []*VarSymbol{}, v.token, &new_scope, v, nil}
 		s.scope.insert(&proc_symbol)
-		new_scope := ScopedSymbolTable{make(map[string]Symbol), v.proc_name, s.scope.scope_level + 1, s.scope, nil}
+		s.reference(v.token, &proc_symbol)
 		s.scope.inferior_scope = append(s.scope.inferior_scope, &new_scope)
 		s.scope = &new_scope
 		for _, param := range v.params {
-			type_symbol, _ := s.scope.lookup(param.var_type.sstring, false)
-			builtin_symbol := (type_symbol).(*BuiltinSymbol)
-			var_symbol := VarSymbol{param.var_name.token.tstring, builtin_symbol, 0}
+			builtin_symbol := s.param_type(param.var_type, param.var_name.token)
+			var_symbol := VarSymbol{param.var_name.token.tstring, builtin_symbol, 0, param.var_name.token, param.constant}
 			s.scope.insert(&var_symbol)
+			s.reference(param.var_name.token, &var_symbol)
 			proc_symbol.params = append(proc_symbol.params, &var_symbol)
 		}
-		s.check(v.block)
+		if v.result != nil {
+			proc_symbol.result = s.function_result(v)
+		}
+		if v.block != nil {
+			s.check(v.block)
+		}
 		s.scope = s.scope.enclosing_scope
-		fmt.Printf("LEAVE scope: %s\n", v.proc_name)
+		tracer.emit(TRACE_SEMA, "LeaveScope", v.token, s.scope, "%s", v.proc_name)
 	case *Block:
-		fmt.Println("Type Block")
 		list := v.declaration_list.elem
 		for _, variable := range list {
 			s.check(variable)
 		}
+		s.forwards(list)
 		s.check(v.compound)
+	case *Uses:
+		if len(v.units) != len(v.names) {
+			compile_error("Semantic", v.token, "units cannot be used here")
+		}
 	case *Compound:
-		fmt.Println("Type Compound")
 		for _, elem := range v.elem {
 			s.check(elem)
 		}
+	case *While:
+		s.check(v.condition)
+		s.check(v.body)
+	case *If:
+		s.check(v.condition)
+		s.check(v.then_branch)
+		s.check(v.else_branch)
+	case *ClassDeclaration:
+		s.declare_class(v)
+	case *Designator:
+		s.check_designator(v, true)
+	case *MemberAssign:
+		s.check_member_assign(v)
+	case *Index:
+		s.check_index(v)
+	case *IndexAssign:
+		s.check_index_assign(v)
+	case *BuiltinCall:
+		s.check_builtin_call(v)
+	case *Inherited:
+		s.check_inherited(v)
+	case *Try:
+		s.check_try(v)
+	case *Raise:
+		s.check_raise(v)
 	case *VarDeclaration:
-		fmt.Println("Type VarDeclaration")
-		type_name := v.spec.sstring
-		type_symbol, _ := s.scope.lookup(type_name, false)
-		builtin_symbol := (type_symbol).(*BuiltinSymbol)
+		builtin_symbol := s.type_symbol(v.spec, v.token)
 		var_name := v.token.tstring
 		if _, found := s.scope.lookup(var_name, true); found == true {
-			fmt.Fprintf(os.Stderr, "Semantic Error: %s already declaed \n", var_name, v.token.line, v.token.column)
-			os.Exit(-1)
+			compile_error("Semantic", v.token, "%s already declared", var_name)
 		}
-		new_var_symbol := &VarSymbol{var_name, builtin_symbol, 0}
+		new_var_symbol := &VarSymbol{var_name, builtin_symbol, 0, v.token, false}
 		s.scope.insert(new_var_symbol)
+		s.reference(v.token, new_var_symbol)
 	case *Var:
-		fmt.Println("Type Var")
 		var_name := v.token.tstring
-		_, ok := s.scope.lookup(var_name, false)
+		symbol, ok := s.scope.lookup(var_name, false)
 		if ok == false {
-			fmt.Fprintf(os.Stderr, "Semantic Error: %s undeclared line [%d:%d]\n", var_name, v.token.line, v.token.column);
-			os.Exit(-1)
+			compile_error("Semantic", v.token, "%s undeclared", var_name)
+		}
+		if _, is_class := symbol.(*ClassSymbol); is_class == true {
+			compile_error("Semantic", v.token, "class %s is not a value", var_name)
+		}
+		s.check_member_name(symbol, v.token)
+		s.reference(v.token, symbol)
+	case *ProcedureCall:
+		symbol := s.callable(v.proc_name)
+		if symbol == nil && v.proc_name == "SETLENGTH" {
+			s.check_set_length(v)
+			break
+		}
+		if builtin, ok := symbol.(*BuiltinSymbol); ok == true && builtin.name == "WRITELN" {
+			for _, arg := range v.args {
+				if _, is_str := arg.token.(*Str); is_str == false {
+					s.check(arg)
+					if _, is_procedure := s.procedure_value(arg); is_procedure == true {
+						compile_error("Semantic", node_token(arg), "WRITELN cannot write a procedure")
+					}
+					if is_reference(s.type_of(arg)) == true {
+						compile_error("Semantic", node_token(arg), "WRITELN cannot write an object")
+					}
+					if s.type_of(arg).array != nil {
+						compile_error("Semantic", node_token(arg), "WRITELN cannot write an array")
+					}
+				}
+			}
+			break
+		}
+		var params []*BuiltinSymbol
+		if variable, ok := symbol.(*VarSymbol); ok == true && variable.stype.procedure != nil {
+			params = variable.stype.procedure.params
+			v.variable = variable
+		} else if proc_symbol, ok := symbol.(*ProcedureSymbol); ok == true {
+			params = proc_signature(proc_symbol)
+			v.proc_symbol = proc_symbol
+		} else {
+			compile_error("Semantic", v.token, "%s is not a procedure", v.proc_name)
 		}
+		s.check_member_name(symbol, v.token)
+		s.check_arguments(v.proc_name, v.token, params, v.args)
+		s.reference(v.token, symbol)
 	case *Assign:
-		fmt.Println("Type Assign")
 		s.check(v.variable)
 		s.check(v.expr)
+		if variable, ok := s.scope.lookup(v.variable.token.tstring, false); ok == true {
+			if target, is_var := variable.(*VarSymbol); is_var == true {
+				s.writable(target, v.variable.token)
+				s.assignable(target.stype, v.expr, v.token)
+			}
+		}
+	case *TypeDeclaration:
+		s.declare_type(v)
 	case *Node:
-		fmt.Println("Type Node")
-		s.check(v.token)
+		if str, ok := v.token.(*Str); ok == true {
+			compile_error("Semantic", str.token, "string '%s' is only allowed as a WRITELN argument", str.token.tstring)
+		}
+		if designator, ok := v.token.(*Designator); ok == true {
+			s.check_designator(designator, false)
+		} else {
+			s.check(v.token)
+		}
 		if v.left != nil {
 			s.check(v.left)
 		}
@@ -813,53 +1468,317 @@ func (s SemanticsAnalyser) check(i interface{}) {
 			s.check(v.right)
 		}
 	case *Op:
-		fmt.Println("Type Op")
+	case *Nil:
 	case *Number:
-		fmt.Println("Type Number")
+	case nil:
 	default:
-		fmt.Printf("Type unknown %T\n", v)
-	//	val, ok := i.(*lexemes)
-	//	fmt.Println(val, ok)
+		compile_error("Semantic", nil, "unknown node %T", v)
+	}
+}
+
+/* Remembers which symbol an identifier resolved to, when someone asked for it */
+func (s SemanticsAnalyser) reference(token *lexemes, symbol Symbol) {
+	if s.references != nil {
+		s.references[token] = symbol
+	}
+}
+
+/* Static type of an expression: REAL as soon as a REAL operand or a '/' is involved, BOOLEAN for a comparison */
+func (s SemanticsAnalyser) type_of(i interface{}) *BuiltinSymbol {
+	integer_symbol, _ := s.scope.lookup("INTEGER_CONST", false)
+	real_symbol, _ := s.scope.lookup("REAL_CONST", false)
+	boolean_symbol, _ := s.scope.lookup("BOOLEAN_CONST", false)
+	switch v := i.(type) {
+	case *Number:
+		if v.token.ttype == REAL_CONST {
+			return real_symbol.(*BuiltinSymbol)
+		}
+		return integer_symbol.(*BuiltinSymbol)
+	case *Var:
+		symbol, ok := s.scope.lookup(v.token.tstring, false)
+		if function, is_proc := symbol.(*ProcedureSymbol); is_proc == true && function.result != nil {
+			compile_error("Semantic", v.token, "function %s is called with its arguments in parentheses, %s() when it takes none", v.token.tstring, v.token.tstring)
+		}
+		var_symbol, is_var := symbol.(*VarSymbol)
+		if ok == false || is_var == false {
+			compile_error("Semantic", v.token, "%s undeclared", v.token.tstring)
+		}
+		return var_symbol.stype
+	case *Designator:
+		return s.designator_type(v)
+	case *Index:
+		return s.array_variable(v.token).stype.array.element
+	case *BuiltinCall:
+		if result := s.call_result(v); result != nil {
+			return result
+		}
+		return integer_symbol.(*BuiltinSymbol)
+	case *Nil:
+		return nil_type
+	case *Node:
+		op, ok := v.token.(*Op)
+		if ok == false {
+			return s.type_of(v.token)
+		}
+		right := s.type_of(v.right)
+		if right.procedure != nil {
+			compile_error("Semantic", op.token, "a procedure cannot be an operand of '%s'", op.token.tstring)
+		}
+		if right.array != nil {
+			compile_error("Semantic", op.token, "an array cannot be an operand of '%s'", op.token.tstring)
+		}
+		if v.left == nil {
+			reference_operands(op, nil, right)
+			boolean_operands(op, nil, right)
+			return right
+		}
+		left := s.type_of(v.left)
+		if left.procedure != nil {
+			compile_error("Semantic", op.token, "a procedure cannot be an operand of '%s'", op.token.tstring)
+		}
+		if left.array != nil {
+			compile_error("Semantic", op.token, "an array cannot be an operand of '%s'", op.token.tstring)
+		}
+		if reference_operands(op, left, right) == true || boolean_operands(op, left, right) == true || relational(op.token.ttype) == true {
+			return boolean_symbol.(*BuiltinSymbol)
+		}
+		if op.token.ttype == INTEGER_DIV || op.token.ttype == MOD {
+			return integer_symbol.(*BuiltinSymbol)
+		}
+		if op.token.ttype == FLOAT_DIV || left.name == "REAL_CONST" || right.name == "REAL_CONST" {
+			return real_symbol.(*BuiltinSymbol)
+		}
+		return integer_symbol.(*BuiltinSymbol)
 	}
+	compile_error("Semantic", node_token(i), "%s has no type", node_kind(i))
+	return nil
 }
 
-func (r *rules) Parse() {
+func (r *rules) Parse() *Block {
 	tree := r.program()
-	if r.lexer.Cur().ttype == EOF {
-		fmt.Println("Parsing FINISHED")
-		symbol_table := ScopedSymbolTable{make(map[string]Symbol), "Global", 0, nil, nil}
-		symbol_table.insert(&BuiltinSymbol{"INTEGER_CONST"})
-		symbol_table.insert(&BuiltinSymbol{"REAL_CONST"})
-		semantics_analyser := SemanticsAnalyser{&symbol_table}
-		semantics_analyser.check(tree)
-		interpreter := Interpreter{&symbol_table}
-		fmt.Println("INTERPRET START")
-		interpreter.run(tree)
-		fmt.Println(">>>>>>>>>>>>>SYMBOL_TABLE<<<<<<<<<<<<<<<<<<<")
-		fmt.Print(symbol_table)
-		fmt.Println("=========================================")
-	} else {
+	if r.lexer.Cur().ttype != EOF {
 		token := r.lexer.Cur()
-		fmt.Fprintf(os.Stderr, "Unexpected token %d '%s' line [%d:%d]\n", token.ttype, token.tstring, token.line, token.column)
+		compile_error("Syntax", token, "unexpected token %s '%s' after end of program", reverse_lex[token.ttype], token.tstring)
+	}
+	tracer.emit(TRACE_PARSE, "Finished", r.lexer.Cur(), nil, "")
+	return tree
+}
+
+func new_global_scope() *ScopedSymbolTable {
+	symbol_table := &ScopedSymbolTable{make(map[string]Symbol), "Global", 0, nil, nil, nil}
+	symbol_table.insert(&BuiltinSymbol{"INTEGER_CONST", nil, nil, nil})
+	symbol_table.insert(&BuiltinSymbol{"REAL_CONST", nil, nil, nil})
+	symbol_table.insert(&BuiltinSymbol{"BOOLEAN_CONST", nil, nil, nil})
+	symbol_table.insert(&BuiltinSymbol{"WRITELN", nil, nil, nil})
+	return symbol_table
+}
+
+func run_program(tree *Block, use_vm bool, disasm bool, emit string, passes int, dump_format string, warnings bool, limits Limits) {
+	uses := program_uses(tree)
+	symbol_table := new_top_scope("Global", uses)
+	semantics_analyser := SemanticsAnalyser{symbol_table, nil}
+	semantics_analyser.check(tree)
+	if uses != nil && (use_vm == true || disasm == true || emit != "") {
+		fmt.Fprintln(os.Stderr, "units only run on the interpreter, drop -vm, -disasm and -emit")
+		os.Exit(-1)
+	}
+	if feature := interpreter_only(tree); feature != "" && (use_vm == true || disasm == true || emit != "") {
+		fmt.Fprintf(os.Stderr, "%s only run on the interpreter, drop -vm, -disasm and -emit\n", feature)
 		os.Exit(-1)
 	}
+	if warnings == true {
+		for _, warning := range semantics_analyser.flow(tree) {
+			fmt.Fprintln(os.Stderr, warning)
+		}
+	}
+	if passes != 0 {
+		optimise(tree, symbol_table, passes)
+	}
+	if dump_format != "" {
+		if err := dump_ast(os.Stdout, tree, dump_format); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(-1)
+		}
+		return
+	}
+	if emit != "" {
+		var err error
+		switch emit {
+		case "go":
+			err = emit_go(os.Stdout, tree, symbol_table)
+		case "c":
+			err = emit_c(os.Stdout, tree, symbol_table)
+		case "wat":
+			err = emit_wat(os.Stdout, tree, symbol_table)
+		case "llvm":
+			err = emit_llvm(os.Stdout, tree, symbol_table)
+		default:
+			err = fmt.Errorf("unknown -emit backend '%s', want go, c, wat or llvm", emit)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(-1)
+		}
+		return
+	}
+	if disasm == true {
+		disassemble(os.Stdout, compile_program(tree, symbol_table))
+		return
+	}
+	tracer.emit(TRACE_EXEC, "Start", nil, symbol_table, "")
+	if use_vm == true {
+		vm := new_vm(compile_program(tree, symbol_table))
+		vm.limits = limits
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err := vm.execute(ctx)
+		stop()
+		vm.export(symbol_table)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(-1)
+		}
+	} else {
+		interpreter := new_interpreter(symbol_table)
+		interpreter.limits = limits
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err := interpreter.execute(ctx, tree)
+		stop()
+		interpreter.export(symbol_table)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(-1)
+		}
+	}
+	tracer.emit(TRACE_EXEC, "SymbolTable", nil, symbol_table, "\n%v", *symbol_table)
 }
 
 func main() {
-	if len(os.Args) != 2 {
+	dump_format := flag.String("dump-ast", "", "dump the parsed tree as json, dot or sexpr instead of running it")
+	trace_spec := flag.String("trace", "", "comma separated phases to trace: lex, parse, sema, exec or all")
+	trace_out := flag.String("trace-out", "", "write trace events to this file instead of stderr")
+	trace_format := flag.String("trace-format", "text", "trace event format: text or json")
+	use_vm := flag.Bool("vm", false, "compile to bytecode and run it on the virtual machine")
+	disasm := flag.Bool("disasm", false, "print the compiled bytecode instead of running it")
+	emit := flag.String("emit", "", "translate the program to another language instead of running it: go, c, wat or llvm")
+	lint_config := flag.String("lint-config", "", "file setting the severity of lint rules")
+	warnings := flag.Bool("W", false, "report reads before assignment and values never used on stderr")
+	max_steps := flag.Int("max-steps", 0, "abort the program after this many statements, 0 for no limit")
+	max_depth := flag.Int("max-depth", 10000, "abort the program when calls nest deeper than this, 0 for no limit")
+	max_memory := flag.Int("max-memory", 0, "abort the program when the memory it allocates exceeds this many bytes, 0 for no limit")
+	timeout := flag.Duration("timeout", 0, "abort the program after running this long, e.g. 2s, 0 for no limit")
+	defines := []string{}
+	flag.Func("D", "define a symbol for {$IFDEF}, may be repeated", func(name string) error {
+		defines = append(defines, name)
+		return nil
+	})
+	unit_path := flag.String("unit-path", "", "list of directories searched for units, separated as in PATH")
+	cache_dir := flag.String("cache-dir", default_cache_dir(), "directory keeping analysed units between runs, empty to analyse every unit each time")
+	rebuild := flag.Bool("rebuild", false, "analyse every unit again, refreshing the unit cache")
+	cache_stats := flag.Bool("cache-stats", false, "report on stderr how many units came from the unit cache")
+	opt_spec := flag.String("O", "", "comma separated optimisation passes: fold, simplify, dce, unused or all; -dump-ast then shows the optimised tree")
+	flag.Parse()
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(*CompileError); ok == true {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(-1)
+			}
+			panic(r)
+		}
+	}()
+	if flag.NArg() == 1 && flag.Arg(0) == "repl" {
+		run_repl(defines, filepath.SplitList(*unit_path), Limits{*max_steps, *max_depth, *max_memory, *timeout}, os.Stdin, os.Stdout)
+		return
+	}
+	if flag.NArg() == 2 && flag.Arg(0) == "debug" {
+		if err := run_debugger(flag.Arg(1), defines, filepath.SplitList(*unit_path), os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.NArg() >= 2 && flag.Arg(0) == "bench" {
+		if err := run_bench(flag.Arg(1), flag.Args()[2:], defines, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.NArg() == 2 && flag.Arg(0) == "lint" {
+		errors, err := run_lint(flag.Arg(1), *lint_config, defines, filepath.SplitList(*unit_path), os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(-1)
+		}
+		if errors > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.NArg() == 1 && flag.Arg(0) == "dap" {
+		if err := run_dap(defines, filepath.SplitList(*unit_path), os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.NArg() == 1 && flag.Arg(0) == "lsp" {
+		if err := run_lsp(defines, filepath.SplitList(*unit_path), os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.NArg() != 1 {
 		fmt.Fprintln(os.Stderr, "Need 1 parameter")
 		os.Exit(-1)
 	}
-	flag.Parse()
+	phases, err := parse_trace_phases(*trace_spec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(-1)
+	}
+	if *trace_format != "text" && *trace_format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown -trace-format '%s', want text or json\n", *trace_format)
+		os.Exit(-1)
+	}
+	passes, err := parse_opt_passes(*opt_spec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(-1)
+	}
+	tracer.phases = phases
+	tracer.json = *trace_format == "json"
+	tracer.out = os.Stderr
+	if *trace_out != "" {
+		trace_file, err := os.Create(*trace_out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer trace_file.Close()
+		tracer.out = trace_file
+	}
 	file, err := os.Open(flag.Arg(0))
 	if err != nil {
 		    log.Fatal(err)
 	}
 	defer file.Close()
-	tokens := tokenize(file)
-	for i, j := range tokens {
-		fmt.Printf("Token[%d] := {%s} '%s'\n", i, reverse_lex[j.ttype], j.tstring)
+	tokens := preprocess(file, flag.Arg(0), defines)
+	for i := range tokens {
+		tracer.emit(TRACE_LEX, "Token", &tokens[i], nil, "{%s} '%s'", reverse_lex[tokens[i].ttype], tokens[i].tstring)
 	}
 	rules := rules{lexer{0, len(tokens), tokens}}
-	rules.Parse()
+	tree := rules.Parse()
+	var cache *UnitCache
+	if *cache_dir != "" {
+		cache = &UnitCache{*cache_dir, *rebuild, 0, 0}
+	}
+	load_units(tree, flag.Arg(0), filepath.SplitList(*unit_path), defines, cache)
+	if *cache_stats == true && cache != nil {
+		cache.report(os.Stderr)
+	}
+	if *dump_format != "" && passes == 0 {
+		if err := dump_ast(os.Stdout, tree, *dump_format); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(-1)
+		}
+		return
+	}
+	run_program(tree, *use_vm, *disasm, *emit, passes, *dump_format, *warnings, Limits{*max_steps, *max_depth, *max_memory, *timeout})
 }
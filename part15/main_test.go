@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* The interpreter binary the tests run, built once for all of them */
+var pascal string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "part15")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	sources := []string{}
+	files, _ := filepath.Glob("*.go")
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") == false {
+			sources = append(sources, file)
+		}
+	}
+	pascal = filepath.Join(dir, "pascal")
+	build := exec.Command("go", append([]string{"build", "-o", pascal}, sources...)...)
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type Run struct {
+	stdout string
+	stderr string
+	code   int
+}
+
+/* Runs the interpreter binary, units never coming from the cache of the user running the tests */
+func run_pascal(t *testing.T, stdin string, args ...string) Run {
+	t.Helper()
+	command := exec.Command(pascal, append([]string{"-cache-dir", ""}, args...)...)
+	var stdout, stderr bytes.Buffer
+	command.Stdin = strings.NewReader(stdin)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	err := command.Run()
+	code := 0
+	if exit, ok := err.(*exec.ExitError); ok == true {
+		code = exit.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return Run{stdout.String(), stderr.String(), code}
+}
+
+/* The sample programs of this directory */
+func samples(t *testing.T) []string {
+	t.Helper()
+	files, err := filepath.Glob("*.pas")
+	if err != nil || len(files) == 0 {
+		t.Fatal("no sample programs found")
+	}
+	return files
+}
+
+/* Writes source to a file of its own and returns its path */
+func write_program(t *testing.T, name string, source string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+/* stdout holds the dump alone, whatever is traced meanwhile, so it can be parsed as it is */
+func TestDumpAst(t *testing.T) {
+	for _, sample := range samples(t) {
+		for _, args := range [][]string{{}, {"-O", "all"}, {"-trace", "all"}} {
+			name := strings.TrimSpace(sample + " " + strings.Join(args, " "))
+			t.Run(name, func(t *testing.T) {
+				run := run_pascal(t, "", append(args, "-dump-ast", "json", sample)...)
+				var tree AstNode
+				if err := json.Unmarshal([]byte(run.stdout), &tree); err != nil || run.code != 0 {
+					t.Fatalf("json dump does not parse: %v, exit %d\n%s", err, run.code, run.stderr)
+				}
+				if tree.Kind != "Block" {
+					t.Errorf("dump starts with %s, want Block", tree.Kind)
+				}
+				run = run_pascal(t, "", append(args, "-dump-ast", "dot", sample)...)
+				if strings.HasPrefix(run.stdout, "digraph AST {\n") == false || strings.HasSuffix(run.stdout, "}\n") == false {
+					t.Errorf("dot dump is not a lone graph:\n%s", run.stdout)
+				}
+				run = run_pascal(t, "", append(args, "-dump-ast", "sexpr", sample)...)
+				if strings.HasPrefix(run.stdout, "(Block") == false || strings.HasSuffix(run.stdout, ")\n") == false {
+					t.Errorf("sexpr dump is not a lone tree:\n%s", run.stdout)
+				}
+			})
+		}
+	}
+}
+
+func TestDumpAstEscapesDot(t *testing.T) {
+	path := write_program(t, "quotes.pas", "PROGRAM Quotes;\nBEGIN\n   WRITELN('say \"hi\" \\ bye')\nEND.\n")
+	run := run_pascal(t, "", "-dump-ast", "dot", path)
+	if strings.Contains(run.stdout, `say \"hi\" \\ bye`) == false {
+		t.Errorf("string not escaped for dot:\n%s", run.stdout)
+	}
+}
+
+/* Text {$IFDEF} leaves out is never lexed, so it may hold characters no token starts with */
+func TestExcludedTextIsNotLexed(t *testing.T) {
+	path := write_program(t, "excluded.pas", `PROGRAM Excluded;
+BEGIN
+{$IFDEF NEVER}
+   # include <stdio.h> ? don't {$IFDEF NESTED} @ {$ENDIF}
+   {$I missing.inc}
+{$ELSE}
+   WRITELN('kept')
+{$ENDIF}
+END.
+`)
+	run := run_pascal(t, "", path)
+	if run.stdout != "kept\n" || run.code != 0 {
+		t.Errorf("exit %d with %q, stderr %q", run.code, run.stdout, run.stderr)
+	}
+	run = run_pascal(t, "", "-D", "NEVER", path)
+	if strings.Contains(run.stderr, "unexpected character '#' line [4:4]") == false {
+		t.Errorf("included text not lexed: exit %d, stderr %q", run.code, run.stderr)
+	}
+}
+
+func TestDuplicateProcedure(t *testing.T) {
+	path := write_program(t, "twice.pas", `PROGRAM Twice;
+PROCEDURE Greet;
+BEGIN
+   WRITELN('hello')
+END;
+PROCEDURE Greet;
+BEGIN
+   WRITELN('again')
+END;
+BEGIN
+   Greet
+END.
+`)
+	run := run_pascal(t, "", path)
+	if run.code == 0 || run.stdout != "" || run.stderr != "Semantic Error: GREET already declared line [6:11]\n" {
+		t.Errorf("exit %d with %q, stderr %q", run.code, run.stdout, run.stderr)
+	}
+}
+
+/* -W and lint report the same 1-based column for the same finding */
+func TestWarningColumns(t *testing.T) {
+	path := write_program(t, "columns.pas", `PROGRAM Columns;
+PROCEDURE P;
+VAR n : INTEGER;
+BEGIN
+   WRITELN(n)
+END;
+BEGIN
+   P
+END.
+`)
+	run := run_pascal(t, "", "-W", path)
+	if strings.Contains(run.stderr, "N is read before being assigned line [5:12]") == false {
+		t.Errorf("-W: exit %d, stderr %q", run.code, run.stderr)
+	}
+	run = run_pascal(t, "", "lint", path)
+	if strings.Contains(run.stdout, "columns.pas:5:12: error: N is read before being assigned") == false {
+		t.Errorf("lint: exit %d, stdout %q", run.code, run.stdout)
+	}
+}
+
+/* Globals start at zero, so neither -W nor lint reports reading one before it is assigned */
+func TestGlobalReadsAreNotReported(t *testing.T) {
+	path := write_program(t, "globals.pas", `PROGRAM Globals;
+VAR n : INTEGER;
+BEGIN
+   WRITELN(n);
+   n := 1;
+   WRITELN(n)
+END.
+`)
+	run := run_pascal(t, "", "-W", path)
+	if strings.Contains(run.stderr, "before being assigned") == true || run.stdout != "0\n1\n" {
+		t.Errorf("-W: exit %d with %q, stderr %q", run.code, run.stdout, run.stderr)
+	}
+	run = run_pascal(t, "", "lint", path)
+	if strings.Contains(run.stdout, "before being assigned") == true || run.code != 0 {
+		t.Errorf("lint: exit %d, stdout %q", run.code, run.stdout)
+	}
+}
+
+/* A program that needs -D FAST and, through -unit-path, the unit in its lib directory */
+func defined_program(t *testing.T) (string, string) {
+	t.Helper()
+	path := write_program(t, "defined.pas", `PROGRAM Defined;
+USES Maths;
+VAR x : INTEGER;
+BEGIN
+{$IFDEF FAST}
+   x := 7;
+{$ELSE}
+   y := 7;
+{$ENDIF}
+   Show(x)
+END.
+`)
+	lib := filepath.Join(t.TempDir(), "lib")
+	if err := os.Mkdir(lib, 0755); err != nil {
+		t.Fatal(err)
+	}
+	unit := "UNIT Maths;\nINTERFACE\nPROCEDURE Show(CONST n : INTEGER);\nIMPLEMENTATION\nPROCEDURE Show(CONST n : INTEGER);\nBEGIN\n   WRITELN('shown ', n)\nEND;\nEND.\n"
+	if err := os.WriteFile(filepath.Join(lib, "maths.pas"), []byte(unit), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, lib
+}
+
+/* Every command reads a program with the -D symbols and the -unit-path directories of a plain run */
+func TestDefinesReachEveryCommand(t *testing.T) {
+	path, lib := defined_program(t)
+	for _, command := range []struct {
+		args   []string
+		stdin  string
+		stdout string
+	}{
+		{[]string{path}, "", "shown 7\n"},
+		{[]string{"lint", path}, "", ""},
+		{[]string{"debug", path}, "c\n", "shown 7\n"},
+		{[]string{"repl"}, ":load " + path + "\n", "shown 7\n"},
+	} {
+		t.Run(command.args[0], func(t *testing.T) {
+			run := run_pascal(t, command.stdin, append([]string{"-D", "FAST", "-unit-path", lib}, command.args...)...)
+			if run.code != 0 || strings.Contains(run.stdout, command.stdout) == false || strings.Contains(run.stdout+run.stderr, "undeclared") == true {
+				t.Errorf("exit %d with %q, stderr %q, want %q", run.code, run.stdout, run.stderr, command.stdout)
+			}
+		})
+	}
+}
+
+/* The unit cache keeps one entry per unit source, dropping those of older sources and versions */
+func TestUnitCacheEntries(t *testing.T) {
+	dir, cache := t.TempDir(), t.TempDir()
+	unit := filepath.Join(dir, "maths.pas")
+	write := func(greeting string) {
+		source := "UNIT Maths;\nINTERFACE\nPROCEDURE Show(CONST n : INTEGER);\nIMPLEMENTATION\nPROCEDURE Show(CONST n : INTEGER);\nBEGIN\n   WRITELN('" + greeting + " ', n)\nEND;\nEND.\n"
+		if err := os.WriteFile(unit, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries := func() []string {
+		found, _ := filepath.Glob(filepath.Join(cache, "*.json"))
+		return found
+	}
+	program := filepath.Join(dir, "uses.pas")
+	if err := os.WriteFile(program, []byte("PROGRAM Cached;\nUSES Maths;\nBEGIN\n   Show(7)\nEND.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	write("first")
+	for _, want := range []string{"first 7\n", "first 7\n"} {
+		if run := run_pascal(t, "", "-cache-dir", cache, program); run.stdout != want || run.code != 0 {
+			t.Fatalf("exit %d with %q, want %q: %s", run.code, run.stdout, want, run.stderr)
+		}
+	}
+	first := entries()
+	if len(first) != 1 {
+		t.Fatalf("cache holds %v, want one entry", first)
+	}
+	old := filepath.Join(cache, strings.Repeat("0", 64)+".json")
+	if err := os.WriteFile(old, []byte(`{"version": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	write("second")
+	if run := run_pascal(t, "", "-cache-dir", cache, program); run.stdout != "second 7\n" || run.code != 0 {
+		t.Fatalf("exit %d with %q: %s", run.code, run.stdout, run.stderr)
+	}
+	if second := entries(); len(second) != 1 || second[0] == first[0] {
+		t.Errorf("cache holds %v after the unit changed, want one entry other than %s", second, first[0])
+	}
+	if err := os.WriteFile(entries()[0], []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run := run_pascal(t, "", "-cache-dir", cache, "-cache-stats", program)
+	if run.stdout != "second 7\n" || strings.Contains(run.stderr, "0 of 1 units") == false || len(entries()) != 1 {
+		t.Errorf("broken entry not replaced: exit %d with %q, stderr %q, entries %v", run.code, run.stdout, run.stderr, entries())
+	}
+}
@@ -0,0 +1,41 @@
+PROGRAM Nested;
+VAR
+   a, depth : INTEGER;
+
+PROCEDURE P1(n : INTEGER);
+VAR
+   a : INTEGER;
+
+   PROCEDURE P2(m : INTEGER);
+   VAR
+      b : INTEGER;
+
+      PROCEDURE P3;
+      VAR
+         a : INTEGER;
+      BEGIN {P3}
+         a := 1000;
+         b := b + a + m;
+         depth := depth + 1
+      END;  {P3}
+
+   BEGIN {P2}
+      b := a * 10;
+      P3;
+      IF m > 0 THEN P2(m - 1);
+      WRITELN('P2 m = ', m, ' sees a = ', a, ' b = ', b)
+   END;  {P2}
+
+BEGIN {P1}
+   a := n;
+   IF n > 1 THEN P1(n - 1);
+   P2(1);
+   WRITELN('P1 n = ', n, ' keeps a = ', a)
+END;  {P1}
+
+BEGIN {Nested}
+   a := 7;
+   depth := 0;
+   P1(3);
+   WRITELN('global a = ', a, ' depth = ', depth)
+END.  {Nested}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+	OPTIMISER
+
+	Rewrites the analysed tree before it is run or translated. Expressions
+	change nothing but may fail: selecting from an object, which may be NIL,
+	indexing an array, calling a function, dividing by a variable and
+	arithmetic under {$Q+} can all end in a runtime error. Only expressions
+	which cannot are dropped, and assignments under {$R+} are always kept.
+*/
+
+const (
+	OPT_FOLD = 1 << iota
+	OPT_SIMPLIFY
+	OPT_DCE
+	OPT_UNUSED
+)
+
+var opt_passes = map[string]int{
+	"fold":     OPT_FOLD,
+	"simplify": OPT_SIMPLIFY,
+	"dce":      OPT_DCE,
+	"unused":   OPT_UNUSED,
+}
+
+func parse_opt_passes(spec string) (int, error) {
+	passes := 0
+	if spec == "" {
+		return passes, nil
+	}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "all" {
+			passes |= OPT_FOLD | OPT_SIMPLIFY | OPT_DCE | OPT_UNUSED
+			continue
+		}
+		pass, ok := opt_passes[name]
+		if ok == false {
+			return 0, fmt.Errorf("unknown optimisation pass '%s', want fold, simplify, dce, unused or all", name)
+		}
+		passes |= pass
+	}
+	return passes, nil
+}
+
+type Optimiser struct {
+	global *ScopedSymbolTable
+	scope  *ScopedSymbolTable
+	passes int
+	reads  map[Symbol]bool
+	unused map[Symbol]bool
+}
+
+func optimise(tree *Block, scope *ScopedSymbolTable, passes int) {
+	o := &Optimiser{scope, scope, passes, nil, make(map[Symbol]bool)}
+	o.block(tree)
+	if passes&OPT_UNUSED == 0 {
+		return
+	}
+	/* dropping an assignment may leave the variables it read unused too */
+	for {
+		o.reads = make(map[Symbol]bool)
+		o.mark_reads(tree)
+		if o.find_unused(tree) == false {
+			return
+		}
+		o.block(tree)
+	}
+}
+
+func (o *Optimiser) block(block *Block) {
+	for _, decl := range block.declaration_list.elem {
+		if v, ok := decl.(*ProcedureDecl); ok == true && v.block != nil {
+			symbol, _ := o.scope.lookup(v.proc_name, true)
+			enclosing := o.scope
+			o.scope = symbol.(*ProcedureSymbol).scope
+			o.block(v.block)
+			o.scope = enclosing
+		}
+	}
+	declarations := []interface{}{}
+	for _, decl := range block.declaration_list.elem {
+		if v, ok := decl.(*VarDeclaration); ok == true {
+			symbol, _ := o.scope.lookup(v.token.tstring, true)
+			if o.unused[symbol] == true {
+				continue
+			}
+		}
+		declarations = append(declarations, decl)
+	}
+	block.declaration_list.elem = declarations
+	block.compound = o.statement(block.compound)
+}
+
+/* Returns the statement to keep in place of node, nil when nothing is left */
+func (o *Optimiser) statement(node interface{}) interface{} {
+	switch v := node.(type) {
+	case *Compound:
+		elems := []interface{}{}
+		for _, elem := range v.elem {
+			if elem = o.statement(elem); elem != nil || o.passes&OPT_DCE == 0 {
+				elems = append(elems, elem)
+			}
+		}
+		v.elem = elems
+	case *Assign:
+		symbol, _ := o.scope.lookup(v.variable.token.tstring, false)
+		if o.unused[symbol] == true {
+			return nil
+		}
+		v.expr = o.expression(v.expr)
+	case *ProcedureCall:
+		for index, arg := range v.args {
+			v.args[index] = o.expression(arg)
+		}
+	case *Designator:
+		o.designator(v)
+	case *MemberAssign:
+		o.designator(v.target)
+		v.expr = o.expression(v.expr)
+	case *IndexAssign:
+		v.target.index = o.expression(v.target.index)
+		v.expr = o.expression(v.expr)
+	case *Inherited:
+		for index, arg := range v.args {
+			v.args[index] = o.expression(arg)
+		}
+	case *While:
+		v.condition = o.expression(v.condition)
+		v.body = o.statement(v.body)
+		if number, ok := v.condition.token.(*Number); ok == true && o.passes&OPT_DCE != 0 && number_value(number.token) == 0 {
+			return nil
+		}
+	case *If:
+		v.condition = o.expression(v.condition)
+		v.then_branch = o.statement(v.then_branch)
+		v.else_branch = o.statement(v.else_branch)
+		if number, ok := v.condition.token.(*Number); ok == true && o.passes&OPT_DCE != 0 {
+			if number_value(number.token) != 0 {
+				return v.then_branch
+			}
+			return v.else_branch
+		}
+	case *Try:
+		o.statement(v.body)
+		enclosing := o.scope
+		for _, handler := range v.all_handlers() {
+			o.scope = handler.scope
+			handler.body = o.statement(handler.body)
+		}
+		o.scope = enclosing
+		if v.finally != nil {
+			o.statement(v.finally)
+		}
+	}
+	return node
+}
+
+func (o *Optimiser) designator(v *Designator) {
+	v.object = o.expression(v.object)
+	for index, arg := range v.args {
+		v.args[index] = o.expression(arg)
+	}
+}
+
+func (o *Optimiser) expression(node *Node) *Node {
+	switch v := node.token.(type) {
+	case *Designator:
+		o.designator(v)
+	case *Index:
+		v.index = o.expression(v.index)
+	case *BuiltinCall:
+		for index, arg := range v.args {
+			v.args[index] = o.expression(arg)
+		}
+	}
+	op, ok := node.token.(*Op)
+	if ok == false {
+		return node
+	}
+	if node.left != nil {
+		node.left = o.expression(node.left)
+	}
+	node.right = o.expression(node.right)
+	if o.passes&OPT_FOLD != 0 {
+		if folded := o.fold(node, op); folded != nil {
+			return folded
+		}
+	}
+	if o.passes&OPT_SIMPLIFY != 0 {
+		return o.simplify(node, op)
+	}
+	return node
+}
+
+/* Computes an operation on constants exactly as the interpreter would, nil if it cannot */
+func (o *Optimiser) fold(node *Node, op *Op) *Node {
+	right, ok := node.right.token.(*Number)
+	if ok == false {
+		return nil
+	}
+	analyser := SemanticsAnalyser{o.scope, nil}
+	stype := analyser.type_of(node)
+	var value float64
+	if node.left == nil {
+		value = number_value(right.token)
+		if op.token.ttype == MINUS {
+			value = -value
+		}
+	} else {
+		left, ok := node.left.token.(*Number)
+		if ok == false {
+			return nil
+		}
+		a, b := number_value(left.token), number_value(right.token)
+		switch op.token.ttype {
+		case PLUS:
+			value = a + b
+		case MINUS:
+			value = a - b
+		case MUL:
+			value = a * b
+		case INTEGER_DIV, MOD:
+			/* leave the division by zero to fail at run time */
+			if int64(b) == 0 {
+				return nil
+			}
+			if op.token.ttype == INTEGER_DIV {
+				value = float64(int64(a) / int64(b))
+			} else {
+				value = float64(int64(a) % int64(b))
+			}
+		case FLOAT_DIV:
+			value = a / b
+		default:
+			value = compare(op.token.ttype, a, b)
+		}
+	}
+	if math.IsInf(value, 0) == true || math.IsNaN(value) == true {
+		return nil
+	}
+	/* overflow fails or wraps at run time depending on {$Q} */
+	if stype.name == "INTEGER_CONST" && fits_integer(op.token, value) == false {
+		return nil
+	}
+	return constant_node(value, stype, op.token)
+}
+
+func constant_node(value float64, stype *BuiltinSymbol, at *lexemes) *Node {
+	token := &lexemes{INTEGER_CONST, strconv.FormatInt(int64(value), 10), at.line, at.column, at.checks, at.file}
+	if stype.name == "REAL_CONST" {
+		text := strconv.FormatFloat(value, 'f', -1, 64)
+		if strings.Contains(text, ".") == false {
+			text += ".0"
+		}
+		token = &lexemes{REAL_CONST, text, at.line, at.column, at.checks, at.file}
+	}
+	return &Node{nil, &Number{token}, nil}
+}
+
+func is_constant(node *Node, value float64) bool {
+	number, ok := node.token.(*Number)
+	return ok == true && number_value(number.token) == value
+}
+
+/* True when node may fail or create an object, which it must be kept to do: it selects from an object, indexes an array, calls a function, divides by what may be zero or does arithmetic under {$Q+} */
+func selects(node *Node) bool {
+	if node == nil {
+		return false
+	}
+	switch v := node.token.(type) {
+	case *Designator, *Index:
+		return true
+	case *BuiltinCall:
+		return v.proc_symbol != nil || v.variable != nil
+	case *Op:
+		switch v.token.ttype {
+		case INTEGER_DIV, MOD, FLOAT_DIV:
+			if number, ok := node.right.token.(*Number); ok == false || number_value(number.token) == 0 {
+				return true
+			}
+		case PLUS, MINUS, MUL:
+			if v.token.checks&CHECK_OVERFLOW != 0 {
+				return true
+			}
+		}
+	}
+	return selects(node.left) || selects(node.right)
+}
+
+func is_negation(node *Node) bool {
+	op, ok := node.token.(*Op)
+	return ok == true && node.left == nil && op.token.ttype == MINUS
+}
+
+/* Algebraic identities, only applied when they keep the type of the expression */
+func (o *Optimiser) simplify(node *Node, op *Op) *Node {
+	analyser := SemanticsAnalyser{o.scope, nil}
+	stype := analyser.type_of(node)
+	same := func(operand *Node) bool {
+		return analyser.type_of(operand).name == stype.name
+	}
+	/* a sign wraps an INTEGER, or fails under {$Q+}, so it only goes from a constant that fits either way */
+	unsigned := func(operand *Node) bool {
+		number, ok := operand.token.(*Number)
+		if stype.name != "INTEGER_CONST" {
+			return true
+		}
+		return ok == true && fits_integer(op.token, number_value(number.token)) == true && fits_integer(op.token, -number_value(number.token)) == true
+	}
+	if node.left == nil {
+		switch {
+		case op.token.ttype == PLUS && unsigned(node.right) == true:
+			return node.right
+		case is_negation(node.right) == true && unsigned(node.right.right) == true:
+			return node.right.right
+		}
+		return node
+	}
+	switch op.token.ttype {
+	case PLUS:
+		switch {
+		case is_constant(node.right, 0) && same(node.left):
+			return node.left
+		case is_constant(node.left, 0) && same(node.right):
+			return node.right
+		case is_negation(node.right) == true && unsigned(node.right.right) == true:
+			minus := &Op{&lexemes{MINUS, "-", op.token.line, op.token.column, op.token.checks, op.token.file}}
+			return o.simplify(&Node{node.left, minus, node.right.right}, minus)
+		}
+	case MINUS:
+		switch {
+		case is_constant(node.right, 0) && same(node.left):
+			return node.left
+		case is_negation(node.right) == true && unsigned(node.right.right) == true:
+			plus := &Op{&lexemes{PLUS, "+", op.token.line, op.token.column, op.token.checks, op.token.file}}
+			return o.simplify(&Node{node.left, plus, node.right.right}, plus)
+		}
+	case MUL:
+		switch {
+		case is_constant(node.right, 1) && same(node.left):
+			return node.left
+		case is_constant(node.left, 1) && same(node.right):
+			return node.right
+		case stype.name == "INTEGER_CONST" && (is_constant(node.left, 0) || is_constant(node.right, 0)) && selects(node) == false:
+			return constant_node(0, stype, op.token)
+		}
+	case INTEGER_DIV:
+		if is_constant(node.right, 1) && same(node.left) {
+			return node.left
+		}
+	}
+	return node
+}
+
+func (o *Optimiser) mark_reads(node interface{}) {
+	switch v := node.(type) {
+	case *Block:
+		for _, decl := range v.declaration_list.elem {
+			o.mark_reads(decl)
+		}
+		o.mark_reads(v.compound)
+	case *ProcedureDecl:
+		if v.block == nil {
+			break
+		}
+		symbol, _ := o.scope.lookup(v.proc_name, true)
+		enclosing := o.scope
+		o.scope = symbol.(*ProcedureSymbol).scope
+		o.mark_reads(v.block)
+		o.scope = enclosing
+	case *Compound:
+		for _, elem := range v.elem {
+			o.mark_reads(elem)
+		}
+	case *Assign:
+		/* an assignment that may raise or create an object stays, as if its variable were read */
+		if selects(v.expr) == true || v.token.checks&CHECK_RANGE != 0 {
+			symbol, _ := o.scope.lookup(v.variable.token.tstring, false)
+			o.reads[symbol] = true
+		}
+		o.mark_reads(v.expr)
+	case *ProcedureCall:
+		for _, arg := range v.args {
+			o.mark_reads(arg)
+		}
+	case *Designator:
+		o.mark_reads(v.object)
+		for _, arg := range v.args {
+			o.mark_reads(arg)
+		}
+	case *MemberAssign:
+		o.mark_reads(v.target)
+		o.mark_reads(v.expr)
+	case *Index:
+		symbol, _ := o.scope.lookup(v.token.tstring, false)
+		o.reads[symbol] = true
+		o.mark_reads(v.index)
+	case *IndexAssign:
+		/* an element is stored in the array, kept as if it were read */
+		o.mark_reads(v.target)
+		o.mark_reads(v.expr)
+	case *BuiltinCall:
+		for _, arg := range v.args {
+			o.mark_reads(arg)
+		}
+	case *Inherited:
+		for _, arg := range v.args {
+			o.mark_reads(arg)
+		}
+	case *While:
+		o.mark_reads(v.condition)
+		o.mark_reads(v.body)
+	case *If:
+		o.mark_reads(v.condition)
+		o.mark_reads(v.then_branch)
+		o.mark_reads(v.else_branch)
+	case *Try:
+		o.mark_reads(v.body)
+		enclosing := o.scope
+		for _, handler := range v.all_handlers() {
+			o.scope = handler.scope
+			o.mark_reads(handler.body)
+		}
+		o.scope = enclosing
+		if v.finally != nil {
+			o.mark_reads(v.finally)
+		}
+	case *Node:
+		if variable, ok := v.token.(*Var); ok == true {
+			symbol, _ := o.scope.lookup(variable.token.tstring, false)
+			o.reads[symbol] = true
+		}
+		switch v.token.(type) {
+		case *Designator, *Index, *BuiltinCall:
+			o.mark_reads(v.token)
+		}
+		if v.left != nil {
+			o.mark_reads(v.left)
+		}
+		if v.right != nil {
+			o.mark_reads(v.right)
+		}
+	}
+}
+
+/* Marks the locals of procedures that are never read, globals stay visible to the debugger */
+func (o *Optimiser) find_unused(block *Block) bool {
+	found := false
+	for _, decl := range block.declaration_list.elem {
+		switch v := decl.(type) {
+		case *ProcedureDecl:
+			if v.block == nil {
+				break
+			}
+			symbol, _ := o.scope.lookup(v.proc_name, true)
+			enclosing := o.scope
+			o.scope = symbol.(*ProcedureSymbol).scope
+			if o.find_unused(v.block) == true {
+				found = true
+			}
+			o.scope = enclosing
+		case *VarDeclaration:
+			symbol, _ := o.scope.lookup(v.token.tstring, true)
+			if o.scope != o.global && o.reads[symbol] == false && o.unused[symbol] == false {
+				o.unused[symbol] = true
+				found = true
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* Programs whose runtime errors an optimiser dropping expressions would lose */
+var optimiser_programs = []struct {
+	name   string
+	source string
+}{
+	{"zero.pas", `PROGRAM Zero;
+VAR x, y : INTEGER;
+BEGIN
+   y := 0;
+   x := 0 * (7 DIV y);
+   WRITELN('after ', x)
+END.
+`},
+	{"unread.pas", `PROGRAM Unread;
+PROCEDURE P(n : INTEGER);
+VAR k : INTEGER;
+BEGIN
+   k := 10 DIV n;
+   WRITELN('after')
+END;
+BEGIN
+   P(0)
+END.
+`},
+	{"checked.pas", `{$Q+}
+PROGRAM Checked;
+PROCEDURE P(n : INTEGER);
+VAR k : INTEGER;
+BEGIN
+   k := n * n;
+   WRITELN('after')
+END;
+BEGIN
+   P(2147483647)
+END.
+`},
+	{"stored.pas", `{$R+}
+PROGRAM Stored;
+PROCEDURE P(r : REAL);
+VAR k : INTEGER;
+BEGIN
+   k := r;
+   WRITELN('after')
+END;
+BEGIN
+   P(3000000000.0)
+END.
+`},
+}
+
+func optimised_backend(t *testing.T, path string) (Run, bool) {
+	return run_pascal(t, "", "-O", "all", path), false
+}
+
+/* -O all changes how a program runs, never what it prints or how it ends */
+func TestOptimisedMatchesInterpreter(t *testing.T) {
+	compare_backend(t, optimised_backend)
+}
+
+/* An expression that may fail stays, even where its value is not needed */
+func TestOptimiserKeepsFailures(t *testing.T) {
+	for _, program := range optimiser_programs {
+		path := write_program(t, program.name, program.source)
+		t.Run(program.name, func(t *testing.T) {
+			want := run_pascal(t, "", path)
+			if want.code == 0 || want.stdout != "" {
+				t.Fatalf("%s runs on: exit %d with %q", program.name, want.code, want.stdout)
+			}
+			for _, passes := range []string{"simplify", "unused", "all"} {
+				got := run_pascal(t, "", "-O", passes, path)
+				if got.stdout != want.stdout || got.code != want.code {
+					t.Errorf("-O %s: got exit %d with\n%s%s\nwant exit %d with\n%s", passes, got.code, got.stdout, got.stderr, want.code, want.stderr)
+				}
+			}
+		})
+	}
+}
+
+/* Simplifying a sign away must keep the wrap, or the overflow under {$Q+}, it stood for */
+func TestSimplifyKeepsSigns(t *testing.T) {
+	for _, program := range parity_programs {
+		if strings.HasPrefix(program.name, "sign") == false {
+			continue
+		}
+		path := write_program(t, program.name, program.source)
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			want := run_pascal(t, "", path)
+			for _, passes := range []string{"simplify", "all"} {
+				got := run_pascal(t, "", "-O", passes, path)
+				if got.stdout != want.stdout || got.code != want.code {
+					t.Errorf("-O %s: got exit %d with\n%s%s\nwant exit %d with\n%s%s", passes, got.code, got.stdout, got.stderr, want.code, want.stdout, want.stderr)
+				}
+			}
+		})
+	}
+}
+
+const optimised_program = `PROGRAM Optimised;
+VAR x, y : INTEGER;
+PROCEDURE P;
+VAR k : INTEGER;
+BEGIN
+   k := 5;
+   WRITELN('p')
+END;
+BEGIN
+   y := 3;
+   x := 2 * 3 + y * 1;
+   IF 1 < 2 THEN WRITELN(x) ELSE WRITELN(y);
+   WHILE 1 > 2 DO x := 0;
+   P
+END.
+`
+
+/* What each pass leaves in the tree -dump-ast shows after it, and what it takes out */
+var optimiser_passes = []struct {
+	passes  string
+	want    []string
+	missing []string
+}{
+	{"fold", []string{`(Number "6" @11:11)`, `(If @12:4` + "\n" + `      (Number "1" @12:9)`, `(While @13:4` + "\n" + `      (Number "0" @13:12)`}, []string{`(BinOp "*" @11:11`, `(BinOp "<"`}},
+	{"simplify", []string{`(BinOp "+" @11:15` + "\n" + `        (BinOp "*" @11:11`, `(Var "Y" @11:17)))`}, []string{`(Number "1" @11:21)`}},
+	{"fold,dce", []string{`(ProcedureCall "WRITELN" @12:18`}, []string{`(If @12:4`, `(While @13:4`, `(Var "Y" @12:42)`}},
+	{"unused", []string{`(NoOp @0:0)`, `(Assign ":=" @10:6`}, []string{`(VarDecl "K`, `(Number "5" @6:9)`}},
+	{"all", []string{`(Number "6" @11:11)` + "\n" + `        (Var "Y" @11:17)))`, `(ProcedureCall "P" @14:4)`}, []string{`(If @12:4`, `(While @13:4`, `(VarDecl "K`, `(NoOp`}},
+}
+
+func TestOptimiserPasses(t *testing.T) {
+	path := write_program(t, "optimised.pas", optimised_program)
+	want := run_pascal(t, "", path)
+	for _, pass := range optimiser_passes {
+		t.Run(pass.passes, func(t *testing.T) {
+			dump := run_pascal(t, "", "-O", pass.passes, "-dump-ast", "sexpr", path)
+			for _, text := range pass.want {
+				if strings.Contains(dump.stdout, text) == false {
+					t.Errorf("tree lacks\n%s\nin\n%s", text, dump.stdout)
+				}
+			}
+			for _, text := range pass.missing {
+				if strings.Contains(dump.stdout, text) == true {
+					t.Errorf("tree still holds %s", text)
+				}
+			}
+			if got := run_pascal(t, "", "-O", pass.passes, path); got.stdout != want.stdout || got.code != want.code {
+				t.Errorf("prints %q, want %q", got.stdout, want.stdout)
+			}
+		})
+	}
+	run := run_pascal(t, "", "-O", "fold,bogus", path)
+	if run.code == 0 || strings.Contains(run.stderr, "unknown optimisation pass 'bogus'") == false {
+		t.Errorf("unknown pass: exit %d, stderr %q", run.code, run.stderr)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+	PREPROCESSOR
+
+	Acts on directives as the scanner meets them, before the parser sees
+	any token:
+
+		{$DEFINE X}  {$UNDEF X}              conditional symbols, also set with -D X
+		{$IFDEF X}  {$IFNDEF X}  {$ELSE}  {$ENDIF}
+		{$I file.inc}  {$INCLUDE file.inc}   relative to the including file
+		{$MODE TP|FPC|OBJFPC|DELPHI}         defines FPC_<MODE>, TP and FPC have a 16 bit INTEGER
+
+	Any other directive sets the switches in checks.go. Text a condition
+	leaves out is skipped character by character and never tokenised, so it
+	may hold anything but an unbalanced comment. Tokens keep the line and
+	file they were read from, so diagnostics point into the include.
+*/
+
+var modes = map[string]int{
+	"TP":     SHORT_INTEGER,
+	"FPC":    SHORT_INTEGER,
+	"OBJFPC": 0,
+	"DELPHI": 0,
+}
+
+type Preprocessor struct {
+	defines   map[string]bool
+	switches  int
+	including []string
+	included  []string
+	tokens    []lexemes
+	/* the {$IFDEF}s open in the file being read, and the directory its includes are relative to */
+	conditions []*Condition
+	dir        string
+}
+
+/* An open {$IFDEF}, whose text is kept when the text around it is and the branch taken matches */
+type Condition struct {
+	token   *lexemes
+	outer   bool
+	defined bool
+	in_else bool
+}
+
+func (c *Condition) active() bool {
+	return c.outer == true && c.defined != c.in_else
+}
+
+/* Scans a program and preprocesses it, path naming the file includes are relative to, "" for the current directory */
+func preprocess(file io.Reader, path string, defines []string) []lexemes {
+	tokens, _ := preprocess_named(file, path, "", defines)
+	return tokens
+}
+
+/* As preprocess, name being the file its tokens say they come from, also returning the files included */
+func preprocess_named(file io.Reader, path string, name string, defines []string) ([]lexemes, []string) {
+	p := &Preprocessor{make(map[string]bool), 0, nil, nil, nil, nil, ""}
+	for _, define := range defines {
+		p.defines[strings.ToUpper(define)] = true
+	}
+	dir := "."
+	if path != "" {
+		absolute, _ := filepath.Abs(path)
+		p.including = append(p.including, absolute)
+		dir = filepath.Dir(path)
+	}
+	eof := p.read(file, name, dir)
+	eof.checks = p.switches
+	return append(p.tokens, eof), p.included
+}
+
+func tokenize(file io.Reader) []lexemes {
+	return preprocess(file, "", nil)
+}
+
+/* Name and argument of a directive comment, {$I defs.inc} giving I and defs.inc */
+func split_directive(text string) (string, string) {
+	body := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(text, "{$"), "}"))
+	end := strings.IndexFunc(body, func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && r != '_'
+	})
+	if end < 0 {
+		return strings.ToUpper(body), ""
+	}
+	return strings.ToUpper(body[:end]), strings.TrimSpace(body[end:])
+}
+
+func directive_symbol(token *lexemes, name string, argument string) string {
+	if argument == "" || strings.ContainsAny(argument, " \t,") == true {
+		compile_error("Preprocessor", token, "{$%s} wants one symbol", name)
+	}
+	return strings.ToUpper(argument)
+}
+
+/* Reads a file into p.tokens, dir being where its includes are found, and returns its EOF */
+func (p *Preprocessor) read(file io.Reader, name string, dir string) lexemes {
+	conditions, outer_dir := p.conditions, p.dir
+	p.conditions, p.dir = nil, dir
+	eof := scan(file, name, p)
+	if len(p.conditions) > 0 {
+		compile_error("Preprocessor", p.conditions[len(p.conditions)-1].token, "{$IFDEF} without {$ENDIF}")
+	}
+	p.conditions, p.dir = conditions, outer_dir
+	return eof
+}
+
+func (p *Preprocessor) active() bool {
+	return len(p.conditions) == 0 || p.conditions[len(p.conditions)-1].active() == true
+}
+
+func (p *Preprocessor) keep(token lexemes) {
+	token.checks = p.switches
+	p.tokens = append(p.tokens, token)
+}
+
+/* Acts on a directive where the scanner met it, true when the text after it is kept */
+func (p *Preprocessor) directive(token *lexemes) bool {
+	name, argument := split_directive(token.tstring)
+	switch name {
+	case "IFDEF", "IFNDEF":
+		defined := p.defines[directive_symbol(token, name, argument)] == (name == "IFDEF")
+		p.conditions = append(p.conditions, &Condition{token, p.active(), defined, false})
+		return p.active()
+	case "ELSE":
+		if len(p.conditions) == 0 || p.conditions[len(p.conditions)-1].in_else == true {
+			compile_error("Preprocessor", token, "{$ELSE} without {$IFDEF}")
+		}
+		p.conditions[len(p.conditions)-1].in_else = true
+		return p.active()
+	case "ENDIF":
+		if len(p.conditions) == 0 {
+			compile_error("Preprocessor", token, "{$ENDIF} without {$IFDEF}")
+		}
+		p.conditions = p.conditions[:len(p.conditions)-1]
+		return p.active()
+	}
+	if p.active() == false {
+		return false
+	}
+	switch {
+	case name == "DEFINE":
+		p.defines[directive_symbol(token, name, argument)] = true
+	case name == "UNDEF":
+		delete(p.defines, directive_symbol(token, name, argument))
+	case name == "MODE":
+		p.mode(token, strings.ToUpper(argument))
+	case (name == "I" || name == "INCLUDE") && argument != "+" && argument != "-":
+		p.include(token, strings.Trim(argument, "'"))
+	default:
+		p.switches = apply_directive(token, p.switches)
+	}
+	return true
+}
+
+func (p *Preprocessor) mode(token *lexemes, mode string) {
+	switches, ok := modes[mode]
+	if ok == false {
+		compile_error("Preprocessor", token, "unknown mode '%s', want TP, FPC, OBJFPC or DELPHI", mode)
+	}
+	for other := range modes {
+		delete(p.defines, "FPC_"+other)
+	}
+	p.defines["FPC_"+mode] = true
+	p.switches = p.switches&^SHORT_INTEGER | switches
+}
+
+func (p *Preprocessor) include(token *lexemes, name string) {
+	if name == "" {
+		compile_error("Preprocessor", token, "{$I} wants a file name")
+	}
+	path := name
+	if filepath.IsAbs(path) == false {
+		path = filepath.Join(p.dir, name)
+	}
+	absolute, _ := filepath.Abs(path)
+	for index, open := range p.including {
+		if open == absolute {
+			chain := []string{}
+			for _, file := range p.including[index:] {
+				chain = append(chain, filepath.Base(file))
+			}
+			compile_error("Preprocessor", token, "include cycle %s -> %s", strings.Join(chain, " -> "), filepath.Base(absolute))
+		}
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		compile_error("Preprocessor", token, "cannot include %s: %v", name, err)
+	}
+	p.including = append(p.including, absolute)
+	p.included = append(p.included, path)
+	p.read(bytes.NewReader(content), path, filepath.Dir(path))
+	p.including = p.including[:len(p.including)-1]
+}
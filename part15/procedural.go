@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+	PROCEDURAL TYPES
+
+		type_section    : TYPE (ID EQUAL (procedural_type | class_type) SEMI)+
+		procedural_type : PROCEDURE (LPAREN formal_parameter_list RPAREN)?
+		                | FUNCTION (LPAREN formal_parameter_list RPAREN)? COLON type_spec
+
+	A variable or parameter of a procedural type holds a procedure, taken by
+	naming it without arguments, and calling the variable calls the
+	procedure. The procedure and the variable's type must take the same
+	parameter types and return the same type. The interpreter keeps the
+	procedure together with the activation it was declared in, so it sees
+	the same non-locals wherever it ends up being called from.
+
+	A FUNCTION returns what was last assigned to its name within it. It is
+	called in an expression with its arguments in parentheses, F() when it
+	takes none, the bare name being the function as a value. A comparison
+	is a BOOLEAN, which may be stored and returned but is no number.
+	Procedural types, functions and BOOLEAN only run on the interpreter.
+*/
+
+type TypeDeclaration struct {
+	token  *lexemes
+	params []Param
+	/* the type a FUNCTION type returns, nil for a PROCEDURE type */
+	result *Spec
+}
+
+type ProcTypeSymbol struct {
+	name   string
+	params []*BuiltinSymbol
+	token  *lexemes
+	/* the type of its variables, pointing back here */
+	stype *BuiltinSymbol
+	/* the type its functions return, nil when it holds procedures */
+	result *BuiltinSymbol
+}
+
+func (p *ProcTypeSymbol) getName() string {
+	return p.name
+}
+
+func (p *ProcTypeSymbol) String() string {
+	return fmt.Sprintf("%s: <%s>", p.name, procedural_text(p))
+}
+
+/* How a procedural type reads in a declaration, PROCEDURE(INTEGER) or FUNCTION(INTEGER): BOOLEAN */
+func procedural_text(p *ProcTypeSymbol) string {
+	if p.result == nil {
+		return fmt.Sprintf("PROCEDURE(%s)", signature_text(p.params))
+	}
+	return fmt.Sprintf("FUNCTION(%s): %s", signature_text(p.params), type_name(p.result))
+}
+
+func signature_text(params []*BuiltinSymbol) string {
+	names := []string{}
+	for _, param := range params {
+		names = append(names, type_name(param))
+	}
+	return strings.Join(names, ", ")
+}
+
+/* A procedure value: the procedure and the activation its non-locals are read from */
+type Closure struct {
+	proc *ProcedureSymbol
+	link *CallFrame
+}
+
+func (r *rules) type_section() []interface{} {
+	declarations := []interface{}{}
+	r.digest(TYPE)
+	for r.lexer.Cur().ttype == ID {
+		token := r.lexer.Cur()
+		r.digest(ID)
+		r.digest(EQUAL)
+		if r.lexer.Cur().ttype == CLASS {
+			declarations = append(declarations, r.class_type(token))
+			r.digest(SEMI)
+			continue
+		}
+		declaration := &TypeDeclaration{token, []Param{}, nil}
+		kind := r.lexer.Cur().ttype
+		if kind == FUNCTION {
+			r.digest(FUNCTION)
+		} else {
+			r.digest(PROCEDURE)
+		}
+		if r.lexer.Cur().ttype == LPAR {
+			r.digest(LPAR)
+			declaration.params = r.formal_parameters_list()
+			r.digest(RPAR)
+		}
+		if kind == FUNCTION {
+			r.digest(COLON)
+			declaration.result = r.type_spec()
+		}
+		r.digest(SEMI)
+		declarations = append(declarations, declaration)
+	}
+	return declarations
+}
+
+func (s SemanticsAnalyser) declare_type(v *TypeDeclaration) {
+	if _, found := s.scope.lookup(v.token.tstring, true); found == true {
+		compile_error("Semantic", v.token, "%s already declared", v.token.tstring)
+	}
+	ptype := &ProcTypeSymbol{v.token.tstring, []*BuiltinSymbol{}, v.token, nil, nil}
+	ptype.stype = &BuiltinSymbol{v.token.tstring, ptype, nil, nil}
+	for _, param := range v.params {
+		ptype.params = append(ptype.params, s.param_type(param.var_type, param.var_name.token))
+	}
+	if v.result != nil {
+		ptype.result = s.result_type(v.result, v.token)
+	}
+	s.scope.insert(ptype)
+	s.reference(v.token, ptype)
+}
+
+/* The type a function returns, anything but an array, which would go with the activation returning it */
+func (s SemanticsAnalyser) result_type(spec *Spec, token *lexemes) *BuiltinSymbol {
+	if spec.array != nil {
+		compile_error("Semantic", token, "a function cannot return an array")
+	}
+	return s.type_symbol(spec, token)
+}
+
+/* The variable holding what the function v returns, named after it in its own scope */
+func (s SemanticsAnalyser) function_result(v *ProcedureDecl) *VarSymbol {
+	if _, found := s.scope.lookup(v.proc_name, true); found == true {
+		compile_error("Semantic", v.token, "%s already declared", v.proc_name)
+	}
+	if v.result.sstring == "" {
+		compile_error("Semantic", v.token, "function %s needs a result type", v.proc_name)
+	}
+	result := &VarSymbol{v.proc_name, s.result_type(v.result, v.token), 0, v.token, false}
+	s.scope.insert(result)
+	return result
+}
+
+/* What a call to name reaches, a function being called rather than its result within itself */
+func (s SemanticsAnalyser) callable(name string) Symbol {
+	symbol, _ := s.scope.lookup(name, false)
+	variable, ok := symbol.(*VarSymbol)
+	if ok == false {
+		return symbol
+	}
+	if scope := declaring_scope(s.scope, variable); scope != nil && scope.enclosing_scope != nil {
+		if function, ok := scope.enclosing_scope.symbols[name].(*ProcedureSymbol); ok == true && function.result == variable {
+			return function
+		}
+	}
+	return symbol
+}
+
+/* The type a declaration names, INTEGER, REAL, BOOLEAN, an array, or a procedural type or class in scope */
+func (s SemanticsAnalyser) type_symbol(spec *Spec, token *lexemes) *BuiltinSymbol {
+	if spec.array != nil {
+		return s.array_symbol(spec, token, false)
+	}
+	symbol, _ := s.scope.lookup(spec.sstring, false)
+	switch v := symbol.(type) {
+	case *BuiltinSymbol:
+		if v.name == "INTEGER_CONST" || v.name == "REAL_CONST" || v.name == "BOOLEAN_CONST" {
+			return v
+		}
+	case *ProcTypeSymbol:
+		return v.stype
+	case *ClassSymbol:
+		return v.stype
+	}
+	compile_error("Semantic", token, "%s is not a type", spec.sstring)
+	return nil
+}
+
+func proc_signature(proc *ProcedureSymbol) []*BuiltinSymbol {
+	params := []*BuiltinSymbol{}
+	for _, param := range proc.params {
+		params = append(params, param.stype)
+	}
+	return params
+}
+
+/* The procedural type proc would be a value of */
+func proc_type(proc *ProcedureSymbol) *ProcTypeSymbol {
+	ptype := &ProcTypeSymbol{proc.name, proc_signature(proc), proc.token, nil, nil}
+	if proc.result != nil {
+		ptype.result = proc.result.stype
+	}
+	return ptype
+}
+
+/* Types are told apart by name, every unit having builtins of its own */
+func same_signature(a []*BuiltinSymbol, b []*BuiltinSymbol) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index := range a {
+		if a[index].name != b[index].name {
+			return false
+		}
+	}
+	return true
+}
+
+/* The type of the procedure, or procedural variable, an expression names when it is a bare identifier */
+func (s SemanticsAnalyser) procedure_value(expr *Node) (*ProcTypeSymbol, bool) {
+	variable, ok := expr.token.(*Var)
+	if ok == false || expr.left != nil || expr.right != nil {
+		return nil, false
+	}
+	symbol, _ := s.scope.lookup(variable.token.tstring, false)
+	switch v := symbol.(type) {
+	case *ProcedureSymbol:
+		if method_member(v) != nil {
+			compile_error("Semantic", variable.token, "method %s cannot be used as a procedure value", v.name)
+		}
+		return proc_type(v), true
+	case *VarSymbol:
+		if v.stype.procedure != nil {
+			return v.stype.procedure, true
+		}
+	}
+	return nil, false
+}
+
+/* Checks expr may be stored in a variable or parameter of type target */
+func (s SemanticsAnalyser) assignable(target *BuiltinSymbol, expr *Node, token *lexemes) {
+	if s.assignable_reference(target, expr, token) == true {
+		return
+	}
+	if s.assignable_array(target, expr, token) == true {
+		return
+	}
+	signature, is_procedure := s.procedure_value(expr)
+	if target.procedure == nil {
+		if is_procedure == true {
+			compile_error("Semantic", token, "a procedure cannot be used as %s", type_name(target))
+		}
+		if stype := s.type_of(expr); (stype.name == "BOOLEAN_CONST") != (target.name == "BOOLEAN_CONST") {
+			compile_error("Semantic", token, "%s cannot be used as %s", type_name(stype), type_name(target))
+		}
+		return
+	}
+	if is_procedure == false {
+		compile_error("Semantic", token, "%s wants a procedure", type_name(target))
+	}
+	wanted := target.procedure
+	same := same_signature(wanted.params, signature.params) && (wanted.result == nil) == (signature.result == nil)
+	if same == false || wanted.result != nil && wanted.result.name != signature.result.name {
+		compile_error("Semantic", token, "%s is not a %s, which is %s", procedural_text(signature), type_name(target), procedural_text(wanted))
+	}
+}
+
+/* A BOOLEAN can only be compared with another BOOLEAN */
+func boolean_operands(op *Op, left *BuiltinSymbol, right *BuiltinSymbol) bool {
+	is_boolean := func(stype *BuiltinSymbol) bool {
+		return stype != nil && stype.name == "BOOLEAN_CONST"
+	}
+	if is_boolean(left) == false && is_boolean(right) == false {
+		return false
+	}
+	if relational(op.token.ttype) == true && is_boolean(left) == true && is_boolean(right) == true {
+		return true
+	}
+	compile_error("Semantic", op.token, "a BOOLEAN cannot be an operand of '%s'", op.token.tstring)
+	return false
+}
+
+/* A call in an expression, to a function or through a variable of a FUNCTION type */
+func (s SemanticsAnalyser) check_function_call(v *BuiltinCall, symbol Symbol) {
+	name := v.token.tstring
+	var signature *ProcTypeSymbol
+	switch target := symbol.(type) {
+	case *ProcedureSymbol:
+		signature = proc_type(target)
+		v.proc_symbol = target
+	case *VarSymbol:
+		signature = target.stype.procedure
+		v.variable = target
+	}
+	if signature == nil {
+		compile_error("Semantic", v.token, "%s is not a function", name)
+	}
+	if signature.result == nil {
+		compile_error("Semantic", v.token, "%s is a procedure, which returns no value", name)
+	}
+	s.check_member_name(symbol, v.token)
+	s.check_arguments(name, v.token, signature.params, v.args)
+	s.reference(v.token, symbol)
+}
+
+/* The type a call in an expression returns, nil when it calls no function */
+func (s SemanticsAnalyser) call_result(v *BuiltinCall) *BuiltinSymbol {
+	switch target := s.callable(v.token.tstring).(type) {
+	case *ProcedureSymbol:
+		if target.result != nil {
+			return target.result.stype
+		}
+	case *VarSymbol:
+		if target.stype.procedure != nil {
+			return target.stype.procedure.result
+		}
+	}
+	return nil
+}
+
+/* The handle of a procedure value, the same procedure and activation always getting the same one */
+func (i *Interpreter) closure(proc *ProcedureSymbol) float64 {
+	closure := Closure{proc, i.activation(i.stack[len(i.stack)-1], proc.scope.enclosing_scope)}
+	handle, ok := i.handles[closure]
+	if ok == false {
+		i.closures = append(i.closures, closure)
+		handle = len(i.closures)
+		i.handles[closure] = handle
+	}
+	return float64(handle)
+}
+
+/* The value an identifier stands for: a variable's, or a handle when it names a procedure */
+func (i *Interpreter) load(token *lexemes) float64 {
+	symbol, _ := i.scope.lookup(token.tstring, false)
+	if proc, ok := symbol.(*ProcedureSymbol); ok == true {
+		return i.closure(proc)
+	}
+	variable, frame := i.variable(token)
+	if variable.stype.array != nil {
+		return i.array_handle(frame, variable, token)
+	}
+	return frame.values[variable]
+}
+
+/* Calls the function a call in an expression names, directly or through a procedural variable */
+func (i *Interpreter) call_function(v *BuiltinCall) float64 {
+	proc := v.proc_symbol
+	var link *CallFrame
+	if v.variable != nil {
+		proc, link = i.callee(v.token)
+	} else {
+		link = i.activation(i.stack[len(i.stack)-1], proc.scope.enclosing_scope)
+	}
+	return i.invoke(proc, link, v.args, v.token, 0)
+}
+
+/* The procedure a call through a procedural variable reaches, and the activation it runs in */
+func (i *Interpreter) callee(token *lexemes) (*ProcedureSymbol, *CallFrame) {
+	handle := int(i.load(token))
+	if handle < 1 || handle > len(i.closures) {
+		i.runtime_error(RUNTIME_NIL_POINTER, token, "%s holds no procedure", token.tstring)
+	}
+	closure := i.closures[handle-1]
+	return closure.proc, closure.link
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+/* Programs calling functions, directly and through FUNCTION types, with what they print or the error they stop on */
+var function_programs = []struct {
+	name   string
+	source string
+	stdout string
+	stderr string
+}{
+	{"compare.pas", `PROGRAM Compare;
+TYPE
+   TCompare = FUNCTION(a, b : INTEGER) : BOOLEAN;
+VAR
+   order : TCompare;
+   kept : BOOLEAN;
+FUNCTION Less(a, b : INTEGER) : BOOLEAN;
+BEGIN
+   Less := a < b
+END;
+FUNCTION Greater(a, b : INTEGER) : BOOLEAN;
+BEGIN
+   Greater := a > b
+END;
+FUNCTION Best(before : TCompare; a, b, c : INTEGER) : INTEGER;
+BEGIN
+   Best := a;
+   IF before(b, Best) THEN Best := b;
+   IF before(c, Best) THEN Best := c
+END;
+BEGIN
+   order := Less;
+   kept := order(1, 2);
+   IF kept = Greater(2, 1) THEN WRITELN(kept, ' ', order(2, 1));
+   WRITELN(Best(Less, 5, 2, 8), ' ', Best(Greater, 5, 2, 8))
+END.
+`, "TRUE FALSE\n2 8\n", ""},
+	{"recursive.pas", `PROGRAM Recursive;
+FUNCTION Fact(n : INTEGER) : INTEGER;
+BEGIN
+   IF n <= 1 THEN Fact := 1 ELSE Fact := n * Fact(n - 1)
+END;
+FUNCTION Twice(n : INTEGER) : INTEGER; FORWARD;
+FUNCTION Quad(n : INTEGER) : INTEGER;
+BEGIN
+   Quad := Twice(Twice(n))
+END;
+FUNCTION Twice;
+BEGIN
+   Twice := 2 * n
+END;
+BEGIN
+   WRITELN(Fact(10), ' ', Quad(3))
+END.
+`, "3628800 12\n", ""},
+	{"closure.pas", `PROGRAM Closure;
+TYPE
+   TMap = FUNCTION(n : INTEGER) : INTEGER;
+FUNCTION Apply(f : TMap; n : INTEGER) : INTEGER;
+BEGIN
+   Apply := f(f(n))
+END;
+FUNCTION Counter(start : INTEGER) : INTEGER;
+VAR seen : INTEGER;
+   FUNCTION Bump(n : INTEGER) : INTEGER;
+   BEGIN
+      seen := seen + n;
+      Bump := seen
+   END;
+BEGIN
+   seen := start;
+   Counter := Apply(Bump, 5)
+END;
+BEGIN
+   WRITELN(Counter(100))
+END.
+`, "210\n", ""},
+	{"signature.pas", `PROGRAM Signature;
+TYPE
+   TCompare = FUNCTION(a, b : INTEGER) : BOOLEAN;
+VAR
+   order : TCompare;
+FUNCTION Sum(a, b : INTEGER) : INTEGER;
+BEGIN
+   Sum := a + b
+END;
+BEGIN
+   order := Sum
+END.
+`, "", "FUNCTION(INTEGER, INTEGER): INTEGER is not a TCOMPARE, which is FUNCTION(INTEGER, INTEGER): BOOLEAN"},
+	{"procedure.pas", `PROGRAM Procedures;
+VAR n : INTEGER;
+PROCEDURE Nothing;
+BEGIN
+END;
+BEGIN
+   n := Nothing()
+END.
+`, "", "NOTHING is a procedure, which returns no value"},
+	{"number.pas", `PROGRAM Number;
+VAR n : INTEGER;
+BEGIN
+   n := 1 < 2
+END.
+`, "", "BOOLEAN cannot be used as INTEGER"},
+	{"arithmetic.pas", `PROGRAM Arithmetic;
+VAR b : BOOLEAN;
+BEGIN
+   b := 1 < 2;
+   WRITELN(b + 1)
+END.
+`, "", "a BOOLEAN cannot be an operand of '+'"},
+}
+
+func TestFunctions(t *testing.T) {
+	for _, program := range function_programs {
+		program := program
+		t.Run(program.name, func(t *testing.T) {
+			path := write_program(t, program.name, program.source)
+			for _, args := range [][]string{{}, {"-O", "all"}} {
+				run := run_pascal(t, "", append(args, path)...)
+				if run.stdout != program.stdout {
+					t.Errorf("%v: stdout\n%s\nwant\n%s", args, run.stdout, program.stdout)
+				}
+				if program.stderr == "" && run.code != 0 {
+					t.Errorf("%v: exit %d: %s", args, run.code, run.stderr)
+				}
+				if program.stderr != "" && (run.code == 0 || strings.Contains(run.stderr, program.stderr) == false) {
+					t.Errorf("%v: exit %d with %q, want %q", args, run.code, run.stderr, program.stderr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+)
+
+/*
+	REPL
+*/
+
+type Repl struct {
+	scope       *ScopedSymbolTable
+	interpreter *Interpreter
+	out         io.Writer
+	defines     []string
+	search      []string
+	limits      Limits
+	interrupts  chan os.Signal
+}
+
+func new_repl(defines []string, search []string, limits Limits, out io.Writer) *Repl {
+	repl := &Repl{nil, nil, out, defines, search, limits, make(chan os.Signal, 1)}
+	repl.reset()
+	return repl
+}
+
+/* Forgets every declaration, keeping the options of the session */
+func (repl *Repl) reset() {
+	repl.scope = new_global_scope()
+	repl.interpreter = new_interpreter(repl.scope)
+	repl.interpreter.limits = repl.limits
+}
+
+/* Runs one input under the limits, an interrupt stopping it and leaving the session at the prompt */
+func (repl *Repl) run(node interface{}) (float64, error) {
+	select {
+	case <-repl.interrupts:
+	default:
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-repl.interrupts:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return repl.interpreter.evaluate(ctx, node)
+}
+
+/* Tokens of an input line, read with the -D symbols like a program */
+func (repl *Repl) tokenize(text string) []lexemes {
+	return preprocess(strings.NewReader(text), "", repl.defines)
+}
+
+/* Declarations first, then statements, like a block without its BEGIN END */
+func (r *rules) repl_input() []interface{} {
+	items := r.declaration().elem
+	if r.lexer.Cur().ttype != EOF {
+		items = append(items, r.statement_list().elem...)
+	}
+	if r.lexer.Cur().ttype != EOF {
+		token := r.lexer.Cur()
+		compile_error("Syntax", token, "unexpected token %s '%s'", reverse_lex[token.ttype], token.tstring)
+	}
+	return items
+}
+
+/* Tokens after which a statement cannot end: a loop or branch without its body, an assignment or operator without its operand */
+var continued = map[int]bool{
+	DO: true, THEN: true, ELSE: true, ASSIGN: true,
+	PLUS: true, MINUS: true, MUL: true, FLOAT_DIV: true, INTEGER_DIV: true, MOD: true,
+	EQUAL: true, NOT_EQUAL: true, LESS: true, LESS_EQUAL: true, GREATER: true, GREATER_EQUAL: true,
+}
+
+/* An open BEGIN, a procedure header still waiting for its body, or a line ending where a statement cannot, asks for more lines */
+func incomplete_input(tokens []lexemes) bool {
+	depth := 0
+	waiting_body := false
+	for _, token := range tokens {
+		switch token.ttype {
+		case PROCEDURE, FUNCTION:
+			waiting_body = true
+		case BEGIN:
+			depth++
+		case END:
+			depth--
+			if depth == 0 {
+				waiting_body = false
+			}
+		}
+	}
+	if len(tokens) > 1 && continued[tokens[len(tokens)-2].ttype] == true {
+		return true
+	}
+	return depth > 0 || waiting_body == true
+}
+
+func (repl *Repl) is_expression(tokens []lexemes) bool {
+	switch tokens[0].ttype {
+	case ID:
+		if symbol, _ := repl.scope.lookup(tokens[0].tstring, false); symbol != nil {
+			switch symbol.(type) {
+			case *ProcedureSymbol, *BuiltinSymbol:
+				return false
+			}
+		}
+		return len(tokens) < 2 || tokens[1].ttype != ASSIGN
+	case INTEGER_CONST, REAL_CONST, LPAR, PLUS, MINUS:
+		return true
+	}
+	return false
+}
+
+func format_value(value float64, stype *BuiltinSymbol) string {
+	if stype.name == "BOOLEAN_CONST" {
+		if value != 0 {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	if stype.procedure != nil {
+		if value == 0 {
+			return "NIL"
+		}
+		return fmt.Sprintf("<procedure %d>", int64(value))
+	}
+	if is_reference(stype) == true {
+		if value == 0 {
+			return "NIL"
+		}
+		return fmt.Sprintf("<object %d>", int64(value))
+	}
+	if stype.array != nil {
+		if value == 0 {
+			return "<empty array>"
+		}
+		return fmt.Sprintf("<array %d>", int64(value))
+	}
+	if stype.name == "INTEGER_CONST" {
+		return fmt.Sprintf("%d", int64(value))
+	}
+	return fmt.Sprintf("%f", value)
+}
+
+func type_name(stype *BuiltinSymbol) string {
+	return strings.TrimSuffix(stype.name, "_CONST")
+}
+
+func (repl *Repl) eval(tokens []lexemes) (err error) {
+	defer catch_compile_error(&err)
+	analyser := SemanticsAnalyser{repl.scope, nil}
+	parser := rules{lexer{0, len(tokens), tokens}}
+	if repl.is_expression(tokens) == true {
+		node := parser.expr()
+		parser.digest(EOF)
+		analyser.check(node)
+		stype := analyser.type_of(node)
+		value, err := repl.run(node)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(repl.out, "%s : %s\n", format_value(value, stype), type_name(stype))
+		return nil
+	}
+	items := parser.repl_input()
+	for _, item := range items {
+		analyser.check(item)
+	}
+	for _, item := range items {
+		if _, err := repl.run(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (repl *Repl) load(path string) (err error) {
+	defer catch_compile_error(&err)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	tokens := preprocess(file, path, repl.defines)
+	parser := rules{lexer{0, len(tokens), tokens}}
+	tree := parser.Parse()
+	load_units(tree, path, repl.search, repl.defines, nil)
+	if uses := program_uses(tree); uses != nil {
+		for _, unit := range uses.units {
+			for _, decl := range unit.exports.elem {
+				repl.scope.insert(unit.exported(decl))
+			}
+		}
+	}
+	analyser := SemanticsAnalyser{repl.scope, nil}
+	analyser.check(tree)
+	_, err = repl.run(tree)
+	return err
+}
+
+func (repl *Repl) expr_type(text string) (err error) {
+	defer catch_compile_error(&err)
+	tokens := repl.tokenize(text)
+	parser := rules{lexer{0, len(tokens), tokens}}
+	node := parser.expr()
+	parser.digest(EOF)
+	analyser := SemanticsAnalyser{repl.scope, nil}
+	analyser.check(node)
+	fmt.Fprintln(repl.out, type_name(analyser.type_of(node)))
+	return nil
+}
+
+func (repl *Repl) print_vars() {
+	names := []string{}
+	for name, symbol := range repl.scope.symbols {
+		if _, ok := symbol.(*VarSymbol); ok == true {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		symbol := repl.scope.symbols[name].(*VarSymbol)
+		fmt.Fprintf(repl.out, "%s : %s = %s\n", name, type_name(symbol.stype), format_value(repl.interpreter.value(repl.interpreter.stack[0], symbol), symbol.stype))
+	}
+}
+
+/* Returns false once the session should end */
+func (repl *Repl) meta(line string) bool {
+	command := strings.Fields(line)
+	arg := strings.TrimSpace(strings.TrimPrefix(line, command[0]))
+	var err error
+	switch command[0] {
+	case ":quit", ":q":
+		return false
+	case ":vars":
+		repl.print_vars()
+	case ":scopes":
+		fmt.Fprint(repl.out, *repl.scope)
+	case ":type":
+		err = repl.expr_type(arg)
+	case ":load":
+		err = repl.load(arg)
+	case ":reset":
+		repl.reset()
+	case ":help":
+		fmt.Fprintln(repl.out, ":vars            list global variables")
+		fmt.Fprintln(repl.out, ":scopes          print the symbol table of every scope")
+		fmt.Fprintln(repl.out, ":type expr       print the type of an expression")
+		fmt.Fprintln(repl.out, ":load file.pas   run a program into the current session")
+		fmt.Fprintln(repl.out, ":reset           forget every declaration")
+		fmt.Fprintln(repl.out, ":quit            leave")
+	default:
+		err = fmt.Errorf("unknown command %s, try :help", command[0])
+	}
+	if err != nil {
+		fmt.Fprintln(repl.out, err)
+	}
+	return true
+}
+
+func run_repl(defines []string, search []string, limits Limits, in io.Reader, out io.Writer) {
+	repl := new_repl(defines, search, limits, out)
+	signal.Notify(repl.interrupts, os.Interrupt)
+	defer signal.Stop(repl.interrupts)
+	scanner := bufio.NewScanner(in)
+	buffer := ""
+	fmt.Fprint(out, "> ")
+	for scanner.Scan() {
+		line := scanner.Text()
+		if buffer == "" && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			if repl.meta(strings.TrimSpace(line)) == false {
+				return
+			}
+			fmt.Fprint(out, "> ")
+			continue
+		}
+		buffer += line + "\n"
+		var tokens []lexemes
+		err := func() (err error) {
+			defer catch_compile_error(&err)
+			tokens = repl.tokenize(buffer)
+			return nil
+		}()
+		switch {
+		case err != nil:
+			fmt.Fprintln(out, err)
+		case len(tokens) == 1:
+		case incomplete_input(tokens) == true:
+			fmt.Fprint(out, ". ")
+			continue
+		default:
+			if err := repl.eval(tokens); err != nil {
+				fmt.Fprintln(out, err)
+			}
+		}
+		buffer = ""
+		fmt.Fprint(out, "> ")
+	}
+	fmt.Fprintln(out)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* Sessions fed on stdin, with what the REPL must print for them */
+var repl_sessions = []struct {
+	name   string
+	args   []string
+	stdin  string
+	stdout []string
+}{
+	{"expression", nil, "1 + 2\n", []string{"3 : INTEGER\n"}},
+	{"declarations", nil, "VAR a : INTEGER;\na := 4;\na * a\n", []string{"16 : INTEGER\n"}},
+	{"while", nil, "VAR a : INTEGER;\nWHILE a < 10 DO\n   a := a + 1;\na\n", []string{"> . > 10 : INTEGER\n"}},
+	{"if", nil, "VAR a : INTEGER;\nIF a = 0 THEN\n   a := 3 ELSE\n   a := 4;\na\n", []string{"> . . > 3 : INTEGER\n"}},
+	{"assignment", nil, "VAR a : INTEGER;\na :=\n   5;\na\n", []string{"5 : INTEGER\n"}},
+	{"operator", nil, "2 *\n   3\n", []string{"> . 6 : INTEGER\n"}},
+	{"block", nil, "BEGIN\n   WRITELN('in')\nEND;\n", []string{"> . . in\n"}},
+	{"steps", []string{"-max-steps", "100"}, "VAR a : INTEGER;\nWHILE a >= 0 DO a := 1\na\n", []string{"step limit of 100 exceeded", "> 1 : INTEGER\n"}},
+	{"depth", []string{"-max-depth", "20"}, "PROCEDURE R;\nBEGIN\n   R\nEND;\nR;\n1\n", []string{"call depth limit of 20 exceeded", "> 1 : INTEGER\n"}},
+	{"runtime error", nil, "VAR a : INTEGER;\n7 DIV a\na + 1\n", []string{"Runtime error 200", "> 1 : INTEGER\n"}},
+}
+
+func TestReplSessions(t *testing.T) {
+	for _, session := range repl_sessions {
+		t.Run(session.name, func(t *testing.T) {
+			run := run_pascal(t, session.stdin, append(session.args, "repl")...)
+			for _, want := range session.stdout {
+				if strings.Contains(run.stdout, want) == false {
+					t.Errorf("exit %d with\n%s\nwant %q", run.code, run.stdout, want)
+				}
+			}
+		})
+	}
+}
+
+/* An interrupt stops the running input and the session goes on at the prompt */
+func TestReplInterrupt(t *testing.T) {
+	command := exec.Command(pascal, "-cache-dir", "", "repl")
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	command.Stdout = &stdout
+	if err := command.Start(); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(stdin, "VAR a : INTEGER;\nWHILE a >= 0 DO a := 1\n")
+	time.Sleep(500 * time.Millisecond)
+	if err := command.Process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	io.WriteString(stdin, "a + 41\n")
+	stdin.Close()
+	if err := command.Wait(); err != nil {
+		t.Fatalf("%v with\n%s", err, stdout.String())
+	}
+	if strings.Contains(stdout.String(), "execution cancelled") == false || strings.Contains(stdout.String(), "42 : INTEGER\n") == false {
+		t.Errorf("session printed\n%s", stdout.String())
+	}
+}
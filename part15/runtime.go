@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+	RUNTIME ERRORS
+
+	Numbered as in Turbo Pascal, so that a failing program reports
+
+		Runtime error 200 at line 12 in P2 called from P1 called from Main
+
+	Embedding code gets them back as a *RuntimeError from Interpreter.execute,
+	or can defer Interpreter.catch around its own calls to run.
+*/
+
+const (
+	RUNTIME_DIVISION_BY_ZERO = 200
+	RUNTIME_RANGE_CHECK      = 201
+	RUNTIME_HEAP_OVERFLOW    = 203
+	RUNTIME_OVERFLOW         = 215
+	RUNTIME_NIL_POINTER      = 216
+	RUNTIME_INVALID_CAST     = 219
+)
+
+var runtime_messages = map[int]string{
+	RUNTIME_DIVISION_BY_ZERO: "division by zero",
+	RUNTIME_RANGE_CHECK:      "range check error",
+	RUNTIME_HEAP_OVERFLOW:    "heap overflow",
+	RUNTIME_OVERFLOW:         "arithmetic overflow",
+	RUNTIME_NIL_POINTER:      "nil dereference",
+	RUNTIME_INVALID_CAST:     "invalid cast",
+}
+
+type RuntimeError struct {
+	code    int
+	message string
+	token   *lexemes
+	stack   []StackEntry
+}
+
+func (e *RuntimeError) Error() string {
+	text := &strings.Builder{}
+	fmt.Fprintf(text, "Runtime error %d", e.code)
+	if e.token != nil {
+		fmt.Fprintf(text, " at line %d", e.token.line)
+		if e.token.file != "" {
+			fmt.Fprintf(text, " of %s", e.token.file)
+		}
+	}
+	for index, frame := range e.stack {
+		if index == 0 {
+			fmt.Fprintf(text, " in %s", frame.name)
+		} else {
+			fmt.Fprintf(text, " called from %s", frame.name)
+		}
+	}
+	fmt.Fprintf(text, ": %s", e.message)
+	return text.String()
+}
+
+func (i *Interpreter) runtime_error(code int, token *lexemes, format string, args ...interface{}) {
+	message := runtime_messages[code]
+	if format != "" {
+		message += ", " + fmt.Sprintf(format, args...)
+	}
+	panic(&RuntimeError{code, message, token, i.backtrace()})
+}
+
+/* Recovers an aborted run into err and unwinds the interpreter back to the program frame */
+func (i *Interpreter) catch(err *error) {
+	if r := recover(); r != nil {
+		switch abort := r.(type) {
+		case *RuntimeError:
+			*err = abort
+		case *LimitError:
+			*err = abort
+		default:
+			panic(r)
+		}
+		i.scope = i.stack[0].scope
+		i.unwind(1)
+		i.handling = nil
+	}
+}
+
+/* The variable a name refers to at run time and the activation holding it, failing like a bad pointer when there is none */
+func (i *Interpreter) variable(token *lexemes) (*VarSymbol, *CallFrame) {
+	for scope := i.scope; scope != nil; scope = scope.enclosing_scope {
+		symbol, ok := scope.lookup(token.tstring, true)
+		if ok == false {
+			continue
+		}
+		variable, ok := symbol.(*VarSymbol)
+		if ok == false {
+			i.runtime_error(RUNTIME_INVALID_CAST, token, "%s is not a variable", token.tstring)
+		}
+		if scope.class != nil {
+			return variable, i.instance(i.self(i.stack[len(i.stack)-1]), token)
+		}
+		return variable, i.activation(i.stack[len(i.stack)-1], scope)
+	}
+	i.runtime_error(RUNTIME_NIL_POINTER, token, "no storage for %s", token.tstring)
+	return nil, nil
+}
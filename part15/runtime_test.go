@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* Programs that fail at run time, with the output before the error and the error naming the calls it happened under */
+var runtime_error_programs = []struct {
+	name   string
+	source string
+	stdout string
+	stderr string
+	vm     bool
+}{
+	{"divide.pas", `PROGRAM Divide;
+FUNCTION Quotient(a, b : INTEGER) : INTEGER;
+BEGIN
+   Quotient := a DIV b
+END;
+PROCEDURE Show(n : INTEGER);
+BEGIN
+   WRITELN(Quotient(10, n))
+END;
+BEGIN
+   Show(2);
+   Show(0)
+END.
+`, "5\n", "Runtime error 200 at line 4 in QUOTIENT called from SHOW called from Global: division by zero\n", false},
+	{"global.pas", `PROGRAM Global;
+VAR n : INTEGER;
+BEGIN
+   WRITELN(7 MOD n)
+END.
+`, "", "Runtime error 200 at line 4 in Global: division by zero\n", true},
+	{"range.pas", `{$R+}
+PROGRAM Range;
+PROCEDURE Store(x : REAL);
+VAR k : INTEGER;
+BEGIN
+   k := x
+END;
+BEGIN
+   Store(1.0);
+   Store(3000000000.0)
+END.
+`, "", "Runtime error 201 at line 6 in STORE called from Global: range check error, 3000000000 is out of INTEGER range\n", true},
+	{"recursion.pas", `{$Q+}
+PROGRAM Recursion;
+PROCEDURE Down(n, x : INTEGER);
+BEGIN
+   IF n = 0 THEN WRITELN(x * x) ELSE Down(n - 1, x)
+END;
+BEGIN
+   Down(2, 3);
+   Down(2, 100000)
+END.
+`, "9\n", "Runtime error 215 at line 5 in DOWN called from DOWN called from DOWN called from Global: arithmetic overflow, 10000000000 does not fit in INTEGER\n", true},
+	{"nil.pas", `PROGRAM Empty;
+TYPE TBox = CLASS
+   v : INTEGER;
+   PROCEDURE Show;
+END;
+PROCEDURE TBox.Show;
+BEGIN
+   WRITELN(v)
+END;
+PROCEDURE Use(b : TBox);
+BEGIN
+   b.Show
+END;
+VAR b : TBox;
+BEGIN
+   Use(b)
+END.
+`, "", "Runtime error 216 at line 12 in USE called from Global: nil dereference, SHOW used on NIL\n", false},
+}
+
+func TestRuntimeErrorStacks(t *testing.T) {
+	for _, program := range runtime_error_programs {
+		path := write_program(t, program.name, program.source)
+		t.Run(program.name, func(t *testing.T) {
+			runs := map[string]Run{"interpreter": run_pascal(t, "", path)}
+			if program.vm == true {
+				runs["vm"] = run_pascal(t, "", "-vm", path)
+			}
+			for name, run := range runs {
+				if run.stdout != program.stdout || run.stderr != program.stderr || run.code != 255 {
+					t.Errorf("%s: exit %d with %q, stderr\n%s\nwant\n%s", name, run.code, run.stdout, run.stderr, program.stderr)
+				}
+			}
+		})
+	}
+}
+
+/* An error in a procedure from an include file gives that file's line */
+func TestRuntimeErrorInInclude(t *testing.T) {
+	path := write_program(t, "main.pas", "PROGRAM Main;\n{$I divide.inc}\nBEGIN\n   Divide(0)\nEND.\n")
+	include := filepath.Join(filepath.Dir(path), "divide.inc")
+	if err := os.WriteFile(include, []byte("PROCEDURE Divide(n : INTEGER);\nBEGIN\n   WRITELN(1 DIV n)\nEND;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run := run_pascal(t, "", path)
+	want := "Runtime error 200 at line 3 of " + include + " in DIVIDE called from Global: division by zero"
+	if strings.TrimSpace(run.stderr) != want || run.code != 255 {
+		t.Errorf("exit %d, stderr\n%s\nwant\n%s", run.code, run.stderr, want)
+	}
+}
@@ -0,0 +1,174 @@
+; generated from Pascal source
+
+@var.a = internal global i64 0
+@var.b = internal global i64 0
+@var.q = internal global i64 0
+@var.r = internal global i64 0
+@var.x = internal global double 0.0
+@var.y = internal global double 0.0
+@fmt.1 = private unnamed_addr constant [62 x i8] c"%lld DIV %lld = %lld, %lld MOD %lld = %lld, %lld / %lld = %f\0A\00"
+@fmt.2 = private unnamed_addr constant [36 x i8] c"q = %lld, r = %lld, x = %f, y = %f\0A\00"
+@fmt.3 = private unnamed_addr constant [17 x i8] c"it's 100%% done\0A\00"
+@runtime.200 = private unnamed_addr constant [17 x i8] c"division by zero\00"
+
+declare i32 @printf(ptr, ...)
+
+declare i32 @fflush(ptr)
+declare i32 @dprintf(i32, ptr, ...)
+declare void @exit(i32)
+
+@runtime.format = private unnamed_addr constant [33 x i8] c"Runtime error %d at line %d: %s\0A\00"
+
+define internal void @runtime_error(i32 %code, i32 %line, ptr %message) {
+entry:
+  %t1 = call i32 @fflush(ptr null)
+  %t2 = call i32 (i32, ptr, ...) @dprintf(i32 2, ptr @runtime.format, i32 %code, i32 %line, ptr %message)
+  call void @exit(i32 255)
+  unreachable
+}
+
+define internal void @proc.show(i64 %arg.n, i64 %arg.d) {
+entry:
+  %var.n = alloca i64
+  %var.d = alloca i64
+  %var.quotient = alloca double
+  store i64 %arg.n, ptr %var.n
+  store i64 %arg.d, ptr %var.d
+  store double 0.0, ptr %var.quotient
+  %t1 = load i64, ptr %var.n
+  %t2 = sitofp i64 %t1 to double
+  %t3 = load i64, ptr %var.d
+  %t4 = sitofp i64 %t3 to double
+  %t5 = fcmp oeq double %t4, 0.0
+  br i1 %t5, label %error1, label %error1.ok
+error1:
+  call void @runtime_error(i32 200, i32 10, ptr @runtime.200)
+  unreachable
+error1.ok:
+  %t6 = fdiv double %t2, %t4
+  store double %t6, ptr %var.quotient
+  %t7 = load i64, ptr %var.n
+  %t8 = load i64, ptr %var.d
+  %t9 = load i64, ptr %var.n
+  %t10 = load i64, ptr %var.d
+  %t11 = icmp eq i64 %t10, 0
+  br i1 %t11, label %error2, label %error2.ok
+error2:
+  call void @runtime_error(i32 200, i32 11, ptr @runtime.200)
+  unreachable
+error2.ok:
+  %t12 = sdiv i64 %t9, %t10
+  %t13 = trunc i64 %t12 to i32
+  %t14 = sext i32 %t13 to i64
+  %t15 = load i64, ptr %var.n
+  %t16 = load i64, ptr %var.d
+  %t17 = load i64, ptr %var.n
+  %t18 = load i64, ptr %var.d
+  %t19 = icmp eq i64 %t18, 0
+  br i1 %t19, label %error3, label %error3.ok
+error3:
+  call void @runtime_error(i32 200, i32 11, ptr @runtime.200)
+  unreachable
+error3.ok:
+  %t20 = srem i64 %t17, %t18
+  %t21 = trunc i64 %t20 to i32
+  %t22 = sext i32 %t21 to i64
+  %t23 = load i64, ptr %var.n
+  %t24 = load i64, ptr %var.d
+  %t25 = load double, ptr %var.quotient
+  %t26 = call i32 (ptr, ...) @printf(ptr @fmt.1, i64 %t7, i64 %t8, i64 %t14, i64 %t15, i64 %t16, i64 %t22, i64 %t23, i64 %t24, double %t25)
+  ret void
+}
+
+define i32 @main() {
+entry:
+  store i64 17, ptr @var.a
+  store i64 5, ptr @var.b
+  %t1 = load i64, ptr @var.a
+  %t2 = load i64, ptr @var.b
+  call void @proc.show(i64 %t1, i64 %t2)
+  %t3 = load i64, ptr @var.a
+  %t4 = sub i64 0, %t3
+  %t5 = trunc i64 %t4 to i32
+  %t6 = sext i32 %t5 to i64
+  %t7 = load i64, ptr @var.b
+  call void @proc.show(i64 %t6, i64 %t7)
+  %t8 = load i64, ptr @var.a
+  %t9 = load i64, ptr @var.b
+  %t10 = sub i64 0, %t9
+  %t11 = trunc i64 %t10 to i32
+  %t12 = sext i32 %t11 to i64
+  call void @proc.show(i64 %t8, i64 %t12)
+  %t13 = load i64, ptr @var.a
+  %t14 = sub i64 0, %t13
+  %t15 = trunc i64 %t14 to i32
+  %t16 = sext i32 %t15 to i64
+  %t17 = load i64, ptr @var.b
+  %t18 = sub i64 0, %t17
+  %t19 = trunc i64 %t18 to i32
+  %t20 = sext i32 %t19 to i64
+  call void @proc.show(i64 %t16, i64 %t20)
+  %t21 = load i64, ptr @var.a
+  %t22 = load i64, ptr @var.b
+  %t23 = icmp eq i64 %t22, 0
+  br i1 %t23, label %error4, label %error4.ok
+error4:
+  call void @runtime_error(i32 200, i32 21, ptr @runtime.200)
+  unreachable
+error4.ok:
+  %t24 = sdiv i64 %t21, %t22
+  %t25 = trunc i64 %t24 to i32
+  %t26 = sext i32 %t25 to i64
+  %t27 = load i64, ptr @var.b
+  %t28 = mul i64 %t26, %t27
+  %t29 = trunc i64 %t28 to i32
+  %t30 = sext i32 %t29 to i64
+  %t31 = load i64, ptr @var.a
+  %t32 = load i64, ptr @var.b
+  %t33 = icmp eq i64 %t32, 0
+  br i1 %t33, label %error5, label %error5.ok
+error5:
+  call void @runtime_error(i32 200, i32 21, ptr @runtime.200)
+  unreachable
+error5.ok:
+  %t34 = srem i64 %t31, %t32
+  %t35 = trunc i64 %t34 to i32
+  %t36 = sext i32 %t35 to i64
+  %t37 = add i64 %t30, %t36
+  %t38 = trunc i64 %t37 to i32
+  %t39 = sext i32 %t38 to i64
+  store i64 %t39, ptr @var.q
+  %t40 = load i64, ptr @var.a
+  %t41 = load i64, ptr @var.b
+  %t42 = sub i64 0, %t41
+  %t43 = trunc i64 %t42 to i32
+  %t44 = sext i32 %t43 to i64
+  %t45 = sub i64 %t40, %t44
+  %t46 = trunc i64 %t45 to i32
+  %t47 = sext i32 %t46 to i64
+  store i64 %t47, ptr @var.r
+  %t48 = icmp eq i64 7, 0
+  br i1 %t48, label %error6, label %error6.ok
+error6:
+  call void @runtime_error(i32 200, i32 23, ptr @runtime.200)
+  unreachable
+error6.ok:
+  %t49 = sdiv i64 20, 7
+  %t50 = trunc i64 %t49 to i32
+  %t51 = sext i32 %t50 to i64
+  %t52 = sitofp i64 %t51 to double
+  %t53 = fadd double %t52, 0x40091EB851EB851F
+  store double %t53, ptr @var.x
+  %t54 = load double, ptr @var.x
+  %t55 = fneg double %t54
+  %t56 = sitofp i64 2 to double
+  %t57 = fmul double %t55, %t56
+  store double %t57, ptr @var.y
+  %t58 = load i64, ptr @var.q
+  %t59 = load i64, ptr @var.r
+  %t60 = load double, ptr @var.x
+  %t61 = load double, ptr @var.y
+  %t62 = call i32 (ptr, ...) @printf(ptr @fmt.2, i64 %t58, i64 %t59, double %t60, double %t61)
+  %t63 = call i32 (ptr, ...) @printf(ptr @fmt.3)
+  ret i32 0
+}
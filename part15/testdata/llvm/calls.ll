@@ -0,0 +1,50 @@
+; generated from Pascal source
+
+@var.a = internal global i64 0
+@var.total = internal global i64 0
+@fmt.1 = private unnamed_addr constant [14 x i8] c"total = %lld\0A\00"
+
+declare i32 @printf(ptr, ...)
+
+define internal void @proc.add(i64 %arg.x) {
+entry:
+  %var.x = alloca i64
+  store i64 %arg.x, ptr %var.x
+  %t1 = load i64, ptr @var.total
+  %t2 = load i64, ptr %var.x
+  %t3 = add i64 %t1, %t2
+  %t4 = trunc i64 %t3 to i32
+  %t5 = sext i32 %t4 to i64
+  store i64 %t5, ptr @var.total
+  ret void
+}
+
+define internal void @proc.twice(i64 %arg.y) {
+entry:
+  %var.y = alloca i64
+  %var.a = alloca i64
+  store i64 %arg.y, ptr %var.y
+  store i64 0, ptr %var.a
+  %t1 = load i64, ptr %var.y
+  %t2 = mul i64 %t1, 2
+  %t3 = trunc i64 %t2 to i32
+  %t4 = sext i32 %t3 to i64
+  store i64 %t4, ptr %var.a
+  %t5 = load i64, ptr %var.a
+  call void @proc.add(i64 %t5)
+  %t6 = load i64, ptr %var.a
+  call void @proc.add(i64 %t6)
+  ret void
+}
+
+define i32 @main() {
+entry:
+  store i64 5, ptr @var.a
+  store i64 0, ptr @var.total
+  %t1 = load i64, ptr @var.a
+  call void @proc.twice(i64 %t1)
+  call void @proc.add(i64 1)
+  %t2 = load i64, ptr @var.total
+  %t3 = call i32 (ptr, ...) @printf(ptr @fmt.1, i64 %t2)
+  ret i32 0
+}
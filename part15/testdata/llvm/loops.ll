@@ -0,0 +1,132 @@
+; generated from Pascal source
+
+@var.i = internal global i64 0
+@var.j = internal global i64 0
+@var.sum = internal global i64 0
+@var.count = internal global i64 0
+@var.mean = internal global double 0.0
+@fmt.1 = private unnamed_addr constant [35 x i8] c"sum = %lld count = %lld mean = %f\0A\00"
+@runtime.200 = private unnamed_addr constant [17 x i8] c"division by zero\00"
+
+declare i32 @printf(ptr, ...)
+
+declare i32 @fflush(ptr)
+declare i32 @dprintf(i32, ptr, ...)
+declare void @exit(i32)
+
+@runtime.format = private unnamed_addr constant [33 x i8] c"Runtime error %d at line %d: %s\0A\00"
+
+define internal void @runtime_error(i32 %code, i32 %line, ptr %message) {
+entry:
+  %t1 = call i32 @fflush(ptr null)
+  %t2 = call i32 (i32, ptr, ...) @dprintf(i32 2, ptr @runtime.format, i32 %code, i32 %line, ptr %message)
+  call void @exit(i32 255)
+  unreachable
+}
+
+define internal void @proc.accumulate(i64 %arg.n) {
+entry:
+  %var.n = alloca i64
+  %var.k = alloca i64
+  store i64 %arg.n, ptr %var.n
+  store i64 0, ptr %var.k
+  store i64 0, ptr %var.k
+  br label %while1.cond
+while1.cond:
+  %t1 = load i64, ptr %var.k
+  %t2 = load i64, ptr %var.n
+  %t3 = icmp slt i64 %t1, %t2
+  br i1 %t3, label %while1.body, label %while1.end
+while1.body:
+  %t4 = load i64, ptr @var.sum
+  %t5 = load i64, ptr %var.k
+  %t6 = load i64, ptr %var.k
+  %t7 = mul i64 %t5, %t6
+  %t8 = trunc i64 %t7 to i32
+  %t9 = sext i32 %t8 to i64
+  %t10 = add i64 %t4, %t9
+  %t11 = trunc i64 %t10 to i32
+  %t12 = sext i32 %t11 to i64
+  %t13 = load i64, ptr %var.k
+  %t14 = icmp eq i64 3, 0
+  br i1 %t14, label %error2, label %error2.ok
+error2:
+  call void @runtime_error(i32 200, i32 13, ptr @runtime.200)
+  unreachable
+error2.ok:
+  %t15 = sdiv i64 %t13, 3
+  %t16 = trunc i64 %t15 to i32
+  %t17 = sext i32 %t16 to i64
+  %t18 = sub i64 %t12, %t17
+  %t19 = trunc i64 %t18 to i32
+  %t20 = sext i32 %t19 to i64
+  store i64 %t20, ptr @var.sum
+  %t21 = load i64, ptr %var.k
+  %t22 = add i64 %t21, 1
+  %t23 = trunc i64 %t22 to i32
+  %t24 = sext i32 %t23 to i64
+  store i64 %t24, ptr %var.k
+  br label %while1.cond
+while1.end:
+  %t25 = load i64, ptr @var.count
+  %t26 = load i64, ptr %var.n
+  %t27 = add i64 %t25, %t26
+  %t28 = trunc i64 %t27 to i32
+  %t29 = sext i32 %t28 to i64
+  store i64 %t29, ptr @var.count
+  ret void
+}
+
+define i32 @main() {
+entry:
+  store i64 0, ptr @var.sum
+  store i64 0, ptr @var.count
+  store i64 0, ptr @var.i
+  br label %while3.cond
+while3.cond:
+  %t1 = load i64, ptr @var.i
+  %t2 = icmp slt i64 %t1, 200
+  br i1 %t2, label %while3.body, label %while3.end
+while3.body:
+  store i64 0, ptr @var.j
+  br label %while4.cond
+while4.cond:
+  %t3 = load i64, ptr @var.j
+  %t4 = load i64, ptr @var.i
+  %t5 = icmp sle i64 %t3, %t4
+  br i1 %t5, label %while4.body, label %while4.end
+while4.body:
+  %t6 = load i64, ptr @var.j
+  %t7 = add i64 %t6, 1
+  %t8 = trunc i64 %t7 to i32
+  %t9 = sext i32 %t8 to i64
+  store i64 %t9, ptr @var.j
+  br label %while4.cond
+while4.end:
+  %t10 = load i64, ptr @var.j
+  call void @proc.accumulate(i64 %t10)
+  %t11 = load i64, ptr @var.i
+  %t12 = add i64 %t11, 1
+  %t13 = trunc i64 %t12 to i32
+  %t14 = sext i32 %t13 to i64
+  store i64 %t14, ptr @var.i
+  br label %while3.cond
+while3.end:
+  %t15 = load i64, ptr @var.sum
+  %t16 = sitofp i64 %t15 to double
+  %t17 = load i64, ptr @var.count
+  %t18 = sitofp i64 %t17 to double
+  %t19 = fcmp oeq double %t18, 0.0
+  br i1 %t19, label %error5, label %error5.ok
+error5:
+  call void @runtime_error(i32 200, i32 31, ptr @runtime.200)
+  unreachable
+error5.ok:
+  %t20 = fdiv double %t16, %t18
+  store double %t20, ptr @var.mean
+  %t21 = load i64, ptr @var.sum
+  %t22 = load i64, ptr @var.count
+  %t23 = load double, ptr @var.mean
+  %t24 = call i32 (ptr, ...) @printf(ptr @fmt.1, i64 %t21, i64 %t22, double %t23)
+  ret i32 0
+}
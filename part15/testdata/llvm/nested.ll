@@ -0,0 +1,129 @@
+; generated from Pascal source
+
+%env.p1.p2 = type { ptr, ptr, ptr }
+%env.p1 = type { ptr, ptr, ptr }
+@var.a = internal global i64 0
+@var.depth = internal global i64 0
+@fmt.1 = private unnamed_addr constant [36 x i8] c"P2 m = %lld sees a = %lld b = %lld\0A\00"
+@fmt.2 = private unnamed_addr constant [28 x i8] c"P1 n = %lld keeps a = %lld\0A\00"
+@fmt.3 = private unnamed_addr constant [30 x i8] c"global a = %lld depth = %lld\0A\00"
+
+declare i32 @printf(ptr, ...)
+
+define internal void @proc.p1.p2.p3(ptr %link) {
+entry:
+  %var.a = alloca i64
+  store i64 0, ptr %var.a
+  store i64 1000, ptr %var.a
+  %t1 = getelementptr %env.p1.p2, ptr %link, i32 0, i32 2
+  %t2 = load ptr, ptr %t1
+  %t3 = load i64, ptr %t2
+  %t4 = load i64, ptr %var.a
+  %t5 = add i64 %t3, %t4
+  %t6 = trunc i64 %t5 to i32
+  %t7 = sext i32 %t6 to i64
+  %t8 = getelementptr %env.p1.p2, ptr %link, i32 0, i32 1
+  %t9 = load ptr, ptr %t8
+  %t10 = load i64, ptr %t9
+  %t11 = add i64 %t7, %t10
+  %t12 = trunc i64 %t11 to i32
+  %t13 = sext i32 %t12 to i64
+  %t14 = getelementptr %env.p1.p2, ptr %link, i32 0, i32 2
+  %t15 = load ptr, ptr %t14
+  store i64 %t13, ptr %t15
+  %t16 = load i64, ptr @var.depth
+  %t17 = add i64 %t16, 1
+  %t18 = trunc i64 %t17 to i32
+  %t19 = sext i32 %t18 to i64
+  store i64 %t19, ptr @var.depth
+  ret void
+}
+
+define internal void @proc.p1.p2(ptr %link, i64 %arg.m) {
+entry:
+  %var.m = alloca i64
+  %var.b = alloca i64
+  store i64 %arg.m, ptr %var.m
+  store i64 0, ptr %var.b
+  %env = alloca %env.p1.p2
+  %env.link = getelementptr %env.p1.p2, ptr %env, i32 0, i32 0
+  store ptr %link, ptr %env.link
+  %t1 = getelementptr %env.p1.p2, ptr %env, i32 0, i32 1
+  store ptr %var.m, ptr %t1
+  %t2 = getelementptr %env.p1.p2, ptr %env, i32 0, i32 2
+  store ptr %var.b, ptr %t2
+  %t3 = getelementptr %env.p1, ptr %link, i32 0, i32 2
+  %t4 = load ptr, ptr %t3
+  %t5 = load i64, ptr %t4
+  %t6 = mul i64 %t5, 10
+  %t7 = trunc i64 %t6 to i32
+  %t8 = sext i32 %t7 to i64
+  store i64 %t8, ptr %var.b
+  call void @proc.p1.p2.p3(ptr %env)
+  %t9 = load i64, ptr %var.m
+  %t10 = icmp sgt i64 %t9, 0
+  br i1 %t10, label %if1.then, label %if1.else
+if1.then:
+  %t11 = load i64, ptr %var.m
+  %t12 = sub i64 %t11, 1
+  %t13 = trunc i64 %t12 to i32
+  %t14 = sext i32 %t13 to i64
+  call void @proc.p1.p2(ptr %link, i64 %t14)
+  br label %if1.end
+if1.else:
+  br label %if1.end
+if1.end:
+  %t15 = load i64, ptr %var.m
+  %t16 = getelementptr %env.p1, ptr %link, i32 0, i32 2
+  %t17 = load ptr, ptr %t16
+  %t18 = load i64, ptr %t17
+  %t19 = load i64, ptr %var.b
+  %t20 = call i32 (ptr, ...) @printf(ptr @fmt.1, i64 %t15, i64 %t18, i64 %t19)
+  ret void
+}
+
+define internal void @proc.p1(i64 %arg.n) {
+entry:
+  %var.n = alloca i64
+  %var.a = alloca i64
+  store i64 %arg.n, ptr %var.n
+  store i64 0, ptr %var.a
+  %env = alloca %env.p1
+  %env.link = getelementptr %env.p1, ptr %env, i32 0, i32 0
+  store ptr null, ptr %env.link
+  %t1 = getelementptr %env.p1, ptr %env, i32 0, i32 1
+  store ptr %var.n, ptr %t1
+  %t2 = getelementptr %env.p1, ptr %env, i32 0, i32 2
+  store ptr %var.a, ptr %t2
+  %t3 = load i64, ptr %var.n
+  store i64 %t3, ptr %var.a
+  %t4 = load i64, ptr %var.n
+  %t5 = icmp sgt i64 %t4, 1
+  br i1 %t5, label %if2.then, label %if2.else
+if2.then:
+  %t6 = load i64, ptr %var.n
+  %t7 = sub i64 %t6, 1
+  %t8 = trunc i64 %t7 to i32
+  %t9 = sext i32 %t8 to i64
+  call void @proc.p1(i64 %t9)
+  br label %if2.end
+if2.else:
+  br label %if2.end
+if2.end:
+  call void @proc.p1.p2(ptr %env, i64 1)
+  %t10 = load i64, ptr %var.n
+  %t11 = load i64, ptr %var.a
+  %t12 = call i32 (ptr, ...) @printf(ptr @fmt.2, i64 %t10, i64 %t11)
+  ret void
+}
+
+define i32 @main() {
+entry:
+  store i64 7, ptr @var.a
+  store i64 0, ptr @var.depth
+  call void @proc.p1(i64 3)
+  %t1 = load i64, ptr @var.a
+  %t2 = load i64, ptr @var.depth
+  %t3 = call i32 (ptr, ...) @printf(ptr @fmt.3, i64 %t1, i64 %t2)
+  ret i32 0
+}
@@ -0,0 +1,260 @@
+(module
+  (import "host" "write_str" (func $write_str (param i32 i32)))
+  (import "host" "write_int" (func $write_int (param i64)))
+  (import "host" "write_real" (func $write_real (param f64)))
+  (import "host" "writeln" (func $writeln))
+  (import "host" "readln" (func $readln (result i64)))
+  (memory (export "memory") 16)
+  (global $sp (mut i32) (i32.const 56))
+  (data (i32.const 0) " DIV  = ,  MOD  / q = , r = , x = , y = it's 100% done")
+  (func $proc.show (param $link i32) (param $arg.n i64) (param $arg.d i64)
+    (local $fp i32)
+    global.get $sp
+    local.set $fp
+    global.get $sp
+    i32.const 32
+    i32.add
+    global.set $sp
+    local.get $fp
+    local.get $link
+    i32.store
+    local.get $fp
+    i64.const 0
+    i64.store offset=8
+    local.get $fp
+    i64.const 0
+    i64.store offset=16
+    local.get $fp
+    i64.const 0
+    i64.store offset=24
+    local.get $fp
+    local.get $arg.n
+    i64.store offset=8
+    local.get $fp
+    local.get $arg.d
+    i64.store offset=16
+    local.get $fp
+    local.get $fp
+    i64.load offset=8
+    f64.convert_i64_s
+    local.get $fp
+    i64.load offset=16
+    f64.convert_i64_s
+    f64.div
+    f64.store offset=24
+    local.get $fp
+    i64.load offset=8
+    call $write_int
+    i32.const 0
+    i32.const 5
+    call $write_str
+    local.get $fp
+    i64.load offset=16
+    call $write_int
+    i32.const 5
+    i32.const 3
+    call $write_str
+    local.get $fp
+    i64.load offset=8
+    local.get $fp
+    i64.load offset=16
+    i64.div_s
+    i64.extend32_s
+    call $write_int
+    i32.const 8
+    i32.const 2
+    call $write_str
+    local.get $fp
+    i64.load offset=8
+    call $write_int
+    i32.const 10
+    i32.const 5
+    call $write_str
+    local.get $fp
+    i64.load offset=16
+    call $write_int
+    i32.const 5
+    i32.const 3
+    call $write_str
+    local.get $fp
+    i64.load offset=8
+    local.get $fp
+    i64.load offset=16
+    i64.rem_s
+    i64.extend32_s
+    call $write_int
+    i32.const 8
+    i32.const 2
+    call $write_str
+    local.get $fp
+    i64.load offset=8
+    call $write_int
+    i32.const 15
+    i32.const 3
+    call $write_str
+    local.get $fp
+    i64.load offset=16
+    call $write_int
+    i32.const 5
+    i32.const 3
+    call $write_str
+    local.get $fp
+    f64.load offset=24
+    call $write_real
+    call $writeln
+    local.get $fp
+    global.set $sp
+  )
+  (func $main
+    (local $fp i32)
+    global.get $sp
+    local.set $fp
+    global.get $sp
+    i32.const 56
+    i32.add
+    global.set $sp
+    local.get $fp
+    i32.const 0
+    i32.store
+    local.get $fp
+    i64.const 0
+    i64.store offset=8
+    local.get $fp
+    i64.const 0
+    i64.store offset=16
+    local.get $fp
+    i64.const 0
+    i64.store offset=24
+    local.get $fp
+    i64.const 0
+    i64.store offset=32
+    local.get $fp
+    i64.const 0
+    i64.store offset=40
+    local.get $fp
+    i64.const 0
+    i64.store offset=48
+    local.get $fp
+    i64.const 17
+    i64.store offset=8
+    local.get $fp
+    i64.const 5
+    i64.store offset=16
+    local.get $fp
+    local.get $fp
+    i64.load offset=8
+    local.get $fp
+    i64.load offset=16
+    call $proc.show
+    local.get $fp
+    i64.const 0
+    local.get $fp
+    i64.load offset=8
+    i64.sub
+    i64.extend32_s
+    local.get $fp
+    i64.load offset=16
+    call $proc.show
+    local.get $fp
+    local.get $fp
+    i64.load offset=8
+    i64.const 0
+    local.get $fp
+    i64.load offset=16
+    i64.sub
+    i64.extend32_s
+    call $proc.show
+    local.get $fp
+    i64.const 0
+    local.get $fp
+    i64.load offset=8
+    i64.sub
+    i64.extend32_s
+    i64.const 0
+    local.get $fp
+    i64.load offset=16
+    i64.sub
+    i64.extend32_s
+    call $proc.show
+    local.get $fp
+    local.get $fp
+    i64.load offset=8
+    local.get $fp
+    i64.load offset=16
+    i64.div_s
+    i64.extend32_s
+    local.get $fp
+    i64.load offset=16
+    i64.mul
+    i64.extend32_s
+    local.get $fp
+    i64.load offset=8
+    local.get $fp
+    i64.load offset=16
+    i64.rem_s
+    i64.extend32_s
+    i64.add
+    i64.extend32_s
+    i64.store offset=24
+    local.get $fp
+    local.get $fp
+    i64.load offset=8
+    i64.const 0
+    local.get $fp
+    i64.load offset=16
+    i64.sub
+    i64.extend32_s
+    i64.sub
+    i64.extend32_s
+    i64.store offset=32
+    local.get $fp
+    i64.const 20
+    i64.const 7
+    i64.div_s
+    i64.extend32_s
+    f64.convert_i64_s
+    f64.const 3.14
+    f64.add
+    f64.store offset=40
+    local.get $fp
+    local.get $fp
+    f64.load offset=40
+    f64.neg
+    i64.const 2
+    f64.convert_i64_s
+    f64.mul
+    f64.store offset=48
+    i32.const 18
+    i32.const 4
+    call $write_str
+    local.get $fp
+    i64.load offset=24
+    call $write_int
+    i32.const 22
+    i32.const 6
+    call $write_str
+    local.get $fp
+    i64.load offset=32
+    call $write_int
+    i32.const 28
+    i32.const 6
+    call $write_str
+    local.get $fp
+    f64.load offset=40
+    call $write_real
+    i32.const 34
+    i32.const 6
+    call $write_str
+    local.get $fp
+    f64.load offset=48
+    call $write_real
+    call $writeln
+    i32.const 40
+    i32.const 14
+    call $write_str
+    call $writeln
+    local.get $fp
+    global.set $sp
+  )
+  (export "main" (func $main))
+)
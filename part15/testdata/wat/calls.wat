@@ -0,0 +1,121 @@
+(module
+  (import "host" "write_str" (func $write_str (param i32 i32)))
+  (import "host" "write_int" (func $write_int (param i64)))
+  (import "host" "write_real" (func $write_real (param f64)))
+  (import "host" "writeln" (func $writeln))
+  (import "host" "readln" (func $readln (result i64)))
+  (memory (export "memory") 16)
+  (global $sp (mut i32) (i32.const 8))
+  (data (i32.const 0) "total = ")
+  (func $proc.add (param $link i32) (param $arg.x i64)
+    (local $fp i32)
+    global.get $sp
+    local.set $fp
+    global.get $sp
+    i32.const 16
+    i32.add
+    global.set $sp
+    local.get $fp
+    local.get $link
+    i32.store
+    local.get $fp
+    i64.const 0
+    i64.store offset=8
+    local.get $fp
+    local.get $arg.x
+    i64.store offset=8
+    local.get $fp
+    i32.load
+    local.get $fp
+    i32.load
+    i64.load offset=16
+    local.get $fp
+    i64.load offset=8
+    i64.add
+    i64.extend32_s
+    i64.store offset=16
+    local.get $fp
+    global.set $sp
+  )
+  (func $proc.twice (param $link i32) (param $arg.y i64)
+    (local $fp i32)
+    global.get $sp
+    local.set $fp
+    global.get $sp
+    i32.const 24
+    i32.add
+    global.set $sp
+    local.get $fp
+    local.get $link
+    i32.store
+    local.get $fp
+    i64.const 0
+    i64.store offset=8
+    local.get $fp
+    i64.const 0
+    i64.store offset=16
+    local.get $fp
+    local.get $arg.y
+    i64.store offset=8
+    local.get $fp
+    local.get $fp
+    i64.load offset=8
+    i64.const 2
+    i64.mul
+    i64.extend32_s
+    i64.store offset=16
+    local.get $fp
+    i32.load
+    local.get $fp
+    i64.load offset=16
+    call $proc.add
+    local.get $fp
+    i32.load
+    local.get $fp
+    i64.load offset=16
+    call $proc.add
+    local.get $fp
+    global.set $sp
+  )
+  (func $main
+    (local $fp i32)
+    global.get $sp
+    local.set $fp
+    global.get $sp
+    i32.const 24
+    i32.add
+    global.set $sp
+    local.get $fp
+    i32.const 0
+    i32.store
+    local.get $fp
+    i64.const 0
+    i64.store offset=8
+    local.get $fp
+    i64.const 0
+    i64.store offset=16
+    local.get $fp
+    i64.const 5
+    i64.store offset=8
+    local.get $fp
+    i64.const 0
+    i64.store offset=16
+    local.get $fp
+    local.get $fp
+    i64.load offset=8
+    call $proc.twice
+    local.get $fp
+    i64.const 1
+    call $proc.add
+    i32.const 0
+    i32.const 8
+    call $write_str
+    local.get $fp
+    i64.load offset=16
+    call $write_int
+    call $writeln
+    local.get $fp
+    global.set $sp
+  )
+  (export "main" (func $main))
+)
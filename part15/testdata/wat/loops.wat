@@ -0,0 +1,197 @@
+(module
+  (import "host" "write_str" (func $write_str (param i32 i32)))
+  (import "host" "write_int" (func $write_int (param i64)))
+  (import "host" "write_real" (func $write_real (param f64)))
+  (import "host" "writeln" (func $writeln))
+  (import "host" "readln" (func $readln (result i64)))
+  (memory (export "memory") 16)
+  (global $sp (mut i32) (i32.const 24))
+  (data (i32.const 0) "sum =  count =  mean = ")
+  (func $proc.accumulate (param $link i32) (param $arg.n i64)
+    (local $fp i32)
+    global.get $sp
+    local.set $fp
+    global.get $sp
+    i32.const 24
+    i32.add
+    global.set $sp
+    local.get $fp
+    local.get $link
+    i32.store
+    local.get $fp
+    i64.const 0
+    i64.store offset=8
+    local.get $fp
+    i64.const 0
+    i64.store offset=16
+    local.get $fp
+    local.get $arg.n
+    i64.store offset=8
+    local.get $fp
+    i64.const 0
+    i64.store offset=16
+    block $exit1
+      loop $loop1
+        local.get $fp
+        i64.load offset=16
+        local.get $fp
+        i64.load offset=8
+        i64.lt_s
+        i32.eqz
+        br_if $exit1
+        local.get $fp
+        i32.load
+        local.get $fp
+        i32.load
+        i64.load offset=24
+        local.get $fp
+        i64.load offset=16
+        local.get $fp
+        i64.load offset=16
+        i64.mul
+        i64.extend32_s
+        i64.add
+        i64.extend32_s
+        local.get $fp
+        i64.load offset=16
+        i64.const 3
+        i64.div_s
+        i64.extend32_s
+        i64.sub
+        i64.extend32_s
+        i64.store offset=24
+        local.get $fp
+        local.get $fp
+        i64.load offset=16
+        i64.const 1
+        i64.add
+        i64.extend32_s
+        i64.store offset=16
+        br $loop1
+      end
+    end
+    local.get $fp
+    i32.load
+    local.get $fp
+    i32.load
+    i64.load offset=32
+    local.get $fp
+    i64.load offset=8
+    i64.add
+    i64.extend32_s
+    i64.store offset=32
+    local.get $fp
+    global.set $sp
+  )
+  (func $main
+    (local $fp i32)
+    global.get $sp
+    local.set $fp
+    global.get $sp
+    i32.const 48
+    i32.add
+    global.set $sp
+    local.get $fp
+    i32.const 0
+    i32.store
+    local.get $fp
+    i64.const 0
+    i64.store offset=8
+    local.get $fp
+    i64.const 0
+    i64.store offset=16
+    local.get $fp
+    i64.const 0
+    i64.store offset=24
+    local.get $fp
+    i64.const 0
+    i64.store offset=32
+    local.get $fp
+    i64.const 0
+    i64.store offset=40
+    local.get $fp
+    i64.const 0
+    i64.store offset=24
+    local.get $fp
+    i64.const 0
+    i64.store offset=32
+    local.get $fp
+    i64.const 0
+    i64.store offset=8
+    block $exit2
+      loop $loop2
+        local.get $fp
+        i64.load offset=8
+        i64.const 200
+        i64.lt_s
+        i32.eqz
+        br_if $exit2
+        local.get $fp
+        i64.const 0
+        i64.store offset=16
+        block $exit3
+          loop $loop3
+            local.get $fp
+            i64.load offset=16
+            local.get $fp
+            i64.load offset=8
+            i64.le_s
+            i32.eqz
+            br_if $exit3
+            local.get $fp
+            local.get $fp
+            i64.load offset=16
+            i64.const 1
+            i64.add
+            i64.extend32_s
+            i64.store offset=16
+            br $loop3
+          end
+        end
+        local.get $fp
+        local.get $fp
+        i64.load offset=16
+        call $proc.accumulate
+        local.get $fp
+        local.get $fp
+        i64.load offset=8
+        i64.const 1
+        i64.add
+        i64.extend32_s
+        i64.store offset=8
+        br $loop2
+      end
+    end
+    local.get $fp
+    local.get $fp
+    i64.load offset=24
+    f64.convert_i64_s
+    local.get $fp
+    i64.load offset=32
+    f64.convert_i64_s
+    f64.div
+    f64.store offset=40
+    i32.const 0
+    i32.const 6
+    call $write_str
+    local.get $fp
+    i64.load offset=24
+    call $write_int
+    i32.const 6
+    i32.const 9
+    call $write_str
+    local.get $fp
+    i64.load offset=32
+    call $write_int
+    i32.const 15
+    i32.const 8
+    call $write_str
+    local.get $fp
+    f64.load offset=40
+    call $write_real
+    call $writeln
+    local.get $fp
+    global.set $sp
+  )
+  (export "main" (func $main))
+)
@@ -0,0 +1,225 @@
+(module
+  (import "host" "write_str" (func $write_str (param i32 i32)))
+  (import "host" "write_int" (func $write_int (param i64)))
+  (import "host" "write_real" (func $write_real (param f64)))
+  (import "host" "writeln" (func $writeln))
+  (import "host" "readln" (func $readln (result i64)))
+  (memory (export "memory") 16)
+  (global $sp (mut i32) (i32.const 64))
+  (data (i32.const 0) "P2 m =  sees a =  b = P1 n =  keeps a = global a =  depth = ")
+  (func $proc.p1.p2.p3 (param $link i32)
+    (local $fp i32)
+    global.get $sp
+    local.set $fp
+    global.get $sp
+    i32.const 16
+    i32.add
+    global.set $sp
+    local.get $fp
+    local.get $link
+    i32.store
+    local.get $fp
+    i64.const 0
+    i64.store offset=8
+    local.get $fp
+    i64.const 1000
+    i64.store offset=8
+    local.get $fp
+    i32.load
+    local.get $fp
+    i32.load
+    i64.load offset=16
+    local.get $fp
+    i64.load offset=8
+    i64.add
+    i64.extend32_s
+    local.get $fp
+    i32.load
+    i64.load offset=8
+    i64.add
+    i64.extend32_s
+    i64.store offset=16
+    local.get $fp
+    i32.load
+    i32.load
+    i32.load
+    local.get $fp
+    i32.load
+    i32.load
+    i32.load
+    i64.load offset=16
+    i64.const 1
+    i64.add
+    i64.extend32_s
+    i64.store offset=16
+    local.get $fp
+    global.set $sp
+  )
+  (func $proc.p1.p2 (param $link i32) (param $arg.m i64)
+    (local $fp i32)
+    global.get $sp
+    local.set $fp
+    global.get $sp
+    i32.const 24
+    i32.add
+    global.set $sp
+    local.get $fp
+    local.get $link
+    i32.store
+    local.get $fp
+    i64.const 0
+    i64.store offset=8
+    local.get $fp
+    i64.const 0
+    i64.store offset=16
+    local.get $fp
+    local.get $arg.m
+    i64.store offset=8
+    local.get $fp
+    local.get $fp
+    i32.load
+    i64.load offset=16
+    i64.const 10
+    i64.mul
+    i64.extend32_s
+    i64.store offset=16
+    local.get $fp
+    call $proc.p1.p2.p3
+    local.get $fp
+    i64.load offset=8
+    i64.const 0
+    i64.gt_s
+    if
+      local.get $fp
+      i32.load
+      local.get $fp
+      i64.load offset=8
+      i64.const 1
+      i64.sub
+      i64.extend32_s
+      call $proc.p1.p2
+    end
+    i32.const 0
+    i32.const 7
+    call $write_str
+    local.get $fp
+    i64.load offset=8
+    call $write_int
+    i32.const 7
+    i32.const 10
+    call $write_str
+    local.get $fp
+    i32.load
+    i64.load offset=16
+    call $write_int
+    i32.const 17
+    i32.const 5
+    call $write_str
+    local.get $fp
+    i64.load offset=16
+    call $write_int
+    call $writeln
+    local.get $fp
+    global.set $sp
+  )
+  (func $proc.p1 (param $link i32) (param $arg.n i64)
+    (local $fp i32)
+    global.get $sp
+    local.set $fp
+    global.get $sp
+    i32.const 24
+    i32.add
+    global.set $sp
+    local.get $fp
+    local.get $link
+    i32.store
+    local.get $fp
+    i64.const 0
+    i64.store offset=8
+    local.get $fp
+    i64.const 0
+    i64.store offset=16
+    local.get $fp
+    local.get $arg.n
+    i64.store offset=8
+    local.get $fp
+    local.get $fp
+    i64.load offset=8
+    i64.store offset=16
+    local.get $fp
+    i64.load offset=8
+    i64.const 1
+    i64.gt_s
+    if
+      local.get $fp
+      i32.load
+      local.get $fp
+      i64.load offset=8
+      i64.const 1
+      i64.sub
+      i64.extend32_s
+      call $proc.p1
+    end
+    local.get $fp
+    i64.const 1
+    call $proc.p1.p2
+    i32.const 22
+    i32.const 7
+    call $write_str
+    local.get $fp
+    i64.load offset=8
+    call $write_int
+    i32.const 29
+    i32.const 11
+    call $write_str
+    local.get $fp
+    i64.load offset=16
+    call $write_int
+    call $writeln
+    local.get $fp
+    global.set $sp
+  )
+  (func $main
+    (local $fp i32)
+    global.get $sp
+    local.set $fp
+    global.get $sp
+    i32.const 24
+    i32.add
+    global.set $sp
+    local.get $fp
+    i32.const 0
+    i32.store
+    local.get $fp
+    i64.const 0
+    i64.store offset=8
+    local.get $fp
+    i64.const 0
+    i64.store offset=16
+    local.get $fp
+    i64.const 7
+    i64.store offset=8
+    local.get $fp
+    i64.const 0
+    i64.store offset=16
+    local.get $fp
+    i64.const 3
+    call $proc.p1
+    i32.const 40
+    i32.const 11
+    call $write_str
+    local.get $fp
+    i64.load offset=8
+    call $write_int
+    i32.const 51
+    i32.const 9
+    call $write_str
+    local.get $fp
+    i64.load offset=16
+    call $write_int
+    call $writeln
+    local.get $fp
+    global.set $sp
+  )
+  (export "main" (func $main))
+)
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+	TRACE
+*/
+
+const (
+	TRACE_LEX = 1 << iota
+	TRACE_PARSE
+	TRACE_SEMA
+	TRACE_EXEC
+)
+
+var trace_phases = map[string]int{
+	"lex":   TRACE_LEX,
+	"parse": TRACE_PARSE,
+	"sema":  TRACE_SEMA,
+	"exec":  TRACE_EXEC,
+}
+
+var reverse_trace_phases = map[int]string{
+	TRACE_LEX:   "lex",
+	TRACE_PARSE: "parse",
+	TRACE_SEMA:  "sema",
+	TRACE_EXEC:  "exec",
+}
+
+type TraceEvent struct {
+	Phase   string `json:"phase"`
+	Kind    string `json:"kind"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Scope   string `json:"scope,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type Tracer struct {
+	phases int
+	json   bool
+	out    io.Writer
+}
+
+/* Nothing is traced until main enables some phases */
+var tracer = &Tracer{}
+
+func parse_trace_phases(spec string) (int, error) {
+	phases := 0
+	if spec == "" {
+		return phases, nil
+	}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "all" {
+			phases |= TRACE_LEX | TRACE_PARSE | TRACE_SEMA | TRACE_EXEC
+			continue
+		}
+		phase, ok := trace_phases[name]
+		if ok == false {
+			return 0, fmt.Errorf("unknown trace phase '%s', want lex, parse, sema, exec or all", name)
+		}
+		phases |= phase
+	}
+	return phases, nil
+}
+
+func (t *Tracer) enabled(phase int) bool {
+	return t.out != nil && t.phases&phase != 0
+}
+
+func (t *Tracer) emit(phase int, kind string, token *lexemes, scope *ScopedSymbolTable, format string, args ...interface{}) {
+	if t.enabled(phase) == false {
+		return
+	}
+	event := TraceEvent{Phase: reverse_trace_phases[phase], Kind: kind}
+	if token != nil {
+		event.Line = token.line
+		event.Column = token.column_number()
+	}
+	if scope != nil {
+		event.Scope = scope.scope_name
+	}
+	if format != "" {
+		event.Message = fmt.Sprintf(format, args...)
+	}
+	if t.json == true {
+		line, _ := json.Marshal(event)
+		fmt.Fprintf(t.out, "%s\n", line)
+		return
+	}
+	repr := fmt.Sprintf("[%s] %s", event.Phase, event.Kind)
+	if token != nil {
+		repr += fmt.Sprintf(" [%d:%d]", event.Line, event.Column)
+	}
+	if event.Scope != "" {
+		repr += fmt.Sprintf(" scope=%s", event.Scope)
+	}
+	if event.Message != "" {
+		repr += " " + event.Message
+	}
+	fmt.Fprintln(t.out, repr)
+}
+
+/* Best effort source position of any tree node, used to locate trace events */
+func node_token(node interface{}) *lexemes {
+	switch v := node.(type) {
+	case *ProcedureDecl:
+		return v.token
+	case *Block:
+		for _, decl := range v.declaration_list.elem {
+			if token := node_token(decl); token != nil {
+				return token
+			}
+		}
+		return node_token(v.compound)
+	case *Compound:
+		return v.token
+	case *VarDeclaration:
+		return v.token
+	case *TypeDeclaration:
+		return v.token
+	case *Uses:
+		return v.token
+	case *Var:
+		return v.token
+	case *Assign:
+		return v.token
+	case *ProcedureCall:
+		return v.token
+	case *While:
+		return v.token
+	case *If:
+		return v.token
+	case *Try:
+		return v.token
+	case *Raise:
+		return v.token
+	case *ClassDeclaration:
+		return v.token
+	case *Designator:
+		return v.token
+	case *MemberAssign:
+		return v.token
+	case *Inherited:
+		return v.token
+	case *Nil:
+		return v.token
+	case *Index:
+		return v.token
+	case *IndexAssign:
+		return v.target.token
+	case *BuiltinCall:
+		return v.token
+	case *Node:
+		if op, ok := v.token.(*Op); ok == true {
+			return op.token
+		}
+		return node_token(v.token)
+	case *Op:
+		return v.token
+	case *Number:
+		return v.token
+	case *Str:
+		return v.token
+	}
+	return nil
+}
+
+func node_kind(node interface{}) string {
+	if node == nil {
+		return "NoOp"
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", node), "*main.")
+}
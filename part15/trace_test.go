@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const traced_program = `PROGRAM Traced;
+VAR x : INTEGER;
+PROCEDURE P(a : INTEGER);
+BEGIN
+   x := a
+END;
+BEGIN
+   P(1)
+END.
+`
+
+/* Lines each -trace prints to stderr, in this order, and the phases it must leave out */
+var trace_tests = []struct {
+	name    string
+	args    []string
+	want    []string
+	missing []string
+}{
+	{"lex", []string{"-trace", "lex"}, []string{
+		"[lex] Token [1:1] {PROGRAM} 'PROGRAM'",
+		"[lex] Token [5:6] {ASSIGN} ':='",
+		"[lex] Token [10:1] {EOF} 'EOF'",
+	}, []string{"[parse]", "[sema]", "[exec]"}},
+	{"parse", []string{"-trace", "parse"}, []string{
+		"[parse] Digest [3:1] [PROCEDURE] 'PROCEDURE'",
+		"[parse] Digest [9:4] [DOT] '.'",
+		"[parse] Finished [10:1]",
+	}, []string{"[lex]", "[sema]", "[exec]"}},
+	{"sema", []string{"-trace", "sema"}, []string{
+		"[sema] ProcedureDecl [3:11] scope=Global",
+		"[sema] EnterScope [3:11] scope=Global P",
+		"[sema] Assign [5:6] scope=P",
+		"[sema] LeaveScope [3:11] scope=Global P",
+	}, []string{"[lex]", "[parse]", "[exec]"}},
+	{"exec", []string{"-trace", "exec"}, []string{
+		"[exec] Start scope=Global",
+		"[exec] ProcedureCall [8:4] scope=Global",
+		"[exec] Assign [5:6] scope=P",
+		"[exec] SymbolTable scope=Global",
+		"X: <INTEGER_CONST val = 1]>",
+	}, []string{"[lex]", "[parse]", "[sema]"}},
+	{"phases", []string{"-trace", "lex, exec"}, []string{
+		"[lex] Token [1:1] {PROGRAM} 'PROGRAM'",
+		"[exec] Start scope=Global",
+	}, []string{"[parse]", "[sema]"}},
+	{"all", []string{"-trace", "all"}, []string{
+		"[lex] Token [1:1] {PROGRAM} 'PROGRAM'",
+		"[parse] Finished [10:1]",
+		"[sema] Assign [5:6] scope=P",
+		"[exec] Assign [5:6] scope=P",
+	}, nil},
+	{"json", []string{"-trace", "sema", "-trace-format", "json"}, []string{
+		`{"phase":"sema","kind":"EnterScope","line":3,"column":11,"scope":"Global","message":"P"}`,
+		`{"phase":"sema","kind":"Assign","line":5,"column":6,"scope":"P"}`,
+	}, []string{"[sema]"}},
+	{"unknown phase", []string{"-trace", "lex,bogus"}, []string{
+		"unknown trace phase 'bogus', want lex, parse, sema, exec or all",
+	}, []string{"[lex]"}},
+}
+
+/* Finds want in text as lines in order, returning the first one missing */
+func lines_in_order(text string, want []string) (string, bool) {
+	for _, line := range want {
+		index := strings.Index(text, line)
+		if index < 0 {
+			return line, false
+		}
+		text = text[index+len(line):]
+	}
+	return "", true
+}
+
+func TestTraceOutput(t *testing.T) {
+	path := write_program(t, "traced.pas", traced_program)
+	for _, test := range trace_tests {
+		t.Run(test.name, func(t *testing.T) {
+			run := run_pascal(t, "", append(test.args, path)...)
+			if line, ok := lines_in_order(run.stderr, test.want); ok == false {
+				t.Errorf("trace lacks %q in\n%s", line, run.stderr)
+			}
+			for _, phase := range test.missing {
+				if strings.Contains(run.stderr, phase) == true {
+					t.Errorf("trace holds %s events", phase)
+				}
+			}
+		})
+	}
+}
+
+/* -trace-out takes the events off stderr, leaving the program output on stdout */
+func TestTraceOut(t *testing.T) {
+	path := write_program(t, "traced.pas", traced_program)
+	out := filepath.Join(t.TempDir(), "trace.txt")
+	run := run_pascal(t, "", "-trace", "exec", "-trace-out", out, path)
+	if run.code != 0 || run.stderr != "" {
+		t.Fatalf("exit %d, stderr %q", run.code, run.stderr)
+	}
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line, ok := lines_in_order(string(content), []string{"[exec] Start scope=Global", "[exec] Assign [5:6] scope=P"}); ok == false {
+		t.Errorf("trace file lacks %q in\n%s", line, content)
+	}
+}
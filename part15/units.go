@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+	UNITS
+
+		unit : UNIT ID SEMI
+		       INTERFACE uses_clause? (VAR (variable_declaration SEMI)+ | procedure_heading)*
+		       IMPLEMENTATION declarations
+		       (INITIALIZATION statement_list (FINALIZATION statement_list)? | BEGIN statement_list)? END DOT
+		uses_clause : USES ID (COMMA ID)* SEMI
+
+	A unit named MATHS lives in maths.pas or maths.pp, found next to the file
+	using it or in one of the -unit-path directories. What its INTERFACE
+	declares is merged into a "Units" scope between the builtins and the
+	program, so the program sees it unless it declares the name itself.
+	Units only run on the interpreter.
+*/
+
+type Uses struct {
+	token *lexemes
+	names []*lexemes
+	/* set by the loader: the units named, then every unit needed, dependencies first */
+	units []*Unit
+	order []*Unit
+}
+
+type Unit struct {
+	token          *lexemes
+	name           string
+	uses           *Uses
+	exports        Elem_list
+	implementation Elem_list
+	initialization interface{}
+	finalization   interface{}
+	scope          *ScopedSymbolTable
+	/* the files it includes, and a hash of its source, defines and dependencies */
+	included []string
+	key      string
+}
+
+type UnitLoader struct {
+	search  []string
+	defines []string
+	cache   *UnitCache
+	units   map[string]*Unit
+	loading []string
+	order   []*Unit
+}
+
+func (r *rules) uses_clause() *Uses {
+	uses := &Uses{r.lexer.Cur(), nil, nil, nil}
+	r.digest(USES)
+	uses.names = append(uses.names, r.lexer.Cur())
+	r.digest(ID)
+	for r.lexer.Cur().ttype == COMMA {
+		r.digest(COMMA)
+		uses.names = append(uses.names, r.lexer.Cur())
+		r.digest(ID)
+	}
+	r.digest(SEMI)
+	return uses
+}
+
+/* The statements up to END, FINALIZATION or the end of the unit, as a compound */
+func (r *rules) unit_section() *Compound {
+	token := r.lexer.Cur()
+	r.lexer.Next()
+	list := r.statement_list()
+	return &Compound{token, list.elem, r.lexer.Cur()}
+}
+
+func (r *rules) unit() *Unit {
+	r.digest(UNIT)
+	unit := &Unit{r.lexer.Cur(), r.lexer.Cur().tstring, &Uses{}, Elem_list{}, Elem_list{}, nil, nil, nil, nil, ""}
+	r.digest(ID)
+	r.digest(SEMI)
+	r.digest(INTERFACE)
+	if r.lexer.Cur().ttype == USES {
+		unit.uses = r.uses_clause()
+	}
+	for {
+		if r.lexer.Cur().ttype == VAR {
+			unit.exports.elem = append(unit.exports.elem, r.variable_section()...)
+		} else if r.lexer.Cur().ttype == PROCEDURE || r.lexer.Cur().ttype == FUNCTION {
+			unit.exports.elem = append(unit.exports.elem, r.procedure_heading())
+		} else {
+			break
+		}
+	}
+	r.digest(IMPLEMENTATION)
+	unit.implementation = r.declaration()
+	switch r.lexer.Cur().ttype {
+	case INITIALIZATION, BEGIN:
+		unit.initialization = r.unit_section()
+		if r.lexer.Cur().ttype == FINALIZATION && unit.initialization.(*Compound).token.ttype == INITIALIZATION {
+			unit.finalization = r.unit_section()
+		}
+	case FINALIZATION:
+		unit.finalization = r.unit_section()
+	}
+	r.digest(END)
+	r.digest(DOT)
+	if r.lexer.Cur().ttype != EOF {
+		token := r.lexer.Cur()
+		compile_error("Syntax", token, "unexpected token %s '%s' after the end of the unit", reverse_lex[token.ttype], token.tstring)
+	}
+	return unit
+}
+
+/* The USES clause of a parsed program, nil when it has none */
+func program_uses(tree *Block) *Uses {
+	if len(tree.declaration_list.elem) > 0 {
+		if uses, ok := tree.declaration_list.elem[0].(*Uses); ok == true {
+			return uses
+		}
+	}
+	return nil
+}
+
+/* The scope a program or unit declares into: builtins, then what the units it uses export */
+func new_top_scope(name string, uses *Uses) *ScopedSymbolTable {
+	builtins := new_global_scope()
+	if uses == nil || len(uses.units) == 0 {
+		builtins.scope_name = name
+		return builtins
+	}
+	builtins.scope_name = "System"
+	exports := &ScopedSymbolTable{make(map[string]Symbol), "Units", 1, builtins, nil, nil}
+	builtins.inferior_scope = append(builtins.inferior_scope, exports)
+	for _, unit := range uses.units {
+		for _, decl := range unit.exports.elem {
+			exports.insert(unit.exported(decl))
+		}
+	}
+	scope := &ScopedSymbolTable{make(map[string]Symbol), name, 2, exports, nil, nil}
+	exports.inferior_scope = append(exports.inferior_scope, scope)
+	return scope
+}
+
+func (u *Unit) exported(decl interface{}) Symbol {
+	var symbol Symbol
+	switch v := decl.(type) {
+	case *VarDeclaration:
+		symbol, _ = u.scope.lookup(v.token.tstring, true)
+	case *ProcedureDecl:
+		symbol, _ = u.scope.lookup(v.proc_name, true)
+	}
+	return symbol
+}
+
+/* Loads and analyses every unit the program uses, path being the program source, cache nil to analyse them all */
+func load_units(tree *Block, path string, search []string, defines []string, cache *UnitCache) {
+	uses := program_uses(tree)
+	if uses == nil {
+		return
+	}
+	loader := &UnitLoader{search, defines, cache, make(map[string]*Unit), nil, nil}
+	dir := "."
+	if path != "" {
+		dir = filepath.Dir(path)
+	}
+	loader.resolve(uses, dir)
+	uses.order = loader.order
+}
+
+func (l *UnitLoader) resolve(uses *Uses, dir string) {
+	for _, name := range uses.names {
+		uses.units = append(uses.units, l.load(name, dir))
+	}
+}
+
+/* Path of the source of a unit, matching the file name without regard to case */
+func (l *UnitLoader) find(name string, dir string) string {
+	for _, directory := range append([]string{dir}, l.search...) {
+		entries, err := os.ReadDir(directory)
+		if err != nil {
+			continue
+		}
+		for _, extension := range []string{".pas", ".pp"} {
+			for _, entry := range entries {
+				if strings.EqualFold(entry.Name(), name+extension) == true {
+					return filepath.Join(directory, entry.Name())
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func (l *UnitLoader) load(token *lexemes, dir string) *Unit {
+	name := token.tstring
+	for index, loading := range l.loading {
+		if loading == name {
+			chain := append(append([]string{}, l.loading[index:]...), name)
+			compile_error("Unit", token, "circular unit reference %s", strings.Join(chain, " -> "))
+		}
+	}
+	if unit, ok := l.units[name]; ok == true {
+		return unit
+	}
+	path := l.find(name, dir)
+	if path == "" {
+		compile_error("Unit", token, "unit %s not found", name)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		compile_error("Unit", token, "cannot read unit %s: %v", name, err)
+	}
+	l.loading = append(l.loading, name)
+	unit := l.cache.fetch(l, name, path, content)
+	if unit == nil {
+		unit = l.analyse(name, path, content)
+		l.cache.store(unit, path, content, l.defines)
+	}
+	l.loading = l.loading[:len(l.loading)-1]
+	l.units[name] = unit
+	l.order = append(l.order, unit)
+	return unit
+}
+
+func (l *UnitLoader) analyse(name string, path string, content []byte) *Unit {
+	tokens, included := preprocess_named(bytes.NewReader(content), path, path, l.defines)
+	parser := rules{lexer{0, len(tokens), tokens}}
+	unit := parser.unit()
+	if unit.name != name {
+		compile_error("Unit", unit.token, "%s declares unit %s, %s was expected", path, unit.name, name)
+	}
+	unit.included = included
+	l.resolve(unit.uses, filepath.Dir(path))
+	analyse_unit(unit)
+	unit.key = unit_key(path, content, l.defines, unit)
+	return unit
+}
+
+func analyse_unit(unit *Unit) {
+	unit.scope = new_top_scope(unit.name, unit.uses)
+	analyser := SemanticsAnalyser{unit.scope, nil}
+	for _, decl := range unit.exports.elem {
+		analyser.check(decl)
+	}
+	for _, decl := range unit.implementation.elem {
+		analyser.check(decl)
+	}
+	analyser.forwards(unit.implementation.elem)
+	for _, decl := range unit.exports.elem {
+		if heading, ok := decl.(*ProcedureDecl); ok == true {
+			if unit.exported(heading).(*ProcedureSymbol).decl.block == nil {
+				compile_error("Semantic", heading.token, "procedure %s of unit %s has no IMPLEMENTATION", heading.proc_name, unit.name)
+			}
+		}
+	}
+	analyser.check(unit.initialization)
+	analyser.check(unit.finalization)
+}
+
+/* Gives the procedure declared by a heading its block, the parameters and result being repeated or left out */
+func (s SemanticsAnalyser) implement(proc *ProcedureSymbol, decl *ProcedureDecl) {
+	if len(decl.params) == 0 {
+		decl.params = proc.decl.params
+	}
+	same := len(decl.params) == len(proc.params)
+	for index := 0; same == true && index < len(decl.params); index++ {
+		param := decl.params[index]
+		same = param.var_name.token.tstring == proc.params[index].name && param.var_type.sstring == proc.params[index].stype.name && param.constant == proc.params[index].constant
+	}
+	if same == false {
+		compile_error("Semantic", decl.token, "parameters of %s differ from its declaration at line %d", decl.proc_name, proc.token.line)
+	}
+	if decl.result != nil && decl.result.sstring == "" {
+		decl.result = proc.decl.result
+	}
+	if (decl.result == nil) != (proc.result == nil) || decl.result != nil && s.type_symbol(decl.result, decl.token).name != proc.result.stype.name {
+		compile_error("Semantic", decl.token, "result of %s differs from its declaration at line %d", decl.proc_name, proc.token.line)
+	}
+	proc.decl = decl
+	s.reference(decl.token, proc)
+	for index, param := range decl.params {
+		s.reference(param.var_name.token, proc.params[index])
+	}
+	s.scope = proc.scope
+	s.check(decl.block)
+}
+
+/* Reports a heading among decls whose procedure was never given its block */
+func (s SemanticsAnalyser) forwards(decls []interface{}) {
+	for _, decl := range decls {
+		if class, ok := decl.(*ClassDeclaration); ok == true {
+			s.unimplemented(class)
+			continue
+		}
+		heading, ok := decl.(*ProcedureDecl)
+		if ok == false || heading.block != nil {
+			continue
+		}
+		symbol, _ := s.scope.lookup(heading.proc_name, true)
+		if proc, is_proc := symbol.(*ProcedureSymbol); is_proc == true && proc.decl.block == nil {
+			compile_error("Semantic", heading.token, "procedure %s is declared FORWARD but never defined", heading.proc_name)
+		}
+	}
+}
+
+func (i *Interpreter) run_section(unit *Unit, section interface{}) {
+	if section == nil {
+		return
+	}
+	i.stack = append(i.stack, &CallFrame{unit.name, unit.scope, nil, nil, nil, i.stack[0].values, 0})
+	caller_scope := i.scope
+	i.scope = unit.scope
+	i.run(section)
+	i.scope = caller_scope
+	i.stack = i.stack[:len(i.stack)-1]
+}
+
+/* Runs the INITIALIZATION of every unit, dependencies first */
+func (i *Interpreter) initialize(uses *Uses) {
+	for _, unit := range uses.order {
+		i.run_section(unit, unit.initialization)
+	}
+}
+
+/* Runs the FINALIZATION of every unit, in the reverse order */
+func (i *Interpreter) finalize(uses *Uses) {
+	for index := len(uses.order) - 1; index >= 0; index-- {
+		i.run_section(uses.order[index], uses.order[index].finalization)
+	}
+}
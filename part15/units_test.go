@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* A unit whose Show prints greeting, then calls the Show of the unit it uses */
+func unit_source(name string, uses string, greeting string) string {
+	clause, call := "", ""
+	if uses != "" {
+		clause, call = "USES "+uses+";\n", ";\n   Show"+uses
+	}
+	return "UNIT " + name + ";\nINTERFACE\n" + clause + "PROCEDURE Show" + name + ";\nIMPLEMENTATION\nPROCEDURE Show" + name + ";\nBEGIN\n   WRITELN('" + greeting + "')" + call + "\nEND;\nEND.\n"
+}
+
+/* Where units are looked for: next to the program, then along -unit-path in order, a unit's own units next to it first */
+var unit_path_tests = []struct {
+	name   string
+	files  map[string]string
+	path   []string
+	stdout string
+	stderr string
+}{
+	{"program directory", map[string]string{
+		"prog/maths.pas": unit_source("Maths", "", "next to the program"),
+	}, nil, "next to the program\n", ""},
+	{"search path", map[string]string{
+		"lib/maths.pas": unit_source("Maths", "", "from lib"),
+	}, []string{"lib"}, "from lib\n", ""},
+	{"program first", map[string]string{
+		"prog/maths.pas": unit_source("Maths", "", "next to the program"),
+		"lib/maths.pas":  unit_source("Maths", "", "from lib"),
+	}, []string{"lib"}, "next to the program\n", ""},
+	{"path in order", map[string]string{
+		"first/maths.pas":  unit_source("Maths", "", "from first"),
+		"second/maths.pas": unit_source("Maths", "", "from second"),
+	}, []string{"missing", "first", "second"}, "from first\n", ""},
+	{"any case and .pp", map[string]string{
+		"lib/MATHS.PP": unit_source("Maths", "", "from MATHS.PP"),
+	}, []string{"lib"}, "from MATHS.PP\n", ""},
+	{"units of a unit", map[string]string{
+		"lib/maths.pas":   unit_source("Maths", "Format", "maths"),
+		"lib/format.pas":  unit_source("Format", "", "format next to maths"),
+		"more/format.pas": unit_source("Format", "", "format from more"),
+	}, []string{"more", "lib"}, "maths\nformat next to maths\n", ""},
+	{"not found", map[string]string{
+		"elsewhere/maths.pas": unit_source("Maths", "", "never"),
+	}, []string{"lib"}, "", "Unit Error: unit MATHS not found line [2:6]"},
+	{"wrong name", map[string]string{
+		"lib/maths.pas": unit_source("Sums", "", "never"),
+	}, []string{"lib"}, "", "declares unit SUMS, MATHS was expected"},
+}
+
+func TestUnitSearchPath(t *testing.T) {
+	for _, test := range unit_path_tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			files := map[string]string{"prog/main.pas": "PROGRAM Main;\nUSES Maths;\nBEGIN\n   ShowMaths\nEND.\n"}
+			for name, source := range test.files {
+				files[name] = source
+			}
+			for name, source := range files {
+				path := filepath.Join(root, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			search := []string{}
+			for _, dir := range test.path {
+				search = append(search, filepath.Join(root, dir))
+			}
+			run := run_pascal(t, "", "-unit-path", strings.Join(search, string(os.PathListSeparator)), filepath.Join(root, "prog", "main.pas"))
+			if run.stdout != test.stdout || strings.Contains(run.stderr, test.stderr) == false || (run.code == 0) != (test.stderr == "") {
+				t.Errorf("exit %d with %q, stderr %q, want %q and %q", run.code, run.stdout, run.stderr, test.stdout, test.stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+/*
+	BYTECODE
+*/
+
+const (
+	OP_CONST = iota
+	OP_LOAD
+	OP_STORE
+	OP_ADD
+	OP_SUB
+	OP_MUL
+	OP_DIV
+	OP_IDIV
+	OP_MOD
+	OP_IADD
+	OP_ISUB
+	OP_IMUL
+	OP_FIT
+	OP_NEG
+	OP_EQ
+	OP_NE
+	OP_LT
+	OP_LE
+	OP_GT
+	OP_GE
+	OP_JUMP
+	OP_JUMP_FALSE
+	OP_CALL
+	OP_RETURN
+	OP_PRINT_STR
+	OP_PRINT_INT
+	OP_PRINT_REAL
+	OP_PRINT_LN
+	OP_HALT
+	OP_STEP
+)
+
+var reverse_op = map[int]string{
+	OP_CONST:      "CONST",
+	OP_LOAD:       "LOAD",
+	OP_STORE:      "STORE",
+	OP_ADD:        "ADD",
+	OP_SUB:        "SUB",
+	OP_MUL:        "MUL",
+	OP_DIV:        "DIV",
+	OP_IDIV:       "IDIV",
+	OP_MOD:        "MOD",
+	OP_IADD:       "IADD",
+	OP_ISUB:       "ISUB",
+	OP_IMUL:       "IMUL",
+	OP_FIT:        "FIT",
+	OP_NEG:        "NEG",
+	OP_EQ:         "EQ",
+	OP_NE:         "NE",
+	OP_LT:         "LT",
+	OP_LE:         "LE",
+	OP_GT:         "GT",
+	OP_GE:         "GE",
+	OP_JUMP:       "JUMP",
+	OP_JUMP_FALSE: "JUMP_FALSE",
+	OP_CALL:       "CALL",
+	OP_RETURN:     "RETURN",
+	OP_PRINT_STR:  "PRINT_STR",
+	OP_PRINT_INT:  "PRINT_INT",
+	OP_PRINT_REAL: "PRINT_REAL",
+	OP_PRINT_LN:   "PRINT_LN",
+	OP_HALT:       "HALT",
+	OP_STEP:       "STEP",
+}
+
+/* Number of operands following each opcode */
+var op_operands = map[int]int{
+	OP_CONST:      1,
+	OP_LOAD:       2,
+	OP_STORE:      2,
+	OP_JUMP:       1,
+	OP_JUMP_FALSE: 1,
+	OP_CALL:       2,
+	OP_PRINT_STR:  1,
+}
+
+var binary_ops = map[int]int{
+	PLUS:          OP_ADD,
+	MINUS:         OP_SUB,
+	MUL:           OP_MUL,
+	FLOAT_DIV:     OP_DIV,
+	INTEGER_DIV:   OP_IDIV,
+	MOD:           OP_MOD,
+	EQUAL:         OP_EQ,
+	NOT_EQUAL:     OP_NE,
+	LESS:          OP_LT,
+	LESS_EQUAL:    OP_LE,
+	GREATER:       OP_GT,
+	GREATER_EQUAL: OP_GE,
+}
+
+/* INTEGER arithmetic is exact and then wraps or overflows as the interpreter's, DIV and MOD always being INTEGER */
+var integer_ops = map[int]int{
+	PLUS:        OP_IADD,
+	MINUS:       OP_ISUB,
+	MUL:         OP_IMUL,
+	INTEGER_DIV: OP_IDIV,
+	MOD:         OP_MOD,
+}
+
+type VmProcedure struct {
+	name   string
+	entry  int
+	level  int
+	params int
+	slots  []string
+}
+
+type Bytecode struct {
+	code []int
+	/* the token each instruction was compiled from, which runtime errors report */
+	positions  []*lexemes
+	constants  []float64
+	strings    []string
+	procedures []*VmProcedure
+}
+
+/*
+	COMPILER
+*/
+
+type Compiler struct {
+	bytecode *Bytecode
+	scope    *ScopedSymbolTable
+	slots    map[*ScopedSymbolTable]map[string]int
+	procs    map[*ProcedureSymbol]int
+	pending  []*ProcedureSymbol
+	at       *lexemes
+}
+
+func compile_program(tree *Block, scope *ScopedSymbolTable) *Bytecode {
+	c := &Compiler{&Bytecode{}, scope, make(map[*ScopedSymbolTable]map[string]int), make(map[*ProcedureSymbol]int), nil, nil}
+	main := &VmProcedure{scope.scope_name, 0, scope.scope_level, 0, nil}
+	c.bytecode.procedures = append(c.bytecode.procedures, main)
+	c.block(tree, main, nil)
+	c.emit(OP_HALT)
+	for len(c.pending) > 0 {
+		proc_symbol := c.pending[0]
+		c.pending = c.pending[1:]
+		proc := c.bytecode.procedures[c.procs[proc_symbol]]
+		proc.entry = len(c.bytecode.code)
+		c.scope = proc_symbol.scope
+		c.block(proc_symbol.decl.block, proc, proc_symbol.params)
+		c.emit(OP_RETURN)
+	}
+	return c.bytecode
+}
+
+func (c *Compiler) emit(op int, operands ...int) int {
+	c.bytecode.code = append(c.bytecode.code, op)
+	c.bytecode.positions = append(c.bytecode.positions, c.at)
+	for _, operand := range operands {
+		c.bytecode.code = append(c.bytecode.code, operand)
+		c.bytecode.positions = append(c.bytecode.positions, c.at)
+	}
+	return len(c.bytecode.code) - len(operands)
+}
+
+func (c *Compiler) constant(value float64) int {
+	for index, constant := range c.bytecode.constants {
+		if constant == value {
+			return index
+		}
+	}
+	c.bytecode.constants = append(c.bytecode.constants, value)
+	return len(c.bytecode.constants) - 1
+}
+
+/* Parameters take the first slots, then the variables in declaration order */
+func (c *Compiler) block(block *Block, proc *VmProcedure, params []*VarSymbol) {
+	slots := make(map[string]int)
+	c.slots[c.scope] = slots
+	for _, param := range params {
+		slots[param.name] = len(proc.slots)
+		proc.slots = append(proc.slots, param.name)
+	}
+	proc.params = len(params)
+	for _, decl := range block.declaration_list.elem {
+		switch v := decl.(type) {
+		case *VarDeclaration:
+			slots[v.token.tstring] = len(proc.slots)
+			proc.slots = append(proc.slots, v.token.tstring)
+		case *ProcedureDecl:
+			if v.block == nil {
+				break
+			}
+			symbol, _ := c.scope.lookup(v.proc_name, true)
+			proc_symbol := symbol.(*ProcedureSymbol)
+			c.procs[proc_symbol] = len(c.bytecode.procedures)
+			c.bytecode.procedures = append(c.bytecode.procedures, &VmProcedure{v.proc_name, 0, proc_symbol.scope.scope_level, 0, nil})
+			c.pending = append(c.pending, proc_symbol)
+		}
+	}
+	c.statement(block.compound)
+}
+
+/* Static link hops and slot of a variable as seen from the current scope */
+func (c *Compiler) resolve(name string) (int, int) {
+	for scope := c.scope; scope != nil; scope = scope.enclosing_scope {
+		if slot, ok := c.slots[scope][name]; ok == true {
+			return c.scope.scope_level - scope.scope_level, slot
+		}
+	}
+	compile_error("Compiler", nil, "%s has no storage", name)
+	return 0, 0
+}
+
+func (c *Compiler) statement(node interface{}) {
+	if token := node_token(node); token != nil {
+		c.at = token
+	}
+	switch v := node.(type) {
+	case *Compound:
+		for _, elem := range v.elem {
+			c.statement(elem)
+		}
+	case *Assign:
+		c.emit(OP_STEP)
+		symbol, _ := c.scope.lookup(v.variable.token.tstring, false)
+		c.expression(v.expr)
+		c.fit(v.expr, symbol.(*VarSymbol).stype, v.token)
+		hops, slot := c.resolve(v.variable.token.tstring)
+		c.emit(OP_STORE, hops, slot)
+	case *ProcedureCall:
+		c.emit(OP_STEP)
+		if v.proc_symbol == nil {
+			c.writeln(v.args)
+			return
+		}
+		for index, arg := range v.args {
+			c.expression(arg)
+			c.fit(arg, v.proc_symbol.params[index].stype, v.token)
+		}
+		hops := c.scope.scope_level - (v.proc_symbol.scope.scope_level - 1)
+		c.emit(OP_CALL, c.procs[v.proc_symbol], hops)
+	case *While:
+		start := len(c.bytecode.code)
+		c.emit(OP_STEP)
+		c.expression(v.condition)
+		exit := c.emit(OP_JUMP_FALSE, 0)
+		c.statement(v.body)
+		c.emit(OP_JUMP, start)
+		c.bytecode.code[exit] = len(c.bytecode.code)
+	case *If:
+		c.emit(OP_STEP)
+		c.expression(v.condition)
+		skip := c.emit(OP_JUMP_FALSE, 0)
+		c.statement(v.then_branch)
+		if v.else_branch != nil {
+			exit := c.emit(OP_JUMP, 0)
+			c.bytecode.code[skip] = len(c.bytecode.code)
+			c.statement(v.else_branch)
+			c.bytecode.code[exit] = len(c.bytecode.code)
+		} else {
+			c.bytecode.code[skip] = len(c.bytecode.code)
+		}
+	case nil:
+	default:
+		compile_error("Compiler", node_token(v), "cannot compile %s", node_kind(v))
+	}
+}
+
+func (c *Compiler) writeln(args []*Node) {
+	analyser := SemanticsAnalyser{c.scope, nil}
+	for _, arg := range args {
+		if str, ok := arg.token.(*Str); ok == true {
+			c.bytecode.strings = append(c.bytecode.strings, str.token.tstring)
+			c.emit(OP_PRINT_STR, len(c.bytecode.strings)-1)
+			continue
+		}
+		c.expression(arg)
+		if analyser.type_of(arg).name == "INTEGER_CONST" {
+			c.emit(OP_PRINT_INT)
+		} else {
+			c.emit(OP_PRINT_REAL)
+		}
+	}
+	c.emit(OP_PRINT_LN)
+}
+
+func (c *Compiler) expression(node *Node) {
+	switch v := node.token.(type) {
+	case *Number:
+		c.emit(OP_CONST, c.constant(number_value(v.token)))
+	case *Var:
+		hops, slot := c.resolve(v.token.tstring)
+		c.emit(OP_LOAD, hops, slot)
+	case *Op:
+		integer := integer_operation(SemanticsAnalyser{c.scope, nil}, node, v.token)
+		if node.left == nil {
+			/* the interpreter takes a signed INTEGER as 0 + x or 0 - x, which wraps */
+			if integer == true {
+				c.emit(OP_CONST, c.constant(0))
+			}
+			c.expression(node.right)
+			if integer == true {
+				c.operation(v.token, integer_ops[v.token.ttype])
+			} else if v.token.ttype == MINUS {
+				c.emit(OP_NEG)
+			}
+			return
+		}
+		c.expression(node.left)
+		c.expression(node.right)
+		if integer == true {
+			c.operation(v.token, integer_ops[v.token.ttype])
+		} else {
+			c.operation(v.token, binary_ops[v.token.ttype])
+		}
+	}
+}
+
+/* Emits the range rules of storing node in a variable of type stype at token, when storing can change it */
+func (c *Compiler) fit(node *Node, stype *BuiltinSymbol, token *lexemes) {
+	if stype.name == "INTEGER_CONST" && may_not_fit(SemanticsAnalyser{c.scope, nil}, node, token) == true {
+		c.operation(token, OP_FIT)
+	}
+}
+
+/* Emits an operation that may fail, positioned at its operator as the interpreter reports it */
+func (c *Compiler) operation(token *lexemes, op int, operands ...int) {
+	at := c.at
+	c.at = token
+	c.emit(op, operands...)
+	c.at = at
+}
+
+func (b *Bytecode) line(pc int) int {
+	if b.positions[pc] == nil {
+		return 0
+	}
+	return b.positions[pc].line
+}
+
+/*
+	VIRTUAL MACHINE
+*/
+
+type VmFrame struct {
+	proc         *VmProcedure
+	locals       []float64
+	static_link  *VmFrame
+	dynamic_link *VmFrame
+	return_pc    int
+}
+
+type VM struct {
+	bytecode *Bytecode
+	stack    []float64
+	globals  *VmFrame
+	out      io.Writer
+	ctx      context.Context
+	/* budgets as the interpreter's, the frames nesting and the bytes they take counted as it counts them */
+	limits Limits
+	steps  int
+	depth  int
+	memory int
+}
+
+func new_vm(bytecode *Bytecode) *VM {
+	main := bytecode.procedures[0]
+	return &VM{bytecode, make([]float64, 0, 256), &VmFrame{main, make([]float64, len(main.slots)), nil, nil, 0}, os.Stdout, context.Background(), Limits{}, 0, 1, 0}
+}
+
+/* The calls leading to the instruction pc of frame, innermost first */
+func (vm *VM) backtrace(pc int, frame *VmFrame) []StackEntry {
+	stack := []StackEntry{}
+	for at := pc; frame != nil; frame = frame.dynamic_link {
+		stack = append(stack, StackEntry{frame.proc.name, vm.bytecode.line(at)})
+		/* the CALL and its two operands come before where the caller resumes */
+		at = frame.return_pc - 3
+	}
+	return stack
+}
+
+/* Fails as the interpreter would, at the instruction pc of frame and the calls leading to it */
+func (vm *VM) runtime_error(code int, pc int, frame *VmFrame, format string, args ...interface{}) {
+	message := runtime_messages[code]
+	if format != "" {
+		message += ", " + fmt.Sprintf(format, args...)
+	}
+	panic(&RuntimeError{code, message, vm.bytecode.positions[pc], vm.backtrace(pc, frame)})
+}
+
+/* Stops the program as the interpreter's abort does, when a limit is exceeded */
+func (vm *VM) abort(limit string, pc int, frame *VmFrame, format string, args ...interface{}) {
+	panic(&LimitError{limit, fmt.Sprintf(format, args...), vm.bytecode.positions[pc], vm.backtrace(pc, frame)})
+}
+
+/* Counts a statement against the step budget, and looks at the context now and then */
+func (vm *VM) step(pc int, frame *VmFrame) {
+	vm.steps++
+	if vm.limits.steps > 0 && vm.steps > vm.limits.steps {
+		vm.abort("steps", pc, frame, "step limit of %d exceeded", vm.limits.steps)
+	}
+	if vm.steps%1024 != 0 {
+		return
+	}
+	select {
+	case <-vm.ctx.Done():
+		if vm.ctx.Err() == context.DeadlineExceeded {
+			vm.abort("timeout", pc, frame, "time limit of %v exceeded", vm.limits.timeout)
+		}
+		vm.abort("cancelled", pc, frame, "execution cancelled")
+	default:
+	}
+}
+
+/* Opens a frame for proc as the interpreter's invoke does, failing past the depth and memory limits */
+func (vm *VM) enter(proc *VmProcedure, pc int, frame *VmFrame) {
+	if vm.limits.depth > 0 && vm.depth > vm.limits.depth {
+		vm.abort("depth", pc, frame, "call depth limit of %d exceeded", vm.limits.depth)
+	}
+	size := len(proc.slots) * VALUE_SIZE
+	if vm.limits.memory > 0 && vm.memory+size > vm.limits.memory {
+		vm.abort("memory", pc, frame, "memory limit of %d bytes exceeded", vm.limits.memory)
+	}
+	vm.memory += size
+	vm.depth++
+}
+
+/* Runs the program under the VM's limits, returning the runtime or limit error that ended it */
+func (vm *VM) execute(ctx context.Context) (err error) {
+	if vm.limits.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, vm.limits.timeout)
+		defer cancel()
+	}
+	vm.ctx = ctx
+	defer func() {
+		if r := recover(); r != nil {
+			switch abort := r.(type) {
+			case *RuntimeError:
+				err = abort
+			case *LimitError:
+				err = abort
+			default:
+				panic(r)
+			}
+		}
+	}()
+	vm.run()
+	return nil
+}
+
+func (vm *VM) run() {
+	code := vm.bytecode.code
+	constants := vm.bytecode.constants
+	stack := vm.stack[:0]
+	frame := vm.globals
+	pc := 0
+	for {
+		switch code[pc] {
+		case OP_CONST:
+			stack = append(stack, constants[code[pc+1]])
+			pc += 2
+		case OP_LOAD:
+			target := frame
+			for hops := code[pc+1]; hops > 0; hops-- {
+				target = target.static_link
+			}
+			stack = append(stack, target.locals[code[pc+2]])
+			pc += 3
+		case OP_STORE:
+			target := frame
+			for hops := code[pc+1]; hops > 0; hops-- {
+				target = target.static_link
+			}
+			target.locals[code[pc+2]] = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			pc += 3
+		case OP_NEG:
+			stack[len(stack)-1] = -stack[len(stack)-1]
+			pc++
+		case OP_JUMP:
+			pc = code[pc+1]
+		case OP_JUMP_FALSE:
+			condition := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if condition == 0 {
+				pc = code[pc+1]
+			} else {
+				pc += 2
+			}
+		case OP_CALL:
+			proc := vm.bytecode.procedures[code[pc+1]]
+			vm.enter(proc, pc, frame)
+			link := frame
+			for hops := code[pc+2]; hops > 0; hops-- {
+				link = link.static_link
+			}
+			callee := &VmFrame{proc, make([]float64, len(proc.slots)), link, frame, pc + 3}
+			copy(callee.locals, stack[len(stack)-proc.params:])
+			stack = stack[:len(stack)-proc.params]
+			frame = callee
+			pc = proc.entry
+		case OP_RETURN:
+			vm.memory -= len(frame.proc.slots) * VALUE_SIZE
+			vm.depth--
+			pc = frame.return_pc
+			frame = frame.dynamic_link
+		case OP_PRINT_STR:
+			fmt.Fprint(vm.out, vm.bytecode.strings[code[pc+1]])
+			pc += 2
+		case OP_PRINT_INT, OP_PRINT_REAL:
+			if code[pc] == OP_PRINT_INT {
+				fmt.Fprintf(vm.out, "%d", int64(stack[len(stack)-1]))
+			} else {
+				fmt.Fprintf(vm.out, "%f", stack[len(stack)-1])
+			}
+			stack = stack[:len(stack)-1]
+			pc++
+		case OP_PRINT_LN:
+			fmt.Fprintln(vm.out)
+			pc++
+		case OP_HALT:
+			vm.stack = stack
+			return
+		case OP_STEP:
+			vm.step(pc, frame)
+			pc++
+		case OP_IADD, OP_ISUB, OP_IMUL, OP_IDIV, OP_MOD:
+			right := int64(stack[len(stack)-1])
+			stack = stack[:len(stack)-1]
+			left := int64(stack[len(stack)-1])
+			var result int64
+			switch code[pc] {
+			case OP_IADD:
+				result = left + right
+			case OP_ISUB:
+				result = left - right
+			case OP_IMUL:
+				result = left * right
+			default:
+				if right == 0 {
+					vm.runtime_error(RUNTIME_DIVISION_BY_ZERO, pc, frame, "")
+				}
+				if code[pc] == OP_IDIV {
+					result = left / right
+				} else {
+					result = left % right
+				}
+			}
+			value, ok := integer_result(vm.bytecode.positions[pc], result)
+			if ok == false {
+				vm.runtime_error(RUNTIME_OVERFLOW, pc, frame, "%d does not fit in INTEGER", result)
+			}
+			stack[len(stack)-1] = value
+			pc++
+		case OP_FIT:
+			value, ok := integer_store(vm.bytecode.positions[pc], stack[len(stack)-1])
+			if ok == false {
+				vm.runtime_error(RUNTIME_RANGE_CHECK, pc, frame, "%.0f is out of INTEGER range", stack[len(stack)-1])
+			}
+			stack[len(stack)-1] = value
+			pc++
+		default:
+			right := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			left := stack[len(stack)-1]
+			var result float64
+			switch code[pc] {
+			case OP_ADD:
+				result = left + right
+			case OP_SUB:
+				result = left - right
+			case OP_MUL:
+				result = left * right
+			case OP_DIV:
+				if right == 0 {
+					vm.runtime_error(RUNTIME_DIVISION_BY_ZERO, pc, frame, "")
+				}
+				result = left / right
+			case OP_EQ:
+				result = compare(EQUAL, left, right)
+			case OP_NE:
+				result = compare(NOT_EQUAL, left, right)
+			case OP_LT:
+				result = compare(LESS, left, right)
+			case OP_LE:
+				result = compare(LESS_EQUAL, left, right)
+			case OP_GT:
+				result = compare(GREATER, left, right)
+			case OP_GE:
+				result = compare(GREATER_EQUAL, left, right)
+			}
+			stack[len(stack)-1] = result
+			pc++
+		}
+	}
+}
+
+/* Copies the global slots back into the symbol table, where the rest of the tools look */
+func (vm *VM) export(scope *ScopedSymbolTable) {
+	for slot, name := range vm.globals.proc.slots {
+		if symbol, ok := scope.symbols[name].(*VarSymbol); ok == true {
+			symbol.value = vm.globals.locals[slot]
+		}
+	}
+}
+
+func disassemble(w io.Writer, bytecode *Bytecode) {
+	fmt.Fprintln(w, "constants:")
+	for index, constant := range bytecode.constants {
+		fmt.Fprintf(w, "	[%d] %v\n", index, constant)
+	}
+	entries := make(map[int]*VmProcedure)
+	for _, proc := range bytecode.procedures {
+		entries[proc.entry] = proc
+	}
+	for pc := 0; pc < len(bytecode.code); {
+		if proc, ok := entries[pc]; ok == true {
+			fmt.Fprintf(w, "%s: level %d params %d slots %v\n", proc.name, proc.level, proc.params, proc.slots)
+		}
+		op := bytecode.code[pc]
+		operands := bytecode.code[pc+1 : pc+1+op_operands[op]]
+		fmt.Fprintf(w, "%04d %4d  %-10s", pc, bytecode.line(pc), reverse_op[op])
+		for _, operand := range operands {
+			fmt.Fprintf(w, " %d", operand)
+		}
+		switch op {
+		case OP_CONST:
+			fmt.Fprintf(w, "	; %v", bytecode.constants[operands[0]])
+		case OP_PRINT_STR:
+			fmt.Fprintf(w, "	; %q", bytecode.strings[operands[0]])
+		case OP_CALL:
+			fmt.Fprintf(w, "	; %s", bytecode.procedures[operands[0]].name)
+		}
+		fmt.Fprintln(w)
+		pc += 1 + op_operands[op]
+	}
+}